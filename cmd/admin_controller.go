@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/pkg/httputil"
+)
+
+func (e *env) handleSearchUsers(c *gin.Context) {
+	actor, err := getActor(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	users, err := e.adminSvc.SearchUsers(actor, c.Query("email"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, users)
+}
+
+func (e *env) handleAdminGetUser(c *gin.Context) {
+	actor, err := getActor(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	u, err := e.adminSvc.GetUser(actor, c.Param("userId"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, u)
+}
+
+func (e *env) handleLockUser(c *gin.Context) {
+	actor, err := getActor(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	err = e.adminSvc.Lock(actor, c.Param("userId"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	httputil.SendOK(c)
+}
+
+func (e *env) handleUnlockUser(c *gin.Context) {
+	actor, err := getActor(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	err = e.adminSvc.Unlock(actor, c.Param("userId"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	httputil.SendOK(c)
+}
+
+func (e *env) handleAdminLogout(c *gin.Context) {
+	actor, err := getActor(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	err = e.adminSvc.Logout(actor, c.Param("userId"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	httputil.SendOK(c)
+}
+
+func (e *env) handleAdminPasswordReset(c *gin.Context) {
+	actor, err := getActor(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	err = e.adminSvc.ResetPassword(actor, c.Param("userId"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	httputil.SendOK(c)
+}
+
+// getActor returns the user that made the request along with the client it was made from.
+func getActor(c *gin.Context) (domain.Actor, error) {
+	userID, err := getAuthUserID(c)
+	if err != nil {
+		return domain.Actor{}, err
+	}
+
+	return domain.Actor{
+		UserID: userID,
+		Client: getClientInfo(c),
+	}, nil
+}
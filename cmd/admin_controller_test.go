@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"testing"
+
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/directory/pkg/repository"
+	"github.com/mimir-news/directory/pkg/service"
+	"github.com/mimir-news/pkg/httputil/auth"
+	"github.com/mimir-news/pkg/id"
+	"github.com/mimir-news/pkg/schema/user"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleAdminUsers(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := getTestConfig()
+	adminID := id.New()
+	targetID := id.New()
+	userRepo := &repository.MockUserRepo{
+		FindUser: domain.FullUser{User: user.User{ID: adminID, Email: "admin@mail.com", Role: domain.AdminRole}},
+		SearchByEmailRes: []domain.FullUser{
+			{User: user.User{ID: targetID, Email: "target@mail.com", Role: auth.UserRole}},
+		},
+	}
+	sessionRepo := &repository.MockSessionRepo{}
+	events := &service.MockEventRecorder{}
+	mockEnv := getTestEnv(conf, userRepo, sessionRepo, nil)
+	mockEnv.adminSvc = service.NewAdminService(mockEnv.passwordResetSvc, mockEnv.apiKeySvc, userRepo, sessionRepo,
+		&repository.MockServiceAccountRepo{}, &repository.MockAuditEventRepo{}, events)
+	server := newServer(mockEnv, conf)
+	adminToken := getTestAdminToken(conf, adminID)
+
+	// Setup: Search for users by email.
+	req := createTestGetRequest("client-id", adminToken, "/v1/admin/users?email=target")
+	res := performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusOK, res.Code)
+	var users []domain.AdminUser
+	err := json.NewDecoder(res.Body).Decode(&users)
+	assert.NoError(err)
+	assert.Len(users, 1)
+	assert.Equal(targetID, users[0].User.ID)
+	assert.Equal("target", userRepo.SearchByEmailArgPrefix)
+	assert.Len(events.Events, 1)
+	assert.Equal(adminID, events.Events[0].ActorID)
+
+	// Setup: Lock a user.
+	req = createTestPutRequest("client-id", adminToken, "/v1/admin/users/"+targetID+"/lock", nil)
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Equal(targetID, userRepo.SetLockedArgUserID)
+	assert.True(userRepo.SetLockedArgLocked)
+	assert.Equal(targetID, sessionRepo.DeleteByUserIDArg)
+
+	// Setup: Unlock a user.
+	req = createTestDeleteRequest("client-id", adminToken, "/v1/admin/users/"+targetID+"/lock")
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusOK, res.Code)
+	assert.False(userRepo.SetLockedArgLocked)
+	assert.Len(events.Events, 3)
+
+	// Setup: Admins can not lock themselves.
+	req = createTestPutRequest("client-id", adminToken, "/v1/admin/users/"+adminID+"/lock", nil)
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusBadRequest, res.Code)
+	assert.Equal(2, userRepo.SetLockedInvocation)
+
+	// Setup: Admin routes can not be used by regular users.
+	req = createTestDeleteRequest("client-id", getTestToken(conf, adminID, "client-id"), "/v1/admin/users/"+targetID+"/sessions")
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusForbidden, res.Code)
+	assert.Len(events.Events, 3)
+
+	// Setup: Admin routes can not be used by users whose admin role has been removed.
+	userRepo.FindUser.User.Role = auth.UserRole
+	req = createTestDeleteRequest("client-id", adminToken, "/v1/admin/users/"+targetID+"/sessions")
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusForbidden, res.Code)
+	assert.Len(events.Events, 3)
+
+	// Setup: Admin routes require a token.
+	req = createTestGetRequest("client-id", "", "/v1/admin/users?email=target")
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusUnauthorized, res.Code)
+}
+
+func TestHandleServiceAccounts(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := getTestConfig()
+	adminID := id.New()
+	userRepo := &repository.MockUserRepo{
+		FindUser: domain.FullUser{User: user.User{ID: adminID, Email: "admin@mail.com", Role: domain.AdminRole}},
+	}
+	accountRepo := &repository.MockServiceAccountRepo{}
+	events := &service.MockEventRecorder{}
+	mockEnv := getTestEnv(conf, userRepo, nil, nil)
+	mockEnv.adminSvc = service.NewAdminService(mockEnv.passwordResetSvc, mockEnv.apiKeySvc, userRepo, nil,
+		accountRepo, &repository.MockAuditEventRepo{}, events)
+	server := newServer(mockEnv, conf)
+	adminToken := getTestAdminToken(conf, adminID)
+
+	// Setup: Create a service account.
+	req := createTestPostRequest("client-id", adminToken, "/v1/admin/service-accounts",
+		domain.ServiceAccountRequest{Name: "importer"})
+	res := performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusOK, res.Code)
+	var account domain.ServiceAccount
+	err := json.NewDecoder(res.Body).Decode(&account)
+	assert.NoError(err)
+	assert.Equal("importer", account.Name)
+	assert.Equal(adminID, account.CreatedBy)
+	assert.Equal(account.ID, accountRepo.SaveArg.ID)
+	assert.Len(events.Events, 1)
+
+	// Setup: Service accounts must be named.
+	req = createTestPostRequest("client-id", adminToken, "/v1/admin/service-accounts",
+		domain.ServiceAccountRequest{Name: " "})
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusBadRequest, res.Code)
+	assert.Equal(1, accountRepo.SaveInvocation)
+
+	// Setup: List service accounts.
+	accountRepo.FindAllAccounts = []domain.ServiceAccount{account}
+	req = createTestGetRequest("client-id", adminToken, "/v1/admin/service-accounts")
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusOK, res.Code)
+	var accounts []domain.ServiceAccount
+	err = json.NewDecoder(res.Body).Decode(&accounts)
+	assert.NoError(err)
+	assert.Len(accounts, 1)
+
+	// Setup: API keys can only be created for service accounts.
+	accountRepo.FindErr = repository.ErrNoSuchServiceAccount
+	keyReq := domain.APIKeyRequest{Name: "importer", Scopes: []string{domain.UsersReadScope}, ValidForDays: 1}
+	req = createTestPostRequest("client-id", adminToken, "/v1/admin/service-accounts/"+adminID+"/api-keys", keyReq)
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusNotFound, res.Code)
+	assert.Equal(adminID, accountRepo.FindArg)
+	assert.Len(events.Events, 1)
+
+	// Setup: Users can not be deleted as service accounts.
+	req = createTestDeleteRequest("client-id", adminToken, "/v1/admin/service-accounts/"+adminID)
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusNotFound, res.Code)
+	assert.Equal("", userRepo.DeleteArg)
+
+	// Setup: Delete a service account.
+	accountRepo.FindErr = nil
+	accountRepo.FindAccount = account
+	req = createTestDeleteRequest("client-id", adminToken, "/v1/admin/service-accounts/"+account.ID)
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Equal(account.ID, userRepo.DeleteArg)
+	assert.Len(events.Events, 2)
+
+	// Setup: Service accounts can not be managed by regular users.
+	req = createTestGetRequest("client-id", getTestToken(conf, adminID, "client-id"), "/v1/admin/service-accounts")
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusForbidden, res.Code)
+}
+
+func getTestAdminToken(conf config, userID string) string {
+	signer := getTestSigner(conf)
+
+	token, err := signer.Sign(id.New(), auth.User{ID: userID, Role: domain.AdminRole})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return token
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/pkg/httputil"
+)
+
+func (e *env) handleCreateAPIKey(c *gin.Context) {
+	userID, err := getUserIDFromPath(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	req, err := getAPIKeyRequest(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	key, err := e.apiKeySvc.Create(userID, req)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, key)
+}
+
+func (e *env) handleGetAPIKeys(c *gin.Context) {
+	userID, err := getUserIDFromPath(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	keys, err := e.apiKeySvc.List(userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, keys)
+}
+
+func (e *env) handleRevokeAPIKey(c *gin.Context) {
+	userID, err := getUserIDFromPath(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	err = e.apiKeySvc.Revoke(userID, c.Param("keyId"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	httputil.SendOK(c)
+}
+
+func getAPIKeyRequest(c *gin.Context) (domain.APIKeyRequest, error) {
+	var req domain.APIKeyRequest
+	err := c.ShouldBindJSON(&req)
+	if err != nil || !req.Valid() {
+		return req, httputil.ErrBadRequest()
+	}
+
+	return req, nil
+}
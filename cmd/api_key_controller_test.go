@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/directory/pkg/repository"
+	"github.com/mimir-news/directory/pkg/service"
+	"github.com/mimir-news/pkg/httputil/auth"
+	"github.com/mimir-news/pkg/id"
+	"github.com/mimir-news/pkg/schema/user"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleAPIKeys(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := getTestConfig()
+	userID := id.New()
+	userRepo := &repository.MockUserRepo{
+		FindUser: domain.FullUser{User: user.User{ID: userID, Email: "mail@mail.com", Role: auth.UserRole}},
+	}
+	keyRepo := &repository.MockAPIKeyRepo{}
+	mockEnv := getTestEnv(conf, userRepo, nil, nil)
+	mockEnv.apiKeySvc = service.NewAPIKeyService(mockEnv.verificationSvc, userRepo, keyRepo)
+	server := newServer(mockEnv, conf)
+	token := getTestToken(conf, userID, "client-id")
+
+	// Setup: Create a key with a token.
+	keyReq := domain.APIKeyRequest{Name: "script", Scopes: []string{domain.UsersReadScope}, ValidForDays: 30}
+	req := createTestPostRequest("client-id", token, "/v1/users/"+userID+"/api-keys", keyReq)
+	res := performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusOK, res.Code)
+	var created domain.CreatedAPIKey
+	err := json.NewDecoder(res.Body).Decode(&created)
+	assert.NoError(err)
+	assert.NotEmpty(created.Key)
+	assert.Equal(keyReq.Scopes, created.Info.Scopes)
+	assert.Equal(userID, keyRepo.SaveArg.UserID)
+
+	// Setup: Read the user with the key.
+	keyRepo.FindByPrefixKey = keyRepo.SaveArg
+	req = createTestGetRequest("client-id", "", "/v1/users/"+userID)
+	req.Header.Set(apiKeyHeader, created.Key)
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Equal(1, keyRepo.UpdateLastUsedInvocation)
+
+	// Setup: Account changes can not be made with a key.
+	req = createTestPostRequest("client-id", "", "/v1/users/"+userID+"/api-keys", keyReq)
+	req.Header.Set(apiKeyHeader, created.Key)
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusForbidden, res.Code)
+	assert.Equal(1, keyRepo.SaveInvocation)
+
+	// Setup: Personal data can not be exported with a key.
+	req = createTestGetRequest("client-id", "", "/v1/users/"+userID+"/export")
+	req.Header.Set(apiKeyHeader, created.Key)
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusForbidden, res.Code)
+
+	// Setup: Use the key without the watchlist scope.
+	req = createTestGetRequest("client-id", "", "/v1/watchlists/"+id.New())
+	req.Header.Set(apiKeyHeader, created.Key)
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusForbidden, res.Code)
+
+	// Setup: Use an invalid key.
+	req = createTestGetRequest("client-id", "", "/v1/users/"+userID)
+	req.Header.Set(apiKeyHeader, created.Key+"0")
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusUnauthorized, res.Code)
+
+	// Setup: List and revoke keys.
+	keyRepo.FindByUserIDKeys = []domain.APIKey{keyRepo.SaveArg}
+	req = createTestGetRequest("client-id", token, "/v1/users/"+userID+"/api-keys")
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusOK, res.Code)
+	var keys []domain.APIKeyInfo
+	err = json.NewDecoder(res.Body).Decode(&keys)
+	assert.NoError(err)
+	assert.Len(keys, 1)
+	assert.Equal(created.Info.ID, keys[0].ID)
+
+	req = createTestDeleteRequest("client-id", token, "/v1/users/"+userID+"/api-keys/"+created.Info.ID)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Equal(userID, keyRepo.RevokeArgUserID)
+	assert.Equal(created.Info.ID, keyRepo.RevokeArgKeyID)
+
+	// Setup: Keys of other users can not be managed.
+	otherToken := getTestToken(conf, id.New(), "client-id")
+	req = createTestDeleteRequest("client-id", otherToken, "/v1/users/"+userID+"/api-keys/"+created.Info.ID)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusForbidden, res.Code)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mimir-news/pkg/httputil"
+)
+
+// jsonLinesContentType content type of responses with one JSON document per line.
+const jsonLinesContentType = "application/x-ndjson"
+
+func (e *env) handleGetEvents(c *gin.Context) {
+	userID, err := getUserIDFromPath(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	before, limit, err := getEventQuery(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	events, err := e.auditSvc.List(userID, before, limit)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, events)
+}
+
+func (e *env) handleExportEvents(c *gin.Context) {
+	actor, err := getActor(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.Header("Content-Type", jsonLinesContentType)
+	err = e.adminSvc.ExportEvents(actor, c.Param("userId"), c.Writer)
+	if err != nil && c.Writer.Written() {
+		// The export has already been partly sent, so the error can only be logged.
+		log.Println(err)
+		return
+	} else if err != nil {
+		c.Writer.Header().Del("Content-Type")
+		c.Error(err)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+// getEventQuery gets the optional before time, in RFC 3339 format, and page size of an event listing.
+func getEventQuery(c *gin.Context) (time.Time, int, error) {
+	var before time.Time
+	var limit int
+	var err error
+	if value := c.Query("before"); value != "" {
+		before, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			return before, limit, httputil.ErrBadRequest()
+		}
+	}
+
+	if value := c.Query("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil {
+			return before, limit, httputil.ErrBadRequest()
+		}
+	}
+
+	return before.UTC(), limit, nil
+}
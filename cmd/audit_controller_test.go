@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/directory/pkg/repository"
+	"github.com/mimir-news/directory/pkg/service"
+	"github.com/mimir-news/pkg/httputil/auth"
+	"github.com/mimir-news/pkg/id"
+	"github.com/mimir-news/pkg/schema/user"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleLoginEvents(t *testing.T) {
+	assert := assert.New(t)
+
+	storedUser := domain.FullUser{
+		User: user.User{ID: id.New(), Email: "mail@mail.com", Role: auth.UserRole},
+		Credentials: domain.StoredCredentials{
+			Email:    "mail@mail.com",
+			Password: encryptedPassword,
+			Salt:     encryptedSalt,
+		},
+	}
+
+	conf := getTestConfig()
+	userRepo := &repository.MockUserRepo{
+		FindByEmailUser: storedUser,
+	}
+	sessionRepo := &repository.MockSessionRepo{}
+	events := &service.MockEventRecorder{}
+	mockEnv := getTestEnv(conf, userRepo, sessionRepo, nil)
+	mockEnv.userSvc = service.NewUserService(
+		mockEnv.passwordSvc, mockEnv.verificationSvc, mockEnv.mfaSvc, mockEnv.emailLoginSvc, mockEnv.externalLoginSvc, getTestSigner(conf),
+		auth.NewVerifier(conf.JWTCredentials, 0), service.NewRefreshTokenHasher(conf.RefreshTokenKey),
+		userRepo, sessionRepo, nil, events)
+	server := newServer(mockEnv, conf)
+
+	// Setup: Log in with the correct password.
+	req := createTestPostRequest("client-id", "", "/v1/login", user.Credentials{Email: "mail@mail.com", Password: correctPassword})
+	req.Header.Set("User-Agent", "test-agent/1.0")
+	req.RemoteAddr = "10.0.0.1:4321"
+	res := performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Len(events.Events, 1)
+	assert.Equal(domain.LoginSuccessEvent, events.Events[0].Type)
+	assert.Equal(storedUser.User.ID, events.Events[0].UserID)
+	assert.Equal(storedUser.User.ID, events.Events[0].ActorID)
+	assert.Equal(sessionRepo.SaveArg.ID, events.Events[0].SessionID)
+	assert.Equal("10.0.0.1", events.Events[0].Client.IP)
+	assert.Equal("test-agent/1.0", events.Events[0].Client.UserAgent)
+
+	// Setup: Log in with the wrong password.
+	req = createTestPostRequest("client-id", "", "/v1/login", user.Credentials{Email: "mail@mail.com", Password: "wrong-password"})
+	req.RemoteAddr = "10.0.0.2:4321"
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusUnauthorized, res.Code)
+	assert.Len(events.Events, 2)
+	assert.Equal(domain.LoginFailureEvent, events.Events[1].Type)
+	assert.Equal(storedUser.User.ID, events.Events[1].UserID)
+	assert.Equal("", events.Events[1].ActorID)
+	assert.Equal("10.0.0.2", events.Events[1].Client.IP)
+
+	// Setup: Log in to an account which does not exist.
+	userRepo.FindByEmailErr = repository.ErrNoSuchUser
+	req = createTestPostRequest("client-id", "", "/v1/login", user.Credentials{Email: "other@mail.com", Password: correctPassword})
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusUnauthorized, res.Code)
+	assert.Len(events.Events, 2)
+}
+
+func TestHandleGetEvents(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := getTestConfig()
+	userID := id.New()
+	event := domain.NewAccountEvent(domain.PasswordChangeEvent, userID, "", domain.ClientInfo{IP: "10.0.0.1"})
+	eventRepo := &repository.MockAuditEventRepo{
+		FindByUserIDEvents: []domain.SecurityEvent{event},
+	}
+	mockEnv := getTestEnv(conf, &repository.MockUserRepo{}, nil, nil)
+	mockEnv.auditSvc = service.NewAuditService(eventRepo)
+	server := newServer(mockEnv, conf)
+	token := getTestToken(conf, userID, "client-id")
+
+	// Setup: Read the latest events.
+	req := createTestGetRequest("client-id", token, "/v1/users/"+userID+"/events")
+	res := performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusOK, res.Code)
+	var events []domain.EventInfo
+	err := json.NewDecoder(res.Body).Decode(&events)
+	assert.NoError(err)
+	assert.Len(events, 1)
+	assert.Equal(event.ID, events[0].ID)
+	assert.Equal(domain.PasswordChangeEvent, events[0].Type)
+	assert.Equal("10.0.0.1", events[0].ClientIP)
+	assert.Equal(userID, eventRepo.FindByUserIDArgUserID)
+	assert.True(eventRepo.FindByUserIDArgBefore.IsZero())
+	assert.True(eventRepo.FindByUserIDArgLimit > 0)
+
+	// Setup: Read a page of older events.
+	before := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	req = createTestGetRequest("client-id", token, "/v1/users/"+userID+"/events?before="+before.Format(time.RFC3339)+"&limit=10")
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusOK, res.Code)
+	assert.True(before.Equal(eventRepo.FindByUserIDArgBefore))
+	assert.Equal(10, eventRepo.FindByUserIDArgLimit)
+
+	// Setup: Use an invalid before time and limit.
+	eventRepo.UnsetArgs()
+	req = createTestGetRequest("client-id", token, "/v1/users/"+userID+"/events?before=yesterday")
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusBadRequest, res.Code)
+
+	req = createTestGetRequest("client-id", token, "/v1/users/"+userID+"/events?limit=100000")
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusBadRequest, res.Code)
+	assert.Equal(0, eventRepo.FindByUserIDInvocation)
+
+	// Setup: Read the events of another user.
+	req = createTestGetRequest("client-id", token, "/v1/users/"+id.New()+"/events")
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusForbidden, res.Code)
+	assert.Equal(0, eventRepo.FindByUserIDInvocation)
+}
+
+func TestHandleExportEvents(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := getTestConfig()
+	adminID := id.New()
+	userID := id.New()
+	userRepo := &repository.MockUserRepo{
+		FindUser: domain.FullUser{User: user.User{ID: adminID, Email: "admin@mail.com", Role: domain.AdminRole}},
+	}
+	eventRepo := &repository.MockAuditEventRepo{
+		FindAllByUserIDEvents: []domain.SecurityEvent{
+			domain.NewAccountEvent(domain.LoginSuccessEvent, userID, id.New(), domain.ClientInfo{}),
+			domain.NewAccountEvent(domain.PasswordChangeEvent, userID, "", domain.ClientInfo{}),
+		},
+	}
+	events := &service.MockEventRecorder{}
+	mockEnv := getTestEnv(conf, userRepo, nil, nil)
+	mockEnv.adminSvc = service.NewAdminService(nil, nil, userRepo, nil, nil, eventRepo, events)
+	server := newServer(mockEnv, conf)
+
+	// Setup: Export the events of a user.
+	req := createTestGetRequest("client-id", getTestAdminToken(conf, adminID), "/v1/admin/users/"+userID+"/events")
+	res := performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Equal(jsonLinesContentType, res.Header().Get("Content-Type"))
+	scanner := bufio.NewScanner(res.Body)
+	exported := make([]domain.EventInfo, 0)
+	for scanner.Scan() {
+		var e domain.EventInfo
+		err := json.Unmarshal(scanner.Bytes(), &e)
+		assert.NoError(err)
+		exported = append(exported, e)
+	}
+	assert.Len(exported, 2)
+	assert.Equal(domain.LoginSuccessEvent, exported[0].Type)
+	assert.Equal(domain.PasswordChangeEvent, exported[1].Type)
+	assert.Equal(userID, eventRepo.FindAllByUserIDArg)
+	assert.Len(events.Events, 1)
+	assert.Equal(domain.AdminEventExportEvent, events.Events[0].Type)
+	assert.Equal(adminID, events.Events[0].ActorID)
+
+	// Setup: Exports require the admin role.
+	eventRepo.UnsetArgs()
+	req = createTestGetRequest("client-id", getTestToken(conf, adminID, "client-id"), "/v1/admin/users/"+userID+"/events")
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusForbidden, res.Code)
+	assert.Equal(0, eventRepo.FindAllByUserIDInvocation)
+
+	// Setup: Failures before the export has started are reported as errors.
+	userRepo.FindErr = expectedTestError
+	req = createTestGetRequest("client-id", getTestAdminToken(conf, adminID), "/v1/admin/users/"+userID+"/events")
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusInternalServerError, res.Code)
+	assert.NotEqual(jsonLinesContentType, res.Header().Get("Content-Type"))
+	assert.Equal(0, eventRepo.FindAllByUserIDInvocation)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mimir-news/directory/pkg/service"
+	"github.com/mimir-news/pkg/httputil"
+	"github.com/mimir-news/pkg/httputil/auth"
+)
+
+// apiKeyHeader header used to authenticate with an API key instead of a token.
+const apiKeyHeader = "X-API-Key"
+
+const (
+	authUserKey   = "directory:authUser"
+	authScopesKey = "directory:authScopes"
+)
+
+// newAccessTokenVerifier creates a verifier for access tokens which does not accept expired tokens.
+func newAccessTokenVerifier(cfg config) auth.Verifier {
+	return service.NewTokenVerifier(cfg.TokenKeyring, cfg.IssuerURL, 0, newLegacyTokenVerifier(cfg, 0))
+}
+
+// newLegacyTokenVerifier creates a verifier for tokens signed with the shared secret, which are accepted until the
+// configured cut-off time so that services can move to the published keys. No verifier is created if there is no cut-off.
+func newLegacyTokenVerifier(cfg config, leeway time.Duration) auth.Verifier {
+	if cfg.LegacyTokensAcceptedUntil.IsZero() {
+		return nil
+	}
+
+	return service.NewLegacyTokenVerifier(cfg.JWTCredentials, leeway, cfg.LegacyTokensAcceptedUntil)
+}
+
+// requireToken requires a valid token or API key for all routes that are not unsecured or match one of the
+// unsecured route patterns. The user of the token is stored in the request context, along with the scopes of the API key.
+func requireToken(
+	verifier auth.Verifier, apiKeys service.APIKeyService, unsecuredRoutes []string, unsecuredPatterns []string) gin.HandlerFunc {
+	unsecured := make(map[string]bool)
+	for _, route := range unsecuredRoutes {
+		unsecured[route] = true
+	}
+
+	return func(c *gin.Context) {
+		path := c.Request.URL.Path
+		if unsecured[path] || matchesAnyRoutePattern(unsecuredPatterns, path) {
+			c.Next()
+			return
+		}
+
+		apiKey := c.GetHeader(apiKeyHeader)
+		if apiKey != "" {
+			authenticateAPIKey(c, apiKeys, apiKey)
+			return
+		}
+
+		header := c.GetHeader(auth.AuthHeaderKey)
+		if !strings.HasPrefix(header, auth.AuthTokenPrefix) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, httputil.ErrUnauthorized())
+			return
+		}
+
+		token, err := verifier.Verify(strings.TrimPrefix(header, auth.AuthTokenPrefix))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, httputil.ErrUnauthorized())
+			return
+		}
+
+		c.Set(authUserKey, token.User)
+		c.Next()
+	}
+}
+
+func authenticateAPIKey(c *gin.Context, apiKeys service.APIKeyService, apiKey string) {
+	key, err := apiKeys.Authenticate(apiKey)
+	if err != nil {
+		c.Error(err)
+		c.Abort()
+		return
+	}
+
+	c.Set(authUserKey, auth.User{ID: key.UserID, Role: key.Role})
+	c.Set(authScopesKey, key.Scopes)
+	c.Next()
+}
+
+// requireScope requires requests made with API keys to have the read scope for reads and the write scope for
+// other requests. An empty scope means that API keys can not be used. Requests made with tokens are not restricted.
+func requireScope(readScope, writeScope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value, ok := c.Get(authScopesKey)
+		if !ok {
+			c.Next()
+			return
+		}
+
+		scope := writeScope
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			scope = readScope
+		}
+
+		scopes, _ := value.([]string)
+		for _, s := range scopes {
+			if scope != "" && s == scope {
+				c.Next()
+				return
+			}
+		}
+
+		c.AbortWithStatusJSON(http.StatusForbidden, httputil.ErrForbidden())
+	}
+}
+
+// disallowRoles rejects requests made with tokens issued to users with any of the given roles.
+func disallowRoles(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := getAuthUser(c)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, httputil.ErrUnauthorized())
+			return
+		}
+
+		for _, role := range roles {
+			if user.Role == role {
+				c.AbortWithStatusJSON(http.StatusForbidden, httputil.ErrForbidden())
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
+
+// requireRoles only allows requests made with tokens issued to users with one of the given roles.
+func requireRoles(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := getAuthUser(c)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, httputil.ErrUnauthorized())
+			return
+		}
+
+		for _, role := range roles {
+			if user.Role == role {
+				c.Next()
+				return
+			}
+		}
+
+		c.AbortWithStatusJSON(http.StatusForbidden, httputil.ErrForbidden())
+	}
+}
+
+// getAuthUser returns the user of the token that the request was made with.
+func getAuthUser(c *gin.Context) (auth.User, error) {
+	value, ok := c.Get(authUserKey)
+	if !ok {
+		return auth.User{}, httputil.ErrUnauthorized()
+	}
+
+	user, ok := value.(auth.User)
+	if !ok {
+		return auth.User{}, httputil.ErrUnauthorized()
+	}
+
+	return user, nil
+}
+
+// getAuthUserID returns the id of the user that the request was made by.
+func getAuthUserID(c *gin.Context) (string, error) {
+	user, err := getAuthUser(c)
+	if err != nil {
+		return "", err
+	}
+
+	return user.ID, nil
+}
+
+func matchesAnyRoutePattern(patterns []string, path string) bool {
+	for _, pattern := range patterns {
+		if matchesRoutePattern(pattern, path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchesRoutePattern checks if a path matches a route pattern where path parameters start with a colon.
+func matchesRoutePattern(pattern, path string) bool {
+	patternParts := strings.Split(strings.Trim(pattern, "/"), "/")
+	pathParts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(patternParts) != len(pathParts) {
+		return false
+	}
+
+	for i, part := range patternParts {
+		if strings.HasPrefix(part, ":") && pathParts[i] != "" {
+			continue
+		}
+		if part != pathParts[i] {
+			return false
+		}
+	}
+
+	return true
+}
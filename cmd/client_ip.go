@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	forwardedForHeader = "X-Forwarded-For"
+	clientIPKey        = "directory:clientIP"
+)
+
+// resolveClientIP resolves the ip of the client making a request and stores it in the request context.
+func resolveClientIP(trustedProxies []*net.IPNet) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(clientIPKey, clientIP(c.Request, trustedProxies))
+		c.Next()
+	}
+}
+
+// getClientIP gets the ip of the client making a request.
+func getClientIP(c *gin.Context) string {
+	return c.GetString(clientIPKey)
+}
+
+// clientIP returns the address that a request was received from. Forwarded addresses are only used if the
+// request was received from a trusted proxy, in which case the X-Forwarded-For header is read from right to left
+// until an address that is not a trusted proxy is found. Addresses that are not valid ips are never used.
+func clientIP(req *http.Request, trustedProxies []*net.IPNet) string {
+	host, _, err := net.SplitHostPort(strings.TrimSpace(req.RemoteAddr))
+	if err != nil {
+		host = strings.TrimSpace(req.RemoteAddr)
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return ""
+	}
+
+	forwarded := strings.Split(strings.Join(req.Header[forwardedForHeader], ","), ",")
+	for i := len(forwarded) - 1; i >= 0 && isTrustedProxy(ip, trustedProxies); i-- {
+		forwardedIP := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if forwardedIP == nil {
+			break
+		}
+		ip = forwardedIP
+	}
+
+	return ip.String()
+}
+
+func isTrustedProxy(ip net.IP, trustedProxies []*net.IPNet) bool {
+	for _, proxy := range trustedProxies {
+		if proxy.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientIP(t *testing.T) {
+	assert := assert.New(t)
+
+	trustedProxies := getTrustedProxies("127.0.0.1, 10.0.0.0/8")
+	cases := []struct {
+		remoteAddr   string
+		forwardedFor []string
+		expectedIP   string
+	}{
+		{remoteAddr: "192.0.2.1:4321", expectedIP: "192.0.2.1"},
+		// Forwarded ips should be ignored unless the request was received from a trusted proxy.
+		{remoteAddr: "192.0.2.1:4321", forwardedFor: []string{"198.51.100.7"}, expectedIP: "192.0.2.1"},
+		{remoteAddr: "127.0.0.1:4321", forwardedFor: []string{"198.51.100.7"}, expectedIP: "198.51.100.7"},
+		// Ips added by the client before the trusted proxies should be ignored.
+		{remoteAddr: "127.0.0.1:4321", forwardedFor: []string{"203.0.113.9, 198.51.100.7, 10.1.2.3"}, expectedIP: "198.51.100.7"},
+		{remoteAddr: "127.0.0.1:4321", forwardedFor: []string{"203.0.113.9", "198.51.100.7"}, expectedIP: "198.51.100.7"},
+		// Forwarded values that are not ips should not be used.
+		{remoteAddr: "127.0.0.1:4321", forwardedFor: []string{"not-an-ip"}, expectedIP: "127.0.0.1"},
+		{remoteAddr: "10.0.0.1:4321", forwardedFor: []string{"2001:db8::1"}, expectedIP: "2001:db8::1"},
+		{remoteAddr: "[::1]:4321", forwardedFor: []string{"198.51.100.7"}, expectedIP: "::1"},
+	}
+
+	for _, tc := range cases {
+		req := createTestGetRequest("", "", "/health")
+		req.RemoteAddr = tc.remoteAddr
+		for _, value := range tc.forwardedFor {
+			req.Header.Add(forwardedForHeader, value)
+		}
+		assert.Equal(tc.expectedIP, clientIP(req, trustedProxies), tc.remoteAddr)
+	}
+}
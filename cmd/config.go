@@ -4,10 +4,17 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"log"
+	"net"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/directory/pkg/service"
 	"github.com/mimir-news/pkg/dbutil"
 	"github.com/mimir-news/pkg/httputil/auth"
+	"gopkg.in/square/go-jose.v2"
 )
 
 // Service metadata.
@@ -21,34 +28,129 @@ var unsecuredRoutes = []string{
 	"/v1/users",
 	"/v1/login",
 	"/v1/login/anonymous",
+	"/v1/login/guest",
+	"/v1/login/mfa",
+	"/v1/login/email",
+	"/v1/login/email/verify",
+	"/v1/password-reset",
+	"/.well-known/openid-configuration",
+	"/.well-known/jwks.json",
+	"/v1/oauth/introspect",
+	"/v1/oauth/revoke",
+}
+
+// unsecuredRoutePatterns unsecured routes which contain path parameters.
+var unsecuredRoutePatterns = []string{
+	"/v1/password-reset/:token",
+	"/v1/login/oidc/:provider",
+	"/v1/login/oidc/:provider/callback",
+	"/v1/users/:userId/email/verify",
+	"/v1/users/:userId/email/verify/resend",
+	"/v1/users/:userId/email/confirm",
+	"/v1/users/:userId/email/revert",
 }
 
 type config struct {
-	DB                    dbutil.Config
-	Port                  string
-	PasswordPepper        string
-	PasswordEncryptionKey string
-	JWTCredentials        auth.JWTCredentials
-	UnsecuredRoutes       []string
+	DB                        dbutil.Config
+	Port                      string
+	PasswordPepper            string
+	EncryptionKeyring         service.EncryptionKeyring
+	RefreshTokenKey           string
+	JWTCredentials            auth.JWTCredentials
+	LegacyTokensAcceptedUntil time.Time
+	IssuerURL                 string
+	TokenKeyring              service.TokenKeyring
+	UnsecuredRoutes           []string
+	UnsecuredRoutePatterns    []string
+	SMTP                      smtpConfig
+	EmailLoginURL             string
+	OIDCProviders             []service.OIDCProviderConfig
+	ServiceClients            []service.ServiceClient
+	EmailVerificationPolicy   service.EmailVerificationPolicy
+	HashingConfig             service.HashingConfig
+	LockoutPolicy             service.LockoutPolicy
+	GuestRetention            time.Duration
+	DeletionGracePeriod       time.Duration
+	RateLimitPolicy           service.RateLimitPolicy
+	TrustedProxies            []*net.IPNet
 }
 
 func getConfig() config {
 	passwordSecret := getSecret(mustGetenv("PASSWORD_SECRETS_FILE"))
 	jwtCredentials := getJWTCredentials(mustGetenv("JWT_CREDENTIALS_FILE"))
+	smtpCredentials := getSMTPCredentials(mustGetenv("SMTP_CREDENTIALS_FILE"))
 
 	return config{
-		DB:                    dbutil.MustGetConfig("DB"),
-		Port:                  mustGetenv("SERVICE_PORT"),
-		PasswordPepper:        passwordSecret.Secret,
-		PasswordEncryptionKey: passwordSecret.Key,
-		JWTCredentials:        jwtCredentials,
-		UnsecuredRoutes:       unsecuredRoutes,
+		DB:                        dbutil.MustGetConfig("DB"),
+		Port:                      mustGetenv("SERVICE_PORT"),
+		PasswordPepper:            passwordSecret.Secret,
+		EncryptionKeyring:         getEncryptionKeyring(passwordSecret),
+		RefreshTokenKey:           getRefreshTokenKey(passwordSecret),
+		JWTCredentials:            jwtCredentials,
+		LegacyTokensAcceptedUntil: getTime(os.Getenv("LEGACY_TOKENS_ACCEPTED_UNTIL")),
+		IssuerURL:                 mustGetenv("TOKEN_ISSUER_URL"),
+		TokenKeyring:              getTokenKeyring(mustGetenv("TOKEN_SIGNING_KEYS_FILE")),
+		UnsecuredRoutes:           unsecuredRoutes,
+		UnsecuredRoutePatterns:    unsecuredRoutePatterns,
+		SMTP: smtpConfig{
+			Host:     mustGetenv("SMTP_HOST"),
+			Port:     mustGetenv("SMTP_PORT"),
+			Username: smtpCredentials.Username,
+			Password: smtpCredentials.Password,
+			Sender:   mustGetenv("MAIL_SENDER"),
+		},
+		EmailLoginURL:           mustGetenv("EMAIL_LOGIN_URL"),
+		OIDCProviders:           getOIDCProviders(os.Getenv("OIDC_PROVIDERS_FILE")),
+		ServiceClients:          getServiceClients(os.Getenv("SERVICE_CLIENTS_FILE")),
+		EmailVerificationPolicy: getEmailVerificationPolicy(mustGetenv("EMAIL_VERIFICATION_POLICY")),
+		HashingConfig:           getHashingConfig(mustGetenv("PASSWORD_HASHING_ALGORITHM")),
+		LockoutPolicy:           service.DefaultLockoutPolicy,
+		GuestRetention:          getDuration(mustGetenv("GUEST_RETENTION_PERIOD")),
+		DeletionGracePeriod:     getDuration(mustGetenv("DELETION_GRACE_PERIOD")),
+		RateLimitPolicy: service.RateLimitPolicy{
+			PerIP:    getRateLimit(os.Getenv("RATE_LIMIT_PER_IP"), service.DefaultRateLimitPolicy.PerIP),
+			PerEmail: getRateLimit(os.Getenv("RATE_LIMIT_PER_EMAIL"), service.DefaultRateLimitPolicy.PerEmail),
+		},
+		TrustedProxies: getTrustedProxies(os.Getenv("TRUSTED_PROXIES")),
 	}
 }
 
+type smtpConfig struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	Sender   string
+}
+
+type smtpCredentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
 type secret struct {
-	Secret string `json:"secret"`
-	Key    string `json:"key"`
+	Secret      string          `json:"secret"`
+	Key         string          `json:"key"`
+	Keys        []encryptionKey `json:"keys"`
+	ActiveKeyID string          `json:"activeKeyId"`
+	RefreshKey  string          `json:"refreshTokenKey"`
+}
+
+type tokenKeys struct {
+	ActiveKeyID string     `json:"activeKeyId"`
+	Keys        []tokenKey `json:"keys"`
+}
+
+type tokenKey struct {
+	ID         string `json:"id"`
+	Algorithm  string `json:"algorithm"`
+	PrivateKey string `json:"privateKey"`
+	PublicKey  string `json:"publicKey"`
+}
+
+type encryptionKey struct {
+	ID  string `json:"id"`
+	Key string `json:"key"`
 }
 
 func getSecret(filename string) secret {
@@ -66,6 +168,21 @@ func getSecret(filename string) secret {
 	return s
 }
 
+func getSMTPCredentials(filename string) smtpCredentials {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var c smtpCredentials
+	err = json.Unmarshal(content, &c)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return c
+}
+
 func getJWTCredentials(filename string) auth.JWTCredentials {
 	f, err := os.Open(filename)
 	if err != nil {
@@ -80,6 +197,270 @@ func getJWTCredentials(filename string) auth.JWTCredentials {
 	return credentials
 }
 
+// getTokenKeyring reads the keys used to sign tokens. Keys that are being rotated
+// in or out of use can be configured with only a public key, they are then only used for verification.
+func getTokenKeyring(filename string) service.TokenKeyring {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var keys tokenKeys
+	err = json.Unmarshal(content, &keys)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	keyring := service.TokenKeyring{
+		ActiveKeyID: keys.ActiveKeyID,
+		Keys:        make(map[string]jose.JSONWebKey),
+	}
+
+	for _, key := range keys.Keys {
+		pemKey := key.PrivateKey
+		if pemKey == "" {
+			pemKey = key.PublicKey
+		}
+
+		jwk, err := service.ParseTokenKey(key.ID, key.Algorithm, pemKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if _, ok := keyring.Keys[key.ID]; ok {
+			log.Fatalf("Duplicate token key id: %s\n", key.ID)
+		}
+		keyring.Keys[key.ID] = jwk
+	}
+
+	err = keyring.Valid()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return keyring
+}
+
+// getOIDCProviders reads the configured OpenID Connect providers,
+// login with external providers is disabled if no providers file is configured.
+func getOIDCProviders(filename string) []service.OIDCProviderConfig {
+	if filename == "" {
+		return nil
+	}
+
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var providers []service.OIDCProviderConfig
+	err = json.Unmarshal(content, &providers)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, provider := range providers {
+		err = provider.Valid()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	return providers
+}
+
+// getServiceClients reads the credentials of services that may introspect and revoke tokens,
+// introspection and revocation is disabled if no service clients file is configured.
+func getServiceClients(filename string) []service.ServiceClient {
+	if filename == "" {
+		return nil
+	}
+
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var clients []service.ServiceClient
+	err = json.Unmarshal(content, &clients)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, client := range clients {
+		err = client.Valid()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	return clients
+}
+
+func getEmailVerificationPolicy(value string) service.EmailVerificationPolicy {
+	policy := service.EmailVerificationPolicy(value)
+	switch policy {
+	case service.AllowUnverified, service.LimitUnverified, service.BlockUnverified:
+		return policy
+	default:
+		log.Fatalf("Invalid email verification policy: %s\n", value)
+	}
+
+	return policy
+}
+
+// getEncryptionKeyring creates a keyring from the password secrets. The unidentified key is
+// kept as the legacy key so that secrets encrypted before keys were identified can be decrypted.
+func getEncryptionKeyring(s secret) service.EncryptionKeyring {
+	keyring := service.EncryptionKeyring{
+		ActiveKeyID: s.ActiveKeyID,
+		Keys:        make(map[string]string),
+	}
+
+	if s.Key != "" {
+		keyring.Keys[service.LegacyKeyID] = s.Key
+	}
+
+	for _, key := range s.Keys {
+		if key.ID == service.LegacyKeyID {
+			log.Fatal("Encryption keys in keyring must have an id")
+		}
+		keyring.Keys[key.ID] = key.Key
+	}
+
+	err := keyring.Valid()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return keyring
+}
+
+func getRefreshTokenKey(s secret) string {
+	if s.RefreshKey == "" {
+		log.Fatal("No refresh token key in password secrets")
+	}
+
+	return s.RefreshKey
+}
+
+// getHashingConfig gets the algorithm and cost parameters that new password hashes are created with.
+// Cost parameters which are not configured keep their default values.
+func getHashingConfig(algorithm string) service.HashingConfig {
+	hashingConfig := service.DefaultHashingConfig
+	hashingConfig.Algorithm = algorithm
+
+	argon2id := &hashingConfig.Argon2id
+	argon2id.Memory = uint32(getUint(os.Getenv("ARGON2ID_MEMORY_KIB"), uint64(argon2id.Memory), 32))
+	argon2id.Iterations = uint32(getUint(os.Getenv("ARGON2ID_ITERATIONS"), uint64(argon2id.Iterations), 32))
+	argon2id.Parallelism = uint8(getUint(os.Getenv("ARGON2ID_PARALLELISM"), uint64(argon2id.Parallelism), 8))
+	hashingConfig.BcryptCost = int(getUint(os.Getenv("BCRYPT_COST"), uint64(hashingConfig.BcryptCost), 8))
+
+	err := hashingConfig.Valid()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return hashingConfig
+}
+
+// getUint parses an unsigned integer which fits in the given number of bits.
+// The default value is used if no value is configured.
+func getUint(value string, defaultValue uint64, bitSize int) uint64 {
+	if value == "" {
+		return defaultValue
+	}
+
+	n, err := strconv.ParseUint(value, 10, bitSize)
+	if err != nil {
+		log.Fatalf("Invalid number: %s\n", value)
+	}
+
+	return n
+}
+
+func getDuration(value string) time.Duration {
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		log.Fatalf("Invalid duration: %s\n", value)
+	}
+
+	return duration
+}
+
+// getTime parses an RFC 3339 timestamp. The zero time is returned if no timestamp is configured.
+func getTime(value string) time.Time {
+	if value == "" {
+		return time.Time{}
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		log.Fatalf("Invalid time: %s\n", value)
+	}
+
+	return t
+}
+
+// getRateLimit parses a rate limit on the form <requests>/<period>, such as 30/1m.
+// The default limit is used if no limit is configured.
+func getRateLimit(value string, defaultLimit domain.RateLimit) domain.RateLimit {
+	if value == "" {
+		return defaultLimit
+	}
+
+	parts := strings.Split(value, "/")
+	if len(parts) != 2 {
+		log.Fatalf("Invalid rate limit: %s\n", value)
+	}
+
+	requests, err := strconv.Atoi(parts[0])
+	if err != nil {
+		log.Fatalf("Invalid rate limit: %s\n", value)
+	}
+
+	limit := domain.RateLimit{
+		Requests: requests,
+		Period:   getDuration(parts[1]),
+	}
+
+	err = limit.Valid()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return limit
+}
+
+// getTrustedProxies parses a comma separated list of ips and CIDR ranges of proxies whose forwarded
+// client ips are trusted. If no proxies are configured forwarded ips are ignored.
+func getTrustedProxies(value string) []*net.IPNet {
+	proxies := make([]*net.IPNet, 0)
+	for _, proxy := range strings.Split(value, ",") {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				log.Fatalf("Invalid trusted proxy: %s\n", proxy)
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			log.Fatalf("Invalid trusted proxy: %s\n", proxy)
+		}
+		proxies = append(proxies, network)
+	}
+
+	return proxies
+}
+
 func mustGetenv(key string) string {
 	val := os.Getenv(key)
 	if val == "" {
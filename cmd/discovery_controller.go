@@ -0,0 +1,20 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Keys are cached for a limited time so that verifiers pick up keys which are rotated in.
+const jwksCacheControl = "public, max-age=3600"
+
+func (e *env) handleGetOpenIDConfiguration(c *gin.Context) {
+	c.Header("Cache-Control", jwksCacheControl)
+	c.JSON(http.StatusOK, e.discoverySvc.OpenIDConfiguration())
+}
+
+func (e *env) handleGetJWKS(c *gin.Context) {
+	c.Header("Cache-Control", jwksCacheControl)
+	c.JSON(http.StatusOK, e.discoverySvc.PublicKeys())
+}
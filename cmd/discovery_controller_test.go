@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/directory/pkg/repository"
+	"github.com/mimir-news/directory/pkg/service"
+	"github.com/mimir-news/pkg/httputil/auth"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/square/go-jose.v2"
+)
+
+func TestHandleDiscovery(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := getTestConfig()
+	mockEnv := getTestEnv(conf, nil, nil, nil)
+	server := newServer(mockEnv, conf)
+
+	req := createTestGetRequest("client-id", "", "/.well-known/openid-configuration")
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+	assert.NotEmpty(res.Header().Get("Cache-Control"))
+
+	var discovery domain.OpenIDConfiguration
+	err := json.NewDecoder(res.Body).Decode(&discovery)
+	assert.NoError(err)
+	assert.Equal(conf.IssuerURL, discovery.Issuer)
+	assert.Equal(conf.IssuerURL+"/.well-known/jwks.json", discovery.JWKSURI)
+	assert.Equal([]string{service.ES256Algorithm}, discovery.IDTokenSigningAlgValuesSupported)
+
+	req = createTestGetRequest("client-id", "", "/.well-known/jwks.json")
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+
+	var jwks jose.JSONWebKeySet
+	err = json.NewDecoder(res.Body).Decode(&jwks)
+	assert.NoError(err)
+	assert.Len(jwks.Keys, 1)
+	assert.Equal("test-key", jwks.Keys[0].KeyID)
+	assert.True(jwks.Keys[0].IsPublic())
+}
+
+func TestRequireTokenWithSigningKeys(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := getTestConfig()
+	userID := "user-0"
+	sessionRepo := &repository.MockSessionRepo{}
+	mockEnv := getTestEnv(conf, nil, sessionRepo, nil)
+	server := newServer(mockEnv, conf)
+
+	signer := service.NewTokenSigner(conf.TokenKeyring, conf.IssuerURL, time.Hour)
+	token, err := signer.Sign("token-0", auth.User{ID: userID, Role: auth.UserRole})
+	assert.NoError(err)
+
+	req := createTestGetRequest("client-id", token, "/v1/users/"+userID+"/sessions")
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Equal(1, sessionRepo.FindByUserIDInvocation)
+
+	// Tokens signed with a key that is not in the keyring are rejected.
+	otherConf := getTestConfig()
+	otherSigner := service.NewTokenSigner(otherConf.TokenKeyring, conf.IssuerURL, time.Hour)
+	otherToken, err := otherSigner.Sign("token-1", auth.User{ID: userID, Role: auth.UserRole})
+	assert.NoError(err)
+
+	req = createTestGetRequest("client-id", otherToken, "/v1/users/"+userID+"/sessions")
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusUnauthorized, res.Code)
+	assert.Equal(1, sessionRepo.FindByUserIDInvocation)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/pkg/httputil"
+)
+
+func (e *env) handleChangeEmail(c *gin.Context) {
+	userID, err := getUserIDFromPath(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	change, err := getEmailChange(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	err = e.emailChangeSvc.RequestChange(userID, change)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	httputil.SendOK(c)
+}
+
+func (e *env) handleEmailChangeConfirmation(c *gin.Context) {
+	userID := c.Param("userId")
+	confirmation, err := getEmailVerification(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	err = e.emailChangeSvc.Confirm(userID, confirmation.Token, getClientInfo(c))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	httputil.SendOK(c)
+}
+
+func (e *env) handleEmailChangeRevert(c *gin.Context) {
+	userID := c.Param("userId")
+	revert, err := getEmailVerification(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	err = e.emailChangeSvc.Revert(userID, revert.Token, getClientInfo(c))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	httputil.SendOK(c)
+}
+
+func getEmailChange(c *gin.Context) (domain.EmailChange, error) {
+	var change domain.EmailChange
+	err := c.ShouldBindJSON(&change)
+	if err != nil {
+		return change, httputil.ErrBadRequest()
+	}
+	if !change.Valid() {
+		return change, httputil.ErrBadRequest()
+	}
+	return change, nil
+}
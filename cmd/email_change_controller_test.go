@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/directory/pkg/repository"
+	"github.com/mimir-news/directory/pkg/service"
+	"github.com/mimir-news/pkg/httputil/auth"
+	"github.com/mimir-news/pkg/id"
+	"github.com/mimir-news/pkg/schema/user"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleEmailChange(t *testing.T) {
+	assert := assert.New(t)
+
+	userID := id.New()
+	clientID := id.New()
+	userEmail := "main@mail.com"
+	newEmail := "new.mail@mail.com"
+
+	expectedUser := domain.FullUser{
+		User: user.User{
+			ID:    userID,
+			Email: userEmail,
+		},
+		Credentials: domain.StoredCredentials{
+			Email:    userEmail,
+			Password: encryptedPassword,
+			Salt:     encryptedSalt,
+		},
+	}
+
+	conf := getTestConfig()
+	userRepo := &repository.MockUserRepo{
+		FindUser:       expectedUser,
+		FindByEmailErr: repository.ErrNoSuchUser,
+	}
+	credentialRepo := &repository.MockOneTimeCredentialRepo{}
+	mailer := &service.MockMailer{}
+	mockEnv := getTestEnv(conf, userRepo, nil, nil)
+	mockEnv.emailChangeSvc = service.NewEmailChangeService(mockEnv.passwordSvc, mailer, userRepo, nil, credentialRepo, &service.MockEventRecorder{})
+	authToken := getTestToken(conf, userID, clientID)
+	server := newServer(mockEnv, conf)
+
+	change := domain.EmailChange{
+		Email:    newEmail,
+		Password: correctPassword,
+	}
+
+	// Setup: Change email happy path.
+	req := createTestPutRequest(clientID, authToken, "/v1/users/"+userID+"/email", change)
+	res := performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Equal(userID, userRepo.FindArg)
+	assert.Equal(newEmail, credentialRepo.SaveArg.Email)
+	assert.Equal(domain.EmailChangeCredential, credentialRepo.SaveArg.Purpose)
+	assert.Equal(1, len(mailer.Outbox))
+	assert.Equal(newEmail, mailer.Outbox[0].To)
+
+	// Setup: Change email without password.
+	credentialRepo.UnsetArgs()
+	userRepo.FindArg = ""
+	req = createTestPutRequest(clientID, authToken, "/v1/users/"+userID+"/email", domain.EmailChange{Email: newEmail})
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusBadRequest, res.Code)
+	assert.Equal("", userRepo.FindArg)
+	assert.Equal(0, credentialRepo.SaveInvocation)
+
+	// Setup: Change email to taken address.
+	userRepo.FindByEmailErr = nil
+	userRepo.FindByEmailUser = domain.FullUser{User: user.User{ID: id.New(), Email: newEmail}}
+	req = createTestPutRequest(clientID, authToken, "/v1/users/"+userID+"/email", change)
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusConflict, res.Code)
+	assert.Equal(0, credentialRepo.SaveInvocation)
+
+	// Setup: Change email of other user.
+	req = createTestPutRequest(clientID, authToken, "/v1/users/wrong-id/email", change)
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusForbidden, res.Code)
+	assert.Equal(0, credentialRepo.SaveInvocation)
+}
+
+func TestHandleEmailChangeConfirmation(t *testing.T) {
+	assert := assert.New(t)
+
+	key := "my-change-key"
+	userID := id.New()
+	oldEmail := "mail@mail.com"
+	newEmail := "new.mail@mail.com"
+	storedUser := domain.FullUser{
+		User: user.User{
+			ID:    userID,
+			Email: oldEmail,
+		},
+	}
+	credential := domain.NewOneTimeCredential(domain.EmailChangeCredential, fmt.Sprintf("%x", auth.HashKey(key)), userID, time.Hour)
+	credential.Email = newEmail
+
+	conf := getTestConfig()
+	userRepo := &repository.MockUserRepo{
+		FindUser:       storedUser,
+		FindByEmailErr: repository.ErrNoSuchUser,
+	}
+	sessionRepo := &repository.MockSessionRepo{}
+	credentialRepo := &repository.MockOneTimeCredentialRepo{
+		FindByKeyCredential: credential,
+	}
+	mailer := &service.MockMailer{}
+	mockEnv := getTestEnv(conf, userRepo, sessionRepo, nil)
+	mockEnv.emailChangeSvc = service.NewEmailChangeService(mockEnv.passwordSvc, mailer, userRepo, sessionRepo, credentialRepo, &service.MockEventRecorder{})
+	server := newServer(mockEnv, conf)
+
+	// Setup: Confirm email change happy path, no auth token is needed.
+	req := createTestPostRequest("", "", "/v1/users/"+userID+"/email/confirm", domain.EmailVerification{Token: key})
+	res := performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Equal(credential.ID, credentialRepo.MarkUsedArg)
+	assert.Equal(newEmail, userRepo.SaveArg.User.Email)
+	assert.Equal(userID, sessionRepo.DeleteByUserIDArg)
+	assert.Equal(1, len(mailer.Outbox))
+	assert.Equal(oldEmail, mailer.Outbox[0].To)
+
+	// Setup: Revert email change with the wrong kind of key.
+	credentialRepo.UnsetArgs()
+	req = createTestPostRequest("", "", "/v1/users/"+userID+"/email/revert", domain.EmailVerification{Token: key})
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusForbidden, res.Code)
+	assert.Equal(domain.EmailRevertCredential, credentialRepo.FindByKeyArgPurpose)
+	assert.Equal(0, credentialRepo.MarkUsedInvocation)
+
+	// Setup: No key provided.
+	credentialRepo.UnsetArgs()
+	req = createTestPostRequest("", "", "/v1/users/"+userID+"/email/confirm", domain.EmailVerification{})
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusBadRequest, res.Code)
+	assert.Equal(0, credentialRepo.FindByKeyInvocation)
+}
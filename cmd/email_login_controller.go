@@ -0,0 +1,76 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/directory/pkg/service"
+	"github.com/mimir-news/pkg/httputil"
+)
+
+func (e *env) handleEmailLoginRequest(c *gin.Context) {
+	request, err := getEmailLoginRequest(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	err = e.rateLimit(c, service.EmailLoginAction, request.Email)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	err = e.emailLoginSvc.RequestLogin(request)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	httputil.SendOK(c)
+}
+
+func (e *env) handleEmailLogin(c *gin.Context) {
+	login, err := getEmailLogin(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	err = e.rateLimit(c, service.EmailCodeAction, login.Email)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	result, err := e.userSvc.AuthenticateEmail(login, getClientInfo(c))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	sendLoginResult(c, result)
+}
+
+func getEmailLoginRequest(c *gin.Context) (domain.EmailLoginRequest, error) {
+	var request domain.EmailLoginRequest
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		return request, httputil.ErrBadRequest()
+	}
+	if !request.Valid() {
+		return request, httputil.ErrBadRequest()
+	}
+	return request, nil
+}
+
+func getEmailLogin(c *gin.Context) (domain.EmailLogin, error) {
+	var login domain.EmailLogin
+	err := c.ShouldBindJSON(&login)
+	if err != nil {
+		return login, httputil.ErrBadRequest()
+	}
+	if !login.Valid() {
+		return login, httputil.ErrBadRequest()
+	}
+	return login, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/directory/pkg/repository"
+	"github.com/mimir-news/directory/pkg/service"
+	"github.com/mimir-news/pkg/httputil/auth"
+	"github.com/mimir-news/pkg/id"
+	"github.com/mimir-news/pkg/schema/user"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleEmailLogin(t *testing.T) {
+	assert := assert.New(t)
+
+	email := "mail@mail.com"
+	token := "my-login-token"
+	storedUser := domain.FullUser{
+		User: user.User{
+			ID:    id.New(),
+			Email: email,
+			Role:  auth.UserRole,
+		},
+		EmailVerified: true,
+	}
+	credential := domain.NewOneTimeCredential(domain.EmailLoginLinkCredential, fmt.Sprintf("%x", auth.HashKey(token)), storedUser.User.ID, time.Minute)
+
+	conf := getTestConfig()
+	userRepo := &repository.MockUserRepo{
+		FindUser:        storedUser,
+		FindByEmailUser: storedUser,
+	}
+	sessionRepo := &repository.MockSessionRepo{}
+	credentialRepo := &repository.MockOneTimeCredentialRepo{
+		FindByKeyCredential: credential,
+	}
+	mailer := &service.MockMailer{}
+	mockEnv := getTestEnv(conf, userRepo, sessionRepo, nil)
+	mockEnv.emailLoginSvc = service.NewEmailLoginService(conf.EmailLoginURL, mockEnv.passwordSvc, mailer, userRepo, credentialRepo, &service.MockEventRecorder{})
+	mockEnv.userSvc = service.NewUserService(
+		mockEnv.passwordSvc, mockEnv.verificationSvc, mockEnv.mfaSvc, mockEnv.emailLoginSvc, mockEnv.externalLoginSvc, getTestSigner(conf),
+		auth.NewVerifier(conf.JWTCredentials, 0), service.NewRefreshTokenHasher(conf.RefreshTokenKey),
+		userRepo, sessionRepo, nil, &service.MockEventRecorder{})
+	server := newServer(mockEnv, conf)
+
+	// Setup: Request a login code, no auth token is needed.
+	req := createTestPostRequest("client-id", "", "/v1/login/email", domain.EmailLoginRequest{Email: email, Method: domain.EmailLoginCode})
+	res := performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Equal(domain.EmailLoginCodeCredential, credentialRepo.SaveArg.Purpose)
+	assert.Equal(1, len(mailer.Outbox))
+	assert.Equal(email, mailer.Outbox[0].To)
+
+	// Setup: Unknown login method.
+	credentialRepo.UnsetArgs()
+	req = createTestPostRequest("client-id", "", "/v1/login/email", domain.EmailLoginRequest{Email: email, Method: "SMS"})
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusBadRequest, res.Code)
+	assert.Equal(0, credentialRepo.SaveInvocation)
+
+	// Setup: Redeem a login link.
+	req = createTestPostRequest("client-id", "", "/v1/login/email/verify", domain.EmailLogin{Token: token})
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusOK, res.Code)
+	var loginToken user.Token
+	err := json.NewDecoder(res.Body).Decode(&loginToken)
+	assert.NoError(err)
+	assert.Equal(storedUser.User.ID, loginToken.User.ID)
+	assert.NotEqual("", loginToken.RefreshToken)
+	assert.Equal(storedUser.User.ID, sessionRepo.SaveArg.UserID)
+	assert.Equal(credential.ID, credentialRepo.MarkUsedArg)
+
+	// Setup: Redeem a used login link.
+	credentialRepo.UnsetArgs()
+	sessionRepo.SaveArg = domain.Session{}
+	usedCredential := credential
+	usedCredential.HasBeenUsed = true
+	credentialRepo.FindByKeyCredential = usedCredential
+	req = createTestPostRequest("client-id", "", "/v1/login/email/verify", domain.EmailLogin{Token: token})
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusUnauthorized, res.Code)
+	assert.Equal("", sessionRepo.SaveArg.UserID)
+
+	// Setup: Redeem with both a token and a code.
+	req = createTestPostRequest("client-id", "", "/v1/login/email/verify", domain.EmailLogin{Token: token, Email: email, Code: "123456"})
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusBadRequest, res.Code)
+}
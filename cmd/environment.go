@@ -8,14 +8,28 @@ import (
 	"github.com/mimir-news/directory/pkg/repository"
 	"github.com/mimir-news/directory/pkg/service"
 	"github.com/mimir-news/pkg/dbutil"
-	"github.com/mimir-news/pkg/httputil/auth"
 )
 
 type env struct {
-	passwordSvc  *service.PasswordService
-	watchlistSvc service.WatchlistService
-	userSvc      service.UserService
-	db           *sql.DB
+	passwordSvc      *service.PasswordService
+	watchlistSvc     service.WatchlistService
+	userSvc          service.UserService
+	passwordResetSvc service.PasswordResetService
+	verificationSvc  service.EmailVerificationService
+	sessionSvc       service.SessionService
+	emailChangeSvc   service.EmailChangeService
+	mfaSvc           service.MFAService
+	emailLoginSvc    service.EmailLoginService
+	externalLoginSvc service.ExternalLoginService
+	retentionSvc     service.RetentionService
+	discoverySvc     service.DiscoveryService
+	oauthSvc         service.OAuthService
+	apiKeySvc        service.APIKeyService
+	adminSvc         service.AdminService
+	auditSvc         service.AuditService
+	exportSvc        service.ExportService
+	rateLimiter      service.RateLimiter
+	db               *sql.DB
 }
 
 func setupEnv(conf config) *env {
@@ -28,19 +42,67 @@ func setupEnv(conf config) *env {
 	userRepo := repository.NewUserRepo(db)
 	sessionRepo := repository.NewSessionRepo(db)
 	watchlsitRepo := repository.NewWatchlistRepo(db)
+	credentialRepo := repository.NewOneTimeCredentialRepo(db)
+	recoveryCodeRepo := repository.NewRecoveryCodeRepo(db)
+	externalIdentityRepo := repository.NewExternalIdentityRepo(db)
+	apiKeyRepo := repository.NewAPIKeyRepo(db)
+	auditEventRepo := repository.NewAuditEventRepo(db)
+	rateLimitRepo := repository.NewRateLimitRepo(db)
+	serviceAccountRepo := repository.NewServiceAccountRepo(db)
 
-	passwordSvc := service.NewPasswordService(userRepo, conf.PasswordPepper, conf.PasswordEncryptionKey)
-	signer := auth.NewSigner(conf.JWTCredentials, 24*time.Hour)
-	verifier := auth.NewVerifier(conf.JWTCredentials, 365*24*time.Hour)
+	passwordSvc := service.NewPasswordService(
+		userRepo, conf.PasswordPepper, conf.EncryptionKeyring, conf.HashingConfig, conf.LockoutPolicy)
+	signer := service.NewTokenSigner(conf.TokenKeyring, conf.IssuerURL, 24*time.Hour)
+	legacyVerifier := newLegacyTokenVerifier(conf, 365*24*time.Hour)
+	verifier := service.NewTokenVerifier(conf.TokenKeyring, conf.IssuerURL, 365*24*time.Hour, legacyVerifier)
 
-	userService := service.NewUserService(passwordSvc, signer, verifier, userRepo, sessionRepo)
+	smtp := conf.SMTP
+	mailer := service.NewSMTPMailer(smtp.Host, smtp.Port, smtp.Username, smtp.Password, smtp.Sender)
+	verificationSvc := service.NewEmailVerificationService(conf.EmailVerificationPolicy, mailer, userRepo, credentialRepo)
+
+	events := service.NewAuditEventRecorder(auditEventRepo)
+	mfaSvc := service.NewMFAService(passwordSvc, conf.EncryptionKeyring, userRepo, recoveryCodeRepo, credentialRepo, events)
+	emailLoginSvc := service.NewEmailLoginService(conf.EmailLoginURL, passwordSvc, mailer, userRepo, credentialRepo, events)
+	externalLoginSvc := service.NewExternalLoginService(conf.OIDCProviders, userRepo, externalIdentityRepo)
+	tokenHasher := service.NewRefreshTokenHasher(conf.RefreshTokenKey)
+	userService := service.NewUserService(
+		passwordSvc, verificationSvc, mfaSvc, emailLoginSvc, externalLoginSvc, signer, verifier,
+		tokenHasher, userRepo, sessionRepo, watchlsitRepo, events)
 	watchlistSvc := service.NewWatchlistService(watchlsitRepo)
+	passwordResetSvc := service.NewPasswordResetService(passwordSvc, mailer, userRepo, sessionRepo, credentialRepo, events)
+	sessionSvc := service.NewSessionService(verifier, sessionRepo, events)
+	emailChangeSvc := service.NewEmailChangeService(passwordSvc, mailer, userRepo, sessionRepo, credentialRepo, events)
+	retentionSvc := service.NewRetentionService(userRepo, conf.GuestRetention, conf.DeletionGracePeriod)
+	discoverySvc := service.NewDiscoveryService(conf.TokenKeyring, conf.IssuerURL)
+	oauthSvc := service.NewOAuthService(
+		conf.ServiceClients, newAccessTokenVerifier(conf), tokenHasher, userRepo, sessionRepo, events)
+	apiKeySvc := service.NewAPIKeyService(verificationSvc, userRepo, apiKeyRepo)
+	adminSvc := service.NewAdminService(
+		passwordResetSvc, apiKeySvc, userRepo, sessionRepo, serviceAccountRepo, auditEventRepo, events)
+	auditSvc := service.NewAuditService(auditEventRepo)
+	exportSvc := service.NewExportService(userRepo, sessionRepo, watchlsitRepo, auditEventRepo, events)
+	rateLimiter := service.NewRateLimiter(conf.RateLimitPolicy, rateLimitRepo)
 
 	return &env{
-		passwordSvc:  passwordSvc,
-		watchlistSvc: watchlistSvc,
-		userSvc:      userService,
-		db:           db,
+		passwordSvc:      passwordSvc,
+		watchlistSvc:     watchlistSvc,
+		userSvc:          userService,
+		passwordResetSvc: passwordResetSvc,
+		verificationSvc:  verificationSvc,
+		sessionSvc:       sessionSvc,
+		emailChangeSvc:   emailChangeSvc,
+		mfaSvc:           mfaSvc,
+		emailLoginSvc:    emailLoginSvc,
+		externalLoginSvc: externalLoginSvc,
+		retentionSvc:     retentionSvc,
+		discoverySvc:     discoverySvc,
+		oauthSvc:         oauthSvc,
+		apiKeySvc:        apiKeySvc,
+		adminSvc:         adminSvc,
+		auditSvc:         auditSvc,
+		exportSvc:        exportSvc,
+		rateLimiter:      rateLimiter,
+		db:               db,
 	}
 }
 
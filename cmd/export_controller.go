@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mimir-news/directory/pkg/service"
+	"github.com/mimir-news/pkg/httputil"
+)
+
+// Export formats.
+const (
+	jsonExportFormat = "json"
+	zipExportFormat  = "zip"
+)
+
+const zipContentType = "application/zip"
+
+func (e *env) handleExportUser(c *gin.Context) {
+	userID, err := getUserIDFromPath(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	format := c.DefaultQuery("format", jsonExportFormat)
+	if format != jsonExportFormat && format != zipExportFormat {
+		c.Error(httputil.ErrBadRequest())
+		return
+	}
+
+	export, err := e.exportSvc.Export(userID, getClientInfo(c))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	if format == jsonExportFormat {
+		c.JSON(http.StatusOK, export)
+		return
+	}
+
+	// The archive is written to a buffer so that failures can still be reported as errors.
+	var archive bytes.Buffer
+	err = service.WriteExportArchive(&archive, export)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="export-%s.zip"`, userID))
+	c.Data(http.StatusOK, zipContentType, archive.Bytes())
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/directory/pkg/repository"
+	"github.com/mimir-news/pkg/id"
+	"github.com/mimir-news/pkg/schema/user"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleExportUser(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := getTestConfig()
+	userID := id.New()
+	userRepo := &repository.MockUserRepo{
+		FindUser: domain.FullUser{User: user.User{ID: userID, Email: "mail@mail.com"}},
+	}
+	listRepo := &repository.MockWatchlistRepo{
+		FindByUserIDWatchlists: []domain.ExportedWatchlist{{ID: "list-1", Name: "My list"}},
+	}
+	sessionRepo := &repository.MockSessionRepo{}
+	mockEnv := getTestEnv(conf, userRepo, sessionRepo, listRepo)
+	server := newServer(mockEnv, conf)
+	token := getTestToken(conf, userID, "client-id")
+
+	// Setup: Export as JSON.
+	req := createTestGetRequest("client-id", token, "/v1/users/"+userID+"/export")
+	res := performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusOK, res.Code)
+	var export domain.UserExport
+	err := json.NewDecoder(res.Body).Decode(&export)
+	assert.NoError(err)
+	assert.Equal(userID, export.Profile.ID)
+	assert.Len(export.Watchlists, 1)
+	assert.Equal(userID, sessionRepo.FindAllByUserIDArg)
+
+	// Setup: Export as a ZIP archive.
+	req = createTestGetRequest("client-id", token, "/v1/users/"+userID+"/export?format=zip")
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Equal(zipContentType, res.Header().Get("Content-Type"))
+	assert.Contains(res.Header().Get("Content-Disposition"), "export-"+userID+".zip")
+	body := res.Body.Bytes()
+	archive, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	assert.NoError(err)
+	assert.Len(archive.File, 4)
+
+	// Setup: Use an unknown format.
+	listRepo.UnsetArgs()
+	req = createTestGetRequest("client-id", token, "/v1/users/"+userID+"/export?format=xml")
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusBadRequest, res.Code)
+	assert.Equal("", listRepo.FindByUserIDArg)
+
+	// Setup: Export the data of another user.
+	req = createTestGetRequest("client-id", token, "/v1/users/"+id.New()+"/export")
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusForbidden, res.Code)
+	assert.Equal("", listRepo.FindByUserIDArg)
+
+	// Setup: Failures are reported as errors.
+	listRepo.FindByUserIDErr = expectedTestError
+	req = createTestGetRequest("client-id", token, "/v1/users/"+userID+"/export?format=zip")
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusInternalServerError, res.Code)
+	assert.NotEqual(zipContentType, res.Header().Get("Content-Type"))
+}
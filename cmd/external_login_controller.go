@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/directory/pkg/service"
+	"github.com/mimir-news/pkg/httputil"
+)
+
+const (
+	externalLoginCookie     = "directory_login_binding"
+	externalLoginCookiePath = "/v1/login/oidc/"
+)
+
+func (e *env) handleExternalLoginRedirect(c *gin.Context) {
+	authURL, binding, err := e.externalLoginSvc.AuthorizationURL(c.Param("provider"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	setExternalLoginCookie(c, binding, int(service.ExternalLoginStateTTL.Seconds()))
+	c.Redirect(http.StatusFound, authURL)
+}
+
+func (e *env) handleExternalLoginCallback(c *gin.Context) {
+	callback, err := getExternalLoginCallback(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	callback.Binding, _ = c.Cookie(externalLoginCookie)
+	setExternalLoginCookie(c, "", -1)
+
+	result, err := e.userSvc.AuthenticateExternal(c.Param("provider"), callback, getClientInfo(c))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	sendLoginResult(c, result)
+}
+
+func getExternalLoginCallback(c *gin.Context) (domain.ExternalLoginCallback, error) {
+	var callback domain.ExternalLoginCallback
+	err := c.ShouldBindQuery(&callback)
+	if err != nil {
+		return callback, httputil.ErrBadRequest()
+	}
+	if !callback.Valid() {
+		return callback, httputil.ErrBadRequest()
+	}
+	return callback, nil
+}
+
+// setExternalLoginCookie sets the cookie which binds an external login to the browser that started it.
+// The cookie is sent along with the top level redirect back from the provider, but not to other sites.
+func setExternalLoginCookie(c *gin.Context, binding string, maxAge int) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     externalLoginCookie,
+		Value:    binding,
+		Path:     externalLoginCookiePath,
+		MaxAge:   maxAge,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/mimir-news/directory/pkg/repository"
+	"github.com/mimir-news/directory/pkg/service"
+	"github.com/mimir-news/pkg/httputil/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleExternalLogin(t *testing.T) {
+	assert := assert.New(t)
+
+	var issuerURL string
+	issuer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuerURL,
+			"authorization_endpoint": issuerURL + "/authorize",
+			"token_endpoint":         issuerURL + "/token",
+			"jwks_uri":               issuerURL + "/jwks",
+		})
+	}))
+	defer issuer.Close()
+	issuerURL = issuer.URL
+
+	conf := getTestConfig()
+	conf.OIDCProviders = []service.OIDCProviderConfig{
+		{
+			Name:        "test",
+			Issuer:      issuerURL,
+			ClientID:    "directory-client",
+			RedirectURL: "https://example.com/login/oidc/test/callback",
+		},
+	}
+	userRepo := &repository.MockUserRepo{}
+	sessionRepo := &repository.MockSessionRepo{}
+	identityRepo := &repository.MockExternalIdentityRepo{}
+	mockEnv := getTestEnv(conf, userRepo, sessionRepo, nil)
+	mockEnv.externalLoginSvc = service.NewExternalLoginService(conf.OIDCProviders, userRepo, identityRepo)
+	mockEnv.userSvc = service.NewUserService(
+		mockEnv.passwordSvc, mockEnv.verificationSvc, mockEnv.mfaSvc, mockEnv.emailLoginSvc, mockEnv.externalLoginSvc,
+		getTestSigner(conf), auth.NewVerifier(conf.JWTCredentials, 0), service.NewRefreshTokenHasher(conf.RefreshTokenKey),
+		userRepo, sessionRepo, nil, &service.MockEventRecorder{})
+	server := newServer(mockEnv, conf)
+
+	// Setup: Start a login, no auth token is needed.
+	req := createTestGetRequest("client-id", "", "/v1/login/oidc/test")
+	res := performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusFound, res.Code)
+	location, err := url.Parse(res.Header().Get("Location"))
+	assert.NoError(err)
+	assert.Equal(issuerURL+"/authorize", location.Scheme+"://"+location.Host+location.Path)
+	assert.Equal("directory-client", location.Query().Get("client_id"))
+	assert.Equal("S256", location.Query().Get("code_challenge_method"))
+	assert.Equal("test", identityRepo.SaveLoginStateArg.Provider)
+	cookies := res.Result().Cookies()
+	assert.Len(cookies, 1)
+	binding := cookies[0]
+	assert.Equal(externalLoginCookie, binding.Name)
+	assert.NotEmpty(binding.Value)
+	assert.True(binding.HttpOnly)
+	assert.True(binding.Secure)
+	assert.Equal(http.SameSiteLaxMode, binding.SameSite)
+	assert.True(binding.MaxAge > 0)
+	state := location.Query().Get("state")
+
+	// Setup: Callback from a browser which did not start the login.
+	identityRepo.ConsumeLoginStateState = identityRepo.SaveLoginStateArg
+	req = createTestGetRequest("client-id", "", "/v1/login/oidc/test/callback?code=some-code&state="+state)
+	req.AddCookie(&http.Cookie{Name: externalLoginCookie, Value: "other-binding"})
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusUnauthorized, res.Code)
+	assert.Equal(1, identityRepo.ConsumeLoginStateInvocation)
+	assert.Equal(0, sessionRepo.SaveInvocation)
+
+	// Setup: Callback without the binding cookie.
+	req = createTestGetRequest("client-id", "", "/v1/login/oidc/test/callback?code=some-code&state="+state)
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusUnauthorized, res.Code)
+	assert.Equal(2, identityRepo.ConsumeLoginStateInvocation)
+	assert.Equal(0, sessionRepo.SaveInvocation)
+
+	// Setup: Start a login with an unknown provider.
+	identityRepo.UnsetArgs()
+	req = createTestGetRequest("client-id", "", "/v1/login/oidc/unknown")
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusNotFound, res.Code)
+	assert.Equal(0, identityRepo.SaveLoginStateInvocation)
+
+	// Setup: Callback with an unknown state.
+	identityRepo.ConsumeLoginStateErr = repository.ErrNoSuchExternalLoginState
+	req = createTestGetRequest("client-id", "", "/v1/login/oidc/test/callback?code=some-code&state=some-state")
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusUnauthorized, res.Code)
+	assert.Equal(1, identityRepo.ConsumeLoginStateInvocation)
+	assert.Equal(0, sessionRepo.SaveInvocation)
+
+	// Setup: Callback without a state.
+	identityRepo.UnsetArgs()
+	req = createTestGetRequest("client-id", "", "/v1/login/oidc/test/callback?code=some-code")
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusBadRequest, res.Code)
+	assert.Equal(0, identityRepo.ConsumeLoginStateInvocation)
+}
@@ -3,9 +3,11 @@ package main
 import (
 	"log"
 	"net/http"
+	"os"
 
 	"github.com/gin-gonic/gin"
 	_ "github.com/lib/pq"
+	"github.com/mimir-news/directory/pkg/domain"
 	"github.com/mimir-news/pkg/dbutil"
 	"github.com/mimir-news/pkg/httputil"
 	"github.com/mimir-news/pkg/httputil/auth"
@@ -15,6 +17,13 @@ func main() {
 	conf := getConfig()
 	e := setupEnv(conf)
 	defer e.close()
+
+	if len(os.Args) > 1 && os.Args[1] == reencryptCommand {
+		runReencryption(e)
+		return
+	}
+
+	startRetentionJob(e, retentionInterval)
 	server := newServer(e, conf)
 
 	log.Printf("Starting %s on port: %s\n", ServiceName, conf.Port)
@@ -27,23 +36,58 @@ func main() {
 func newServer(e *env, conf config) *http.Server {
 	r := newRouter(e, conf)
 
-	disallowAnonymous := auth.DisallowRoles(auth.AnonymousRole)
+	disallowAnonymous := disallowRoles(auth.AnonymousRole)
+	disallowUnverified := disallowRoles(auth.AnonymousRole, domain.UnverifiedRole)
 
 	// Unsecured enpoints
 	r.POST("/v1/users", e.handleUserCreation)
 	r.POST("/v1/login", e.handleLogin)
 	r.PUT("/v1/login", e.handleTokenRenewal)
+	r.DELETE("/v1/login", e.handleLogout)
 	r.GET("/v1/login/anonymous", e.getAnonymousToken)
+	r.POST("/v1/login/guest", e.handleGuestLogin)
+	r.POST("/v1/login/mfa", e.handleMFALogin)
+	r.POST("/v1/login/email", e.handleEmailLoginRequest)
+	r.POST("/v1/login/email/verify", e.handleEmailLogin)
+	r.GET("/v1/login/oidc/:provider", e.handleExternalLoginRedirect)
+	r.GET("/v1/login/oidc/:provider/callback", e.handleExternalLoginCallback)
+	r.POST("/v1/password-reset", e.handlePasswordResetRequest)
+	r.PUT("/v1/password-reset/:token", e.handlePasswordReset)
+	r.POST("/v1/users/:userId/email/verify", e.handleEmailVerification)
+	r.POST("/v1/users/:userId/email/verify/resend", e.handleEmailVerificationResend)
+	r.POST("/v1/users/:userId/email/confirm", e.handleEmailChangeConfirmation)
+	r.POST("/v1/users/:userId/email/revert", e.handleEmailChangeRevert)
+	r.GET("/.well-known/openid-configuration", e.handleGetOpenIDConfiguration)
+	r.GET("/.well-known/jwks.json", e.handleGetJWKS)
+
+	// Routes secured with service client credentials
+	oauthGroup := r.Group("/v1/oauth", e.requireServiceClient)
+	oauthGroup.POST("/introspect", e.handleTokenIntrospection)
+	oauthGroup.POST("/revoke", e.handleTokenRevocation)
 
 	// Secured user routes
-	userGroup := r.Group("/v1/users", disallowAnonymous)
+	userGroup := r.Group("/v1/users", disallowAnonymous, requireScope(domain.UsersReadScope, ""))
 	userGroup.GET("/:userId", e.handleGetUser)
 	userGroup.PUT("/:userId/password", e.handleChangePassword)
 	userGroup.PUT("/:userId/email", e.handleChangeEmail)
 	userGroup.DELETE("/:userId", e.handleDeleteUser)
+	userGroup.GET("/:userId/sessions", e.handleGetSessions)
+	userGroup.GET("/:userId/events", e.handleGetEvents)
+	// Exports contain all personal data of a user and can not be made with API keys.
+	userGroup.GET("/:userId/export", requireScope("", ""), e.handleExportUser)
+	userGroup.DELETE("/:userId/sessions", e.handleLogoutAll)
+	userGroup.DELETE("/:userId/sessions/:sessionId", e.handleDeleteSession)
+	userGroup.POST("/:userId/mfa/totp", e.handleTOTPEnrollment)
+	userGroup.POST("/:userId/mfa/totp/confirm", e.handleTOTPConfirmation)
+	userGroup.POST("/:userId/mfa/totp/disable", e.handleTOTPDisable)
+	userGroup.POST("/:userId/mfa/recovery-codes", e.handleRecoveryCodeRegeneration)
+	userGroup.POST("/:userId/api-keys", e.handleCreateAPIKey)
+	userGroup.GET("/:userId/api-keys", e.handleGetAPIKeys)
+	userGroup.DELETE("/:userId/api-keys/:keyId", e.handleRevokeAPIKey)
 
 	// Secured watchlist routes
-	watchlistGroup := r.Group("/v1/watchlists", disallowAnonymous)
+	watchlistGroup := r.Group("/v1/watchlists", disallowUnverified,
+		requireScope(domain.WatchlistsReadScope, domain.WatchlistsWriteScope))
 	watchlistGroup.POST("/:name", e.handleCreateWatchlist)
 	watchlistGroup.DELETE("/:watchlistId", e.handleDeleteWatchlist)
 	watchlistGroup.GET("/:watchlistId", e.handleGetWatchlist)
@@ -51,6 +95,22 @@ func newServer(e *env, conf config) *http.Server {
 	watchlistGroup.PUT("/:watchlistId/stock/:symbol", e.handleAddStockToWatchlist)
 	watchlistGroup.DELETE("/:watchlistId/stock/:symbol", e.handleDeleteStockFromWatchlist)
 
+	// Secured admin routes, which can not be used with API keys
+	adminGroup := r.Group("/v1/admin", requireRoles(domain.AdminRole), requireScope("", ""))
+	adminGroup.GET("/users", e.handleSearchUsers)
+	adminGroup.GET("/users/:userId", e.handleAdminGetUser)
+	adminGroup.PUT("/users/:userId/lock", e.handleLockUser)
+	adminGroup.DELETE("/users/:userId/lock", e.handleUnlockUser)
+	adminGroup.DELETE("/users/:userId/sessions", e.handleAdminLogout)
+	adminGroup.POST("/users/:userId/password-reset", e.handleAdminPasswordReset)
+	adminGroup.GET("/users/:userId/events", e.handleExportEvents)
+	adminGroup.POST("/service-accounts", e.handleCreateServiceAccount)
+	adminGroup.GET("/service-accounts", e.handleGetServiceAccounts)
+	adminGroup.DELETE("/service-accounts/:accountId", e.handleDeleteServiceAccount)
+	adminGroup.POST("/service-accounts/:accountId/api-keys", e.handleCreateServiceAccountKey)
+	adminGroup.GET("/service-accounts/:accountId/api-keys", e.handleGetServiceAccountKeys)
+	adminGroup.DELETE("/service-accounts/:accountId/api-keys/:keyId", e.handleRevokeServiceAccountKey)
+
 	return &http.Server{
 		Addr:    ":" + conf.Port,
 		Handler: r,
@@ -58,9 +118,9 @@ func newServer(e *env, conf config) *http.Server {
 }
 
 func newRouter(e *env, cfg config) *gin.Engine {
-	authOpts := auth.NewOptions(cfg.JWTCredentials, cfg.UnsecuredRoutes...)
 	r := httputil.NewRouter(ServiceName, ServiceVersion, e.healthCheck)
-	r.Use(auth.RequireToken(authOpts))
+	r.Use(resolveClientIP(cfg.TrustedProxies))
+	r.Use(requireToken(newAccessTokenVerifier(cfg), e.apiKeySvc, cfg.UnsecuredRoutes, cfg.UnsecuredRoutePatterns))
 
 	return r
 }
@@ -2,6 +2,9 @@ package main
 
 import (
 	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"encoding/json"
 	"io"
 	"log"
@@ -72,15 +75,51 @@ func createTestRequest(clientID, token, route, method string, body interface{})
 func getTestEnv(cfg config, userRepo repository.UserRepo,
 	sessionRepo repository.SessionRepo, listRepo repository.WatchlistRepo) *env {
 
-	passwordSvc := service.NewPasswordService(userRepo, cfg.PasswordPepper, cfg.PasswordEncryptionKey)
+	passwordSvc := service.NewPasswordService(
+		userRepo, cfg.PasswordPepper, cfg.EncryptionKeyring, cfg.HashingConfig, cfg.LockoutPolicy)
+	verificationSvc := service.NewEmailVerificationService(
+		cfg.EmailVerificationPolicy, &service.MockMailer{}, userRepo, &repository.MockOneTimeCredentialRepo{})
+	mfaSvc := service.NewMFAService(
+		passwordSvc, cfg.EncryptionKeyring, userRepo, &repository.MockRecoveryCodeRepo{}, &repository.MockOneTimeCredentialRepo{}, &service.MockEventRecorder{})
+	emailLoginSvc := service.NewEmailLoginService(
+		cfg.EmailLoginURL, passwordSvc, &service.MockMailer{}, userRepo, &repository.MockOneTimeCredentialRepo{}, &service.MockEventRecorder{})
+	externalLoginSvc := service.NewExternalLoginService(cfg.OIDCProviders, userRepo, &repository.MockExternalIdentityRepo{})
 	tokenSigner := getTestSigner(cfg)
 	verifier := auth.NewVerifier(cfg.JWTCredentials, 365*24*time.Hour)
-	userSvc := service.NewUserService(passwordSvc, tokenSigner, verifier, userRepo, sessionRepo)
+	userSvc := service.NewUserService(
+		passwordSvc, verificationSvc, mfaSvc, emailLoginSvc, externalLoginSvc, tokenSigner, verifier, service.NewRefreshTokenHasher(cfg.RefreshTokenKey),
+		userRepo, sessionRepo, listRepo, &service.MockEventRecorder{})
 	listSvc := service.NewWatchlistService(listRepo)
+	sessionSvc := service.NewSessionService(verifier, sessionRepo, &service.MockEventRecorder{})
+	discoverySvc := service.NewDiscoveryService(cfg.TokenKeyring, cfg.IssuerURL)
+	oauthSvc := service.NewOAuthService(
+		cfg.ServiceClients, newAccessTokenVerifier(cfg), service.NewRefreshTokenHasher(cfg.RefreshTokenKey), userRepo, sessionRepo, &service.MockEventRecorder{})
+	apiKeySvc := service.NewAPIKeyService(verificationSvc, userRepo, &repository.MockAPIKeyRepo{})
+	passwordResetSvc := service.NewPasswordResetService(
+		passwordSvc, &service.MockMailer{}, userRepo, sessionRepo, &repository.MockOneTimeCredentialRepo{}, &service.MockEventRecorder{})
+	adminSvc := service.NewAdminService(passwordResetSvc, apiKeySvc, userRepo, sessionRepo,
+		&repository.MockServiceAccountRepo{}, &repository.MockAuditEventRepo{}, &service.MockEventRecorder{})
+	auditSvc := service.NewAuditService(&repository.MockAuditEventRepo{})
+	exportSvc := service.NewExportService(
+		userRepo, sessionRepo, listRepo, &repository.MockAuditEventRepo{}, &service.MockEventRecorder{})
+	rateLimiter := service.NewRateLimiter(cfg.RateLimitPolicy, repository.NewMemoryRateLimitRepo())
 	return &env{
-		passwordSvc:  passwordSvc,
-		watchlistSvc: listSvc,
-		userSvc:      userSvc,
+		passwordSvc:      passwordSvc,
+		watchlistSvc:     listSvc,
+		userSvc:          userSvc,
+		passwordResetSvc: passwordResetSvc,
+		verificationSvc:  verificationSvc,
+		sessionSvc:       sessionSvc,
+		mfaSvc:           mfaSvc,
+		emailLoginSvc:    emailLoginSvc,
+		externalLoginSvc: externalLoginSvc,
+		discoverySvc:     discoverySvc,
+		oauthSvc:         oauthSvc,
+		apiKeySvc:        apiKeySvc,
+		adminSvc:         adminSvc,
+		auditSvc:         auditSvc,
+		exportSvc:        exportSvc,
+		rateLimiter:      rateLimiter,
 	}
 }
 
@@ -101,15 +140,44 @@ func getTestToken(conf config, userID, clientID string) string {
 
 func getTestConfig() config {
 	return config{
-		PasswordPepper:        "my-pepper",
-		PasswordEncryptionKey: "my-encryption-key",
-		Port:                  "8080",
-		UnsecuredRoutes:       unsecuredRoutes,
+		PasswordPepper:          "my-pepper",
+		EncryptionKeyring:       service.NewEncryptionKeyring("my-encryption-key"),
+		RefreshTokenKey:         "my-refresh-token-key",
+		Port:                    "8080",
+		UnsecuredRoutes:         unsecuredRoutes,
+		UnsecuredRoutePatterns:  unsecuredRoutePatterns,
+		EmailLoginURL:           "https://example.com/login/email",
+		EmailVerificationPolicy: service.AllowUnverified,
+		HashingConfig:           service.DefaultHashingConfig,
+		LockoutPolicy:           service.DefaultLockoutPolicy,
+		GuestRetention:          30 * 24 * time.Hour,
+		DeletionGracePeriod:     30 * 24 * time.Hour,
+		RateLimitPolicy:         service.DefaultRateLimitPolicy,
 		JWTCredentials: auth.JWTCredentials{
 			Issuer: "directory",
 			Secret: "my-secret",
 		},
+		LegacyTokensAcceptedUntil: time.Now().Add(24 * time.Hour),
+		IssuerURL:                 "http://directory:8080",
+		TokenKeyring:              getTestTokenKeyring(),
+		ServiceClients: []service.ServiceClient{
+			{ID: "test-service", Secret: "test-service-secret"},
+		},
+	}
+}
+
+func getTestTokenKeyring() service.TokenKeyring {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		log.Fatal(err)
 	}
+
+	key, err := service.NewTokenKey("test-key", service.ES256Algorithm, privateKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return service.NewTokenKeyring(key)
 }
 
 func testHandler(c *gin.Context) {
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/directory/pkg/service"
+	"github.com/mimir-news/pkg/httputil"
+)
+
+func (e *env) handleTOTPEnrollment(c *gin.Context) {
+	userID, err := getUserIDFromPath(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	request, err := getTOTPEnrollmentRequest(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	enrollment, err := e.mfaSvc.EnrollTOTP(userID, request.Password)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, enrollment)
+}
+
+func (e *env) handleTOTPConfirmation(c *gin.Context) {
+	userID, err := getUserIDFromPath(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	confirmation, err := getTOTPConfirmation(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	recoveryCodes, err := e.mfaSvc.ConfirmTOTP(userID, confirmation.Code)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, recoveryCodes)
+}
+
+func (e *env) handleTOTPDisable(c *gin.Context) {
+	userID, err := getUserIDFromPath(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	reauth, err := getMFAReauthentication(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	err = e.mfaSvc.DisableTOTP(userID, reauth, getClientInfo(c))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	httputil.SendOK(c)
+}
+
+func (e *env) handleRecoveryCodeRegeneration(c *gin.Context) {
+	userID, err := getUserIDFromPath(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	reauth, err := getMFAReauthentication(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	recoveryCodes, err := e.mfaSvc.RegenerateRecoveryCodes(userID, reauth, getClientInfo(c))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, recoveryCodes)
+}
+
+func (e *env) handleMFALogin(c *gin.Context) {
+	verification, err := getMFAVerification(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	err = e.rateLimit(c, service.MFALoginAction, "")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	token, err := e.userSvc.CompleteMFALogin(verification, getClientInfo(c))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, token)
+}
+
+func getTOTPEnrollmentRequest(c *gin.Context) (domain.TOTPEnrollmentRequest, error) {
+	var request domain.TOTPEnrollmentRequest
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		return request, httputil.ErrBadRequest()
+	}
+	if !request.Valid() {
+		return request, httputil.ErrBadRequest()
+	}
+	return request, nil
+}
+
+func getTOTPConfirmation(c *gin.Context) (domain.TOTPConfirmation, error) {
+	var confirmation domain.TOTPConfirmation
+	err := c.ShouldBindJSON(&confirmation)
+	if err != nil {
+		return confirmation, httputil.ErrBadRequest()
+	}
+	if !confirmation.Valid() {
+		return confirmation, httputil.ErrBadRequest()
+	}
+	return confirmation, nil
+}
+
+func getMFAVerification(c *gin.Context) (domain.MFAVerification, error) {
+	var verification domain.MFAVerification
+	err := c.ShouldBindJSON(&verification)
+	if err != nil {
+		return verification, httputil.ErrBadRequest()
+	}
+	if !verification.Valid() {
+		return verification, httputil.ErrBadRequest()
+	}
+	return verification, nil
+}
+
+func getMFAReauthentication(c *gin.Context) (domain.MFAReauthentication, error) {
+	var reauth domain.MFAReauthentication
+	err := c.ShouldBindJSON(&reauth)
+	if err != nil {
+		return reauth, httputil.ErrBadRequest()
+	}
+	if !reauth.Valid() {
+		return reauth, httputil.ErrBadRequest()
+	}
+	return reauth, nil
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/directory/pkg/repository"
+	"github.com/mimir-news/directory/pkg/service"
+	"github.com/mimir-news/pkg/httputil/auth"
+	"github.com/mimir-news/pkg/id"
+	"github.com/mimir-news/pkg/schema/user"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleTOTPEnrollment(t *testing.T) {
+	assert := assert.New(t)
+
+	userID := id.New()
+	clientID := id.New()
+	storedUser := domain.FullUser{
+		User: user.User{
+			ID:    userID,
+			Email: "mail@mail.com",
+		},
+		Credentials: domain.StoredCredentials{
+			Email:    "mail@mail.com",
+			Password: encryptedPassword,
+			Salt:     encryptedSalt,
+		},
+	}
+
+	conf := getTestConfig()
+	userRepo := &repository.MockUserRepo{
+		FindUser: storedUser,
+	}
+	recoveryCodeRepo := &repository.MockRecoveryCodeRepo{}
+	mockEnv := getTestEnv(conf, userRepo, nil, nil)
+	mockEnv.mfaSvc = service.NewMFAService(mockEnv.passwordSvc, conf.EncryptionKeyring, userRepo, recoveryCodeRepo, nil, &service.MockEventRecorder{})
+	authToken := getTestToken(conf, userID, clientID)
+	server := newServer(mockEnv, conf)
+
+	// Setup: Enroll without the current password.
+	req := createTestPostRequest(clientID, authToken, "/v1/users/"+userID+"/mfa/totp", nil)
+	res := performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusBadRequest, res.Code)
+	assert.Equal(0, userRepo.SaveTOTPSecretInvocation)
+
+	// Setup: Enroll with the wrong password.
+	req = createTestPostRequest(clientID, authToken, "/v1/users/"+userID+"/mfa/totp", domain.TOTPEnrollmentRequest{Password: "wrong-password"})
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusForbidden, res.Code)
+	assert.Equal(0, userRepo.SaveTOTPSecretInvocation)
+
+	// Setup: Enroll happy path.
+	req = createTestPostRequest(clientID, authToken, "/v1/users/"+userID+"/mfa/totp", domain.TOTPEnrollmentRequest{Password: correctPassword})
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusOK, res.Code)
+	var enrollment domain.TOTPEnrollment
+	err := json.NewDecoder(res.Body).Decode(&enrollment)
+	assert.NoError(err)
+	assert.NotEqual("", enrollment.Secret)
+	assert.NotEqual("", enrollment.URI)
+	assert.Equal(userID, userRepo.SaveTOTPSecretArgUserID)
+
+	// Setup: Confirm enrollment with a valid code.
+	storedUser.TOTP.Secret = userRepo.SaveTOTPSecretArgSecret
+	userRepo.FindUser = storedUser
+	code, err := service.TOTPCode(enrollment.Secret, time.Now())
+	assert.NoError(err)
+	req = createTestPostRequest(clientID, authToken, "/v1/users/"+userID+"/mfa/totp/confirm", domain.TOTPConfirmation{Code: code})
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusOK, res.Code)
+	var recoveryCodes domain.RecoveryCodes
+	err = json.NewDecoder(res.Body).Decode(&recoveryCodes)
+	assert.NoError(err)
+	assert.Equal(len(recoveryCodeRepo.ReplaceArgCodes), len(recoveryCodes.Codes))
+	assert.Equal(userID, userRepo.EnableTOTPArg)
+
+	// Setup: Confirm enrollment without a code.
+	recoveryCodeRepo.UnsetArgs()
+	req = createTestPostRequest(clientID, authToken, "/v1/users/"+userID+"/mfa/totp/confirm", domain.TOTPConfirmation{})
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusBadRequest, res.Code)
+	assert.Equal(0, recoveryCodeRepo.ReplaceInvocation)
+
+	// Setup: Enroll other user.
+	userRepo.SaveTOTPSecretInvocation = 0
+	req = createTestPostRequest(clientID, authToken, "/v1/users/wrong-id/mfa/totp", domain.TOTPEnrollmentRequest{Password: correctPassword})
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusForbidden, res.Code)
+	assert.Equal(0, userRepo.SaveTOTPSecretInvocation)
+}
+
+func TestHandleTOTPDisableAndRecoveryCodeRegeneration(t *testing.T) {
+	assert := assert.New(t)
+
+	userID := id.New()
+	clientID := id.New()
+	conf := getTestConfig()
+	totpSecret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	ciphertext, err := auth.NewAESEncryptor().Encrypt(auth.HashKey(conf.EncryptionKeyring.Keys[service.LegacyKeyID]), []byte(totpSecret))
+	assert.NoError(err)
+	storedUser := domain.FullUser{
+		User: user.User{
+			ID:    userID,
+			Email: "mail@mail.com",
+		},
+		Credentials: domain.StoredCredentials{
+			Email:    "mail@mail.com",
+			Password: encryptedPassword,
+			Salt:     encryptedSalt,
+		},
+		TOTP: domain.TOTP{
+			Secret:  base64.StdEncoding.EncodeToString(ciphertext),
+			Enabled: true,
+		},
+	}
+
+	userRepo := &repository.MockUserRepo{
+		FindUser: storedUser,
+	}
+	recoveryCodeRepo := &repository.MockRecoveryCodeRepo{}
+	events := &service.MockEventRecorder{}
+	mockEnv := getTestEnv(conf, userRepo, nil, nil)
+	mockEnv.mfaSvc = service.NewMFAService(mockEnv.passwordSvc, conf.EncryptionKeyring, userRepo, recoveryCodeRepo, nil, events)
+	authToken := getTestToken(conf, userID, clientID)
+	server := newServer(mockEnv, conf)
+
+	// Setup: Regenerate recovery codes with a recovery code.
+	reauth := domain.MFAReauthentication{Password: correctPassword, RecoveryCode: "abcde-12345"}
+	req := createTestPostRequest(clientID, authToken, "/v1/users/"+userID+"/mfa/recovery-codes", reauth)
+	res := performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusOK, res.Code)
+	var recoveryCodes domain.RecoveryCodes
+	err = json.NewDecoder(res.Body).Decode(&recoveryCodes)
+	assert.NoError(err)
+	assert.Equal(len(recoveryCodeRepo.ReplaceArgCodes), len(recoveryCodes.Codes))
+	assert.Equal(userID, recoveryCodeRepo.UseArgUserID)
+	assert.Equal(domain.RecoveryCodesRegenerateEvent, events.Events[0].Type)
+
+	// Setup: Regenerate recovery codes without a second factor.
+	recoveryCodeRepo.UnsetArgs()
+	req = createTestPostRequest(clientID, authToken, "/v1/users/"+userID+"/mfa/recovery-codes", domain.MFAReauthentication{Password: correctPassword})
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusBadRequest, res.Code)
+	assert.Equal(0, recoveryCodeRepo.ReplaceInvocation)
+
+	// Setup: Disable with the wrong password.
+	code, err := service.TOTPCode(totpSecret, time.Now())
+	assert.NoError(err)
+	req = createTestPostRequest(clientID, authToken, "/v1/users/"+userID+"/mfa/totp/disable", domain.MFAReauthentication{Password: "wrong-password", Code: code})
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusForbidden, res.Code)
+	assert.Equal(0, userRepo.DisableTOTPInvocation)
+
+	// Setup: Disable with the wrong TOTP code.
+	req = createTestPostRequest(clientID, authToken, "/v1/users/"+userID+"/mfa/totp/disable", domain.MFAReauthentication{Password: correctPassword, Code: "000000x"})
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusUnauthorized, res.Code)
+	assert.Equal(0, userRepo.DisableTOTPInvocation)
+
+	// Setup: Disable other user.
+	req = createTestPostRequest(clientID, authToken, "/v1/users/wrong-id/mfa/totp/disable", domain.MFAReauthentication{Password: correctPassword, Code: code})
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusForbidden, res.Code)
+	assert.Equal(0, userRepo.DisableTOTPInvocation)
+
+	// Setup: Disable happy path.
+	req = createTestPostRequest(clientID, authToken, "/v1/users/"+userID+"/mfa/totp/disable", domain.MFAReauthentication{Password: correctPassword, Code: code})
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Equal(userID, userRepo.DisableTOTPArg)
+	assert.Equal(userID, recoveryCodeRepo.ReplaceArgUserID)
+	assert.Equal(0, len(recoveryCodeRepo.ReplaceArgCodes))
+	assert.Equal(domain.MFADisableEvent, events.Events[len(events.Events)-1].Type)
+}
+
+func TestHandleLoginWithMFA(t *testing.T) {
+	assert := assert.New(t)
+
+	credentials := user.Credentials{
+		Email:    "mail@mail.com",
+		Password: correctPassword,
+	}
+	conf := getTestConfig()
+	totpSecret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	ciphertext, err := auth.NewAESEncryptor().Encrypt(auth.HashKey(conf.EncryptionKeyring.Keys[service.LegacyKeyID]), []byte(totpSecret))
+	assert.NoError(err)
+	encryptedSecret := base64.StdEncoding.EncodeToString(ciphertext)
+
+	storedUser := domain.FullUser{
+		User: user.User{
+			ID:    id.New(),
+			Email: credentials.Email,
+			Role:  auth.UserRole,
+		},
+		Credentials: domain.StoredCredentials{
+			Email:    credentials.Email,
+			Password: encryptedPassword,
+			Salt:     encryptedSalt,
+		},
+		TOTP: domain.TOTP{
+			Secret:  encryptedSecret,
+			Enabled: true,
+		},
+	}
+
+	userRepo := &repository.MockUserRepo{
+		FindByEmailUser: storedUser,
+		FindUser:        storedUser,
+	}
+	sessionRepo := &repository.MockSessionRepo{}
+	credentialRepo := &repository.MockOneTimeCredentialRepo{}
+	mockEnv := getTestEnv(conf, userRepo, sessionRepo, nil)
+	mockEnv.mfaSvc = service.NewMFAService(
+		mockEnv.passwordSvc, conf.EncryptionKeyring, userRepo, &repository.MockRecoveryCodeRepo{}, credentialRepo, &service.MockEventRecorder{})
+	mockEnv.userSvc = service.NewUserService(
+		mockEnv.passwordSvc, mockEnv.verificationSvc, mockEnv.mfaSvc, mockEnv.emailLoginSvc, mockEnv.externalLoginSvc, getTestSigner(conf),
+		auth.NewVerifier(conf.JWTCredentials, 0), service.NewRefreshTokenHasher(conf.RefreshTokenKey),
+		userRepo, sessionRepo, nil, &service.MockEventRecorder{})
+	server := newServer(mockEnv, conf)
+
+	// Setup: Password login should return a challenge instead of a token.
+	req := createTestPostRequest("client-id", "", "/v1/login", credentials)
+	res := performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusOK, res.Code)
+	var challenge domain.MFAChallenge
+	err = json.NewDecoder(res.Body).Decode(&challenge)
+	assert.NoError(err)
+	assert.True(challenge.MFARequired)
+	assert.NotEqual("", challenge.ChallengeToken)
+	assert.Equal(0, sessionRepo.SaveInvocation)
+	assert.Equal(domain.MFAChallengeCredential, credentialRepo.SaveArg.Purpose)
+	credentialRepo.FindByKeyCredential = credentialRepo.SaveArg
+
+	// Setup: Wrong code should not start a session.
+	req = createTestPostRequest("client-id", "", "/v1/login/mfa", domain.MFAVerification{ChallengeToken: challenge.ChallengeToken, Code: "000000x"})
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusUnauthorized, res.Code)
+	assert.Equal(0, sessionRepo.SaveInvocation)
+	assert.Equal(storedUser.User.ID, userRepo.RecordFailedLoginArgUserID)
+
+	// Setup: Complete the challenge with a valid code, no auth token is needed.
+	code, err := service.TOTPCode(totpSecret, time.Now())
+	assert.NoError(err)
+	req = createTestPostRequest("client-id", "", "/v1/login/mfa", domain.MFAVerification{ChallengeToken: challenge.ChallengeToken, Code: code})
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusOK, res.Code)
+	var token user.Token
+	err = json.NewDecoder(res.Body).Decode(&token)
+	assert.NoError(err)
+	assert.Equal(storedUser.User.ID, token.User.ID)
+	assert.Equal(storedUser.User.ID, sessionRepo.SaveArg.UserID)
+	assert.Equal(credentialRepo.FindByKeyCredential.ID, credentialRepo.MarkUsedArg)
+
+	// Setup: Missing second factor.
+	req = createTestPostRequest("client-id", "", "/v1/login/mfa", domain.MFAVerification{ChallengeToken: challenge.ChallengeToken})
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusBadRequest, res.Code)
+}
@@ -0,0 +1,6 @@
+-- +migrate Up
+ALTER TABLE one_time_credential 
+ADD COLUMN email VARCHAR(100);
+
+-- +migrate Down
+ALTER TABLE one_time_credential DROP COLUMN email;
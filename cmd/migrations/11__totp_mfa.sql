@@ -0,0 +1,26 @@
+-- +migrate Up
+ALTER TABLE app_user 
+ADD COLUMN totp_secret VARCHAR(255);
+
+ALTER TABLE app_user 
+ADD COLUMN totp_enabled BOOLEAN DEFAULT FALSE;
+
+ALTER TABLE app_user 
+ADD COLUMN totp_last_used_step BIGINT;
+
+CREATE TABLE recovery_code (
+  id VARCHAR(50) PRIMARY KEY,
+  user_id VARCHAR(50) REFERENCES app_user(id),
+  code_hash VARCHAR(255),
+  created_at TIMESTAMP,
+  used_at TIMESTAMP
+);
+
+CREATE INDEX recovery_code_user_id_idx ON recovery_code(user_id);
+
+-- +migrate Down
+DROP INDEX recovery_code_user_id_idx;
+DROP TABLE IF EXISTS recovery_code;
+ALTER TABLE app_user DROP COLUMN totp_last_used_step;
+ALTER TABLE app_user DROP COLUMN totp_enabled;
+ALTER TABLE app_user DROP COLUMN totp_secret;
@@ -0,0 +1,9 @@
+-- +migrate Up
+ALTER TABLE one_time_credential 
+ADD COLUMN attempts INTEGER DEFAULT 0;
+
+CREATE INDEX one_time_credential_user_id_idx ON one_time_credential(user_id, purpose);
+
+-- +migrate Down
+DROP INDEX one_time_credential_user_id_idx;
+ALTER TABLE one_time_credential DROP COLUMN attempts;
@@ -0,0 +1,5 @@
+-- +migrate Up
+CREATE INDEX app_user_role_idx ON app_user(role, created_at);
+
+-- +migrate Down
+DROP INDEX app_user_role_idx;
@@ -0,0 +1,26 @@
+-- +migrate Up
+CREATE TABLE external_identity (
+  id VARCHAR(50) PRIMARY KEY,
+  user_id VARCHAR(50) REFERENCES app_user(id),
+  provider VARCHAR(100),
+  subject VARCHAR(255),
+  email VARCHAR(100),
+  created_at TIMESTAMP,
+  UNIQUE(provider, subject)
+);
+
+CREATE INDEX external_identity_user_id_idx ON external_identity(user_id);
+
+CREATE TABLE external_login_state (
+  state_hash VARCHAR(255) PRIMARY KEY,
+  provider VARCHAR(100),
+  code_verifier VARCHAR(255),
+  nonce VARCHAR(255),
+  created_at TIMESTAMP,
+  valid_to TIMESTAMP
+);
+
+-- +migrate Down
+DROP TABLE external_login_state;
+DROP INDEX external_identity_user_id_idx;
+DROP TABLE external_identity;
@@ -0,0 +1,5 @@
+-- +migrate Up
+CREATE INDEX session_refresh_token_hash_idx ON session(refresh_token_hash);
+
+-- +migrate Down
+DROP INDEX session_refresh_token_hash_idx;
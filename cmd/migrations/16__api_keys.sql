@@ -0,0 +1,19 @@
+-- +migrate Up
+CREATE TABLE api_key (
+  id VARCHAR(50) PRIMARY KEY,
+  user_id VARCHAR(50) REFERENCES app_user(id),
+  name VARCHAR(100),
+  prefix VARCHAR(50) UNIQUE,
+  key_hash VARCHAR(255),
+  scopes TEXT[],
+  created_at TIMESTAMP,
+  expires_at TIMESTAMP,
+  last_used_at TIMESTAMP,
+  revoked_at TIMESTAMP
+);
+
+CREATE INDEX api_key_user_id_idx ON api_key(user_id);
+
+-- +migrate Down
+DROP INDEX api_key_user_id_idx;
+DROP TABLE api_key;
@@ -0,0 +1,31 @@
+-- +migrate Up
+CREATE TABLE audit_event (
+  id VARCHAR(50) PRIMARY KEY,
+  type VARCHAR(50) NOT NULL,
+  user_id VARCHAR(50),
+  actor_id VARCHAR(50),
+  session_id VARCHAR(50),
+  client_ip VARCHAR(50),
+  user_agent VARCHAR(255),
+  occurred_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX audit_event_user_id_idx ON audit_event(user_id, occurred_at);
+
+-- +migrate StatementBegin
+CREATE FUNCTION reject_audit_event_change() RETURNS TRIGGER AS $$
+BEGIN
+  RAISE EXCEPTION 'audit_event is append-only';
+END;
+$$ LANGUAGE plpgsql;
+-- +migrate StatementEnd
+
+CREATE TRIGGER audit_event_append_only
+BEFORE UPDATE OR DELETE ON audit_event
+FOR EACH ROW EXECUTE PROCEDURE reject_audit_event_change();
+
+-- +migrate Down
+DROP TRIGGER audit_event_append_only ON audit_event;
+DROP FUNCTION reject_audit_event_change();
+DROP INDEX audit_event_user_id_idx;
+DROP TABLE audit_event;
@@ -0,0 +1,13 @@
+-- +migrate Up
+CREATE TABLE rate_limit_bucket (
+  key VARCHAR(350) PRIMARY KEY,
+  tokens DOUBLE PRECISION NOT NULL,
+  updated_at TIMESTAMP NOT NULL,
+  full_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX rate_limit_bucket_full_at_idx ON rate_limit_bucket(full_at);
+
+-- +migrate Down
+DROP INDEX rate_limit_bucket_full_at_idx;
+DROP TABLE rate_limit_bucket;
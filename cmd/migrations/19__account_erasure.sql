@@ -0,0 +1,42 @@
+-- +migrate Up
+ALTER TABLE app_user
+ADD COLUMN deleted_at TIMESTAMP;
+
+CREATE INDEX app_user_deleted_at_idx ON app_user(deleted_at);
+
+-- Users deleted before the grace period was introduced no longer have credentials and can not be restored.
+UPDATE app_user SET deleted_at = NOW()
+WHERE email IS NULL AND role <> 'GUEST' AND locked = TRUE;
+
+-- Audit events remain append-only, except that personal data may be removed when users are erased.
+-- +migrate StatementBegin
+CREATE OR REPLACE FUNCTION reject_audit_event_change() RETURNS TRIGGER AS $$
+BEGIN
+  IF TG_OP = 'UPDATE'
+    AND NEW.id = OLD.id
+    AND NEW.type = OLD.type
+    AND NEW.occurred_at = OLD.occurred_at
+    AND (NEW.user_id IS NULL OR NEW.user_id = OLD.user_id)
+    AND (NEW.actor_id IS NULL OR NEW.actor_id = OLD.actor_id)
+    AND (NEW.session_id IS NULL OR NEW.session_id = OLD.session_id)
+    AND (NEW.client_ip IS NULL OR NEW.client_ip = OLD.client_ip)
+    AND (NEW.user_agent IS NULL OR NEW.user_agent = OLD.user_agent) THEN
+    RETURN NEW;
+  END IF;
+
+  RAISE EXCEPTION 'audit_event is append-only';
+END;
+$$ LANGUAGE plpgsql;
+-- +migrate StatementEnd
+
+-- +migrate Down
+-- +migrate StatementBegin
+CREATE OR REPLACE FUNCTION reject_audit_event_change() RETURNS TRIGGER AS $$
+BEGIN
+  RAISE EXCEPTION 'audit_event is append-only';
+END;
+$$ LANGUAGE plpgsql;
+-- +migrate StatementEnd
+
+DROP INDEX app_user_deleted_at_idx;
+ALTER TABLE app_user DROP COLUMN deleted_at;
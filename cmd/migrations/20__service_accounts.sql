@@ -0,0 +1,10 @@
+-- +migrate Up
+CREATE TABLE service_account (
+  user_id VARCHAR(50) PRIMARY KEY REFERENCES app_user(id),
+  name VARCHAR(100),
+  created_by VARCHAR(50) REFERENCES app_user(id),
+  created_at TIMESTAMP
+);
+
+-- +migrate Down
+DROP TABLE service_account;
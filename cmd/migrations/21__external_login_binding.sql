@@ -0,0 +1,6 @@
+-- +migrate Up
+ALTER TABLE external_login_state
+ADD COLUMN binding_hash VARCHAR(255);
+
+-- +migrate Down
+ALTER TABLE external_login_state DROP COLUMN binding_hash;
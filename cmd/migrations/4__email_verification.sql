@@ -0,0 +1,14 @@
+-- +migrate Up
+ALTER TABLE app_user 
+ADD COLUMN email_verified BOOLEAN DEFAULT FALSE;
+
+UPDATE app_user SET email_verified = TRUE WHERE email IS NOT NULL;
+
+ALTER TABLE one_time_credential 
+ADD COLUMN purpose VARCHAR(50);
+
+UPDATE one_time_credential SET purpose = 'PASSWORD_RESET';
+
+-- +migrate Down
+ALTER TABLE one_time_credential DROP COLUMN purpose;
+ALTER TABLE app_user DROP COLUMN email_verified;
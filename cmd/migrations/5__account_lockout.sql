@@ -0,0 +1,18 @@
+-- +migrate Up
+ALTER TABLE app_user 
+ADD COLUMN failed_login_attempts INTEGER DEFAULT 0;
+
+ALTER TABLE app_user 
+ADD COLUMN last_failed_login_at TIMESTAMP;
+
+ALTER TABLE app_user 
+ADD COLUMN login_lockouts INTEGER DEFAULT 0;
+
+ALTER TABLE app_user 
+ADD COLUMN locked_until TIMESTAMP;
+
+-- +migrate Down
+ALTER TABLE app_user DROP COLUMN locked_until;
+ALTER TABLE app_user DROP COLUMN login_lockouts;
+ALTER TABLE app_user DROP COLUMN last_failed_login_at;
+ALTER TABLE app_user DROP COLUMN failed_login_attempts;
@@ -0,0 +1,21 @@
+-- +migrate Up
+ALTER TABLE session 
+ADD COLUMN user_agent VARCHAR(255);
+
+ALTER TABLE session 
+ADD COLUMN client_ip VARCHAR(50);
+
+ALTER TABLE session 
+ADD COLUMN device_name VARCHAR(100);
+
+ALTER TABLE session 
+ADD COLUMN last_refreshed_at TIMESTAMP;
+
+CREATE INDEX session_user_id_idx ON session(user_id);
+
+-- +migrate Down
+DROP INDEX session_user_id_idx;
+ALTER TABLE session DROP COLUMN last_refreshed_at;
+ALTER TABLE session DROP COLUMN device_name;
+ALTER TABLE session DROP COLUMN client_ip;
+ALTER TABLE session DROP COLUMN user_agent;
@@ -0,0 +1,15 @@
+-- +migrate Up
+ALTER TABLE session 
+ADD COLUMN family_id VARCHAR(50);
+
+ALTER TABLE session 
+ADD COLUMN rotated_at TIMESTAMP;
+
+UPDATE session SET family_id = id;
+
+CREATE INDEX session_family_id_idx ON session(family_id);
+
+-- +migrate Down
+DROP INDEX session_family_id_idx;
+ALTER TABLE session DROP COLUMN rotated_at;
+ALTER TABLE session DROP COLUMN family_id;
@@ -0,0 +1,13 @@
+-- +migrate Up
+UPDATE session SET 
+  refresh_token = NULL, 
+  is_active = FALSE, 
+  deleted_at = NOW() 
+WHERE deleted_at IS NULL;
+
+ALTER TABLE session 
+RENAME COLUMN refresh_token TO refresh_token_hash;
+
+-- +migrate Down
+ALTER TABLE session 
+RENAME COLUMN refresh_token_hash TO refresh_token;
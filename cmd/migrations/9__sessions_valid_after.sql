@@ -0,0 +1,6 @@
+-- +migrate Up
+ALTER TABLE app_user 
+ADD COLUMN sessions_valid_after TIMESTAMP;
+
+-- +migrate Down
+ALTER TABLE app_user DROP COLUMN sessions_valid_after;
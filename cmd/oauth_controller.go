@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/pkg/httputil"
+)
+
+const serviceClientRealm = `Basic realm="directory"`
+
+func (e *env) handleTokenIntrospection(c *gin.Context) {
+	req, err := getTokenRequest(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	introspection, err := e.oauthSvc.Introspect(req)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, introspection)
+}
+
+func (e *env) handleTokenRevocation(c *gin.Context) {
+	req, err := getTokenRequest(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	err = e.oauthSvc.Revoke(req, getClientInfo(c))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	httputil.SendOK(c)
+}
+
+// requireServiceClient requires requests to be authenticated with the credentials of
+// a service client using HTTP basic authentication.
+func (e *env) requireServiceClient(c *gin.Context) {
+	clientID, secret, ok := c.Request.BasicAuth()
+	if !ok || e.oauthSvc.AuthenticateClient(clientID, secret) != nil {
+		c.Header("WWW-Authenticate", serviceClientRealm)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, httputil.ErrUnauthorized())
+		return
+	}
+
+	c.Next()
+}
+
+func getTokenRequest(c *gin.Context) (domain.TokenRequest, error) {
+	var req domain.TokenRequest
+	err := c.ShouldBind(&req)
+	if err != nil || !req.Valid() {
+		return req, httputil.ErrBadRequest()
+	}
+
+	return req, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/directory/pkg/repository"
+	"github.com/mimir-news/pkg/httputil/auth"
+	"github.com/mimir-news/pkg/id"
+	"github.com/mimir-news/pkg/schema/user"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleTokenIntrospection(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := getTestConfig()
+	userID := id.New()
+	session := domain.NewSession(userID, domain.ClientInfo{})
+	userRepo := &repository.MockUserRepo{
+		FindUser: domain.FullUser{User: user.User{ID: userID, Email: "mail@mail.com", Role: auth.UserRole}},
+	}
+	sessionRepo := &repository.MockSessionRepo{
+		FindSession:               session,
+		FindByRefreshTokenHashErr: repository.ErrNoSuchSession,
+	}
+	mockEnv := getTestEnv(conf, userRepo, sessionRepo, nil)
+	server := newServer(mockEnv, conf)
+
+	signer := getTestSigner(conf)
+	accessToken, err := signer.Sign(session.ID, auth.User{ID: userID, Role: auth.UserRole})
+	assert.NoError(err)
+
+	req := createTestTokenRequest("/v1/oauth/introspect", accessToken, "")
+	req.SetBasicAuth("test-service", "test-service-secret")
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+
+	var introspection domain.TokenIntrospection
+	err = json.NewDecoder(res.Body).Decode(&introspection)
+	assert.NoError(err)
+	assert.True(introspection.Active)
+	assert.Equal(userID, introspection.Subject)
+	assert.Equal(session.ID, introspection.SessionID)
+
+	// Unknown tokens are reported as inactive.
+	req = createTestTokenRequest("/v1/oauth/introspect", "unknown-token", "")
+	req.SetBasicAuth("test-service", "test-service-secret")
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Equal(`{"active":false}`, strings.TrimSpace(res.Body.String()))
+
+	// Service client credentials are required, a user token is not enough.
+	req = createTestTokenRequest("/v1/oauth/introspect", accessToken, "")
+	req.Header.Set(auth.AuthHeaderKey, auth.AuthTokenPrefix+accessToken)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusUnauthorized, res.Code)
+	assert.NotEmpty(res.Header().Get("WWW-Authenticate"))
+
+	req = createTestTokenRequest("/v1/oauth/introspect", accessToken, "")
+	req.SetBasicAuth("test-service", "wrong-secret")
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusUnauthorized, res.Code)
+
+	req = createTestTokenRequest("/v1/oauth/introspect", "", "")
+	req.SetBasicAuth("test-service", "test-service-secret")
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusBadRequest, res.Code)
+}
+
+func TestHandleTokenRevocation(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := getTestConfig()
+	userID := id.New()
+	session := domain.NewSession(userID, domain.ClientInfo{})
+	sessionRepo := &repository.MockSessionRepo{
+		FindSession:                   session,
+		FindByRefreshTokenHashSession: session,
+	}
+	mockEnv := getTestEnv(conf, nil, sessionRepo, nil)
+	server := newServer(mockEnv, conf)
+
+	req := createTestTokenRequest("/v1/oauth/revoke", session.RefreshToken, domain.RefreshTokenType)
+	req.SetBasicAuth("test-service", "test-service-secret")
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Equal(session.ID, sessionRepo.DeleteArg)
+
+	sessionRepo.UnsetArgs()
+	req = createTestTokenRequest("/v1/oauth/revoke", session.RefreshToken, domain.RefreshTokenType)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusUnauthorized, res.Code)
+	assert.Equal(0, sessionRepo.DeleteInvocation)
+}
+
+func createTestTokenRequest(route, token, tokenTypeHint string) *http.Request {
+	form := url.Values{}
+	form.Set("token", token)
+	if tokenTypeHint != "" {
+		form.Set("token_type_hint", tokenTypeHint)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, route, strings.NewReader(form.Encode()))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return req
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/directory/pkg/service"
+	"github.com/mimir-news/pkg/httputil"
+)
+
+func (e *env) handlePasswordResetRequest(c *gin.Context) {
+	request, err := getPasswordResetRequest(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	err = e.rateLimit(c, service.PasswordResetAction, request.Email)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	err = e.passwordResetSvc.RequestReset(request.Email)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	httputil.SendOK(c)
+}
+
+func (e *env) handlePasswordReset(c *gin.Context) {
+	key := c.Param("token")
+	reset, err := getPasswordReset(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	err = e.passwordResetSvc.Reset(key, reset, getClientInfo(c))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	httputil.SendOK(c)
+}
+
+func getPasswordResetRequest(c *gin.Context) (domain.PasswordResetRequest, error) {
+	var request domain.PasswordResetRequest
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		return request, httputil.ErrBadRequest()
+	}
+	if !request.Valid() {
+		return request, httputil.ErrBadRequest()
+	}
+	return request, nil
+}
+
+func getPasswordReset(c *gin.Context) (domain.PasswordReset, error) {
+	var reset domain.PasswordReset
+	err := c.ShouldBindJSON(&reset)
+	if err != nil {
+		return reset, httputil.ErrBadRequest()
+	}
+	if !reset.Valid() {
+		return reset, httputil.ErrBadRequest()
+	}
+	return reset, nil
+}
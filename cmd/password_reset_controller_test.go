@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/directory/pkg/repository"
+	"github.com/mimir-news/directory/pkg/service"
+	"github.com/mimir-news/pkg/httputil/auth"
+	"github.com/mimir-news/pkg/id"
+	"github.com/mimir-news/pkg/schema/user"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandlePasswordResetRequest(t *testing.T) {
+	assert := assert.New(t)
+
+	userEmail := "mail@mail.com"
+	storedUser := domain.FullUser{
+		User: user.User{
+			ID:    id.New(),
+			Email: userEmail,
+		},
+	}
+
+	conf := getTestConfig()
+	userRepo := &repository.MockUserRepo{
+		FindByEmailUser: storedUser,
+	}
+	credentialRepo := &repository.MockOneTimeCredentialRepo{}
+	mailer := &service.MockMailer{}
+	mockEnv := getTestEnv(conf, userRepo, nil, nil)
+	mockEnv.passwordResetSvc = service.NewPasswordResetService(mockEnv.passwordSvc, mailer, userRepo, nil, credentialRepo, &service.MockEventRecorder{})
+	server := newServer(mockEnv, conf)
+
+	// Setup: Request reset happy path.
+	req := createTestPostRequest("", "", "/v1/password-reset", domain.PasswordResetRequest{Email: userEmail})
+	res := performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Equal(userEmail, userRepo.FindByEmailArg)
+	assert.Equal(storedUser.User.ID, credentialRepo.SaveArg.UserID)
+	assert.Equal(1, len(mailer.Outbox))
+	assert.Equal(userEmail, mailer.Outbox[0].To)
+
+	// Setup: No email provided.
+	credentialRepo.UnsetArgs()
+	req = createTestPostRequest("", "", "/v1/password-reset", domain.PasswordResetRequest{})
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusBadRequest, res.Code)
+	assert.Equal(0, credentialRepo.SaveInvocation)
+}
+
+func TestHandlePasswordReset(t *testing.T) {
+	assert := assert.New(t)
+
+	key := "my-reset-key"
+	userID := id.New()
+	userEmail := "mail@mail.com"
+	storedUser := domain.FullUser{
+		User: user.User{
+			ID:    userID,
+			Email: userEmail,
+			Role:  auth.UserRole,
+		},
+		Credentials: domain.StoredCredentials{
+			Email:    userEmail,
+			Password: encryptedPassword,
+			Salt:     encryptedSalt,
+		},
+	}
+	credential := domain.NewOneTimeCredential(domain.PasswordResetCredential, fmt.Sprintf("%x", auth.HashKey(key)), userID, time.Hour)
+
+	conf := getTestConfig()
+	userRepo := &repository.MockUserRepo{
+		FindUser: storedUser,
+	}
+	sessionRepo := &repository.MockSessionRepo{}
+	credentialRepo := &repository.MockOneTimeCredentialRepo{
+		FindByKeyCredential: credential,
+	}
+	mockEnv := getTestEnv(conf, userRepo, sessionRepo, nil)
+	mockEnv.passwordResetSvc = service.NewPasswordResetService(mockEnv.passwordSvc, nil, userRepo, sessionRepo, credentialRepo, &service.MockEventRecorder{})
+	server := newServer(mockEnv, conf)
+
+	reset := domain.PasswordReset{
+		New:      "new-password",
+		Repeated: "new-password",
+	}
+
+	// Setup: Reset password happy path.
+	req := createTestPutRequest("", "", "/v1/password-reset/"+key, reset)
+	res := performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Equal(credential.ID, credentialRepo.MarkUsedArg)
+	assert.Equal(userID, userRepo.SaveArg.User.ID)
+	assert.NotEqual(encryptedPassword, userRepo.SaveArg.Credentials.Password)
+	assert.Equal(userID, sessionRepo.DeleteByUserIDArg)
+
+	// Setup: Unknown key.
+	credentialRepo.UnsetArgs()
+	sessionRepo.UnsetArgs()
+	credentialRepo.FindByKeyErr = repository.ErrNoSuchCredential
+	req = createTestPutRequest("", "", "/v1/password-reset/wrong-key", reset)
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusForbidden, res.Code)
+	assert.Equal(0, credentialRepo.MarkUsedInvocation)
+	assert.Equal(0, sessionRepo.DeleteByUserIDInvocation)
+
+	// Setup: No new password provided.
+	credentialRepo.UnsetArgs()
+	req = createTestPutRequest("", "", "/v1/password-reset/"+key, domain.PasswordReset{})
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusBadRequest, res.Code)
+	assert.Equal(0, credentialRepo.FindByKeyInvocation)
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const retryAfterHeader = "Retry-After"
+
+// rateLimit checks the rate limits of an action for the client ip and the given email address.
+// If a limit has been exceeded the Retry-After header is set to the number of seconds until the action may be retried.
+func (e *env) rateLimit(c *gin.Context, action, email string) error {
+	retryAfter, err := e.rateLimiter.Allow(action, getClientIP(c), email)
+	if retryAfter > 0 {
+		seconds := int(math.Ceil(retryAfter.Seconds()))
+		c.Header(retryAfterHeader, strconv.Itoa(seconds))
+	}
+
+	return err
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"log"
+)
+
+const (
+	reencryptCommand      = "reencrypt"
+	reencryptionBatchSize = 100
+)
+
+// runReencryption re-encrypts all stored credentials and TOTP secrets that are not encrypted with the active encryption key.
+// Run after a new key has been made active, once done the old keys can be removed from the keyring.
+func runReencryption(e *env) {
+	log.Println("Re-encrypting stored credentials with the active encryption key")
+	count, err := e.passwordSvc.ReencryptCredentials(reencryptionBatchSize)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Re-encrypted credentials of %d users\n", count)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+const retentionInterval = time.Hour
+
+// startRetentionJob periodically purges data that is no longer retained, such as inactive guests,
+// deleted users and refilled rate limits.
+func startRetentionJob(e *env, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			runRetention(e)
+			<-ticker.C
+		}
+	}()
+}
+
+func runRetention(e *env) {
+	count, err := e.retentionSvc.PurgeInactiveGuests()
+	if err != nil {
+		log.Println(err)
+	}
+
+	if count > 0 {
+		log.Printf("Purged %d inactive guests\n", count)
+	}
+
+	count, err = e.retentionSvc.PurgeDeletedUsers()
+	if err != nil {
+		log.Println(err)
+	}
+
+	if count > 0 {
+		log.Printf("Purged %d deleted users\n", count)
+	}
+
+	_, err = e.rateLimiter.PurgeFull()
+	if err != nil {
+		log.Println(err)
+	}
+}
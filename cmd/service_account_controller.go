@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/pkg/httputil"
+)
+
+func (e *env) handleCreateServiceAccount(c *gin.Context) {
+	actor, err := getActor(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	var req domain.ServiceAccountRequest
+	err = c.ShouldBindJSON(&req)
+	if err != nil || !req.Valid() {
+		c.Error(httputil.ErrBadRequest())
+		return
+	}
+
+	account, err := e.adminSvc.CreateServiceAccount(actor, req)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, account)
+}
+
+func (e *env) handleGetServiceAccounts(c *gin.Context) {
+	actor, err := getActor(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	accounts, err := e.adminSvc.ListServiceAccounts(actor)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, accounts)
+}
+
+func (e *env) handleDeleteServiceAccount(c *gin.Context) {
+	actor, err := getActor(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	err = e.adminSvc.DeleteServiceAccount(actor, c.Param("accountId"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	httputil.SendOK(c)
+}
+
+func (e *env) handleCreateServiceAccountKey(c *gin.Context) {
+	actor, err := getActor(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	req, err := getAPIKeyRequest(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	key, err := e.adminSvc.CreateServiceAccountKey(actor, c.Param("accountId"), req)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, key)
+}
+
+func (e *env) handleGetServiceAccountKeys(c *gin.Context) {
+	actor, err := getActor(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	keys, err := e.adminSvc.ListServiceAccountKeys(actor, c.Param("accountId"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, keys)
+}
+
+func (e *env) handleRevokeServiceAccountKey(c *gin.Context) {
+	actor, err := getActor(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	err = e.adminSvc.RevokeServiceAccountKey(actor, c.Param("accountId"), c.Param("keyId"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	httputil.SendOK(c)
+}
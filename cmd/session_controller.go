@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/pkg/httputil"
+	"github.com/mimir-news/pkg/httputil/auth"
+)
+
+// deviceNameHeader optional header used by clients to name the device a session is started from.
+const deviceNameHeader = "X-Device-Name"
+
+const (
+	maxUserAgentLength  = 255
+	maxDeviceNameLength = 100
+	maxClientIPLength   = 50
+)
+
+func (e *env) handleLogout(c *gin.Context) {
+	accessToken, err := getAccessToken(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	err = e.sessionSvc.Logout(accessToken, getClientInfo(c))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	httputil.SendOK(c)
+}
+
+func (e *env) handleLogoutAll(c *gin.Context) {
+	userID, err := getUserIDFromPath(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	err = e.sessionSvc.LogoutAll(userID, getClientInfo(c))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	httputil.SendOK(c)
+}
+
+func (e *env) handleGetSessions(c *gin.Context) {
+	userID, err := getUserIDFromPath(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	sessions, err := e.sessionSvc.List(userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, sessions)
+}
+
+func (e *env) handleDeleteSession(c *gin.Context) {
+	userID, err := getUserIDFromPath(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	err = e.sessionSvc.Delete(userID, c.Param("sessionId"), getClientInfo(c))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	httputil.SendOK(c)
+}
+
+// getClientInfo gets information about the client making a request.
+func getClientInfo(c *gin.Context) domain.ClientInfo {
+	return domain.ClientInfo{
+		UserAgent:  truncate(c.Request.UserAgent(), maxUserAgentLength),
+		IP:         truncate(getClientIP(c), maxClientIPLength),
+		DeviceName: truncate(strings.TrimSpace(c.GetHeader(deviceNameHeader)), maxDeviceNameLength),
+	}
+}
+
+// truncate shortens a value to at most maxLength characters without splitting multi-byte characters.
+func truncate(value string, maxLength int) string {
+	runes := []rune(value)
+	if len(runes) <= maxLength {
+		return value
+	}
+
+	return string(runes[:maxLength])
+}
+
+// getAccessToken gets the bearer token from the authorization header.
+func getAccessToken(c *gin.Context) (string, error) {
+	header := c.GetHeader(auth.AuthHeaderKey)
+	if !strings.HasPrefix(header, auth.AuthTokenPrefix) {
+		return "", httputil.ErrUnauthorized()
+	}
+
+	token := strings.TrimPrefix(header, auth.AuthTokenPrefix)
+	if token == "" {
+		return "", httputil.ErrUnauthorized()
+	}
+
+	return token, nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/directory/pkg/repository"
+	"github.com/mimir-news/pkg/httputil/auth"
+	"github.com/mimir-news/pkg/id"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleLogout(t *testing.T) {
+	assert := assert.New(t)
+
+	userID := id.New()
+	sessionID := id.New()
+	clientID := id.New()
+
+	conf := getTestConfig()
+	sessionRepo := &repository.MockSessionRepo{}
+	mockEnv := getTestEnv(conf, nil, sessionRepo, nil)
+	server := newServer(mockEnv, conf)
+
+	token, err := getTestSigner(conf).Sign(sessionID, auth.User{ID: userID, Role: auth.UserRole})
+	assert.NoError(err)
+
+	// Setup: Logout happy path.
+	req := createTestDeleteRequest(clientID, token, "/v1/login")
+	res := performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Equal(1, sessionRepo.DeleteUserSessionInvocation)
+	assert.Equal(userID, sessionRepo.DeleteUserSessionArgUserID)
+	assert.Equal(sessionID, sessionRepo.DeleteUserSessionArgSessionID)
+
+	// Setup: Logout from already deactivated session.
+	sessionRepo.UnsetArgs()
+	sessionRepo.DeleteUserSessionErr = repository.ErrNoSuchSession
+	req = createTestDeleteRequest(clientID, token, "/v1/login")
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Equal(1, sessionRepo.DeleteUserSessionInvocation)
+
+	// Setup: Logout without token.
+	sessionRepo.UnsetArgs()
+	req = createTestDeleteRequest(clientID, "", "/v1/login")
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusUnauthorized, res.Code)
+	assert.Equal(0, sessionRepo.DeleteUserSessionInvocation)
+
+	// Setup: Logout with invalid token.
+	req = createTestDeleteRequest(clientID, "invalid-token", "/v1/login")
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusUnauthorized, res.Code)
+	assert.Equal(0, sessionRepo.DeleteUserSessionInvocation)
+}
+
+func TestHandleLogoutAll(t *testing.T) {
+	assert := assert.New(t)
+
+	userID := id.New()
+	clientID := id.New()
+
+	conf := getTestConfig()
+	sessionRepo := &repository.MockSessionRepo{}
+	mockEnv := getTestEnv(conf, nil, sessionRepo, nil)
+	server := newServer(mockEnv, conf)
+	token := getTestToken(conf, userID, clientID)
+
+	// Setup: Logout all happy path.
+	req := createTestDeleteRequest(clientID, token, "/v1/users/"+userID+"/sessions")
+	res := performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Equal(userID, sessionRepo.DeleteByUserIDArg)
+
+	// Setup: Logout all sessions of another user.
+	sessionRepo.UnsetArgs()
+	req = createTestDeleteRequest(clientID, token, "/v1/users/"+id.New()+"/sessions")
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusForbidden, res.Code)
+	assert.Equal(0, sessionRepo.DeleteByUserIDInvocation)
+
+	// Setup: Logout all without token.
+	req = createTestDeleteRequest(clientID, "", "/v1/users/"+userID+"/sessions")
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusUnauthorized, res.Code)
+	assert.Equal(0, sessionRepo.DeleteByUserIDInvocation)
+}
+
+func TestHandleGetSessions(t *testing.T) {
+	assert := assert.New(t)
+
+	userID := id.New()
+	clientID := id.New()
+	session := domain.NewSession(userID, domain.ClientInfo{
+		UserAgent:  "test-agent/1.0",
+		IP:         "10.0.0.1",
+		DeviceName: "My phone",
+	})
+
+	conf := getTestConfig()
+	sessionRepo := &repository.MockSessionRepo{
+		FindByUserIDSessions: []domain.Session{session},
+	}
+	mockEnv := getTestEnv(conf, nil, sessionRepo, nil)
+	server := newServer(mockEnv, conf)
+	token := getTestToken(conf, userID, clientID)
+
+	// Setup: Get sessions happy path.
+	req := createTestGetRequest(clientID, token, "/v1/users/"+userID+"/sessions")
+	res := performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Equal(userID, sessionRepo.FindByUserIDArg)
+	body := res.Body.String()
+	assert.False(strings.Contains(body, session.RefreshToken))
+	var sessions []domain.SessionInfo
+	err := json.Unmarshal([]byte(body), &sessions)
+	assert.NoError(err)
+	assert.Len(sessions, 1)
+	assert.Equal(session.ID, sessions[0].ID)
+	assert.Equal(session.UserAgent, sessions[0].UserAgent)
+	assert.Equal(session.ClientIP, sessions[0].ClientIP)
+	assert.Equal(session.DeviceName, sessions[0].DeviceName)
+
+	// Setup: Get sessions of another user.
+	sessionRepo.UnsetArgs()
+	req = createTestGetRequest(clientID, token, "/v1/users/"+id.New()+"/sessions")
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusForbidden, res.Code)
+	assert.Equal(0, sessionRepo.FindByUserIDInvocation)
+}
+
+func TestHandleDeleteSession(t *testing.T) {
+	assert := assert.New(t)
+
+	userID := id.New()
+	clientID := id.New()
+	sessionID := id.New()
+
+	conf := getTestConfig()
+	sessionRepo := &repository.MockSessionRepo{}
+	mockEnv := getTestEnv(conf, nil, sessionRepo, nil)
+	server := newServer(mockEnv, conf)
+	token := getTestToken(conf, userID, clientID)
+
+	// Setup: Delete session happy path.
+	req := createTestDeleteRequest(clientID, token, "/v1/users/"+userID+"/sessions/"+sessionID)
+	res := performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Equal(userID, sessionRepo.DeleteUserSessionArgUserID)
+	assert.Equal(sessionID, sessionRepo.DeleteUserSessionArgSessionID)
+
+	// Setup: Delete unknown session.
+	sessionRepo.UnsetArgs()
+	sessionRepo.DeleteUserSessionErr = repository.ErrNoSuchSession
+	req = createTestDeleteRequest(clientID, token, "/v1/users/"+userID+"/sessions/"+sessionID)
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusNotFound, res.Code)
+
+	// Setup: Delete session of another user.
+	sessionRepo.UnsetArgs()
+	req = createTestDeleteRequest(clientID, token, "/v1/users/"+id.New()+"/sessions/"+sessionID)
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusForbidden, res.Code)
+	assert.Equal(0, sessionRepo.DeleteUserSessionInvocation)
+}
+
+func TestGetClientInfo(t *testing.T) {
+	assert := assert.New(t)
+
+	req := createTestGetRequest("", "", "/v1/sessions")
+	req.RemoteAddr = "127.0.0.1:4321"
+	req.Header.Set(forwardedForHeader, "198.51.100.7")
+	req.Header.Set("User-Agent", strings.Repeat("å", maxUserAgentLength+1))
+	req.Header.Set(deviceNameHeader, " Pixel ")
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = req
+	resolveClientIP(getTrustedProxies("127.0.0.1"))(c)
+
+	client := getClientInfo(c)
+	assert.Equal("198.51.100.7", client.IP)
+	assert.Equal(strings.Repeat("å", maxUserAgentLength), client.UserAgent)
+	assert.Equal("Pixel", client.DeviceName)
+
+	// Forwarded values that are not ips should not be used as the client ip.
+	req.RemoteAddr = "127.0.0.1:4321"
+	req.Header.Set(forwardedForHeader, strings.Repeat("x", 2*maxClientIPLength))
+	resolveClientIP(getTrustedProxies("127.0.0.1"))(c)
+	assert.Equal("127.0.0.1", getClientInfo(c).IP)
+}
+
+func TestTruncate(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("abc", truncate("abc", 3))
+	assert.Equal("ab", truncate("abc", 2))
+	assert.Equal("åäö", truncate("åäö", 3))
+	assert.Equal("åä", truncate("åäö", 2))
+	assert.Equal("", truncate("", 2))
+}
@@ -1,10 +1,11 @@
 package main
 
 import (
-	"fmt"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/directory/pkg/service"
 	"github.com/mimir-news/pkg/httputil"
 	"github.com/mimir-news/pkg/httputil/auth"
 	"github.com/mimir-news/pkg/schema/user"
@@ -19,7 +20,19 @@ func (e *env) handleUserCreation(c *gin.Context) {
 		return
 	}
 
-	newUser, err := e.userSvc.Create(credentials)
+	err = e.rateLimit(c, service.RegistrationAction, credentials.Email)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	// Users registering with an anonymous or guest token keep the user id of the token.
+	var newUser user.User
+	if c.GetHeader(auth.AuthHeaderKey) != "" {
+		newUser, err = e.upgradeAnonymousUser(c, credentials)
+	} else {
+		newUser, err = e.userSvc.Create(credentials)
+	}
 	if err != nil {
 		c.Error(err)
 		return
@@ -28,6 +41,15 @@ func (e *env) handleUserCreation(c *gin.Context) {
 	c.JSON(http.StatusOK, newUser)
 }
 
+func (e *env) upgradeAnonymousUser(c *gin.Context, credentials user.Credentials) (user.User, error) {
+	anonymousToken, err := getAccessToken(c)
+	if err != nil {
+		return user.User{}, err
+	}
+
+	return e.userSvc.Upgrade(anonymousToken, credentials)
+}
+
 func (e *env) handleLogin(c *gin.Context) {
 	credentials, err := getCredentials(c)
 	if err != nil {
@@ -35,13 +57,29 @@ func (e *env) handleLogin(c *gin.Context) {
 		return
 	}
 
-	token, err := e.userSvc.Authenticate(credentials)
+	err = e.rateLimit(c, service.LoginAction, credentials.Email)
 	if err != nil {
 		c.Error(err)
 		return
 	}
 
-	c.JSON(http.StatusOK, token)
+	result, err := e.userSvc.Authenticate(credentials, getClientInfo(c))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	sendLoginResult(c, result)
+}
+
+// sendLoginResult responds with the token of a login, or with the MFA challenge if the user must provide a second factor.
+func sendLoginResult(c *gin.Context, result domain.LoginResult) {
+	if result.Challenge != nil {
+		c.JSON(http.StatusOK, result.Challenge)
+		return
+	}
+
+	c.JSON(http.StatusOK, result.Token)
 }
 
 func (e *env) handleTokenRenewal(c *gin.Context) {
@@ -51,7 +89,7 @@ func (e *env) handleTokenRenewal(c *gin.Context) {
 		return
 	}
 
-	newToken, err := e.userSvc.RefreshToken(oldToken)
+	newToken, err := e.userSvc.RefreshToken(oldToken, getClientInfo(c))
 	if err != nil {
 		c.Error(err)
 		return
@@ -83,7 +121,7 @@ func (e *env) handleDeleteUser(c *gin.Context) {
 		return
 	}
 
-	err = e.userSvc.Delete(userID)
+	err = e.userSvc.Delete(userID, getClientInfo(c))
 	if err != nil {
 		c.Error(err)
 		return
@@ -93,20 +131,19 @@ func (e *env) handleDeleteUser(c *gin.Context) {
 }
 
 func (e *env) handleChangePassword(c *gin.Context) {
-	_, err := getUserIDFromPath(c)
+	userID, err := getUserIDFromPath(c)
 	if err != nil {
 		c.Error(err)
 		return
 	}
 
 	change, err := getPasswordChange(c)
-	fmt.Println(err)
 	if err != nil {
 		c.Error(err)
 		return
 	}
 
-	err = e.userSvc.ChangePassword(change)
+	err = e.userSvc.ChangePassword(userID, change, getClientInfo(c))
 	if err != nil {
 		c.Error(err)
 		return
@@ -115,20 +152,34 @@ func (e *env) handleChangePassword(c *gin.Context) {
 	httputil.SendOK(c)
 }
 
-func (e *env) handleChangeEmail(c *gin.Context) {
-	userID, err := getUserIDFromPath(c)
+func (e *env) handleEmailVerification(c *gin.Context) {
+	userID := c.Param("userId")
+	verification, err := getEmailVerification(c)
 	if err != nil {
 		c.Error(err)
 		return
 	}
 
-	u, err := getUserFromBody(c)
+	err = e.verificationSvc.Verify(userID, verification.Token)
 	if err != nil {
 		c.Error(err)
 		return
 	}
 
-	err = e.userSvc.ChangeEmail(userID, u.Email)
+	httputil.SendOK(c)
+}
+
+func (e *env) handleEmailVerificationResend(c *gin.Context) {
+	userID := c.Param("userId")
+
+	// Resends are limited per user id since the email address is not known until the user has been found.
+	err := e.rateLimit(c, service.VerificationResendAction, userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	err = e.verificationSvc.ResendVerification(userID)
 	if err != nil {
 		c.Error(err)
 		return
@@ -138,7 +189,29 @@ func (e *env) handleChangeEmail(c *gin.Context) {
 }
 
 func (e *env) getAnonymousToken(c *gin.Context) {
-	token, err := e.userSvc.GetAnonymousToken()
+	err := e.rateLimit(c, service.AnonymousTokenAction, "")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	token, err := e.userSvc.GetAnonymousToken(getClientInfo(c))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, token)
+}
+
+func (e *env) handleGuestLogin(c *gin.Context) {
+	err := e.rateLimit(c, service.GuestAction, "")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	token, err := e.userSvc.CreateGuest(getClientInfo(c))
 	if err != nil {
 		c.Error(err)
 		return
@@ -148,7 +221,7 @@ func (e *env) getAnonymousToken(c *gin.Context) {
 }
 
 func getUserIDFromPath(c *gin.Context) (string, error) {
-	authID, err := auth.GetUserID(c)
+	authID, err := getAuthUserID(c)
 	if err != nil {
 		return "", err
 	}
@@ -161,18 +234,6 @@ func getUserIDFromPath(c *gin.Context) (string, error) {
 	return userID, nil
 }
 
-func getUserFromBody(c *gin.Context) (user.User, error) {
-	var u user.User
-	err := c.ShouldBindJSON(&u)
-	if err != nil {
-		return u, httputil.ErrBadRequest()
-	}
-	if !u.Valid() {
-		return u, httputil.ErrBadRequest()
-	}
-	return u, nil
-}
-
 func getCredentials(c *gin.Context) (user.Credentials, error) {
 	var credentials user.Credentials
 	err := c.ShouldBindJSON(&credentials)
@@ -191,12 +252,24 @@ func getPasswordChange(c *gin.Context) (user.PasswordChange, error) {
 	if err != nil {
 		return change, httputil.ErrBadRequest()
 	}
-	if !change.Valid() {
+	if change.New == "" || change.Repeated == "" || change.Old.Password == "" {
 		return change, httputil.ErrBadRequest()
 	}
 	return change, nil
 }
 
+func getEmailVerification(c *gin.Context) (domain.EmailVerification, error) {
+	var verification domain.EmailVerification
+	err := c.ShouldBindJSON(&verification)
+	if err != nil {
+		return verification, httputil.ErrBadRequest()
+	}
+	if !verification.Valid() {
+		return verification, httputil.ErrBadRequest()
+	}
+	return verification, nil
+}
+
 func getRefreshToken(c *gin.Context) (user.Token, error) {
 	var token user.Token
 	err := c.ShouldBindJSON(&token)
@@ -9,6 +9,7 @@ import (
 
 	"github.com/mimir-news/directory/pkg/domain"
 	"github.com/mimir-news/directory/pkg/repository"
+	"github.com/mimir-news/directory/pkg/service"
 	"github.com/mimir-news/pkg/httputil/auth"
 	"github.com/mimir-news/pkg/id"
 	"github.com/mimir-news/pkg/schema/user"
@@ -52,6 +53,23 @@ func TestUserCreation(t *testing.T) {
 	assert.NotEqual("", userRepo.SaveArg.Credentials.Password)
 	assert.NotEqual(credentials.Password, userRepo.SaveArg.Credentials.Password)
 
+	// Registration should not fail once the user is stored if the verification email cannot be sent.
+	mailer := &service.MockMailer{SendErr: expectedTestError}
+	mockEnv.verificationSvc = service.NewEmailVerificationService(
+		conf.EmailVerificationPolicy, mailer, userRepo, &repository.MockOneTimeCredentialRepo{})
+	mockEnv.userSvc = service.NewUserService(
+		mockEnv.passwordSvc, mockEnv.verificationSvc, mockEnv.mfaSvc, mockEnv.emailLoginSvc, mockEnv.externalLoginSvc, getTestSigner(conf),
+		auth.NewVerifier(conf.JWTCredentials, 0), service.NewRefreshTokenHasher(conf.RefreshTokenKey),
+		userRepo, nil, nil, &service.MockEventRecorder{})
+	server = newServer(mockEnv, conf)
+	userRepo.SaveArg = domain.FullUser{}
+
+	req = createTestPostRequest("client-id", "", "/v1/users", credentials)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Equal(credentials.Email, userRepo.SaveArg.User.Email)
+	assert.Equal(0, len(mailer.Outbox))
+
 	userRepo = &repository.MockUserRepo{
 		FindByEmailUser: domain.FullUser{User: u},
 		FindByEmailErr:  nil,
@@ -64,6 +82,49 @@ func TestUserCreation(t *testing.T) {
 	assert.Equal(http.StatusConflict, res.Code)
 }
 
+func TestUserCreationWithAnonymousToken(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := getTestConfig()
+	userRepo := &repository.MockUserRepo{
+		FindErr:        repository.ErrNoSuchUser,
+		FindByEmailErr: repository.ErrNoSuchUser,
+	}
+	listRepo := &repository.MockWatchlistRepo{}
+	mockEnv := getTestEnv(conf, userRepo, nil, listRepo)
+	server := newServer(mockEnv, conf)
+
+	req := createTestGetRequest("client-id", "", "/v1/login/anonymous")
+	res := performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusOK, res.Code)
+	var anonymousToken user.Token
+	err := json.NewDecoder(res.Body).Decode(&anonymousToken)
+	assert.NoError(err)
+
+	credentials := user.Credentials{
+		Email:    "mail@mail.com",
+		Password: "super-secret-password",
+	}
+	req = createTestPostRequest("client-id", anonymousToken.Token, "/v1/users", credentials)
+	res = performTestRequest(server.Handler, req)
+
+	assert.Equal(http.StatusOK, res.Code)
+	var u user.User
+	err = json.NewDecoder(res.Body).Decode(&u)
+	assert.NoError(err)
+	assert.Equal(anonymousToken.User.ID, u.ID)
+	assert.Equal(auth.UserRole, u.Role)
+	assert.Equal(anonymousToken.User.ID, userRepo.SaveArg.User.ID)
+	assert.Equal(anonymousToken.User.ID, listRepo.SaveArgUserID)
+	assert.Equal(len(anonymousToken.User.Watchlists[0].Stocks), len(listRepo.SaveArgWatchlist.Stocks))
+
+	userRepo.SaveArg = domain.FullUser{}
+	req = createTestPostRequest("client-id", "invalid-token", "/v1/users", credentials)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusUnauthorized, res.Code)
+	assert.Equal("", userRepo.SaveArg.User.ID)
+}
+
 func TestHandleLogin(t *testing.T) {
 	assert := assert.New(t)
 
@@ -95,9 +156,15 @@ func TestHandleLogin(t *testing.T) {
 	server := newServer(mockEnv, conf)
 
 	req := createTestPostRequest("client-id", "", "/v1/login", credentials)
+	req.Header.Set("User-Agent", "test-agent/1.0")
+	req.Header.Set(deviceNameHeader, "My phone")
+	req.RemoteAddr = "10.0.0.1:4321"
 	res := performTestRequest(server.Handler, req)
 
 	assert.Equal(http.StatusOK, res.Code)
+	assert.Equal("test-agent/1.0", sessionRepo.SaveArg.UserAgent)
+	assert.Equal("My phone", sessionRepo.SaveArg.DeviceName)
+	assert.Equal("10.0.0.1", sessionRepo.SaveArg.ClientIP)
 	var token user.Token
 	err := json.NewDecoder(res.Body).Decode(&token)
 	assert.NoError(err)
@@ -107,7 +174,7 @@ func TestHandleLogin(t *testing.T) {
 	assert.Equal(expectedUser.User.ID, authToken.User.ID)
 	assert.Equal(expectedUser.User.ID, sessionRepo.SaveArg.UserID)
 	assert.Equal(expectedUser.User.Role, token.User.Role)
-	assert.Equal(sessionRepo.SaveArg.RefreshToken, token.RefreshToken)
+	assert.Equal(service.NewRefreshTokenHasher(conf.RefreshTokenKey).Hash(token.RefreshToken), sessionRepo.SaveArg.RefreshTokenHash)
 	assert.Equal(sessionRepo.SaveArg.ID, authToken.ID)
 
 	wrongCredentials := user.Credentials{
@@ -119,6 +186,69 @@ func TestHandleLogin(t *testing.T) {
 	res = performTestRequest(server.Handler, req)
 	assert.Equal(http.StatusUnauthorized, res.Code)
 	assert.Equal("", sessionRepo.SaveArg.UserID)
+
+	lockedUser := expectedUser
+	lockedUser.LoginFailures.LockedUntil = time.Now().UTC().Add(time.Minute)
+	userRepo.FindByEmailUser = lockedUser
+	req = createTestPostRequest("client-id", "", "/v1/login", credentials)
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusLocked, res.Code)
+	assert.Equal("", sessionRepo.SaveArg.UserID)
+}
+
+func TestHandleLoginRestoresDeletedUser(t *testing.T) {
+	assert := assert.New(t)
+
+	credentials := user.Credentials{Email: "mail@mail.com", Password: correctPassword}
+	deletedUser := domain.FullUser{
+		User: user.User{ID: "user-id", Email: credentials.Email, Role: auth.UserRole},
+		Credentials: domain.StoredCredentials{
+			Email:    credentials.Email,
+			Password: encryptedPassword,
+			Salt:     encryptedSalt,
+		},
+		DeletedAt: time.Now().UTC().Add(-time.Hour),
+	}
+
+	conf := getTestConfig()
+	userRepo := &repository.MockUserRepo{
+		FindByEmailUser: deletedUser,
+	}
+	sessionRepo := &repository.MockSessionRepo{}
+	events := &service.MockEventRecorder{}
+	mockEnv := getTestEnv(conf, userRepo, sessionRepo, nil)
+	mockEnv.userSvc = service.NewUserService(
+		mockEnv.passwordSvc, mockEnv.verificationSvc, mockEnv.mfaSvc, mockEnv.emailLoginSvc, mockEnv.externalLoginSvc, getTestSigner(conf),
+		auth.NewVerifier(conf.JWTCredentials, 0), service.NewRefreshTokenHasher(conf.RefreshTokenKey),
+		userRepo, sessionRepo, nil, events)
+	server := newServer(mockEnv, conf)
+
+	// Setup: Log in to a deleted account which has not been purged.
+	req := createTestPostRequest("client-id", "", "/v1/login", credentials)
+	res := performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Equal(deletedUser.User.ID, userRepo.RestoreArg)
+	assert.Equal(deletedUser.User.ID, sessionRepo.SaveArg.UserID)
+	assert.Len(events.Events, 2)
+	assert.Equal(domain.UserRestoreEvent, events.Events[0].Type)
+	assert.Equal(domain.LoginSuccessEvent, events.Events[1].Type)
+
+	// Setup: Failed logins do not restore the account.
+	userRepo.RestoreArg = ""
+	req = createTestPostRequest("client-id", "", "/v1/login", user.Credentials{Email: credentials.Email, Password: "wrong-password"})
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusUnauthorized, res.Code)
+	assert.Equal("", userRepo.RestoreArg)
+
+	// Setup: Logins to accounts which have not been deleted do not restore them.
+	userRepo.FindByEmailUser.DeletedAt = time.Time{}
+	req = createTestPostRequest("client-id", "", "/v1/login", credentials)
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Equal(1, userRepo.RestoreInvocation)
 }
 
 func TestHandleGetUser(t *testing.T) {
@@ -190,7 +320,8 @@ func TestHandleDeleteUser(t *testing.T) {
 
 	conf := getTestConfig()
 	userRepo := &repository.MockUserRepo{}
-	mockEnv := getTestEnv(conf, userRepo, nil, nil)
+	sessionRepo := &repository.MockSessionRepo{}
+	mockEnv := getTestEnv(conf, userRepo, sessionRepo, nil)
 	signer := getTestSigner(conf)
 	authToken, err := signer.Sign(id.New(), auth.User{ID: userID, Role: auth.UserRole})
 	assert.NoError(err)
@@ -202,6 +333,8 @@ func TestHandleDeleteUser(t *testing.T) {
 	// Test
 	assert.Equal(http.StatusOK, res.Code)
 	assert.Equal(userID, userRepo.DeleteArg)
+	assert.Equal(userID, userRepo.InvalidateSessionsArgUserID)
+	assert.Equal(userID, sessionRepo.DeleteByUserIDArg)
 
 	// Setup: Missing token.
 	userRepo.DeleteArg = ""
@@ -252,16 +385,16 @@ func TestHandlePasswordChange(t *testing.T) {
 
 	conf := getTestConfig()
 	userRepo := &repository.MockUserRepo{
-		FindByEmailUser: expectedUser,
+		FindUser: expectedUser,
 	}
-	mockEnv := getTestEnv(conf, userRepo, nil, nil)
+	sessionRepo := &repository.MockSessionRepo{}
+	mockEnv := getTestEnv(conf, userRepo, sessionRepo, nil)
 	authToken := getTestToken(conf, userID, clientID)
 
 	pwdChange := user.PasswordChange{
 		New:      "new-password",
 		Repeated: "new-password",
 		Old: user.Credentials{
-			Email:    userEmail,
 			Password: correctPassword,
 		},
 	}
@@ -274,10 +407,14 @@ func TestHandlePasswordChange(t *testing.T) {
 	assert.Equal(http.StatusOK, res.Code)
 	savedUser := userRepo.SaveArg
 	assert.Equal(userID, savedUser.User.ID)
+	assert.Equal(userID, userRepo.FindArg)
+	assert.Equal("", userRepo.FindByEmailArg)
 	assert.NotEqual(expectedUser.Credentials.Password, savedUser.Credentials.Password)
 	assert.NotEqual("", savedUser.Credentials.Password)
 	assert.NotEqual(expectedUser.Credentials.Salt, savedUser.Credentials.Salt)
 	assert.NotEqual("", savedUser.Credentials.Salt)
+	assert.Equal(userID, userRepo.InvalidateSessionsArgUserID)
+	assert.Equal(userID, sessionRepo.DeleteByUserIDArg)
 
 	// Setup: Change password wrong user id.
 	userRepo.SaveArg = domain.FullUser{}
@@ -297,58 +434,15 @@ func TestHandlePasswordChange(t *testing.T) {
 	savedUser = userRepo.SaveArg
 	assert.Equal("", savedUser.User.ID)
 
-}
-
-func TestHandleEmailChange(t *testing.T) {
-	assert := assert.New(t)
-
-	userID := id.New()
-	clientID := id.New()
-	userEmail := "main@mail.com"
-
-	expectedUser := domain.FullUser{
-		User: user.User{
-			ID:    userID,
-			Email: userEmail,
-		},
-		Credentials: domain.StoredCredentials{
-			Email: userEmail,
-		},
-	}
-
-	conf := getTestConfig()
-	userRepo := &repository.MockUserRepo{
-		FindUser: expectedUser,
-	}
-	mockEnv := getTestEnv(conf, userRepo, nil, nil)
-	authToken := getTestToken(conf, userID, clientID)
-
-	u := user.User{
-		ID:    userID,
-		Email: userEmail,
-	}
-
-	// Setup: Change email happy path.
-	server := newServer(mockEnv, conf)
-	req := createTestPutRequest(clientID, authToken, "/v1/users/"+userID+"/email", u)
-	res := performTestRequest(server.Handler, req)
-	// Test
-	assert.Equal(http.StatusOK, res.Code)
-	savedUser := userRepo.SaveArg
-	assert.Equal(u.Email, savedUser.User.Email)
-	assert.Equal(u.Email, savedUser.Credentials.Email)
-	assert.Equal(userID, savedUser.User.ID)
-	assert.Equal(userID, userRepo.FindArg)
-
-	// Setup: Change password no email provided.
+	// Setup: Change password with email of another account.
 	userRepo.SaveArg = domain.FullUser{}
-	userRepo.FindArg = ""
-	req = createTestPutRequest(clientID, authToken, "/v1/users/"+userID+"/email", user.User{})
+	otherAccountChange := pwdChange
+	otherAccountChange.Old.Email = "other@mail.com"
+	req = createTestPutRequest(clientID, authToken, "/v1/users/"+userID+"/password", otherAccountChange)
 	res = performTestRequest(server.Handler, req)
 	// Test
-	assert.Equal(http.StatusBadRequest, res.Code)
+	assert.Equal(http.StatusForbidden, res.Code)
 	assert.Equal("", userRepo.SaveArg.User.ID)
-	assert.Equal("", userRepo.FindArg)
 
 }
 
@@ -381,18 +475,157 @@ func TestGetAnonymousToken(t *testing.T) {
 	assert.Equal(auth.AnonymousRole, content.User.Role)
 }
 
+func TestRateLimitedEndpoints(t *testing.T) {
+	assert := assert.New(t)
+
+	cfg := getTestConfig()
+	cfg.RateLimitPolicy = service.RateLimitPolicy{
+		PerIP:    domain.RateLimit{Requests: 2, Period: time.Minute},
+		PerEmail: domain.RateLimit{Requests: 1, Period: time.Minute},
+	}
+	userRepo := &repository.MockUserRepo{
+		FindByEmailErr: repository.ErrNoSuchUser,
+	}
+	mockEnv := getTestEnv(cfg, userRepo, &repository.MockSessionRepo{}, nil)
+	server := newServer(mockEnv, cfg)
+	credentials := user.Credentials{Email: "mail@mail.com", Password: correctPassword}
+
+	// Setup: Log in until the email address is limited.
+	req := createTestPostRequest("", "", "/v1/login", credentials)
+	req.RemoteAddr = "10.0.0.1:4321"
+	res := performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusUnauthorized, res.Code)
+	assert.Equal("", res.Header().Get(retryAfterHeader))
+
+	userRepo.FindByEmailArg = ""
+	req = createTestPostRequest("", "", "/v1/login", credentials)
+	req.RemoteAddr = "10.0.0.2:4321"
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusTooManyRequests, res.Code)
+	assert.Equal("60", res.Header().Get(retryAfterHeader))
+	assert.Equal("", userRepo.FindByEmailArg)
+
+	// Setup: Log in with another email until the client ip is limited.
+	credentials.Email = "other@mail.com"
+	req = createTestPostRequest("", "", "/v1/login", credentials)
+	req.RemoteAddr = "10.0.0.1:4321"
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusUnauthorized, res.Code)
+
+	req = createTestPostRequest("", "", "/v1/login", credentials)
+	req.RemoteAddr = "10.0.0.1:4321"
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusTooManyRequests, res.Code)
+	assert.Equal("30", res.Header().Get(retryAfterHeader))
+
+	// Setup: Registration and anonymous tokens are limited separately from logins.
+	userRepo.SaveErr = expectedTestError
+	req = createTestPostRequest("", "", "/v1/users", credentials)
+	req.RemoteAddr = "10.0.0.1:4321"
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusInternalServerError, res.Code)
+
+	req = createTestPostRequest("", "", "/v1/users", credentials)
+	req.RemoteAddr = "10.0.0.1:4321"
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusTooManyRequests, res.Code)
+
+	for i := 0; i < 2; i++ {
+		req = createTestGetRequest("", "", "/v1/login/anonymous")
+		req.RemoteAddr = "10.0.0.1:4321"
+		res = performTestRequest(server.Handler, req)
+		// Test
+		assert.Equal(http.StatusOK, res.Code)
+	}
+
+	req = createTestGetRequest("", "", "/v1/login/anonymous")
+	req.RemoteAddr = "10.0.0.1:4321"
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusTooManyRequests, res.Code)
+	assert.NotEqual("", res.Header().Get(retryAfterHeader))
+
+	// Setup: Email logins are limited per email address so that codes cannot be reissued to reset their attempt limit.
+	emailLogin := domain.EmailLoginRequest{Email: "mail@mail.com", Method: domain.EmailLoginCode}
+	req = createTestPostRequest("", "", "/v1/login/email", emailLogin)
+	req.RemoteAddr = "10.0.0.3:4321"
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusOK, res.Code)
+
+	req = createTestPostRequest("", "", "/v1/login/email", emailLogin)
+	req.RemoteAddr = "10.0.0.4:4321"
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusTooManyRequests, res.Code)
+
+	// Setup: Password reset requests are limited per email address.
+	resetRequest := domain.PasswordResetRequest{Email: "mail@mail.com"}
+	req = createTestPostRequest("", "", "/v1/password-reset", resetRequest)
+	req.RemoteAddr = "10.0.0.3:4321"
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusOK, res.Code)
+
+	req = createTestPostRequest("", "", "/v1/password-reset", resetRequest)
+	req.RemoteAddr = "10.0.0.4:4321"
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusTooManyRequests, res.Code)
+
+	// Setup: Guest and MFA logins are limited per client ip.
+	for i := 0; i < 2; i++ {
+		req = createTestPostRequest("", "", "/v1/login/guest", nil)
+		req.RemoteAddr = "10.0.0.5:4321"
+		res = performTestRequest(server.Handler, req)
+		// Test
+		assert.Equal(http.StatusInternalServerError, res.Code)
+	}
+
+	req = createTestPostRequest("", "", "/v1/login/guest", nil)
+	req.RemoteAddr = "10.0.0.5:4321"
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusTooManyRequests, res.Code)
+
+	verification := domain.MFAVerification{ChallengeToken: "unknown-challenge", Code: "123456"}
+	for i := 0; i < 2; i++ {
+		req = createTestPostRequest("", "", "/v1/login/mfa", verification)
+		req.RemoteAddr = "10.0.0.5:4321"
+		res = performTestRequest(server.Handler, req)
+		// Test
+		assert.Equal(http.StatusUnauthorized, res.Code)
+	}
+
+	// Setup: Forwarded ips should not be trusted unless the request was received from a trusted proxy.
+	req = createTestPostRequest("", "", "/v1/login/mfa", verification)
+	req.RemoteAddr = "10.0.0.5:4321"
+	req.Header.Set(forwardedForHeader, "198.51.100.7")
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusTooManyRequests, res.Code)
+}
+
 func TestHandleTokenRenewal(t *testing.T) {
 	assert := assert.New(t)
 
 	userID := id.New()
 	tokenID := id.New()
+	refreshToken := id.New()
 
+	cfg := getTestConfig()
 	oldSession := domain.Session{
-		ID:           tokenID,
-		UserID:       userID,
-		Active:       true,
-		RefreshToken: id.New(),
-		CreatedAt:    time.Now().UTC().Add(-48 * time.Hour),
+		ID:               tokenID,
+		UserID:           userID,
+		Active:           true,
+		RefreshTokenHash: service.NewRefreshTokenHasher(cfg.RefreshTokenKey).Hash(refreshToken),
+		CreatedAt:        time.Now().UTC().Add(-48 * time.Hour),
 	}
 
 	authUser := auth.User{
@@ -415,7 +648,6 @@ func TestHandleTokenRenewal(t *testing.T) {
 		FindSession: oldSession,
 	}
 
-	cfg := getTestConfig()
 	verifier := auth.NewVerifier(cfg.JWTCredentials, 0)
 	signer := auth.NewSigner(cfg.JWTCredentials, 24*time.Hour)
 
@@ -423,7 +655,7 @@ func TestHandleTokenRenewal(t *testing.T) {
 	assert.NoError(err)
 	oldToken := user.Token{
 		Token:        jwt,
-		RefreshToken: oldSession.RefreshToken,
+		RefreshToken: refreshToken,
 	}
 	mockEnv := getTestEnv(cfg, userRepo, sessionRepo, nil)
 
@@ -449,6 +681,45 @@ func TestHandleTokenRenewal(t *testing.T) {
 	assert.Equal(http.StatusBadRequest, res.Code)
 }
 
+func TestHandleGuestLogin(t *testing.T) {
+	assert := assert.New(t)
+
+	cfg := getTestConfig()
+	verifier := auth.NewVerifier(cfg.JWTCredentials, 0)
+	userRepo := &repository.MockUserRepo{}
+	sessionRepo := &repository.MockSessionRepo{}
+	listRepo := &repository.MockWatchlistRepo{}
+	mockEnv := getTestEnv(cfg, userRepo, sessionRepo, listRepo)
+	server := newServer(mockEnv, cfg)
+
+	// Setup: Create a guest, no auth token is needed.
+	req := createTestPostRequest("", "", "/v1/login/guest", nil)
+	res := performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusOK, res.Code)
+	var token user.Token
+	err := json.NewDecoder(res.Body).Decode(&token)
+	assert.NoError(err)
+	assert.Equal(domain.GuestRole, token.User.Role)
+	assert.NotEqual("", token.RefreshToken)
+	assert.Equal(token.User.ID, userRepo.SaveArg.User.ID)
+	assert.Equal(token.User.ID, sessionRepo.SaveArg.UserID)
+	assert.Equal(token.User.ID, listRepo.SaveArgUserID)
+
+	content, err := verifier.Verify(token.Token)
+	assert.NoError(err)
+	assert.Equal(domain.GuestRole, content.User.Role)
+
+	// Setup: Guests should be able to edit their own watchlists.
+	listRepo.SaveArgUserID = ""
+	req = createTestPostRequest("", token.Token, "/v1/watchlists/my-list", nil)
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Equal(token.User.ID, listRepo.SaveArgUserID)
+	assert.Equal("my-list", listRepo.SaveArgWatchlist.Name)
+}
+
 func TestDisallowAnonymousToken(t *testing.T) {
 	assert := assert.New(t)
 
@@ -508,3 +779,170 @@ func TestDisallowAnonymousToken(t *testing.T) {
 	}
 
 }
+
+func TestHandleEmailVerification(t *testing.T) {
+	assert := assert.New(t)
+
+	key := "my-verification-key"
+	userID := id.New()
+	userEmail := "mail@mail.com"
+	storedUser := domain.FullUser{
+		User: user.User{
+			ID:    userID,
+			Email: userEmail,
+			Role:  auth.UserRole,
+		},
+	}
+	hashedKey := fmt.Sprintf("%x", auth.HashKey(key, userEmail))
+	credential := domain.NewOneTimeCredential(domain.EmailVerificationCredential, hashedKey, userID, time.Hour)
+
+	conf := getTestConfig()
+	userRepo := &repository.MockUserRepo{
+		FindUser: storedUser,
+	}
+	credentialRepo := &repository.MockOneTimeCredentialRepo{
+		FindByKeyCredential: credential,
+	}
+	mockEnv := getTestEnv(conf, userRepo, nil, nil)
+	mockEnv.verificationSvc = service.NewEmailVerificationService(service.LimitUnverified, nil, userRepo, credentialRepo)
+	server := newServer(mockEnv, conf)
+
+	// Setup: Verify email happy path, no auth token required.
+	route := "/v1/users/" + userID + "/email/verify"
+	req := createTestPostRequest("", "", route, domain.EmailVerification{Token: key})
+	res := performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Equal(credential.ID, credentialRepo.MarkUsedArg)
+	assert.True(userRepo.SaveArg.EmailVerified)
+
+	// Setup: Wrong key.
+	credentialRepo.UnsetArgs()
+	userRepo.SaveArg = domain.FullUser{}
+	credentialRepo.FindByKeyErr = repository.ErrNoSuchCredential
+	req = createTestPostRequest("", "", route, domain.EmailVerification{Token: "wrong-key"})
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusForbidden, res.Code)
+	assert.False(userRepo.SaveArg.EmailVerified)
+
+	// Setup: No token provided.
+	credentialRepo.UnsetArgs()
+	req = createTestPostRequest("", "", route, domain.EmailVerification{})
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusBadRequest, res.Code)
+	assert.Equal(0, credentialRepo.FindByKeyInvocation)
+}
+
+func TestHandleEmailVerificationResend(t *testing.T) {
+	assert := assert.New(t)
+
+	userID := id.New()
+	storedUser := domain.FullUser{
+		User: user.User{
+			ID:    userID,
+			Email: "mail@mail.com",
+			Role:  auth.UserRole,
+		},
+	}
+
+	conf := getTestConfig()
+	conf.RateLimitPolicy = service.RateLimitPolicy{
+		PerIP:    domain.RateLimit{Requests: 5, Period: time.Minute},
+		PerEmail: domain.RateLimit{Requests: 1, Period: time.Minute},
+	}
+	userRepo := &repository.MockUserRepo{
+		FindUser: storedUser,
+	}
+	credentialRepo := &repository.MockOneTimeCredentialRepo{}
+	mailer := &service.MockMailer{}
+	mockEnv := getTestEnv(conf, userRepo, nil, nil)
+	mockEnv.verificationSvc = service.NewEmailVerificationService(service.BlockUnverified, mailer, userRepo, credentialRepo)
+	server := newServer(mockEnv, conf)
+
+	// Setup: Resend happy path, no auth token required.
+	route := "/v1/users/" + userID + "/email/verify/resend"
+	req := createTestPostRequest("", "", route, nil)
+	res := performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Equal(userID, credentialRepo.SaveArg.UserID)
+	assert.Equal(1, len(mailer.Outbox))
+	assert.Equal(storedUser.User.Email, mailer.Outbox[0].To)
+
+	// Setup: Resends are limited per user.
+	credentialRepo.UnsetArgs()
+	req = createTestPostRequest("", "", route, nil)
+	req.RemoteAddr = "10.0.0.2:4321"
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusTooManyRequests, res.Code)
+	assert.Equal(0, credentialRepo.SaveInvocation)
+	assert.Equal(1, len(mailer.Outbox))
+
+	// Setup: Unknown users should not be revealed.
+	userRepo.FindErr = repository.ErrNoSuchUser
+	req = createTestPostRequest("", "", "/v1/users/unknown-id/email/verify/resend", nil)
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Equal(0, credentialRepo.SaveInvocation)
+}
+
+func TestHandleLoginWithUnverifiedEmail(t *testing.T) {
+	assert := assert.New(t)
+
+	credentials := user.Credentials{
+		Email:    "mail@mail.com",
+		Password: correctPassword,
+	}
+
+	unverifiedUser := domain.FullUser{
+		User: user.User{
+			ID:        id.New(),
+			Email:     credentials.Email,
+			Role:      auth.UserRole,
+			CreatedAt: time.Now().UTC(),
+		},
+		Credentials: domain.StoredCredentials{
+			Email:    credentials.Email,
+			Password: encryptedPassword,
+			Salt:     encryptedSalt,
+		},
+	}
+
+	// Setup: Limit unverified users.
+	conf := getTestConfig()
+	conf.EmailVerificationPolicy = service.LimitUnverified
+	userRepo := &repository.MockUserRepo{
+		FindByEmailUser: unverifiedUser,
+	}
+	sessionRepo := &repository.MockSessionRepo{}
+	server := newServer(getTestEnv(conf, userRepo, sessionRepo, nil), conf)
+
+	req := createTestPostRequest("", "", "/v1/login", credentials)
+	res := performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusOK, res.Code)
+	var token user.Token
+	err := json.NewDecoder(res.Body).Decode(&token)
+	assert.NoError(err)
+	assert.Equal(domain.UnverifiedRole, token.User.Role)
+	assert.Equal(1, sessionRepo.SaveInvocation)
+
+	req = createTestGetRequest("", token.Token, "/v1/watchlists/some-list-id")
+	res = performTestRequest(server.Handler, req)
+	assert.Equal(http.StatusForbidden, res.Code)
+
+	// Setup: Block unverified users.
+	conf.EmailVerificationPolicy = service.BlockUnverified
+	sessionRepo.UnsetArgs()
+	server = newServer(getTestEnv(conf, userRepo, sessionRepo, nil), conf)
+
+	req = createTestPostRequest("", "", "/v1/login", credentials)
+	res = performTestRequest(server.Handler, req)
+	// Test
+	assert.Equal(http.StatusForbidden, res.Code)
+	assert.Equal(0, sessionRepo.SaveInvocation)
+}
@@ -5,12 +5,11 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/mimir-news/pkg/httputil"
-	"github.com/mimir-news/pkg/httputil/auth"
 )
 
 func (e *env) handleCreateWatchlist(c *gin.Context) {
 	listName := c.Param("name")
-	userID, err := auth.GetUserID(c)
+	userID, err := getAuthUserID(c)
 	if err != nil {
 		c.Error(err)
 		return
@@ -110,6 +109,6 @@ func (e *env) handleDeleteStockFromWatchlist(c *gin.Context) {
 
 func getUserAndWatchlistID(c *gin.Context) (string, string, error) {
 	listID := c.Param("watchlistId")
-	userID, err := auth.GetUserID(c)
+	userID, err := getAuthUserID(c)
 	return userID, listID, err
 }
@@ -0,0 +1,40 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/mimir-news/pkg/schema/user"
+)
+
+// AdminUser description of a user account as shown to admins.
+type AdminUser struct {
+	User          user.User     `json:"user"`
+	EmailVerified bool          `json:"emailVerified"`
+	Locked        bool          `json:"locked"`
+	LockedUntil   *time.Time    `json:"lockedUntil,omitempty"`
+	MFAEnabled    bool          `json:"mfaEnabled"`
+	DeletedAt     *time.Time    `json:"deletedAt,omitempty"`
+	Sessions      []SessionInfo `json:"sessions,omitempty"`
+}
+
+// NewAdminUser creates the admin view of a user, a temporary lockout is only included while it is in effect.
+func NewAdminUser(u FullUser, at time.Time) AdminUser {
+	adminUser := AdminUser{
+		User:          u.User,
+		EmailVerified: u.EmailVerified,
+		Locked:        u.Locked,
+		MFAEnabled:    u.TOTP.Enabled,
+	}
+
+	if at.Before(u.LoginFailures.LockedUntil) {
+		lockedUntil := u.LoginFailures.LockedUntil
+		adminUser.LockedUntil = &lockedUntil
+	}
+
+	if u.IsDeleted() {
+		deletedAt := u.DeletedAt
+		adminUser.DeletedAt = &deletedAt
+	}
+
+	return adminUser
+}
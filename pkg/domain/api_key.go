@@ -0,0 +1,140 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/mimir-news/pkg/id"
+)
+
+// API key scopes, which grant read or write access to a group of routes.
+// Changes to the account itself, such as credentials and sessions, can not be made with API keys.
+const (
+	UsersReadScope       = "users:read"
+	WatchlistsReadScope  = "watchlists:read"
+	WatchlistsWriteScope = "watchlists:write"
+)
+
+// MaxAPIKeyValidity longest time that an API key can be valid for.
+const MaxAPIKeyValidity = 365 * 24 * time.Hour
+
+var apiKeyScopes = map[string]bool{
+	UsersReadScope:       true,
+	WatchlistsReadScope:  true,
+	WatchlistsWriteScope: true,
+}
+
+// APIKey long lived credential used by scripts and services to act as a user.
+// The prefix identifies the key and is stored in plain text while the rest of the key is only stored hashed.
+type APIKey struct {
+	ID         string
+	UserID     string
+	Name       string
+	Prefix     string
+	KeyHash    string
+	Scopes     []string
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	LastUsedAt time.Time
+	RevokedAt  time.Time
+}
+
+// NewAPIKey creates a new API key belonging to a user.
+func NewAPIKey(userID, name, prefix, hashedKey string, scopes []string, validity time.Duration) APIKey {
+	now := time.Now().UTC()
+	return APIKey{
+		ID:        id.New(),
+		UserID:    userID,
+		Name:      name,
+		Prefix:    prefix,
+		KeyHash:   hashedKey,
+		Scopes:    scopes,
+		CreatedAt: now,
+		ExpiresAt: now.Add(validity),
+	}
+}
+
+// Valid checks if the key has not been revoked and has not expired.
+func (k APIKey) Valid(at time.Time) bool {
+	return k.RevokedAt.IsZero() && at.Before(k.ExpiresAt)
+}
+
+// HasScope checks if the key has been granted a scope.
+func (k APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Info returns the description of the key that can be shown to its owner, which excludes the key hash.
+func (k APIKey) Info() APIKeyInfo {
+	info := APIKeyInfo{
+		ID:        k.ID,
+		Name:      k.Name,
+		Prefix:    k.Prefix,
+		Scopes:    k.Scopes,
+		CreatedAt: k.CreatedAt,
+		ExpiresAt: k.ExpiresAt,
+	}
+
+	if !k.LastUsedAt.IsZero() {
+		lastUsedAt := k.LastUsedAt
+		info.LastUsedAt = &lastUsedAt
+	}
+
+	return info
+}
+
+// APIKeyInfo description of an API key.
+type APIKeyInfo struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  time.Time  `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+}
+
+// CreatedAPIKey newly created API key, the plaintext key is only returned when the key is created.
+type CreatedAPIKey struct {
+	Key  string     `json:"key"`
+	Info APIKeyInfo `json:"info"`
+}
+
+// APIKeyRequest request to create an API key with the given scopes, valid for a number of days.
+type APIKeyRequest struct {
+	Name         string   `json:"name"`
+	Scopes       []string `json:"scopes"`
+	ValidForDays int      `json:"validForDays"`
+}
+
+// Valid checks if an API key request is named, has known scopes and a validity no longer than the maximum.
+func (r APIKeyRequest) Valid() bool {
+	if r.Name == "" || len(r.Name) > 100 || len(r.Scopes) == 0 {
+		return false
+	}
+
+	for _, scope := range r.Scopes {
+		if !apiKeyScopes[scope] {
+			return false
+		}
+	}
+
+	return r.ValidForDays > 0 && r.Validity() <= MaxAPIKeyValidity
+}
+
+// Validity returns the time that the requested key should be valid for.
+func (r APIKeyRequest) Validity() time.Duration {
+	return time.Duration(r.ValidForDays) * 24 * time.Hour
+}
+
+// AuthenticatedKey owner and scopes of an API key that a request was authenticated with.
+type AuthenticatedKey struct {
+	UserID string
+	Role   string
+	Scopes []string
+}
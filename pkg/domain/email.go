@@ -0,0 +1,8 @@
+package domain
+
+// Email message to send to a user.
+type Email struct {
+	To      string
+	Subject string
+	Body    string
+}
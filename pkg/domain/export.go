@@ -0,0 +1,79 @@
+package domain
+
+import (
+	"time"
+)
+
+// UserExport the data stored about a user, which the user can export.
+type UserExport struct {
+	Profile    ExportedProfile     `json:"profile"`
+	Watchlists []ExportedWatchlist `json:"watchlists"`
+	Sessions   []ExportedSession   `json:"sessions"`
+	Events     []EventInfo         `json:"events"`
+	ExportedAt time.Time           `json:"exportedAt"`
+}
+
+// ExportedProfile the profile of an exported user, which excludes credentials and secrets.
+type ExportedProfile struct {
+	ID            string    `json:"id"`
+	Email         string    `json:"email"`
+	Role          string    `json:"role"`
+	EmailVerified bool      `json:"emailVerified"`
+	MFAEnabled    bool      `json:"mfaEnabled"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+// NewExportedProfile creates the exported profile of a user.
+func NewExportedProfile(u FullUser) ExportedProfile {
+	return ExportedProfile{
+		ID:            u.User.ID,
+		Email:         u.User.Email,
+		Role:          u.User.Role,
+		EmailVerified: u.EmailVerified,
+		MFAEnabled:    u.TOTP.Enabled,
+		CreatedAt:     u.User.CreatedAt,
+	}
+}
+
+// ExportedWatchlist watchlist of an exported user along with when each stock was added to it.
+type ExportedWatchlist struct {
+	ID        string                    `json:"id"`
+	Name      string                    `json:"name"`
+	CreatedAt time.Time                 `json:"createdAt"`
+	Members   []ExportedWatchlistMember `json:"members"`
+}
+
+// ExportedWatchlistMember stock in an exported watchlist.
+type ExportedWatchlistMember struct {
+	Symbol  string    `json:"symbol"`
+	Name    string    `json:"name"`
+	AddedAt time.Time `json:"addedAt"`
+}
+
+// ExportedSession session of an exported user, including sessions that have ended.
+type ExportedSession struct {
+	SessionInfo
+	Active    bool       `json:"active"`
+	RotatedAt *time.Time `json:"rotatedAt,omitempty"`
+	EndedAt   *time.Time `json:"endedAt,omitempty"`
+}
+
+// NewExportedSession creates the exported session history entry of a session.
+func NewExportedSession(s Session) ExportedSession {
+	exported := ExportedSession{
+		SessionInfo: s.Info(),
+		Active:      s.Active,
+	}
+
+	if s.Rotated() {
+		rotatedAt := s.RotatedAt
+		exported.RotatedAt = &rotatedAt
+	}
+
+	if !s.DeletedAt.IsZero() {
+		endedAt := s.DeletedAt
+		exported.EndedAt = &endedAt
+	}
+
+	return exported
+}
@@ -0,0 +1,84 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/mimir-news/pkg/id"
+)
+
+// ExternalIdentity identity of a user at an external OpenID Connect provider.
+type ExternalIdentity struct {
+	ID        string
+	UserID    string
+	Provider  string
+	Subject   string
+	Email     string
+	CreatedAt time.Time
+}
+
+// NewExternalIdentity creates a new external identity linked to a user.
+func NewExternalIdentity(provider, subject, email, userID string) ExternalIdentity {
+	return ExternalIdentity{
+		ID:        id.New(),
+		UserID:    userID,
+		Provider:  provider,
+		Subject:   subject,
+		Email:     email,
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+// ExternalLoginState state of an external login that was started by redirecting the user to a provider.
+// The state parameter is stored hashed, the PKCE code verifier and nonce are used when the user returns.
+// The binding is stored hashed as well and ties the login to the browser that started it.
+type ExternalLoginState struct {
+	StateHash    string
+	BindingHash  string
+	Provider     string
+	CodeVerifier string
+	Nonce        string
+	CreatedAt    time.Time
+	ValidTo      time.Time
+}
+
+// NewExternalLoginState creates a new external login state valid for the given ttl.
+func NewExternalLoginState(
+	hashedState, hashedBinding, provider, codeVerifier, nonce string, ttl time.Duration) ExternalLoginState {
+	now := time.Now().UTC()
+	return ExternalLoginState{
+		StateHash:    hashedState,
+		BindingHash:  hashedBinding,
+		Provider:     provider,
+		CodeVerifier: codeVerifier,
+		Nonce:        nonce,
+		CreatedAt:    now,
+		ValidTo:      now.Add(ttl),
+	}
+}
+
+// Valid checks if the login state has not expired.
+func (s ExternalLoginState) Valid() bool {
+	return time.Now().UTC().Before(s.ValidTo)
+}
+
+// ExternalLoginCallback parameters which a provider redirects the user back with,
+// either an authorization code or an error along with the state of the login.
+// The binding is not a parameter, it is read from the browser that the user returns with.
+type ExternalLoginCallback struct {
+	Code    string `form:"code"`
+	State   string `form:"state"`
+	Error   string `form:"error"`
+	Binding string `form:"-"`
+}
+
+// Valid checks if the callback contains a state and either a code or an error.
+func (c ExternalLoginCallback) Valid() bool {
+	return c.State != "" && (c.Code != "" || c.Error != "")
+}
+
+// ExternalClaims verified claims about a user issued by an external provider.
+type ExternalClaims struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
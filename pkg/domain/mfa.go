@@ -0,0 +1,86 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/mimir-news/pkg/schema/user"
+)
+
+// TOTP time based one time password configuration of a user. The secret is stored encrypted.
+type TOTP struct {
+	Secret       string
+	Enabled      bool
+	LastUsedStep int64
+}
+
+// TOTPEnrollment secret and otpauth uri used to add a TOTP generator to an authenticator app.
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// TOTPEnrollmentRequest current password of a user which is required to enroll a TOTP generator.
+type TOTPEnrollmentRequest struct {
+	Password string `json:"password"`
+}
+
+// Valid checks if the enrollment request contains a password.
+func (r TOTPEnrollmentRequest) Valid() bool {
+	return r.Password != ""
+}
+
+// TOTPConfirmation code used to confirm a TOTP enrollment.
+type TOTPConfirmation struct {
+	Code string `json:"code"`
+}
+
+// Valid checks if the confirmation contains a code.
+func (c TOTPConfirmation) Valid() bool {
+	return c.Code != ""
+}
+
+// RecoveryCodes single use codes that can be used instead of a TOTP code.
+type RecoveryCodes struct {
+	Codes []string `json:"recoveryCodes"`
+}
+
+// MFAChallenge challenge issued after a successful password login for users with
+// multi factor authentication enabled. The challenge token must be submitted along with a second factor.
+type MFAChallenge struct {
+	MFARequired    bool      `json:"mfaRequired"`
+	ChallengeToken string    `json:"challengeToken"`
+	ValidTo        time.Time `json:"validTo"`
+}
+
+// MFAVerification second factor submitted to complete an MFA challenge,
+// either a TOTP code or a recovery code.
+type MFAVerification struct {
+	ChallengeToken string `json:"challengeToken"`
+	Code           string `json:"code"`
+	RecoveryCode   string `json:"recoveryCode"`
+}
+
+// Valid checks if the verification contains a challenge token and exactly one second factor.
+func (v MFAVerification) Valid() bool {
+	return v.ChallengeToken != "" && (v.Code == "") != (v.RecoveryCode == "")
+}
+
+// MFAReauthentication current password and second factor of a user, either a TOTP code or a recovery code,
+// which are required to disable multi factor authentication or to issue new recovery codes.
+type MFAReauthentication struct {
+	Password     string `json:"password"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+// Valid checks if the reauthentication contains a password and exactly one second factor.
+func (r MFAReauthentication) Valid() bool {
+	return r.Password != "" && (r.Code == "") != (r.RecoveryCode == "")
+}
+
+// LoginResult result of a successful password login. Either a token is issued or, if the user
+// has multi factor authentication enabled, a challenge that must be completed to get a token.
+type LoginResult struct {
+	Token     user.Token
+	Challenge *MFAChallenge
+}
@@ -0,0 +1,32 @@
+package domain
+
+// Token type hints of introspection and revocation requests.
+const (
+	AccessTokenType  = "access_token"
+	RefreshTokenType = "refresh_token"
+)
+
+// TokenRequest request to introspect or revoke a token. The type hint is optional
+// and only decides which kind of token is looked up first.
+type TokenRequest struct {
+	Token         string `form:"token" json:"token"`
+	TokenTypeHint string `form:"token_type_hint" json:"token_type_hint"`
+}
+
+// Valid checks if a token request contains a token.
+func (r TokenRequest) Valid() bool {
+	return r.Token != ""
+}
+
+// TokenIntrospection state of a token. Only the active flag is set for tokens that are not active.
+type TokenIntrospection struct {
+	Active    bool   `json:"active"`
+	TokenType string `json:"token_type,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	Role      string `json:"role,omitempty"`
+	SessionID string `json:"jti,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+}
+
+// InactiveToken introspection of a token that is invalid, expired or revoked.
+var InactiveToken = TokenIntrospection{Active: false}
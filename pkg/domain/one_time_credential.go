@@ -0,0 +1,128 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/mimir-news/pkg/id"
+)
+
+// One time credential purposes.
+const (
+	PasswordResetCredential     = "PASSWORD_RESET"
+	EmailVerificationCredential = "EMAIL_VERIFICATION"
+	EmailChangeCredential       = "EMAIL_CHANGE"
+	EmailRevertCredential       = "EMAIL_REVERT"
+	MFAChallengeCredential      = "MFA_CHALLENGE"
+	EmailLoginLinkCredential    = "EMAIL_LOGIN_LINK"
+	EmailLoginCodeCredential    = "EMAIL_LOGIN_CODE"
+)
+
+// Passwordless email login methods.
+const (
+	EmailLoginLink = "LINK"
+	EmailLoginCode = "CODE"
+)
+
+// OneTimeCredential single use credential that is only valid for a limited time.
+// Credentials used to change email addresses record the address they were issued for and
+// credentials which can be guessed, such as short codes, record failed attempts to use them.
+type OneTimeCredential struct {
+	ID          string
+	Purpose     string
+	Key         string
+	UserID      string
+	Email       string
+	Attempts    int
+	HasBeenUsed bool
+	CreatedAt   time.Time
+	ValidTo     time.Time
+}
+
+// NewOneTimeCredential creates a new one time credential with a hashed key.
+func NewOneTimeCredential(purpose, hashedKey, userID string, ttl time.Duration) OneTimeCredential {
+	now := time.Now().UTC()
+	return OneTimeCredential{
+		ID:          id.New(),
+		Purpose:     purpose,
+		Key:         hashedKey,
+		UserID:      userID,
+		HasBeenUsed: false,
+		CreatedAt:   now,
+		ValidTo:     now.Add(ttl),
+	}
+}
+
+// Valid checks if the credential is unused and has not expired.
+func (c OneTimeCredential) Valid() bool {
+	return !c.HasBeenUsed && time.Now().UTC().Before(c.ValidTo)
+}
+
+// PasswordResetRequest request to reset the password of the user with the given email.
+type PasswordResetRequest struct {
+	Email string `json:"email"`
+}
+
+// Valid checks if the reset request contains an email.
+func (r PasswordResetRequest) Valid() bool {
+	return r.Email != ""
+}
+
+// PasswordReset new password to set when completing a password reset.
+type PasswordReset struct {
+	New      string `json:"new"`
+	Repeated string `json:"repeated"`
+}
+
+// Valid checks if the new password has been provided.
+func (r PasswordReset) Valid() bool {
+	return r.New != "" && r.Repeated != ""
+}
+
+// EmailVerification key used to verify the email address of a user.
+type EmailVerification struct {
+	Token string `json:"token"`
+}
+
+// Valid checks if the verification contains a token.
+func (v EmailVerification) Valid() bool {
+	return v.Token != ""
+}
+
+// EmailChange request to change the email address of a user, requires the users current password.
+type EmailChange struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// Valid checks if the email change contains both a new email and a password.
+func (c EmailChange) Valid() bool {
+	return c.Email != "" && c.Password != ""
+}
+
+// EmailLoginRequest request to log in without a password by receiving a link or a code by email.
+type EmailLoginRequest struct {
+	Email  string `json:"email"`
+	Method string `json:"method"`
+}
+
+// Valid checks if the request contains an email and a known login method.
+// If no method is provided a link is sent.
+func (r EmailLoginRequest) Valid() bool {
+	return r.Email != "" && (r.Method == "" || r.Method == EmailLoginLink || r.Method == EmailLoginCode)
+}
+
+// EmailLogin token from a login link or email and code used to complete a passwordless login.
+type EmailLogin struct {
+	Token string `json:"token"`
+	Email string `json:"email"`
+	Code  string `json:"code"`
+}
+
+// Valid checks if the login contains either a token or both an email and a code.
+func (l EmailLogin) Valid() bool {
+	if l.Token != "" {
+		return l.Email == "" && l.Code == ""
+	}
+
+	return l.Email != "" && l.Code != ""
+}
@@ -0,0 +1,68 @@
+package domain
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// RateLimit token bucket limit which allows bursts of up to Requests requests,
+// with the bucket being refilled at a rate of Requests per Period.
+type RateLimit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// Valid checks that the rate limit allows requests to be made.
+func (l RateLimit) Valid() error {
+	if l.Requests <= 0 || l.Period <= 0 {
+		return fmt.Errorf("Invalid rate limit: %d requests per %s", l.Requests, l.Period)
+	}
+
+	return nil
+}
+
+// refillTime returns the time it takes to refill a number of tokens.
+func (l RateLimit) refillTime(tokens float64) time.Duration {
+	return time.Duration(tokens * float64(l.Period) / float64(l.Requests))
+}
+
+// TokenBucket the tokens left of a rate limited key.
+type TokenBucket struct {
+	Key       string
+	Tokens    float64
+	UpdatedAt time.Time
+}
+
+// NewTokenBucket creates a full token bucket.
+func NewTokenBucket(key string, limit RateLimit, at time.Time) TokenBucket {
+	return TokenBucket{
+		Key:       key,
+		Tokens:    float64(limit.Requests),
+		UpdatedAt: at,
+	}
+}
+
+// Take refills the bucket for the time passed since it was last updated and takes a token from it.
+// If the bucket is empty no token is taken and the time until a token is available is returned.
+func (b TokenBucket) Take(limit RateLimit, at time.Time) (TokenBucket, time.Duration) {
+	elapsed := at.Sub(b.UpdatedAt)
+	if elapsed > 0 {
+		refilled := float64(elapsed) * float64(limit.Requests) / float64(limit.Period)
+		b.Tokens = math.Min(float64(limit.Requests), b.Tokens+refilled)
+		b.UpdatedAt = at
+	}
+
+	if b.Tokens < 1 {
+		return b, limit.refillTime(1 - b.Tokens)
+	}
+
+	b.Tokens--
+	return b, 0
+}
+
+// FullAt returns the time when the bucket will have been refilled,
+// after which it is no different from a new bucket and does not need to be kept.
+func (b TokenBucket) FullAt(limit RateLimit) time.Time {
+	return b.UpdatedAt.Add(limit.refillTime(float64(limit.Requests) - b.Tokens))
+}
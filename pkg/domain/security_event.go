@@ -0,0 +1,106 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/mimir-news/pkg/id"
+)
+
+// Security event types.
+const (
+	RefreshTokenReuseEvent         = "REFRESH_TOKEN_REUSE"
+	LoginSuccessEvent              = "LOGIN_SUCCESS"
+	LoginFailureEvent              = "LOGIN_FAILURE"
+	TokenRefreshEvent              = "TOKEN_REFRESH"
+	PasswordChangeEvent            = "PASSWORD_CHANGE"
+	EmailChangeEvent               = "EMAIL_CHANGE"
+	EmailChangeRevertEvent         = "EMAIL_CHANGE_REVERT"
+	UserDeletionEvent              = "USER_DELETION"
+	MFADisableEvent                = "MFA_DISABLE"
+	RecoveryCodesRegenerateEvent   = "RECOVERY_CODES_REGENERATE"
+	UserRestoreEvent               = "USER_RESTORE"
+	AnonymousTokenEvent            = "ANONYMOUS_TOKEN"
+	SessionRevocationEvent         = "SESSION_REVOCATION"
+	DataExportEvent                = "DATA_EXPORT"
+	AdminEventExportEvent          = "ADMIN_EVENT_EXPORT"
+	AdminUserSearchEvent           = "ADMIN_USER_SEARCH"
+	AdminUserViewEvent             = "ADMIN_USER_VIEW"
+	AdminUserLockEvent             = "ADMIN_USER_LOCK"
+	AdminUserUnlockEvent           = "ADMIN_USER_UNLOCK"
+	AdminUserLogoutEvent           = "ADMIN_USER_LOGOUT"
+	AdminPasswordResetEvent        = "ADMIN_PASSWORD_RESET"
+	AdminServiceAccountCreateEvent = "ADMIN_SERVICE_ACCOUNT_CREATE"
+	AdminServiceAccountDeleteEvent = "ADMIN_SERVICE_ACCOUNT_DELETE"
+	AdminAPIKeyCreateEvent         = "ADMIN_API_KEY_CREATE"
+	AdminAPIKeyRevokeEvent         = "ADMIN_API_KEY_REVOKE"
+)
+
+// SecurityEvent security relevant event that has occured for a user.
+type SecurityEvent struct {
+	ID         string
+	Type       string
+	UserID     string
+	ActorID    string
+	SessionID  string
+	Client     ClientInfo
+	OccurredAt time.Time
+}
+
+// NewSecurityEvent creates a new security event.
+func NewSecurityEvent(eventType, userID, sessionID string, client ClientInfo) SecurityEvent {
+	return SecurityEvent{
+		ID:         id.New(),
+		Type:       eventType,
+		UserID:     userID,
+		SessionID:  sessionID,
+		Client:     client,
+		OccurredAt: time.Now().UTC(),
+	}
+}
+
+// NewAccountEvent creates a new security event for an action taken by a user on its own account.
+func NewAccountEvent(eventType, userID, sessionID string, client ClientInfo) SecurityEvent {
+	event := NewSecurityEvent(eventType, userID, sessionID, client)
+	event.ActorID = userID
+	return event
+}
+
+// Info returns the description of the event that is shown to users and exported to admins.
+func (e SecurityEvent) Info() EventInfo {
+	return EventInfo{
+		ID:         e.ID,
+		Type:       e.Type,
+		UserID:     e.UserID,
+		ActorID:    e.ActorID,
+		SessionID:  e.SessionID,
+		ClientIP:   e.Client.IP,
+		UserAgent:  e.Client.UserAgent,
+		OccurredAt: e.OccurredAt,
+	}
+}
+
+// EventInfo description of a security event.
+type EventInfo struct {
+	ID         string    `json:"id"`
+	Type       string    `json:"type"`
+	UserID     string    `json:"userId"`
+	ActorID    string    `json:"actorId,omitempty"`
+	SessionID  string    `json:"sessionId,omitempty"`
+	ClientIP   string    `json:"clientIp"`
+	UserAgent  string    `json:"userAgent"`
+	OccurredAt time.Time `json:"occurredAt"`
+}
+
+// NewAdminEvent creates a new security event for an action taken by an admin on the account of a user.
+// The user id is empty for actions that do not target a single user, such as searches.
+func NewAdminEvent(eventType string, actor Actor, userID string) SecurityEvent {
+	event := NewSecurityEvent(eventType, userID, "", actor.Client)
+	event.ActorID = actor.UserID
+	return event
+}
+
+// Actor user that performed an action along with the client it was performed from.
+type Actor struct {
+	UserID string
+	Client ClientInfo
+}
@@ -0,0 +1,40 @@
+package domain
+
+import (
+	"strings"
+	"time"
+
+	"github.com/mimir-news/pkg/schema/user"
+)
+
+const maxServiceAccountNameLength = 100
+
+// ServiceAccount named account created by an admin for services and scripts, which act as the account through its API keys.
+type ServiceAccount struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedBy string    `json:"createdBy"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// NewServiceAccount creates a new service account.
+func NewServiceAccount(name, createdBy string) ServiceAccount {
+	u := user.New("", ServiceAccountRole, nil)
+	return ServiceAccount{
+		ID:        u.ID,
+		Name:      strings.TrimSpace(name),
+		CreatedBy: createdBy,
+		CreatedAt: u.CreatedAt,
+	}
+}
+
+// ServiceAccountRequest request to create a service account.
+type ServiceAccountRequest struct {
+	Name string `json:"name"`
+}
+
+// Valid checks if the service account request has a name.
+func (r ServiceAccountRequest) Valid() bool {
+	name := strings.TrimSpace(r.Name)
+	return name != "" && len(name) <= maxServiceAccountNameLength
+}
@@ -11,10 +11,61 @@ import (
 	"golang.org/x/crypto/sha3"
 )
 
+// UnverifiedRole role given to users that have not verified their email address.
+const UnverifiedRole = "UNVERIFIED"
+
+// GuestRole role of persisted users without an email, which are purged after a period of inactivity.
+const GuestRole = "GUEST"
+
+// AdminRole role of users that are allowed to manage the accounts of other users.
+const AdminRole = "ADMIN"
+
+// ServiceAccountRole role of accounts without email or credentials, which are only used through API keys.
+const ServiceAccountRole = "SERVICE"
+
 // FullUser user with credentials.
 type FullUser struct {
-	User        user.User
-	Credentials StoredCredentials
+	User          user.User
+	Credentials   StoredCredentials
+	EmailVerified bool
+	Locked        bool
+	LoginFailures LoginFailures
+
+	SessionsValidAfter time.Time
+	TOTP               TOTP
+	DeletedAt          time.Time
+}
+
+// IsLocked checks if the user is locked, either permanently or temporarily at the given time.
+func (u FullUser) IsLocked(at time.Time) bool {
+	return u.Locked || at.Before(u.LoginFailures.LockedUntil)
+}
+
+// IsDeleted checks if the user has been deleted and is waiting to be purged.
+func (u FullUser) IsDeleted() bool {
+	return !u.DeletedAt.IsZero()
+}
+
+// IsGuest checks if the user is a guest.
+func (u FullUser) IsGuest() bool {
+	return u.User.Role == GuestRole
+}
+
+// IsServiceAccount checks if the user is a service account.
+func (u FullUser) IsServiceAccount() bool {
+	return u.User.Role == ServiceAccountRole
+}
+
+// SessionRevoked checks if a session was started before the users sessions were invalidated.
+func (u FullUser) SessionRevoked(session Session) bool {
+	return session.CreatedAt.Before(u.SessionsValidAfter)
+}
+
+// LoginFailures record of failed login attempts made against a users account.
+type LoginFailures struct {
+	Attempts    int
+	Lockouts    int
+	LockedUntil time.Time
 }
 
 // NewUser creates a new full users.
@@ -25,6 +76,13 @@ func NewUser(credentials StoredCredentials, watchlists []user.Watchlist) FullUse
 	}
 }
 
+// NewGuest creates a new guest user without email or credentials.
+func NewGuest(watchlists []user.Watchlist) FullUser {
+	return FullUser{
+		User: user.New("", GuestRole, watchlists),
+	}
+}
+
 // StoredCredentials user credentials in hashed and encrypted from.
 type StoredCredentials struct {
 	Email    string
@@ -32,24 +90,98 @@ type StoredCredentials struct {
 	Salt     string
 }
 
-// Session describes a users session.
+// UserCredentials stored credentials and encrypted TOTP secret belonging to a user.
+type UserCredentials struct {
+	UserID      string
+	Credentials StoredCredentials
+	TOTPSecret  string
+}
+
+// Session describes a users session. The plaintext refresh token is only
+// known when the session is created, only its hash is stored.
 type Session struct {
-	ID           string
-	UserID       string
-	RefreshToken string
-	Active       bool
-	CreatedAt    time.Time
+	ID               string
+	UserID           string
+	RefreshToken     string
+	RefreshTokenHash string
+	Active           bool
+	CreatedAt        time.Time
+	UserAgent        string
+	ClientIP         string
+	DeviceName       string
+	LastRefreshedAt  time.Time
+	FamilyID         string
+	RotatedAt        time.Time
+	DeletedAt        time.Time
 }
 
-// NewSession creates a new session.
-func NewSession(userID string) Session {
+// NewSession creates a new session which starts a new session family.
+func NewSession(userID string, client ClientInfo) Session {
+	sessionID := id.New()
 	return Session{
-		ID:           id.New(),
+		ID:           sessionID,
+		FamilyID:     sessionID,
 		UserID:       userID,
 		RefreshToken: generateRefreshToken(),
 		Active:       true,
 		CreatedAt:    time.Now().UTC(),
+		UserAgent:    client.UserAgent,
+		ClientIP:     client.IP,
+		DeviceName:   client.DeviceName,
+	}
+}
+
+// Rotate creates the session that replaces this session when its refresh token is used.
+// The new session belongs to the same family and keeps the device name unless a new one is provided.
+func (s Session) Rotate(client ClientInfo) Session {
+	if client.DeviceName == "" {
+		client.DeviceName = s.DeviceName
+	}
+
+	next := NewSession(s.UserID, client)
+	next.FamilyID = s.FamilyID
+	next.LastRefreshedAt = next.CreatedAt
+	return next
+}
+
+// Rotated checks if the session has been replaced by a refreshed session.
+func (s Session) Rotated() bool {
+	return !s.RotatedAt.IsZero()
+}
+
+// Info returns the session info that can be shown to the user, which excludes the refresh token.
+func (s Session) Info() SessionInfo {
+	info := SessionInfo{
+		ID:         s.ID,
+		UserAgent:  s.UserAgent,
+		ClientIP:   s.ClientIP,
+		DeviceName: s.DeviceName,
+		CreatedAt:  s.CreatedAt,
+	}
+
+	if !s.LastRefreshedAt.IsZero() {
+		lastRefreshedAt := s.LastRefreshedAt
+		info.LastRefreshedAt = &lastRefreshedAt
 	}
+
+	return info
+}
+
+// ClientInfo describes the client that a session was started or refreshed from.
+type ClientInfo struct {
+	UserAgent  string
+	IP         string
+	DeviceName string
+}
+
+// SessionInfo description of an active session.
+type SessionInfo struct {
+	ID              string     `json:"id"`
+	UserAgent       string     `json:"userAgent"`
+	ClientIP        string     `json:"clientIp"`
+	DeviceName      string     `json:"deviceName,omitempty"`
+	CreatedAt       time.Time  `json:"createdAt"`
+	LastRefreshedAt *time.Time `json:"lastRefreshedAt,omitempty"`
 }
 
 // generateRefreshToken generates a random refresh token.
@@ -0,0 +1,224 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/pkg/dbutil"
+	"github.com/pkg/errors"
+)
+
+// API key errors.
+var (
+	ErrNoSuchAPIKey = errors.New("no such api key")
+)
+
+var (
+	emptyAPIKey = domain.APIKey{}
+)
+
+// APIKeyRepo interface for storing hashed API keys.
+type APIKeyRepo interface {
+	Save(key domain.APIKey) error
+	FindByPrefix(prefix string) (domain.APIKey, error)
+	FindByUserID(userID string) ([]domain.APIKey, error)
+	Revoke(userID, keyID string) error
+	UpdateLastUsed(keyID string, at time.Time) error
+}
+
+// NewAPIKeyRepo creates a new APIKeyRepo using the default implementation.
+func NewAPIKeyRepo(db *sql.DB) APIKeyRepo {
+	return &pgAPIKeyRepo{
+		db: db,
+	}
+}
+
+type pgAPIKeyRepo struct {
+	db *sql.DB
+}
+
+const saveAPIKeyQuery = `
+	INSERT INTO api_key(id, user_id, name, prefix, key_hash, scopes, created_at, expires_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+
+// Save stores a new API key.
+func (kr *pgAPIKeyRepo) Save(k domain.APIKey) error {
+	res, err := kr.db.Exec(saveAPIKeyQuery,
+		k.ID, k.UserID, k.Name, k.Prefix, k.KeyHash, pq.Array(k.Scopes), k.CreatedAt, k.ExpiresAt)
+	if err != nil {
+		return errors.Wrap(err, "pgAPIKeyRepo.Save failed")
+	}
+
+	return dbutil.AssertRowsAffected(res, 1, dbutil.ErrFailedInsert)
+}
+
+const findAPIKeyByPrefixQuery = `
+	SELECT id, user_id, name, prefix, key_hash, scopes, created_at, expires_at, last_used_at, revoked_at
+	FROM api_key WHERE prefix = $1`
+
+// FindByPrefix retrieves the API key with the given prefix, both revoked and expired keys are returned.
+func (kr *pgAPIKeyRepo) FindByPrefix(prefix string) (domain.APIKey, error) {
+	k, err := scanAPIKey(kr.db.QueryRow(findAPIKeyByPrefixQuery, prefix))
+	if err == sql.ErrNoRows {
+		return emptyAPIKey, ErrNoSuchAPIKey
+	} else if err != nil {
+		return emptyAPIKey, errors.Wrap(err, "pgAPIKeyRepo.FindByPrefix failed")
+	}
+
+	return k, nil
+}
+
+const findUserAPIKeysQuery = `
+	SELECT id, user_id, name, prefix, key_hash, scopes, created_at, expires_at, last_used_at, revoked_at
+	FROM api_key WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > $2
+	ORDER BY created_at DESC`
+
+// FindByUserID retrieves the API keys of a user which have not been revoked or expired.
+func (kr *pgAPIKeyRepo) FindByUserID(userID string) ([]domain.APIKey, error) {
+	rows, err := kr.db.Query(findUserAPIKeysQuery, userID, time.Now().UTC())
+	if err != nil {
+		return nil, errors.Wrap(err, "pgAPIKeyRepo.FindByUserID failed")
+	}
+	defer rows.Close()
+
+	keys := make([]domain.APIKey, 0)
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, errors.Wrap(err, "pgAPIKeyRepo.FindByUserID failed")
+		}
+		keys = append(keys, k)
+	}
+
+	return keys, rows.Err()
+}
+
+const revokeAPIKeyQuery = `
+	UPDATE api_key SET revoked_at = $3
+	WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`
+
+// Revoke revokes an API key if it belongs to the given user and has not already been revoked.
+func (kr *pgAPIKeyRepo) Revoke(userID, keyID string) error {
+	res, err := kr.db.Exec(revokeAPIKeyQuery, keyID, userID, time.Now().UTC())
+	if err != nil {
+		return errors.Wrap(err, "pgAPIKeyRepo.Revoke failed")
+	}
+
+	return dbutil.AssertRowsAffected(res, 1, ErrNoSuchAPIKey)
+}
+
+const updateAPIKeyLastUsedQuery = `UPDATE api_key SET last_used_at = $2 WHERE id = $1`
+
+// UpdateLastUsed records when an API key was last used.
+func (kr *pgAPIKeyRepo) UpdateLastUsed(keyID string, at time.Time) error {
+	_, err := kr.db.Exec(updateAPIKeyLastUsedQuery, keyID, at)
+	if err != nil {
+		return errors.Wrap(err, "pgAPIKeyRepo.UpdateLastUsed failed")
+	}
+
+	return nil
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAPIKey(row rowScanner) (domain.APIKey, error) {
+	var k domain.APIKey
+	var lastUsedAt, revokedAt pq.NullTime
+	err := row.Scan(&k.ID, &k.UserID, &k.Name, &k.Prefix, &k.KeyHash, pq.Array(&k.Scopes),
+		&k.CreatedAt, &k.ExpiresAt, &lastUsedAt, &revokedAt)
+	if err != nil {
+		return emptyAPIKey, err
+	}
+
+	k.LastUsedAt = lastUsedAt.Time
+	k.RevokedAt = revokedAt.Time
+	return k, nil
+}
+
+// MockAPIKeyRepo mock implementation of APIKeyRepo.
+type MockAPIKeyRepo struct {
+	SaveErr        error
+	SaveArg        domain.APIKey
+	SaveInvocation int
+
+	FindByPrefixKey        domain.APIKey
+	FindByPrefixErr        error
+	FindByPrefixArg        string
+	FindByPrefixInvocation int
+
+	FindByUserIDKeys       []domain.APIKey
+	FindByUserIDErr        error
+	FindByUserIDArg        string
+	FindByUserIDInvocation int
+
+	RevokeErr        error
+	RevokeArgUserID  string
+	RevokeArgKeyID   string
+	RevokeInvocation int
+
+	UpdateLastUsedErr        error
+	UpdateLastUsedArgKeyID   string
+	UpdateLastUsedArgAt      time.Time
+	UpdateLastUsedInvocation int
+}
+
+// Save mock implementation of saving an API key.
+func (kr *MockAPIKeyRepo) Save(key domain.APIKey) error {
+	kr.SaveArg = key
+	kr.SaveInvocation++
+	return kr.SaveErr
+}
+
+// FindByPrefix mock implementation of finding an API key by prefix.
+func (kr *MockAPIKeyRepo) FindByPrefix(prefix string) (domain.APIKey, error) {
+	kr.FindByPrefixArg = prefix
+	kr.FindByPrefixInvocation++
+	return kr.FindByPrefixKey, kr.FindByPrefixErr
+}
+
+// FindByUserID mock implementation of finding the API keys of a user.
+func (kr *MockAPIKeyRepo) FindByUserID(userID string) ([]domain.APIKey, error) {
+	kr.FindByUserIDArg = userID
+	kr.FindByUserIDInvocation++
+	return kr.FindByUserIDKeys, kr.FindByUserIDErr
+}
+
+// Revoke mock implementation of revoking an API key.
+func (kr *MockAPIKeyRepo) Revoke(userID, keyID string) error {
+	kr.RevokeArgUserID = userID
+	kr.RevokeArgKeyID = keyID
+	kr.RevokeInvocation++
+	return kr.RevokeErr
+}
+
+// UpdateLastUsed mock implementation of recording when an API key was last used.
+func (kr *MockAPIKeyRepo) UpdateLastUsed(keyID string, at time.Time) error {
+	kr.UpdateLastUsedArgKeyID = keyID
+	kr.UpdateLastUsedArgAt = at
+	kr.UpdateLastUsedInvocation++
+	return kr.UpdateLastUsedErr
+}
+
+// UnsetArgs sets all MockAPIKeyRepo fields to their default value.
+func (kr *MockAPIKeyRepo) UnsetArgs() {
+	kr.SaveArg = emptyAPIKey
+	kr.SaveInvocation = 0
+
+	kr.FindByPrefixArg = ""
+	kr.FindByPrefixInvocation = 0
+
+	kr.FindByUserIDArg = ""
+	kr.FindByUserIDInvocation = 0
+
+	kr.RevokeArgUserID = ""
+	kr.RevokeArgKeyID = ""
+	kr.RevokeInvocation = 0
+
+	kr.UpdateLastUsedArgKeyID = ""
+	kr.UpdateLastUsedArgAt = time.Time{}
+	kr.UpdateLastUsedInvocation = 0
+}
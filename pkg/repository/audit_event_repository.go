@@ -0,0 +1,182 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/pkg/dbutil"
+	"github.com/pkg/errors"
+)
+
+// AuditEventRepo interface for appending security events to the audit log and reading them back.
+type AuditEventRepo interface {
+	Save(event domain.SecurityEvent) error
+	FindByUserID(userID string, before time.Time, limit int) ([]domain.SecurityEvent, error)
+	FindAllByUserID(userID string, fn func(domain.SecurityEvent) error) error
+}
+
+// NewAuditEventRepo creates a new AuditEventRepo using the default implementation.
+func NewAuditEventRepo(db *sql.DB) AuditEventRepo {
+	return &pgAuditEventRepo{
+		db: db,
+	}
+}
+
+type pgAuditEventRepo struct {
+	db *sql.DB
+}
+
+const saveAuditEventQuery = `
+	INSERT INTO audit_event(id, type, user_id, actor_id, session_id, client_ip, user_agent, occurred_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+
+// Save appends an event to the audit log, events can not be changed once they are stored.
+func (er *pgAuditEventRepo) Save(e domain.SecurityEvent) error {
+	res, err := er.db.Exec(saveAuditEventQuery,
+		e.ID, e.Type, nullString(e.UserID), nullString(e.ActorID), nullString(e.SessionID),
+		nullString(e.Client.IP), nullString(e.Client.UserAgent), e.OccurredAt)
+	if err != nil {
+		return errors.Wrap(err, "pgAuditEventRepo.Save failed")
+	}
+
+	return dbutil.AssertRowsAffected(res, 1, dbutil.ErrFailedInsert)
+}
+
+const findUserAuditEventsQuery = `
+	SELECT id, type, user_id, actor_id, session_id, client_ip, user_agent, occurred_at
+	FROM audit_event WHERE user_id = $1 AND ($2::TIMESTAMP IS NULL OR occurred_at < $2)
+	ORDER BY occurred_at DESC
+	LIMIT $3`
+
+// FindByUserID retrieves the latest events of a user which occurred before the given time, newest first.
+// A zero before time means that the latest events are retrieved.
+func (er *pgAuditEventRepo) FindByUserID(userID string, before time.Time, limit int) ([]domain.SecurityEvent, error) {
+	rows, err := er.db.Query(findUserAuditEventsQuery, userID, nullTime(before), limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "pgAuditEventRepo.FindByUserID failed")
+	}
+	defer rows.Close()
+
+	events := make([]domain.SecurityEvent, 0)
+	for rows.Next() {
+		e, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, errors.Wrap(err, "pgAuditEventRepo.FindByUserID failed")
+		}
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
+
+const findAllUserAuditEventsQuery = `
+	SELECT id, type, user_id, actor_id, session_id, client_ip, user_agent, occurred_at
+	FROM audit_event WHERE user_id = $1
+	ORDER BY occurred_at`
+
+// FindAllByUserID passes every event of a user to fn, oldest first, without keeping them all in memory.
+// Iteration stops at the first error returned by fn.
+func (er *pgAuditEventRepo) FindAllByUserID(userID string, fn func(domain.SecurityEvent) error) error {
+	rows, err := er.db.Query(findAllUserAuditEventsQuery, userID)
+	if err != nil {
+		return errors.Wrap(err, "pgAuditEventRepo.FindAllByUserID failed")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e, err := scanAuditEvent(rows)
+		if err != nil {
+			return errors.Wrap(err, "pgAuditEventRepo.FindAllByUserID failed")
+		}
+
+		err = fn(e)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func scanAuditEvent(row rowScanner) (domain.SecurityEvent, error) {
+	var e domain.SecurityEvent
+	var userID, actorID, sessionID, clientIP, userAgent sql.NullString
+	err := row.Scan(&e.ID, &e.Type, &userID, &actorID, &sessionID, &clientIP, &userAgent, &e.OccurredAt)
+	if err != nil {
+		return domain.SecurityEvent{}, err
+	}
+
+	e.UserID = userID.String
+	e.ActorID = actorID.String
+	e.SessionID = sessionID.String
+	e.Client = domain.ClientInfo{IP: clientIP.String, UserAgent: userAgent.String}
+	return e, nil
+}
+
+// MockAuditEventRepo mock implementation of AuditEventRepo.
+type MockAuditEventRepo struct {
+	SaveErr        error
+	SaveArg        domain.SecurityEvent
+	SaveInvocation int
+
+	FindByUserIDEvents     []domain.SecurityEvent
+	FindByUserIDErr        error
+	FindByUserIDArgUserID  string
+	FindByUserIDArgBefore  time.Time
+	FindByUserIDArgLimit   int
+	FindByUserIDInvocation int
+
+	FindAllByUserIDEvents     []domain.SecurityEvent
+	FindAllByUserIDErr        error
+	FindAllByUserIDArg        string
+	FindAllByUserIDInvocation int
+}
+
+// Save mock implementation of saving an audit event.
+func (er *MockAuditEventRepo) Save(event domain.SecurityEvent) error {
+	er.SaveArg = event
+	er.SaveInvocation++
+	return er.SaveErr
+}
+
+// FindByUserID mock implementation of finding the latest events of a user.
+func (er *MockAuditEventRepo) FindByUserID(userID string, before time.Time, limit int) ([]domain.SecurityEvent, error) {
+	er.FindByUserIDArgUserID = userID
+	er.FindByUserIDArgBefore = before
+	er.FindByUserIDArgLimit = limit
+	er.FindByUserIDInvocation++
+	return er.FindByUserIDEvents, er.FindByUserIDErr
+}
+
+// FindAllByUserID mock implementation of iterating over all events of a user.
+func (er *MockAuditEventRepo) FindAllByUserID(userID string, fn func(domain.SecurityEvent) error) error {
+	er.FindAllByUserIDArg = userID
+	er.FindAllByUserIDInvocation++
+	if er.FindAllByUserIDErr != nil {
+		return er.FindAllByUserIDErr
+	}
+
+	for _, e := range er.FindAllByUserIDEvents {
+		err := fn(e)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UnsetArgs sets all MockAuditEventRepo fields to their default value.
+func (er *MockAuditEventRepo) UnsetArgs() {
+	er.SaveArg = domain.SecurityEvent{}
+	er.SaveInvocation = 0
+
+	er.FindByUserIDArgUserID = ""
+	er.FindByUserIDArgBefore = time.Time{}
+	er.FindByUserIDArgLimit = 0
+	er.FindByUserIDInvocation = 0
+
+	er.FindAllByUserIDArg = ""
+	er.FindAllByUserIDInvocation = 0
+}
@@ -0,0 +1,204 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/pkg/dbutil"
+	"github.com/pkg/errors"
+)
+
+// External identity errors.
+var (
+	ErrNoSuchExternalIdentity   = errors.New("no such external identity")
+	ErrExternalIdentityLinked   = errors.New("external identity already linked")
+	ErrNoSuchExternalLoginState = errors.New("no such external login state")
+)
+
+var (
+	emptyExternalIdentity   = domain.ExternalIdentity{}
+	emptyExternalLoginState = domain.ExternalLoginState{}
+)
+
+// ExternalIdentityRepo interface for storing identities at external providers and the state of external logins.
+type ExternalIdentityRepo interface {
+	Find(provider, subject string) (domain.ExternalIdentity, error)
+	Save(identity domain.ExternalIdentity) error
+	SaveLoginState(state domain.ExternalLoginState) error
+	ConsumeLoginState(hashedState string) (domain.ExternalLoginState, error)
+}
+
+// NewExternalIdentityRepo creates a new ExternalIdentityRepo using the default implementation.
+func NewExternalIdentityRepo(db *sql.DB) ExternalIdentityRepo {
+	return &pgExternalIdentityRepo{
+		db: db,
+	}
+}
+
+type pgExternalIdentityRepo struct {
+	db *sql.DB
+}
+
+const findExternalIdentityQuery = `
+	SELECT id, user_id, provider, subject, email, created_at
+	FROM external_identity WHERE provider = $1 AND subject = $2`
+
+// Find retrieves the identity with the given subject at a provider.
+func (ir *pgExternalIdentityRepo) Find(provider, subject string) (domain.ExternalIdentity, error) {
+	var i domain.ExternalIdentity
+	var email sql.NullString
+	err := ir.db.QueryRow(findExternalIdentityQuery, provider, subject).Scan(
+		&i.ID, &i.UserID, &i.Provider, &i.Subject, &email, &i.CreatedAt)
+	if err == sql.ErrNoRows {
+		return emptyExternalIdentity, ErrNoSuchExternalIdentity
+	} else if err != nil {
+		return emptyExternalIdentity, errors.Wrap(err, "pgExternalIdentityRepo.Find failed")
+	}
+
+	i.Email = email.String
+	return i, nil
+}
+
+const saveExternalIdentityQuery = `
+	INSERT INTO external_identity(id, user_id, provider, subject, email, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6)`
+
+// Save links an external identity to a user, fails if the identity is already linked.
+func (ir *pgExternalIdentityRepo) Save(i domain.ExternalIdentity) error {
+	res, err := ir.db.Exec(saveExternalIdentityQuery,
+		i.ID, i.UserID, i.Provider, i.Subject, i.Email, i.CreatedAt)
+	if err != nil {
+		pgErr, ok := err.(*pq.Error)
+		if ok && pgErr.Code == uniqueConstraintErrorCode {
+			return ErrExternalIdentityLinked
+		}
+		return errors.Wrap(err, "pgExternalIdentityRepo.Save failed")
+	}
+
+	return dbutil.AssertRowsAffected(res, 1, dbutil.ErrFailedInsert)
+}
+
+const deleteExpiredExternalLoginStatesQuery = `DELETE FROM external_login_state WHERE valid_to < $1`
+
+const saveExternalLoginStateQuery = `
+	INSERT INTO external_login_state(state_hash, binding_hash, provider, code_verifier, nonce, created_at, valid_to)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)`
+
+// SaveLoginState stores the state of a started external login.
+// Expired states of logins which were never completed are removed at the same time.
+func (ir *pgExternalIdentityRepo) SaveLoginState(s domain.ExternalLoginState) error {
+	tx, err := ir.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(deleteExpiredExternalLoginStatesQuery, time.Now().UTC())
+	if err != nil {
+		dbutil.RollbackTx(tx)
+		return errors.Wrap(err, "pgExternalIdentityRepo.SaveLoginState failed")
+	}
+
+	res, err := tx.Exec(saveExternalLoginStateQuery,
+		s.StateHash, s.BindingHash, s.Provider, s.CodeVerifier, s.Nonce, s.CreatedAt, s.ValidTo)
+	if err != nil {
+		dbutil.RollbackTx(tx)
+		return errors.Wrap(err, "pgExternalIdentityRepo.SaveLoginState failed")
+	}
+
+	err = dbutil.AssertRowsAffected(res, 1, dbutil.ErrFailedInsert)
+	if err != nil {
+		dbutil.RollbackTx(tx)
+		return err
+	}
+
+	return tx.Commit()
+}
+
+const consumeExternalLoginStateQuery = `
+	DELETE FROM external_login_state WHERE state_hash = $1
+	RETURNING state_hash, binding_hash, provider, code_verifier, nonce, created_at, valid_to`
+
+// ConsumeLoginState retrieves and removes the state of an external login so that it can only be used once.
+func (ir *pgExternalIdentityRepo) ConsumeLoginState(hashedState string) (domain.ExternalLoginState, error) {
+	var s domain.ExternalLoginState
+	var bindingHash sql.NullString
+	err := ir.db.QueryRow(consumeExternalLoginStateQuery, hashedState).Scan(
+		&s.StateHash, &bindingHash, &s.Provider, &s.CodeVerifier, &s.Nonce, &s.CreatedAt, &s.ValidTo)
+	if err == sql.ErrNoRows {
+		return emptyExternalLoginState, ErrNoSuchExternalLoginState
+	} else if err != nil {
+		return emptyExternalLoginState, errors.Wrap(err, "pgExternalIdentityRepo.ConsumeLoginState failed")
+	}
+
+	s.BindingHash = bindingHash.String
+	return s, nil
+}
+
+// MockExternalIdentityRepo mock implementation of ExternalIdentityRepo.
+type MockExternalIdentityRepo struct {
+	FindIdentity    domain.ExternalIdentity
+	FindErr         error
+	FindArgProvider string
+	FindArgSubject  string
+	FindInvocation  int
+
+	SaveErr        error
+	SaveArg        domain.ExternalIdentity
+	SaveInvocation int
+
+	SaveLoginStateErr        error
+	SaveLoginStateArg        domain.ExternalLoginState
+	SaveLoginStateInvocation int
+
+	ConsumeLoginStateState      domain.ExternalLoginState
+	ConsumeLoginStateErr        error
+	ConsumeLoginStateArg        string
+	ConsumeLoginStateInvocation int
+}
+
+// Find mock implementation of finding an external identity.
+func (ir *MockExternalIdentityRepo) Find(provider, subject string) (domain.ExternalIdentity, error) {
+	ir.FindArgProvider = provider
+	ir.FindArgSubject = subject
+	ir.FindInvocation++
+	return ir.FindIdentity, ir.FindErr
+}
+
+// Save mock implementation of saving an external identity.
+func (ir *MockExternalIdentityRepo) Save(identity domain.ExternalIdentity) error {
+	ir.SaveArg = identity
+	ir.SaveInvocation++
+	return ir.SaveErr
+}
+
+// SaveLoginState mock implementation of saving an external login state.
+func (ir *MockExternalIdentityRepo) SaveLoginState(state domain.ExternalLoginState) error {
+	ir.SaveLoginStateArg = state
+	ir.SaveLoginStateInvocation++
+	return ir.SaveLoginStateErr
+}
+
+// ConsumeLoginState mock implementation of consuming an external login state.
+func (ir *MockExternalIdentityRepo) ConsumeLoginState(hashedState string) (domain.ExternalLoginState, error) {
+	ir.ConsumeLoginStateArg = hashedState
+	ir.ConsumeLoginStateInvocation++
+	return ir.ConsumeLoginStateState, ir.ConsumeLoginStateErr
+}
+
+// UnsetArgs sets all MockExternalIdentityRepo fields to their default value.
+func (ir *MockExternalIdentityRepo) UnsetArgs() {
+	ir.FindArgProvider = ""
+	ir.FindArgSubject = ""
+	ir.FindInvocation = 0
+
+	ir.SaveArg = emptyExternalIdentity
+	ir.SaveInvocation = 0
+
+	ir.SaveLoginStateArg = emptyExternalLoginState
+	ir.SaveLoginStateInvocation = 0
+
+	ir.ConsumeLoginStateArg = ""
+	ir.ConsumeLoginStateInvocation = 0
+}
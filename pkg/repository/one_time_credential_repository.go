@@ -0,0 +1,217 @@
+package repository
+
+import (
+	"database/sql"
+
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/pkg/dbutil"
+	"github.com/pkg/errors"
+)
+
+// One time credential errors.
+var (
+	ErrNoSuchCredential = errors.New("no such one time credential")
+)
+
+var (
+	emptyOneTimeCredential = domain.OneTimeCredential{}
+)
+
+// OneTimeCredentialRepo interface for storing single use credentials.
+type OneTimeCredentialRepo interface {
+	Save(credential domain.OneTimeCredential) error
+	FindByKey(purpose, key string) (domain.OneTimeCredential, error)
+	FindLatest(purpose, userID string) (domain.OneTimeCredential, error)
+	MarkUsed(id string) error
+	RecordAttempt(id string, maxAttempts int) error
+}
+
+// NewOneTimeCredentialRepo creates a new OneTimeCredentialRepo using the default implementation.
+func NewOneTimeCredentialRepo(db *sql.DB) OneTimeCredentialRepo {
+	return &pgOneTimeCredentialRepo{
+		db: db,
+	}
+}
+
+type pgOneTimeCredentialRepo struct {
+	db *sql.DB
+}
+
+const saveOneTimeCredentialQuery = `
+	INSERT INTO one_time_credential(id, purpose, key, user_id, email, attempts, has_been_used, created_at, valid_to)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+
+// Save stores a one time credential in the database.
+func (cr *pgOneTimeCredentialRepo) Save(c domain.OneTimeCredential) error {
+	res, err := cr.db.Exec(saveOneTimeCredentialQuery,
+		c.ID, c.Purpose, c.Key, c.UserID, c.Email, c.Attempts, c.HasBeenUsed, c.CreatedAt, c.ValidTo)
+	if err != nil {
+		return errors.Wrap(err, "pgOneTimeCredentialRepo.Save failed")
+	}
+
+	return dbutil.AssertRowsAffected(res, 1, dbutil.ErrFailedInsert)
+}
+
+const findOneTimeCredentialByKeyQuery = `
+	SELECT id, purpose, key, user_id, email, attempts, has_been_used, created_at, valid_to
+	FROM one_time_credential WHERE purpose = $1 AND key = $2`
+
+// FindByKey retrieves a one time credential by its purpose and hashed key.
+func (cr *pgOneTimeCredentialRepo) FindByKey(purpose, key string) (domain.OneTimeCredential, error) {
+	c, err := scanOneTimeCredential(cr.db.QueryRow(findOneTimeCredentialByKeyQuery, purpose, key))
+	if err == sql.ErrNoRows {
+		return emptyOneTimeCredential, ErrNoSuchCredential
+	} else if err != nil {
+		return emptyOneTimeCredential, errors.Wrap(err, "pgOneTimeCredentialRepo.FindByKey failed")
+	}
+
+	return c, nil
+}
+
+const findLatestOneTimeCredentialQuery = `
+	SELECT id, purpose, key, user_id, email, attempts, has_been_used, created_at, valid_to
+	FROM one_time_credential WHERE purpose = $1 AND user_id = $2
+	ORDER BY created_at DESC LIMIT 1`
+
+// FindLatest retrieves the most recently issued one time credential with a purpose for a user.
+func (cr *pgOneTimeCredentialRepo) FindLatest(purpose, userID string) (domain.OneTimeCredential, error) {
+	c, err := scanOneTimeCredential(cr.db.QueryRow(findLatestOneTimeCredentialQuery, purpose, userID))
+	if err == sql.ErrNoRows {
+		return emptyOneTimeCredential, ErrNoSuchCredential
+	} else if err != nil {
+		return emptyOneTimeCredential, errors.Wrap(err, "pgOneTimeCredentialRepo.FindLatest failed")
+	}
+
+	return c, nil
+}
+
+const markOneTimeCredentialUsedQuery = `
+	UPDATE one_time_credential SET has_been_used = TRUE
+	WHERE id = $1 AND has_been_used = FALSE`
+
+// MarkUsed marks a one time credential as used, fails if it has already been used.
+func (cr *pgOneTimeCredentialRepo) MarkUsed(id string) error {
+	res, err := cr.db.Exec(markOneTimeCredentialUsedQuery, id)
+	if err != nil {
+		return errors.Wrap(err, "pgOneTimeCredentialRepo.MarkUsed failed")
+	}
+
+	return dbutil.AssertRowsAffected(res, 1, ErrNoSuchCredential)
+}
+
+const recordOneTimeCredentialAttemptQuery = `
+	UPDATE one_time_credential SET attempts = attempts + 1
+	WHERE id = $1 AND has_been_used = FALSE AND attempts < $2`
+
+// RecordAttempt counts an attempt to use a one time credential. The attempt limit is checked
+// in the same statement so that concurrent attempts cannot exceed it, fails if the limit has been reached.
+func (cr *pgOneTimeCredentialRepo) RecordAttempt(id string, maxAttempts int) error {
+	res, err := cr.db.Exec(recordOneTimeCredentialAttemptQuery, id, maxAttempts)
+	if err != nil {
+		return errors.Wrap(err, "pgOneTimeCredentialRepo.RecordAttempt failed")
+	}
+
+	return dbutil.AssertRowsAffected(res, 1, ErrNoSuchCredential)
+}
+
+func scanOneTimeCredential(row *sql.Row) (domain.OneTimeCredential, error) {
+	var c domain.OneTimeCredential
+	var email sql.NullString
+	var attempts sql.NullInt64
+	err := row.Scan(
+		&c.ID, &c.Purpose, &c.Key, &c.UserID, &email, &attempts, &c.HasBeenUsed, &c.CreatedAt, &c.ValidTo)
+	if err != nil {
+		return emptyOneTimeCredential, err
+	}
+
+	c.Email = email.String
+	c.Attempts = int(attempts.Int64)
+	return c, nil
+}
+
+// MockOneTimeCredentialRepo mock implementation of OneTimeCredentialRepo.
+type MockOneTimeCredentialRepo struct {
+	SaveErr        error
+	SaveArg        domain.OneTimeCredential
+	SaveInvocation int
+
+	FindByKeyCredential domain.OneTimeCredential
+	FindByKeyErr        error
+	FindByKeyArgPurpose string
+	FindByKeyArg        string
+	FindByKeyInvocation int
+
+	FindLatestCredential domain.OneTimeCredential
+	FindLatestErr        error
+	FindLatestArgPurpose string
+	FindLatestArgUserID  string
+	FindLatestInvocation int
+
+	MarkUsedErr        error
+	MarkUsedArg        string
+	MarkUsedInvocation int
+
+	RecordAttemptErr        error
+	RecordAttemptArg        string
+	RecordAttemptArgMax     int
+	RecordAttemptInvocation int
+}
+
+// Save mock implementation of saving a one time credential.
+func (cr *MockOneTimeCredentialRepo) Save(credential domain.OneTimeCredential) error {
+	cr.SaveArg = credential
+	cr.SaveInvocation++
+	return cr.SaveErr
+}
+
+// FindByKey mock implementation of finding a one time credential.
+func (cr *MockOneTimeCredentialRepo) FindByKey(purpose, key string) (domain.OneTimeCredential, error) {
+	cr.FindByKeyArgPurpose = purpose
+	cr.FindByKeyArg = key
+	cr.FindByKeyInvocation++
+	return cr.FindByKeyCredential, cr.FindByKeyErr
+}
+
+// FindLatest mock implementation of finding the latest one time credential.
+func (cr *MockOneTimeCredentialRepo) FindLatest(purpose, userID string) (domain.OneTimeCredential, error) {
+	cr.FindLatestArgPurpose = purpose
+	cr.FindLatestArgUserID = userID
+	cr.FindLatestInvocation++
+	return cr.FindLatestCredential, cr.FindLatestErr
+}
+
+// MarkUsed mock implementation of marking a one time credential as used.
+func (cr *MockOneTimeCredentialRepo) MarkUsed(id string) error {
+	cr.MarkUsedArg = id
+	cr.MarkUsedInvocation++
+	return cr.MarkUsedErr
+}
+
+// RecordAttempt mock implementation of counting an attempt.
+func (cr *MockOneTimeCredentialRepo) RecordAttempt(id string, maxAttempts int) error {
+	cr.RecordAttemptArg = id
+	cr.RecordAttemptArgMax = maxAttempts
+	cr.RecordAttemptInvocation++
+	return cr.RecordAttemptErr
+}
+
+// UnsetArgs sets all MockOneTimeCredentialRepo fields to their default value.
+func (cr *MockOneTimeCredentialRepo) UnsetArgs() {
+	cr.SaveArg = emptyOneTimeCredential
+	cr.SaveInvocation = 0
+
+	cr.FindByKeyArgPurpose = ""
+	cr.FindByKeyArg = ""
+	cr.FindByKeyInvocation = 0
+
+	cr.FindLatestArgPurpose = ""
+	cr.FindLatestArgUserID = ""
+	cr.FindLatestInvocation = 0
+
+	cr.MarkUsedArg = ""
+	cr.MarkUsedInvocation = 0
+
+	cr.RecordAttemptArg = ""
+	cr.RecordAttemptArgMax = 0
+	cr.RecordAttemptInvocation = 0
+}
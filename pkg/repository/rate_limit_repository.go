@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/pkg/dbutil"
+	"github.com/pkg/errors"
+)
+
+// RateLimitRepo interface for storing the token buckets of rate limited keys.
+type RateLimitRepo interface {
+	Take(key string, limit domain.RateLimit, at time.Time) (time.Duration, error)
+	DeleteFull(at time.Time) (int, error)
+}
+
+// NewRateLimitRepo creates a new RateLimitRepo using the default implementation.
+// Buckets are stored in the database so that limits are shared by all replicas of the service.
+func NewRateLimitRepo(db *sql.DB) RateLimitRepo {
+	return &pgRateLimitRepo{
+		db: db,
+	}
+}
+
+type pgRateLimitRepo struct {
+	db *sql.DB
+}
+
+const createRateLimitBucketQuery = `
+	INSERT INTO rate_limit_bucket(key, tokens, updated_at, full_at)
+	VALUES ($1, $2, $3, $3)
+	ON CONFLICT (key) DO NOTHING`
+
+const findRateLimitBucketQuery = `
+	SELECT key, tokens, updated_at FROM rate_limit_bucket WHERE key = $1 FOR UPDATE`
+
+const updateRateLimitBucketQuery = `
+	UPDATE rate_limit_bucket SET tokens = $2, updated_at = $3, full_at = $4 WHERE key = $1`
+
+// Take takes a token from the bucket of a key and returns the time until a token is available if the bucket is empty.
+// The bucket is locked while it is updated, so concurrent requests from different replicas can not take the same token.
+func (rr *pgRateLimitRepo) Take(key string, limit domain.RateLimit, at time.Time) (time.Duration, error) {
+	tx, err := rr.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(createRateLimitBucketQuery, key, limit.Requests, at)
+	if err != nil {
+		dbutil.RollbackTx(tx)
+		return 0, errors.Wrap(err, "pgRateLimitRepo.Take failed")
+	}
+
+	var bucket domain.TokenBucket
+	err = tx.QueryRow(findRateLimitBucketQuery, key).Scan(&bucket.Key, &bucket.Tokens, &bucket.UpdatedAt)
+	if err != nil {
+		dbutil.RollbackTx(tx)
+		return 0, errors.Wrap(err, "pgRateLimitRepo.Take failed")
+	}
+
+	bucket, retryAfter := bucket.Take(limit, at)
+	_, err = tx.Exec(updateRateLimitBucketQuery, key, bucket.Tokens, bucket.UpdatedAt, bucket.FullAt(limit))
+	if err != nil {
+		dbutil.RollbackTx(tx)
+		return 0, errors.Wrap(err, "pgRateLimitRepo.Take failed")
+	}
+
+	return retryAfter, tx.Commit()
+}
+
+const deleteFullRateLimitBucketsQuery = `
+	DELETE FROM rate_limit_bucket WHERE full_at <= $1`
+
+// DeleteFull deletes buckets which have been refilled and returns the number deleted.
+func (rr *pgRateLimitRepo) DeleteFull(at time.Time) (int, error) {
+	res, err := rr.db.Exec(deleteFullRateLimitBucketsQuery, at)
+	if err != nil {
+		return 0, errors.Wrap(err, "pgRateLimitRepo.DeleteFull failed")
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "pgRateLimitRepo.DeleteFull failed")
+	}
+
+	return int(deleted), nil
+}
+
+// NewMemoryRateLimitRepo creates a RateLimitRepo which keeps buckets in memory,
+// limits are then only enforced within a single instance of the service.
+func NewMemoryRateLimitRepo() RateLimitRepo {
+	return &memoryRateLimitRepo{
+		buckets: make(map[string]domain.TokenBucket),
+		fullAt:  make(map[string]time.Time),
+	}
+}
+
+type memoryRateLimitRepo struct {
+	mu      sync.Mutex
+	buckets map[string]domain.TokenBucket
+	fullAt  map[string]time.Time
+}
+
+// Take takes a token from the bucket of a key and returns the time until a token is available if the bucket is empty.
+func (rr *memoryRateLimitRepo) Take(key string, limit domain.RateLimit, at time.Time) (time.Duration, error) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	bucket, ok := rr.buckets[key]
+	if !ok {
+		bucket = domain.NewTokenBucket(key, limit, at)
+	}
+
+	bucket, retryAfter := bucket.Take(limit, at)
+	rr.buckets[key] = bucket
+	rr.fullAt[key] = bucket.FullAt(limit)
+	return retryAfter, nil
+}
+
+// DeleteFull deletes buckets which have been refilled and returns the number deleted.
+func (rr *memoryRateLimitRepo) DeleteFull(at time.Time) (int, error) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	deleted := 0
+	for key, fullAt := range rr.fullAt {
+		if !fullAt.After(at) {
+			delete(rr.buckets, key)
+			delete(rr.fullAt, key)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
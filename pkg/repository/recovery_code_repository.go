@@ -0,0 +1,117 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/mimir-news/pkg/dbutil"
+	"github.com/mimir-news/pkg/id"
+	"github.com/pkg/errors"
+)
+
+// Recovery code errors.
+var (
+	ErrNoSuchRecoveryCode = errors.New("no such recovery code")
+)
+
+// RecoveryCodeRepo interface for storing hashed single use MFA recovery codes.
+type RecoveryCodeRepo interface {
+	Replace(userID string, hashedCodes []string) error
+	Use(userID, hashedCode string) error
+}
+
+// NewRecoveryCodeRepo creates a new RecoveryCodeRepo using the default implementation.
+func NewRecoveryCodeRepo(db *sql.DB) RecoveryCodeRepo {
+	return &pgRecoveryCodeRepo{
+		db: db,
+	}
+}
+
+type pgRecoveryCodeRepo struct {
+	db *sql.DB
+}
+
+const deleteRecoveryCodesQuery = `DELETE FROM recovery_code WHERE user_id = $1`
+
+const saveRecoveryCodeQuery = `
+	INSERT INTO recovery_code(id, user_id, code_hash, created_at) 
+	VALUES ($1, $2, $3, $4)`
+
+// Replace replaces all recovery codes of a user with a new set of hashed codes.
+func (rr *pgRecoveryCodeRepo) Replace(userID string, hashedCodes []string) error {
+	tx, err := rr.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(deleteRecoveryCodesQuery, userID)
+	if err != nil {
+		dbutil.RollbackTx(tx)
+		return errors.Wrap(err, "pgRecoveryCodeRepo.Replace failed")
+	}
+
+	now := time.Now().UTC()
+	for _, hashedCode := range hashedCodes {
+		_, err = tx.Exec(saveRecoveryCodeQuery, id.New(), userID, hashedCode, now)
+		if err != nil {
+			dbutil.RollbackTx(tx)
+			return errors.Wrap(err, "pgRecoveryCodeRepo.Replace failed")
+		}
+	}
+
+	return tx.Commit()
+}
+
+const useRecoveryCodeQuery = `
+	UPDATE recovery_code SET used_at = $3
+	WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`
+
+// Use marks a recovery code as used, fails if the code does not exist or has already been used.
+func (rr *pgRecoveryCodeRepo) Use(userID, hashedCode string) error {
+	res, err := rr.db.Exec(useRecoveryCodeQuery, userID, hashedCode, time.Now().UTC())
+	if err != nil {
+		return errors.Wrap(err, "pgRecoveryCodeRepo.Use failed")
+	}
+
+	return dbutil.AssertRowsAffected(res, 1, ErrNoSuchRecoveryCode)
+}
+
+// MockRecoveryCodeRepo mock implementation of RecoveryCodeRepo.
+type MockRecoveryCodeRepo struct {
+	ReplaceErr        error
+	ReplaceArgUserID  string
+	ReplaceArgCodes   []string
+	ReplaceInvocation int
+
+	UseErr        error
+	UseArgUserID  string
+	UseArgCode    string
+	UseInvocation int
+}
+
+// Replace mock implementation of replacing recovery codes.
+func (rr *MockRecoveryCodeRepo) Replace(userID string, hashedCodes []string) error {
+	rr.ReplaceArgUserID = userID
+	rr.ReplaceArgCodes = hashedCodes
+	rr.ReplaceInvocation++
+	return rr.ReplaceErr
+}
+
+// Use mock implementation of using a recovery code.
+func (rr *MockRecoveryCodeRepo) Use(userID, hashedCode string) error {
+	rr.UseArgUserID = userID
+	rr.UseArgCode = hashedCode
+	rr.UseInvocation++
+	return rr.UseErr
+}
+
+// UnsetArgs sets all MockRecoveryCodeRepo fields to their default value.
+func (rr *MockRecoveryCodeRepo) UnsetArgs() {
+	rr.ReplaceArgUserID = ""
+	rr.ReplaceArgCodes = nil
+	rr.ReplaceInvocation = 0
+
+	rr.UseArgUserID = ""
+	rr.UseArgCode = ""
+	rr.UseInvocation = 0
+}
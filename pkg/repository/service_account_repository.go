@@ -0,0 +1,168 @@
+package repository
+
+import (
+	"database/sql"
+
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/pkg/dbutil"
+	"github.com/pkg/errors"
+)
+
+// Service account errors.
+var (
+	ErrNoSuchServiceAccount = errors.New("no such service account")
+)
+
+var (
+	emptyServiceAccount = domain.ServiceAccount{}
+)
+
+// ServiceAccountRepo interface for storing service accounts.
+type ServiceAccountRepo interface {
+	Save(account domain.ServiceAccount) error
+	Find(id string) (domain.ServiceAccount, error)
+	FindAll() ([]domain.ServiceAccount, error)
+}
+
+// NewServiceAccountRepo creates a new ServiceAccountRepo using the default implementation.
+func NewServiceAccountRepo(db *sql.DB) ServiceAccountRepo {
+	return &pgServiceAccountRepo{
+		db: db,
+	}
+}
+
+type pgServiceAccountRepo struct {
+	db *sql.DB
+}
+
+const saveServiceAccountUserQuery = `
+	INSERT INTO app_user(id, role, email_verified, created_at)
+	VALUES ($1, $2, FALSE, $3)`
+
+const saveServiceAccountQuery = `
+	INSERT INTO service_account(user_id, name, created_by, created_at)
+	VALUES ($1, $2, $3, $4)`
+
+// Save stores a new service account along with the user, without email or credentials, that it acts as.
+func (sr *pgServiceAccountRepo) Save(a domain.ServiceAccount) error {
+	tx, err := sr.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(saveServiceAccountUserQuery, a.ID, domain.ServiceAccountRole, a.CreatedAt)
+	if err != nil {
+		dbutil.RollbackTx(tx)
+		return errors.Wrap(err, "pgServiceAccountRepo.Save failed")
+	}
+
+	res, err := tx.Exec(saveServiceAccountQuery, a.ID, a.Name, a.CreatedBy, a.CreatedAt)
+	if err != nil {
+		dbutil.RollbackTx(tx)
+		return errors.Wrap(err, "pgServiceAccountRepo.Save failed")
+	}
+
+	err = dbutil.AssertRowsAffected(res, 1, dbutil.ErrFailedInsert)
+	if err != nil {
+		dbutil.RollbackTx(tx)
+		return err
+	}
+
+	return tx.Commit()
+}
+
+const findServiceAccountQuery = `
+	SELECT s.user_id, s.name, s.created_by, s.created_at FROM service_account s
+	JOIN app_user u ON u.id = s.user_id
+	WHERE s.user_id = $1 AND u.deleted_at IS NULL`
+
+// Find retrieves a service account by its id, deleted service accounts are not found.
+func (sr *pgServiceAccountRepo) Find(id string) (domain.ServiceAccount, error) {
+	var a domain.ServiceAccount
+	var createdBy sql.NullString
+	err := sr.db.QueryRow(findServiceAccountQuery, id).Scan(&a.ID, &a.Name, &createdBy, &a.CreatedAt)
+	if err == sql.ErrNoRows {
+		return emptyServiceAccount, ErrNoSuchServiceAccount
+	} else if err != nil {
+		return emptyServiceAccount, errors.Wrap(err, "pgServiceAccountRepo.Find failed")
+	}
+
+	a.CreatedBy = createdBy.String
+	return a, nil
+}
+
+const findAllServiceAccountsQuery = `
+	SELECT s.user_id, s.name, s.created_by, s.created_at FROM service_account s
+	JOIN app_user u ON u.id = s.user_id
+	WHERE u.deleted_at IS NULL
+	ORDER BY s.created_at`
+
+// FindAll retrieves all service accounts which have not been deleted, oldest first.
+func (sr *pgServiceAccountRepo) FindAll() ([]domain.ServiceAccount, error) {
+	rows, err := sr.db.Query(findAllServiceAccountsQuery)
+	if err != nil {
+		return nil, errors.Wrap(err, "pgServiceAccountRepo.FindAll failed")
+	}
+	defer rows.Close()
+
+	accounts := make([]domain.ServiceAccount, 0)
+	for rows.Next() {
+		var a domain.ServiceAccount
+		var createdBy sql.NullString
+		err = rows.Scan(&a.ID, &a.Name, &createdBy, &a.CreatedAt)
+		if err != nil {
+			return nil, errors.Wrap(err, "pgServiceAccountRepo.FindAll failed")
+		}
+		a.CreatedBy = createdBy.String
+		accounts = append(accounts, a)
+	}
+
+	return accounts, rows.Err()
+}
+
+// MockServiceAccountRepo mock implementation of ServiceAccountRepo.
+type MockServiceAccountRepo struct {
+	SaveErr        error
+	SaveArg        domain.ServiceAccount
+	SaveInvocation int
+
+	FindAccount    domain.ServiceAccount
+	FindErr        error
+	FindArg        string
+	FindInvocation int
+
+	FindAllAccounts   []domain.ServiceAccount
+	FindAllErr        error
+	FindAllInvocation int
+}
+
+// Save mock implementation of saving a service account.
+func (sr *MockServiceAccountRepo) Save(account domain.ServiceAccount) error {
+	sr.SaveArg = account
+	sr.SaveInvocation++
+	return sr.SaveErr
+}
+
+// Find mock implementation of finding a service account.
+func (sr *MockServiceAccountRepo) Find(id string) (domain.ServiceAccount, error) {
+	sr.FindArg = id
+	sr.FindInvocation++
+	return sr.FindAccount, sr.FindErr
+}
+
+// FindAll mock implementation of finding all service accounts.
+func (sr *MockServiceAccountRepo) FindAll() ([]domain.ServiceAccount, error) {
+	sr.FindAllInvocation++
+	return sr.FindAllAccounts, sr.FindAllErr
+}
+
+// UnsetArgs sets all MockServiceAccountRepo fields to their default value.
+func (sr *MockServiceAccountRepo) UnsetArgs() {
+	sr.SaveArg = emptyServiceAccount
+	sr.SaveInvocation = 0
+
+	sr.FindArg = ""
+	sr.FindInvocation = 0
+
+	sr.FindAllInvocation = 0
+}
@@ -2,7 +2,9 @@ package repository
 
 import (
 	"database/sql"
+	"time"
 
+	"github.com/lib/pq"
 	"github.com/mimir-news/directory/pkg/domain"
 	"github.com/mimir-news/pkg/dbutil"
 	"github.com/pkg/errors"
@@ -21,7 +23,14 @@ var (
 type SessionRepo interface {
 	Save(session domain.Session) error
 	Find(id string) (domain.Session, error)
+	FindByUserID(userID string) ([]domain.Session, error)
+	FindAllByUserID(userID string) ([]domain.Session, error)
+	FindByRefreshTokenHash(hash string) (domain.Session, error)
 	Delete(id string) error
+	Rotate(id string) error
+	DeleteFamily(familyID string) error
+	DeleteByUserID(userID string) error
+	DeleteUserSession(userID, sessionID string) error
 }
 
 // NewSessionRepo creates a new SesssionRepo using the default implementation.
@@ -35,11 +44,16 @@ type pgSessionRepo struct {
 	db *sql.DB
 }
 
-const saveSessionQuery = `INSERT INTO session(id, user_id, refresh_token, is_active, created_at) VALUES ($1, $2, $3, $4, $5)`
+const saveSessionQuery = `
+	INSERT INTO session(
+		id, user_id, refresh_token_hash, is_active, created_at, 
+		user_agent, client_ip, device_name, last_refreshed_at, family_id) 
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
 
 // Save stores a session in the database.
 func (sr *pgSessionRepo) Save(s domain.Session) error {
-	res, err := sr.db.Exec(saveSessionQuery, s.ID, s.UserID, s.RefreshToken, s.Active, s.CreatedAt)
+	res, err := sr.db.Exec(saveSessionQuery, s.ID, s.UserID, s.RefreshTokenHash, s.Active, s.CreatedAt,
+		s.UserAgent, s.ClientIP, s.DeviceName, nullTime(s.LastRefreshedAt), s.FamilyID)
 	if err != nil {
 		return errors.Wrap(err, "pgSessionRepo.Save failed")
 	}
@@ -48,25 +62,110 @@ func (sr *pgSessionRepo) Save(s domain.Session) error {
 }
 
 const findSessionQuery = `
-	SELECT id, user_id, refresh_token, is_active, created_at 
-	FROM session WHERE id = $1 AND is_active = 'TRUE'`
+	SELECT 
+		id, user_id, refresh_token_hash, is_active, created_at, 
+		user_agent, client_ip, device_name, last_refreshed_at, family_id, rotated_at, deleted_at 
+	FROM session WHERE id = $1`
 
-// Find retrieves a session from the database.
+// Find retrieves a session from the database, both active and inactive sessions are returned.
 func (sr *pgSessionRepo) Find(id string) (domain.Session, error) {
-	var s domain.Session
+	var s nullSession
 	err := sr.db.QueryRow(findSessionQuery, id).Scan(
-		&s.ID, &s.UserID, &s.RefreshToken, &s.Active, &s.CreatedAt)
+		&s.id, &s.userID, &s.refreshTokenHash, &s.active, &s.createdAt,
+		&s.userAgent, &s.clientIP, &s.deviceName, &s.lastRefreshedAt, &s.familyID, &s.rotatedAt, &s.deletedAt)
 	if err == sql.ErrNoRows {
-		return s, ErrNoSuchSession
+		return emptySession, ErrNoSuchSession
 	} else if err != nil {
-		return s, errors.Wrap(err, "pgSessionRepo.Find failed")
+		return emptySession, errors.Wrap(err, "pgSessionRepo.Find failed")
 	}
 
-	return s, nil
+	return s.session(), nil
+}
+
+const findUserSessionsQuery = `
+	SELECT 
+		id, user_id, refresh_token_hash, is_active, created_at, 
+		user_agent, client_ip, device_name, last_refreshed_at, family_id, rotated_at, deleted_at 
+	FROM session WHERE user_id = $1 AND is_active = 'TRUE'
+	ORDER BY created_at DESC`
+
+// FindByUserID retrieves the active sessions of a user.
+func (sr *pgSessionRepo) FindByUserID(userID string) ([]domain.Session, error) {
+	rows, err := sr.db.Query(findUserSessionsQuery, userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "pgSessionRepo.FindByUserID failed")
+	}
+	defer rows.Close()
+
+	sessions := make([]domain.Session, 0)
+	for rows.Next() {
+		var s nullSession
+		err = rows.Scan(
+			&s.id, &s.userID, &s.refreshTokenHash, &s.active, &s.createdAt,
+			&s.userAgent, &s.clientIP, &s.deviceName, &s.lastRefreshedAt, &s.familyID, &s.rotatedAt, &s.deletedAt)
+		if err != nil {
+			return nil, errors.Wrap(err, "pgSessionRepo.FindByUserID failed")
+		}
+		sessions = append(sessions, s.session())
+	}
+
+	return sessions, rows.Err()
+}
+
+const findAllUserSessionsQuery = `
+	SELECT 
+		id, user_id, refresh_token_hash, is_active, created_at, 
+		user_agent, client_ip, device_name, last_refreshed_at, family_id, rotated_at, deleted_at 
+	FROM session WHERE user_id = $1
+	ORDER BY created_at DESC`
+
+// FindAllByUserID retrieves the session history of a user, which includes sessions that have ended.
+func (sr *pgSessionRepo) FindAllByUserID(userID string) ([]domain.Session, error) {
+	rows, err := sr.db.Query(findAllUserSessionsQuery, userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "pgSessionRepo.FindAllByUserID failed")
+	}
+	defer rows.Close()
+
+	sessions := make([]domain.Session, 0)
+	for rows.Next() {
+		var s nullSession
+		err = rows.Scan(
+			&s.id, &s.userID, &s.refreshTokenHash, &s.active, &s.createdAt,
+			&s.userAgent, &s.clientIP, &s.deviceName, &s.lastRefreshedAt, &s.familyID, &s.rotatedAt, &s.deletedAt)
+		if err != nil {
+			return nil, errors.Wrap(err, "pgSessionRepo.FindAllByUserID failed")
+		}
+		sessions = append(sessions, s.session())
+	}
+
+	return sessions, rows.Err()
+}
+
+const findSessionByRefreshTokenHashQuery = `
+	SELECT 
+		id, user_id, refresh_token_hash, is_active, created_at, 
+		user_agent, client_ip, device_name, last_refreshed_at, family_id, rotated_at, deleted_at 
+	FROM session WHERE refresh_token_hash = $1`
+
+// FindByRefreshTokenHash retrieves the session that a refresh token was issued for.
+// Sessions which have been deleted no longer have a refresh token hash and are not found.
+func (sr *pgSessionRepo) FindByRefreshTokenHash(hash string) (domain.Session, error) {
+	var s nullSession
+	err := sr.db.QueryRow(findSessionByRefreshTokenHashQuery, hash).Scan(
+		&s.id, &s.userID, &s.refreshTokenHash, &s.active, &s.createdAt,
+		&s.userAgent, &s.clientIP, &s.deviceName, &s.lastRefreshedAt, &s.familyID, &s.rotatedAt, &s.deletedAt)
+	if err == sql.ErrNoRows {
+		return emptySession, ErrNoSuchSession
+	} else if err != nil {
+		return emptySession, errors.Wrap(err, "pgSessionRepo.FindByRefreshTokenHash failed")
+	}
+
+	return s.session(), nil
 }
 
 const deleteSessionQuery = `
-	UPDATE session SET refresh_token = NULL, is_active = 'FALSE', deleted_at = NOW() 
+	UPDATE session SET refresh_token_hash = NULL, is_active = 'FALSE', deleted_at = NOW() 
 	WHERE id = $1 AND is_active = 'TRUE'`
 
 // Delete stores a session in the database.
@@ -79,6 +178,102 @@ func (sr *pgSessionRepo) Delete(id string) error {
 	return dbutil.AssertRowsAffected(res, 1, ErrNoSuchSession)
 }
 
+const rotateSessionQuery = `
+	UPDATE session SET is_active = 'FALSE', rotated_at = NOW() 
+	WHERE id = $1 AND is_active = 'TRUE'`
+
+// Rotate deactivates a session that has been replaced by a refreshed session.
+// The refresh token hash is kept so that reuse of the refresh token can be detected.
+func (sr *pgSessionRepo) Rotate(id string) error {
+	res, err := sr.db.Exec(rotateSessionQuery, id)
+	if err != nil {
+		return errors.Wrap(err, "pgSessionRepo.Rotate failed")
+	}
+
+	return dbutil.AssertRowsAffected(res, 1, ErrNoSuchSession)
+}
+
+const deleteSessionFamilyQuery = `
+	UPDATE session SET refresh_token_hash = NULL, is_active = 'FALSE', deleted_at = NOW() 
+	WHERE family_id = $1 AND deleted_at IS NULL`
+
+// DeleteFamily deactivates all sessions in a session family and removes their refresh token hashes.
+func (sr *pgSessionRepo) DeleteFamily(familyID string) error {
+	_, err := sr.db.Exec(deleteSessionFamilyQuery, familyID)
+	if err != nil {
+		return errors.Wrap(err, "pgSessionRepo.DeleteFamily failed")
+	}
+
+	return nil
+}
+
+const deleteUserSessionsQuery = `
+	UPDATE session SET refresh_token_hash = NULL, is_active = 'FALSE', deleted_at = NOW() 
+	WHERE user_id = $1 AND is_active = 'TRUE'`
+
+// DeleteByUserID deactivates all active sessions of a user.
+func (sr *pgSessionRepo) DeleteByUserID(userID string) error {
+	_, err := sr.db.Exec(deleteUserSessionsQuery, userID)
+	if err != nil {
+		return errors.Wrap(err, "pgSessionRepo.DeleteByUserID failed")
+	}
+
+	return nil
+}
+
+const deleteUserSessionQuery = `
+	UPDATE session SET refresh_token_hash = NULL, is_active = 'FALSE', deleted_at = NOW() 
+	WHERE id = $1 AND user_id = $2 AND is_active = 'TRUE'`
+
+// DeleteUserSession deactivates a session if it is active and belongs to the given user.
+func (sr *pgSessionRepo) DeleteUserSession(userID, sessionID string) error {
+	res, err := sr.db.Exec(deleteUserSessionQuery, sessionID, userID)
+	if err != nil {
+		return errors.Wrap(err, "pgSessionRepo.DeleteUserSession failed")
+	}
+
+	return dbutil.AssertRowsAffected(res, 1, ErrNoSuchSession)
+}
+
+type nullSession struct {
+	id               string
+	userID           string
+	refreshTokenHash sql.NullString
+	active           bool
+	createdAt        time.Time
+	userAgent        sql.NullString
+	clientIP         sql.NullString
+	deviceName       sql.NullString
+	lastRefreshedAt  pq.NullTime
+	familyID         sql.NullString
+	rotatedAt        pq.NullTime
+	deletedAt        pq.NullTime
+}
+
+func (s nullSession) session() domain.Session {
+	return domain.Session{
+		ID:               s.id,
+		UserID:           s.userID,
+		RefreshTokenHash: s.refreshTokenHash.String,
+		Active:           s.active,
+		CreatedAt:        s.createdAt,
+		UserAgent:        s.userAgent.String,
+		ClientIP:         s.clientIP.String,
+		DeviceName:       s.deviceName.String,
+		LastRefreshedAt:  s.lastRefreshedAt.Time,
+		FamilyID:         s.familyID.String,
+		RotatedAt:        s.rotatedAt.Time,
+		DeletedAt:        s.deletedAt.Time,
+	}
+}
+
+func nullTime(t time.Time) pq.NullTime {
+	return pq.NullTime{
+		Time:  t,
+		Valid: !t.IsZero(),
+	}
+}
+
 // MockSessionRepo mock implementation of SessionRepo.
 type MockSessionRepo struct {
 	SaveErr        error
@@ -90,9 +285,41 @@ type MockSessionRepo struct {
 	FindArg        string
 	FindInvocation int
 
+	FindByUserIDSessions   []domain.Session
+	FindByUserIDErr        error
+	FindByUserIDArg        string
+	FindByUserIDInvocation int
+
+	FindAllByUserIDSessions   []domain.Session
+	FindAllByUserIDErr        error
+	FindAllByUserIDArg        string
+	FindAllByUserIDInvocation int
+
+	FindByRefreshTokenHashSession    domain.Session
+	FindByRefreshTokenHashErr        error
+	FindByRefreshTokenHashArg        string
+	FindByRefreshTokenHashInvocation int
+
 	DeleteErr        error
 	DeleteArg        string
 	DeleteInvocation int
+
+	RotateErr        error
+	RotateArg        string
+	RotateInvocation int
+
+	DeleteFamilyErr        error
+	DeleteFamilyArg        string
+	DeleteFamilyInvocation int
+
+	DeleteByUserIDErr        error
+	DeleteByUserIDArg        string
+	DeleteByUserIDInvocation int
+
+	DeleteUserSessionErr          error
+	DeleteUserSessionArgUserID    string
+	DeleteUserSessionArgSessionID string
+	DeleteUserSessionInvocation   int
 }
 
 // Save mock implementation of Saving a session.
@@ -109,6 +336,27 @@ func (sr *MockSessionRepo) Find(id string) (domain.Session, error) {
 	return sr.FindSession, sr.FindErr
 }
 
+// FindByUserID mock implementation of finding the sessions of a user.
+func (sr *MockSessionRepo) FindByUserID(userID string) ([]domain.Session, error) {
+	sr.FindByUserIDArg = userID
+	sr.FindByUserIDInvocation++
+	return sr.FindByUserIDSessions, sr.FindByUserIDErr
+}
+
+// FindAllByUserID mock implementation of finding the session history of a user.
+func (sr *MockSessionRepo) FindAllByUserID(userID string) ([]domain.Session, error) {
+	sr.FindAllByUserIDArg = userID
+	sr.FindAllByUserIDInvocation++
+	return sr.FindAllByUserIDSessions, sr.FindAllByUserIDErr
+}
+
+// FindByRefreshTokenHash mock implementation of finding a session by refresh token hash.
+func (sr *MockSessionRepo) FindByRefreshTokenHash(hash string) (domain.Session, error) {
+	sr.FindByRefreshTokenHashArg = hash
+	sr.FindByRefreshTokenHashInvocation++
+	return sr.FindByRefreshTokenHashSession, sr.FindByRefreshTokenHashErr
+}
+
 // Delete mock implementation of deleting a session.
 func (sr *MockSessionRepo) Delete(id string) error {
 	sr.DeleteArg = id
@@ -116,6 +364,35 @@ func (sr *MockSessionRepo) Delete(id string) error {
 	return sr.DeleteErr
 }
 
+// Rotate mock implementation of rotating a session.
+func (sr *MockSessionRepo) Rotate(id string) error {
+	sr.RotateArg = id
+	sr.RotateInvocation++
+	return sr.RotateErr
+}
+
+// DeleteFamily mock implementation of deleting a session family.
+func (sr *MockSessionRepo) DeleteFamily(familyID string) error {
+	sr.DeleteFamilyArg = familyID
+	sr.DeleteFamilyInvocation++
+	return sr.DeleteFamilyErr
+}
+
+// DeleteByUserID mock implementation of deleting all sessions of a user.
+func (sr *MockSessionRepo) DeleteByUserID(userID string) error {
+	sr.DeleteByUserIDArg = userID
+	sr.DeleteByUserIDInvocation++
+	return sr.DeleteByUserIDErr
+}
+
+// DeleteUserSession mock implementation of deleting a session belonging to a user.
+func (sr *MockSessionRepo) DeleteUserSession(userID, sessionID string) error {
+	sr.DeleteUserSessionArgUserID = userID
+	sr.DeleteUserSessionArgSessionID = sessionID
+	sr.DeleteUserSessionInvocation++
+	return sr.DeleteUserSessionErr
+}
+
 // UnsetArgs sets all MockSessionRepo fields to their default value.
 func (sr *MockSessionRepo) UnsetArgs() {
 	sr.SaveArg = domain.Session{}
@@ -124,6 +401,28 @@ func (sr *MockSessionRepo) UnsetArgs() {
 	sr.FindArg = ""
 	sr.FindInvocation = 0
 
+	sr.FindByUserIDArg = ""
+	sr.FindByUserIDInvocation = 0
+
+	sr.FindAllByUserIDArg = ""
+	sr.FindAllByUserIDInvocation = 0
+
+	sr.FindByRefreshTokenHashArg = ""
+	sr.FindByRefreshTokenHashInvocation = 0
+
 	sr.DeleteArg = ""
 	sr.DeleteInvocation = 0
+
+	sr.RotateArg = ""
+	sr.RotateInvocation = 0
+
+	sr.DeleteFamilyArg = ""
+	sr.DeleteFamilyInvocation = 0
+
+	sr.DeleteByUserIDArg = ""
+	sr.DeleteByUserIDInvocation = 0
+
+	sr.DeleteUserSessionArgUserID = ""
+	sr.DeleteUserSessionArgSessionID = ""
+	sr.DeleteUserSessionInvocation = 0
 }
@@ -5,8 +5,10 @@ import (
 	"errors"
 	"log"
 	"sort"
+	"strings"
 	"time"
 
+	"github.com/lib/pq"
 	"github.com/mimir-news/directory/pkg/domain"
 	"github.com/mimir-news/pkg/dbutil"
 	"github.com/mimir-news/pkg/schema/stock"
@@ -15,7 +17,9 @@ import (
 
 // Common user related errors.
 var (
-	ErrNoSuchUser = errors.New("No such user")
+	ErrNoSuchUser   = errors.New("No such user")
+	ErrEmailTaken   = errors.New("Email is already in use")
+	ErrTOTPStepUsed = errors.New("TOTP time step has already been used")
 )
 
 var (
@@ -28,7 +32,22 @@ type UserRepo interface {
 	FindByEmail(email string) (domain.FullUser, error)
 	Save(user domain.FullUser) error
 	Delete(userID string) error
+	Restore(userID string) error
 	FindWatchlists(userID string) ([]user.Watchlist, error)
+	RecordFailedLogin(userID string, windowStart time.Time) (domain.LoginFailures, error)
+	LockUntil(userID string, until time.Time) error
+	ResetLoginFailures(userID string) error
+	ListCredentials(afterUserID string, limit int) ([]domain.UserCredentials, error)
+	ReplaceCredentials(old, new domain.UserCredentials) error
+	InvalidateSessions(userID string, validAfter time.Time) error
+	SaveTOTPSecret(userID, secret string) error
+	EnableTOTP(userID string) error
+	DisableTOTP(userID string) error
+	UseTOTPStep(userID string, step int64) error
+	PurgeInactiveGuests(inactiveSince time.Time, limit int) (int, error)
+	PurgeDeletedUsers(deletedBefore time.Time, limit int) (int, error)
+	SearchByEmail(emailPrefix string, limit int) ([]domain.FullUser, error)
+	SetLocked(userID string, locked bool) error
 }
 
 // NewUserRepo creates a new UserRepo using the default implementation.
@@ -43,14 +62,18 @@ type pgUserRepo struct {
 }
 
 const findUserByIDQuery = `SELECT 
-	id, email, role, password, salt, created_at
+	id, email, role, password, salt, email_verified, locked, 
+	failed_login_attempts, login_lockouts, locked_until, sessions_valid_after, 
+	totp_secret, totp_enabled, totp_last_used_step, created_at, deleted_at
 	FROM app_user WHERE id = $1`
 
 // Find attempts to find a user by ID.
 func (ur *pgUserRepo) Find(userID string) (domain.FullUser, error) {
 	var u nullUser
 	err := ur.db.QueryRow(findUserByIDQuery, userID).Scan(
-		&u.id, &u.email, &u.role, &u.password, &u.salt, &u.createdAt)
+		&u.id, &u.email, &u.role, &u.password, &u.salt, &u.emailVerified, &u.locked,
+		&u.failedLoginAttempts, &u.loginLockouts, &u.lockedUntil, &u.sessionsValidAfter,
+		&u.totpSecret, &u.totpEnabled, &u.totpLastUsedStep, &u.createdAt, &u.deletedAt)
 
 	if err == sql.ErrNoRows {
 		return emptyUser, ErrNoSuchUser
@@ -62,14 +85,18 @@ func (ur *pgUserRepo) Find(userID string) (domain.FullUser, error) {
 }
 
 const findUserByEmailQuery = `SELECT 
-	id, email, role, password, salt, created_at
+	id, email, role, password, salt, email_verified, locked, 
+	failed_login_attempts, login_lockouts, locked_until, sessions_valid_after, 
+	totp_secret, totp_enabled, totp_last_used_step, created_at, deleted_at
 	FROM app_user WHERE email = $1`
 
 // FindByEmail attempts to find a user by email.
 func (ur *pgUserRepo) FindByEmail(email string) (domain.FullUser, error) {
 	var u nullUser
 	err := ur.db.QueryRow(findUserByEmailQuery, email).Scan(
-		&u.id, &u.email, &u.role, &u.password, &u.salt, &u.createdAt)
+		&u.id, &u.email, &u.role, &u.password, &u.salt, &u.emailVerified, &u.locked,
+		&u.failedLoginAttempts, &u.loginLockouts, &u.lockedUntil, &u.sessionsValidAfter,
+		&u.totpSecret, &u.totpEnabled, &u.totpLastUsedStep, &u.createdAt, &u.deletedAt)
 
 	if err == sql.ErrNoRows {
 		return emptyUser, ErrNoSuchUser
@@ -81,17 +108,22 @@ func (ur *pgUserRepo) FindByEmail(email string) (domain.FullUser, error) {
 
 const saveUserQuery = `
 	INSERT INTO 
-	app_user(id, email, role, password, salt, created_at)
-	VALUES ($1, $2, $3, $4, $5, $6)
+	app_user(id, email, role, password, salt, email_verified, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
 	ON CONFLICT ON CONSTRAINT app_user_pkey 
-	DO UPDATE SET email = $2, password = $4, salt = $5`
+	DO UPDATE SET email = $2, role = $3, password = $4, salt = $5, email_verified = $6`
 
-// Save upserts a user in the database.
+// Save upserts a user in the database. Empty emails and credentials, which guests do not have, are stored as NULL.
 func (ur *pgUserRepo) Save(user domain.FullUser) error {
 	u := user.User
 	c := user.Credentials
-	res, err := ur.db.Exec(saveUserQuery, u.ID, u.Email, u.Role, c.Password, c.Salt, u.CreatedAt)
+	res, err := ur.db.Exec(saveUserQuery,
+		u.ID, nullString(u.Email), u.Role, nullString(c.Password), nullString(c.Salt), user.EmailVerified, u.CreatedAt)
 	if err != nil {
+		pgErr, ok := err.(*pq.Error)
+		if ok && pgErr.Code == uniqueConstraintErrorCode {
+			return ErrEmailTaken
+		}
 		return err
 	}
 
@@ -99,16 +131,379 @@ func (ur *pgUserRepo) Save(user domain.FullUser) error {
 }
 
 const deleteUserQuery = `
+	UPDATE app_user SET deleted_at = COALESCE(deleted_at, $2) WHERE id = $1`
+
+const revokeUserAPIKeysQuery = `UPDATE api_key SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`
+
+// Delete marks a user as deleted and revokes its API keys. The email, credentials and external identities
+// of the user are kept so that the user can restore the account by logging in until the user is purged.
+func (ur *pgUserRepo) Delete(userID string) error {
+	tx, err := ur.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.Exec(deleteUserQuery, userID, time.Now().UTC())
+	if err != nil {
+		dbutil.RollbackTx(tx)
+		return err
+	}
+
+	err = dbutil.AssertRowsAffected(res, 1, ErrNoSuchUser)
+	if err != nil {
+		dbutil.RollbackTx(tx)
+		return err
+	}
+
+	_, err = tx.Exec(revokeUserAPIKeysQuery, userID)
+	if err != nil {
+		dbutil.RollbackTx(tx)
+		return err
+	}
+
+	return tx.Commit()
+}
+
+const restoreUserQuery = `UPDATE app_user SET deleted_at = NULL WHERE id = $1`
+
+// Restore restores a user that has been deleted but not yet purged.
+func (ur *pgUserRepo) Restore(userID string) error {
+	res, err := ur.db.Exec(restoreUserQuery, userID)
+	if err != nil {
+		return err
+	}
+
+	return dbutil.AssertRowsAffected(res, 1, ErrNoSuchUser)
+}
+
+const recordFailedLoginQuery = `
 	UPDATE app_user SET
-		email = NULL, 
-		password = NULL,
-		salt = NULL,
-		locked = TRUE
+		failed_login_attempts = CASE 
+			WHEN last_failed_login_at > $2 THEN COALESCE(failed_login_attempts, 0) + 1 
+			ELSE 1 END,
+		last_failed_login_at = $3
+	WHERE id = $1
+	RETURNING failed_login_attempts, COALESCE(login_lockouts, 0), locked_until`
+
+// RecordFailedLogin counts a failed login attempt, attempts made before the window start are discarded.
+func (ur *pgUserRepo) RecordFailedLogin(userID string, windowStart time.Time) (domain.LoginFailures, error) {
+	var failures domain.LoginFailures
+	var lockedUntil pq.NullTime
+	err := ur.db.QueryRow(recordFailedLoginQuery, userID, windowStart, time.Now().UTC()).Scan(
+		&failures.Attempts, &failures.Lockouts, &lockedUntil)
+	if err == sql.ErrNoRows {
+		return failures, ErrNoSuchUser
+	} else if err != nil {
+		return failures, err
+	}
+
+	failures.LockedUntil = lockedUntil.Time
+	return failures, nil
+}
+
+const lockUserUntilQuery = `
+	UPDATE app_user SET
+		locked_until = $2,
+		login_lockouts = COALESCE(login_lockouts, 0) + 1,
+		failed_login_attempts = 0
 	WHERE id = $1`
 
-// Save upserts a user in the database.
-func (ur *pgUserRepo) Delete(userID string) error {
-	res, err := ur.db.Exec(deleteUserQuery, userID)
+// LockUntil temporarily locks a user until the given time.
+func (ur *pgUserRepo) LockUntil(userID string, until time.Time) error {
+	res, err := ur.db.Exec(lockUserUntilQuery, userID, until)
+	if err != nil {
+		return err
+	}
+
+	return dbutil.AssertRowsAffected(res, 1, ErrNoSuchUser)
+}
+
+const resetLoginFailuresQuery = `
+	UPDATE app_user SET
+		failed_login_attempts = 0,
+		login_lockouts = 0,
+		locked_until = NULL
+	WHERE id = $1`
+
+// ResetLoginFailures clears the failed login attempts and lockouts of a user.
+func (ur *pgUserRepo) ResetLoginFailures(userID string) error {
+	res, err := ur.db.Exec(resetLoginFailuresQuery, userID)
+	if err != nil {
+		return err
+	}
+
+	return dbutil.AssertRowsAffected(res, 1, ErrNoSuchUser)
+}
+
+const listCredentialsQuery = `
+	SELECT id, email, password, salt, totp_secret FROM app_user 
+	WHERE id > $1 AND (password IS NOT NULL OR totp_secret IS NOT NULL)
+	ORDER BY id 
+	LIMIT $2`
+
+// ListCredentials lists the stored credentials and TOTP secrets of users ordered by user id, starting after the given user id.
+func (ur *pgUserRepo) ListCredentials(afterUserID string, limit int) ([]domain.UserCredentials, error) {
+	rows, err := ur.db.Query(listCredentialsQuery, afterUserID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credentials := make([]domain.UserCredentials, 0)
+	for rows.Next() {
+		var userID string
+		var email, password, salt, totpSecret sql.NullString
+		err = rows.Scan(&userID, &email, &password, &salt, &totpSecret)
+		if err != nil {
+			return nil, err
+		}
+
+		credentials = append(credentials, domain.UserCredentials{
+			UserID: userID,
+			Credentials: domain.StoredCredentials{
+				Email:    email.String,
+				Password: password.String,
+				Salt:     salt.String,
+			},
+			TOTPSecret: totpSecret.String,
+		})
+	}
+
+	return credentials, rows.Err()
+}
+
+const replaceCredentialsQuery = `
+	UPDATE app_user SET
+		password = $5,
+		salt = $6,
+		totp_secret = $7
+	WHERE id = $1 
+	AND password IS NOT DISTINCT FROM $2 
+	AND salt IS NOT DISTINCT FROM $3 
+	AND totp_secret IS NOT DISTINCT FROM $4`
+
+// ReplaceCredentials replaces the password, salt and TOTP secret of a user if they have not been changed since they were read.
+func (ur *pgUserRepo) ReplaceCredentials(old, new domain.UserCredentials) error {
+	res, err := ur.db.Exec(replaceCredentialsQuery, old.UserID,
+		nullString(old.Credentials.Password), nullString(old.Credentials.Salt), nullString(old.TOTPSecret),
+		nullString(new.Credentials.Password), nullString(new.Credentials.Salt), nullString(new.TOTPSecret))
+	if err != nil {
+		return err
+	}
+
+	return dbutil.AssertRowsAffected(res, 1, ErrNoSuchUser)
+}
+
+const invalidateSessionsQuery = `UPDATE app_user SET sessions_valid_after = $2 WHERE id = $1`
+
+// InvalidateSessions marks all sessions of a user started before the given time as invalid.
+func (ur *pgUserRepo) InvalidateSessions(userID string, validAfter time.Time) error {
+	res, err := ur.db.Exec(invalidateSessionsQuery, userID, validAfter)
+	if err != nil {
+		return err
+	}
+
+	return dbutil.AssertRowsAffected(res, 1, ErrNoSuchUser)
+}
+
+const saveTOTPSecretQuery = `
+	UPDATE app_user SET
+		totp_secret = $2,
+		totp_enabled = FALSE,
+		totp_last_used_step = NULL
+	WHERE id = $1`
+
+// SaveTOTPSecret stores a new encrypted TOTP secret for a user, the secret is disabled until it is enabled.
+func (ur *pgUserRepo) SaveTOTPSecret(userID, secret string) error {
+	res, err := ur.db.Exec(saveTOTPSecretQuery, userID, secret)
+	if err != nil {
+		return err
+	}
+
+	return dbutil.AssertRowsAffected(res, 1, ErrNoSuchUser)
+}
+
+const enableTOTPQuery = `
+	UPDATE app_user SET totp_enabled = TRUE 
+	WHERE id = $1 AND totp_secret IS NOT NULL`
+
+// EnableTOTP enables the stored TOTP secret of a user as a second factor.
+func (ur *pgUserRepo) EnableTOTP(userID string) error {
+	res, err := ur.db.Exec(enableTOTPQuery, userID)
+	if err != nil {
+		return err
+	}
+
+	return dbutil.AssertRowsAffected(res, 1, ErrNoSuchUser)
+}
+
+const disableTOTPQuery = `
+	UPDATE app_user SET
+		totp_secret = NULL,
+		totp_enabled = FALSE,
+		totp_last_used_step = NULL
+	WHERE id = $1`
+
+// DisableTOTP removes the TOTP secret of a user so that it is no longer used as a second factor.
+func (ur *pgUserRepo) DisableTOTP(userID string) error {
+	res, err := ur.db.Exec(disableTOTPQuery, userID)
+	if err != nil {
+		return err
+	}
+
+	return dbutil.AssertRowsAffected(res, 1, ErrNoSuchUser)
+}
+
+const useTOTPStepQuery = `
+	UPDATE app_user SET totp_last_used_step = $2 
+	WHERE id = $1 AND (totp_last_used_step IS NULL OR totp_last_used_step < $2)`
+
+// UseTOTPStep records the time step of an accepted TOTP code, fails if the same or a later step has already been used.
+func (ur *pgUserRepo) UseTOTPStep(userID string, step int64) error {
+	res, err := ur.db.Exec(useTOTPStepQuery, userID, step)
+	if err != nil {
+		return err
+	}
+
+	return dbutil.AssertRowsAffected(res, 1, ErrTOTPStepUsed)
+}
+
+const findInactiveGuestsQuery = `
+	SELECT u.id FROM app_user u
+	WHERE u.role = 'GUEST' 
+	AND u.created_at < $1
+	AND NOT EXISTS (
+		SELECT 1 FROM session s WHERE s.user_id = u.id AND s.created_at >= $1
+	)
+	LIMIT $2
+	FOR UPDATE`
+
+const findDeletedUsersQuery = `
+	SELECT id FROM app_user
+	WHERE deleted_at < $1
+	LIMIT $2
+	FOR UPDATE`
+
+// Statements deleting purged users and everything that references them, in the order they must be run.
+// Audit events are kept, but the ids, sessions and clients of purged users are removed from them.
+var purgeUsersQueries = []string{
+	`UPDATE audit_event SET client_ip = NULL, user_agent = NULL
+	WHERE (user_id = ANY($1) AND actor_id IS NULL) OR actor_id = ANY($1)`,
+	`UPDATE audit_event SET actor_id = NULL WHERE actor_id = ANY($1)`,
+	`UPDATE audit_event SET user_id = NULL, session_id = NULL WHERE user_id = ANY($1)`,
+	`DELETE FROM watchlist_member WHERE watchlist_id IN (SELECT id FROM watchlist WHERE user_id = ANY($1))`,
+	`DELETE FROM watchlist WHERE user_id = ANY($1)`,
+	`DELETE FROM session WHERE user_id = ANY($1)`,
+	`DELETE FROM one_time_credential WHERE user_id = ANY($1)`,
+	`DELETE FROM recovery_code WHERE user_id = ANY($1)`,
+	`DELETE FROM external_identity WHERE user_id = ANY($1)`,
+	`DELETE FROM api_key WHERE user_id = ANY($1)`,
+	`UPDATE service_account SET created_by = NULL WHERE created_by = ANY($1)`,
+	`DELETE FROM service_account WHERE user_id = ANY($1)`,
+	`DELETE FROM app_user WHERE id = ANY($1)`,
+}
+
+// PurgeInactiveGuests deletes guests that have not started or refreshed a session since the given time,
+// along with their watchlists and sessions. At most limit guests are purged, the number purged is returned.
+func (ur *pgUserRepo) PurgeInactiveGuests(inactiveSince time.Time, limit int) (int, error) {
+	return ur.purgeUsers(findInactiveGuestsQuery, inactiveSince, limit)
+}
+
+// PurgeDeletedUsers deletes users that were deleted before the given time, along with their
+// watchlists and sessions. At most limit users are purged, the number purged is returned.
+func (ur *pgUserRepo) PurgeDeletedUsers(deletedBefore time.Time, limit int) (int, error) {
+	return ur.purgeUsers(findDeletedUsersQuery, deletedBefore, limit)
+}
+
+// purgeUsers deletes the users found by a query which takes a time and a limit.
+func (ur *pgUserRepo) purgeUsers(findQuery string, at time.Time, limit int) (int, error) {
+	tx, err := ur.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	userIDs, err := findUserIDs(tx, findQuery, at, limit)
+	if err != nil {
+		dbutil.RollbackTx(tx)
+		return 0, err
+	}
+
+	if len(userIDs) == 0 {
+		return 0, tx.Commit()
+	}
+
+	for _, query := range purgeUsersQueries {
+		_, err = tx.Exec(query, pq.Array(userIDs))
+		if err != nil {
+			dbutil.RollbackTx(tx)
+			return 0, err
+		}
+	}
+
+	return len(userIDs), tx.Commit()
+}
+
+func findUserIDs(tx *sql.Tx, query string, at time.Time, limit int) ([]string, error) {
+	rows, err := tx.Query(query, at, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	userIDs := make([]string, 0)
+	for rows.Next() {
+		var userID string
+		err = rows.Scan(&userID)
+		if err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+
+	return userIDs, rows.Err()
+}
+
+const searchUsersByEmailQuery = `SELECT 
+	id, email, role, password, salt, email_verified, locked, 
+	failed_login_attempts, login_lockouts, locked_until, sessions_valid_after, 
+	totp_secret, totp_enabled, totp_last_used_step, created_at, deleted_at
+	FROM app_user WHERE LOWER(email) LIKE $1 ESCAPE '\'
+	ORDER BY email
+	LIMIT $2`
+
+// SearchByEmail finds users whose email starts with the given prefix, ignoring case. At most limit users are returned.
+func (ur *pgUserRepo) SearchByEmail(emailPrefix string, limit int) ([]domain.FullUser, error) {
+	pattern := likeEscaper.Replace(strings.ToLower(emailPrefix)) + "%"
+	rows, err := ur.db.Query(searchUsersByEmailQuery, pattern, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make([]domain.FullUser, 0)
+	for rows.Next() {
+		var u nullUser
+		err = rows.Scan(
+			&u.id, &u.email, &u.role, &u.password, &u.salt, &u.emailVerified, &u.locked,
+			&u.failedLoginAttempts, &u.loginLockouts, &u.lockedUntil, &u.sessionsValidAfter,
+			&u.totpSecret, &u.totpEnabled, &u.totpLastUsedStep, &u.createdAt, &u.deletedAt)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u.user())
+	}
+
+	return users, rows.Err()
+}
+
+// likeEscaper escapes the characters that have a special meaning in LIKE patterns.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+const setUserLockedQuery = `UPDATE app_user SET locked = $2 WHERE id = $1`
+
+// SetLocked locks or unlocks the account of a user. Temporary lockouts caused by failed logins are not affected.
+func (ur *pgUserRepo) SetLocked(userID string, locked bool) error {
+	res, err := ur.db.Exec(setUserLockedQuery, userID, locked)
 	if err != nil {
 		return err
 	}
@@ -222,12 +617,24 @@ func extractWatchlistMembers(rows *sql.Rows) ([]watchlistMember, error) {
 }
 
 type nullUser struct {
-	id        string
-	email     sql.NullString
-	role      sql.NullString
-	password  sql.NullString
-	salt      sql.NullString
-	createdAt time.Time
+	id            string
+	email         sql.NullString
+	role          sql.NullString
+	password      sql.NullString
+	salt          sql.NullString
+	emailVerified sql.NullBool
+	locked        sql.NullBool
+	createdAt     time.Time
+	deletedAt     pq.NullTime
+
+	failedLoginAttempts sql.NullInt64
+	loginLockouts       sql.NullInt64
+	lockedUntil         pq.NullTime
+	sessionsValidAfter  pq.NullTime
+
+	totpSecret       sql.NullString
+	totpEnabled      sql.NullBool
+	totpLastUsedStep sql.NullInt64
 }
 
 func (u nullUser) user() domain.FullUser {
@@ -244,9 +651,28 @@ func (u nullUser) user() domain.FullUser {
 			Password: u.password.String,
 			Salt:     u.salt.String,
 		},
+		EmailVerified: u.emailVerified.Bool,
+		Locked:        u.locked.Bool,
+		LoginFailures: domain.LoginFailures{
+			Attempts:    int(u.failedLoginAttempts.Int64),
+			Lockouts:    int(u.loginLockouts.Int64),
+			LockedUntil: u.lockedUntil.Time,
+		},
+		SessionsValidAfter: u.sessionsValidAfter.Time,
+		TOTP: domain.TOTP{
+			Secret:       u.totpSecret.String,
+			Enabled:      u.totpEnabled.Bool,
+			LastUsedStep: u.totpLastUsedStep.Int64,
+		},
+		DeletedAt: u.deletedAt.Time,
 	}
 }
 
+// nullString converts empty strings to NULL.
+func nullString(value string) sql.NullString {
+	return sql.NullString{String: value, Valid: value != ""}
+}
+
 // MockUserRepo mock implementation of UserRepo.
 type MockUserRepo struct {
 	FindUser domain.FullUser
@@ -263,9 +689,85 @@ type MockUserRepo struct {
 	DeleteErr error
 	DeleteArg string
 
+	RestoreErr        error
+	RestoreArg        string
+	RestoreInvocation int
+
 	FindWatchlistsRes []user.Watchlist
 	FindWatchlistsErr error
 	FindWatchlistsArg string
+
+	RecordFailedLoginRes            domain.LoginFailures
+	RecordFailedLoginErr            error
+	RecordFailedLoginArgUserID      string
+	RecordFailedLoginArgWindowStart time.Time
+	RecordFailedLoginInvocation     int
+
+	LockUntilErr        error
+	LockUntilArgUserID  string
+	LockUntilArgUntil   time.Time
+	LockUntilInvocation int
+
+	ResetLoginFailuresErr        error
+	ResetLoginFailuresArg        string
+	ResetLoginFailuresInvocation int
+
+	ListCredentialsRes        []domain.UserCredentials
+	ListCredentialsErr        error
+	ListCredentialsArgAfterID string
+	ListCredentialsArgLimit   int
+	ListCredentialsInvocation int
+
+	ReplaceCredentialsErr        error
+	ReplaceCredentialsArgOld     domain.UserCredentials
+	ReplaceCredentialsArgNew     domain.UserCredentials
+	ReplaceCredentialsInvocation int
+
+	InvalidateSessionsErr           error
+	InvalidateSessionsArgUserID     string
+	InvalidateSessionsArgValidAfter time.Time
+	InvalidateSessionsInvocation    int
+
+	SaveTOTPSecretErr        error
+	SaveTOTPSecretArgUserID  string
+	SaveTOTPSecretArgSecret  string
+	SaveTOTPSecretInvocation int
+
+	EnableTOTPErr        error
+	EnableTOTPArg        string
+	EnableTOTPInvocation int
+
+	DisableTOTPErr        error
+	DisableTOTPArg        string
+	DisableTOTPInvocation int
+
+	UseTOTPStepErr        error
+	UseTOTPStepArgUserID  string
+	UseTOTPStepArgStep    int64
+	UseTOTPStepInvocation int
+
+	PurgeInactiveGuestsRes        int
+	PurgeInactiveGuestsErr        error
+	PurgeInactiveGuestsArgSince   time.Time
+	PurgeInactiveGuestsArgLimit   int
+	PurgeInactiveGuestsInvocation int
+
+	PurgeDeletedUsersRes        int
+	PurgeDeletedUsersErr        error
+	PurgeDeletedUsersArgBefore  time.Time
+	PurgeDeletedUsersArgLimit   int
+	PurgeDeletedUsersInvocation int
+
+	SearchByEmailRes        []domain.FullUser
+	SearchByEmailErr        error
+	SearchByEmailArgPrefix  string
+	SearchByEmailArgLimit   int
+	SearchByEmailInvocation int
+
+	SetLockedErr        error
+	SetLockedArgUserID  string
+	SetLockedArgLocked  bool
+	SetLockedInvocation int
 }
 
 // Find mock implementation of finding a user by id.
@@ -292,8 +794,135 @@ func (ur *MockUserRepo) Delete(id string) error {
 	return ur.DeleteErr
 }
 
+// Restore mock implementation of restoring a deleted user.
+func (ur *MockUserRepo) Restore(userID string) error {
+	ur.RestoreArg = userID
+	ur.RestoreInvocation++
+	return ur.RestoreErr
+}
+
 // FindWatchlists mock implementation of finding watchlists by user id.
 func (ur *MockUserRepo) FindWatchlists(userID string) ([]user.Watchlist, error) {
 	ur.FindWatchlistsArg = userID
 	return ur.FindWatchlistsRes, ur.FindWatchlistsErr
 }
+
+// RecordFailedLogin mock implementation of recording a failed login.
+func (ur *MockUserRepo) RecordFailedLogin(userID string, windowStart time.Time) (domain.LoginFailures, error) {
+	ur.RecordFailedLoginArgUserID = userID
+	ur.RecordFailedLoginArgWindowStart = windowStart
+	ur.RecordFailedLoginInvocation++
+	return ur.RecordFailedLoginRes, ur.RecordFailedLoginErr
+}
+
+// LockUntil mock implementation of temporarily locking a user.
+func (ur *MockUserRepo) LockUntil(userID string, until time.Time) error {
+	ur.LockUntilArgUserID = userID
+	ur.LockUntilArgUntil = until
+	ur.LockUntilInvocation++
+	return ur.LockUntilErr
+}
+
+// ResetLoginFailures mock implementation of resetting failed logins.
+func (ur *MockUserRepo) ResetLoginFailures(userID string) error {
+	ur.ResetLoginFailuresArg = userID
+	ur.ResetLoginFailuresInvocation++
+	return ur.ResetLoginFailuresErr
+}
+
+// ListCredentials mock implementation of listing stored credentials.
+// Only users with ids after the given id are returned.
+func (ur *MockUserRepo) ListCredentials(afterUserID string, limit int) ([]domain.UserCredentials, error) {
+	ur.ListCredentialsArgAfterID = afterUserID
+	ur.ListCredentialsArgLimit = limit
+	ur.ListCredentialsInvocation++
+	if ur.ListCredentialsErr != nil {
+		return nil, ur.ListCredentialsErr
+	}
+
+	credentials := make([]domain.UserCredentials, 0)
+	for _, c := range ur.ListCredentialsRes {
+		if c.UserID > afterUserID && len(credentials) < limit {
+			credentials = append(credentials, c)
+		}
+	}
+	return credentials, nil
+}
+
+// ReplaceCredentials mock implementation of replacing stored credentials.
+func (ur *MockUserRepo) ReplaceCredentials(old, new domain.UserCredentials) error {
+	ur.ReplaceCredentialsArgOld = old
+	ur.ReplaceCredentialsArgNew = new
+	ur.ReplaceCredentialsInvocation++
+	return ur.ReplaceCredentialsErr
+}
+
+// InvalidateSessions mock implementation of invalidating a users sessions.
+func (ur *MockUserRepo) InvalidateSessions(userID string, validAfter time.Time) error {
+	ur.InvalidateSessionsArgUserID = userID
+	ur.InvalidateSessionsArgValidAfter = validAfter
+	ur.InvalidateSessionsInvocation++
+	return ur.InvalidateSessionsErr
+}
+
+// SaveTOTPSecret mock implementation of storing a TOTP secret.
+func (ur *MockUserRepo) SaveTOTPSecret(userID, secret string) error {
+	ur.SaveTOTPSecretArgUserID = userID
+	ur.SaveTOTPSecretArgSecret = secret
+	ur.SaveTOTPSecretInvocation++
+	return ur.SaveTOTPSecretErr
+}
+
+// EnableTOTP mock implementation of enabling TOTP.
+func (ur *MockUserRepo) EnableTOTP(userID string) error {
+	ur.EnableTOTPArg = userID
+	ur.EnableTOTPInvocation++
+	return ur.EnableTOTPErr
+}
+
+// DisableTOTP mock implementation of disabling TOTP.
+func (ur *MockUserRepo) DisableTOTP(userID string) error {
+	ur.DisableTOTPArg = userID
+	ur.DisableTOTPInvocation++
+	return ur.DisableTOTPErr
+}
+
+// UseTOTPStep mock implementation of recording a used TOTP time step.
+func (ur *MockUserRepo) UseTOTPStep(userID string, step int64) error {
+	ur.UseTOTPStepArgUserID = userID
+	ur.UseTOTPStepArgStep = step
+	ur.UseTOTPStepInvocation++
+	return ur.UseTOTPStepErr
+}
+
+// PurgeInactiveGuests mock implementation of purging inactive guests.
+func (ur *MockUserRepo) PurgeInactiveGuests(inactiveSince time.Time, limit int) (int, error) {
+	ur.PurgeInactiveGuestsArgSince = inactiveSince
+	ur.PurgeInactiveGuestsArgLimit = limit
+	ur.PurgeInactiveGuestsInvocation++
+	return ur.PurgeInactiveGuestsRes, ur.PurgeInactiveGuestsErr
+}
+
+// PurgeDeletedUsers mock implementation of purging deleted users.
+func (ur *MockUserRepo) PurgeDeletedUsers(deletedBefore time.Time, limit int) (int, error) {
+	ur.PurgeDeletedUsersArgBefore = deletedBefore
+	ur.PurgeDeletedUsersArgLimit = limit
+	ur.PurgeDeletedUsersInvocation++
+	return ur.PurgeDeletedUsersRes, ur.PurgeDeletedUsersErr
+}
+
+// SearchByEmail mock implementation of searching for users by email.
+func (ur *MockUserRepo) SearchByEmail(emailPrefix string, limit int) ([]domain.FullUser, error) {
+	ur.SearchByEmailArgPrefix = emailPrefix
+	ur.SearchByEmailArgLimit = limit
+	ur.SearchByEmailInvocation++
+	return ur.SearchByEmailRes, ur.SearchByEmailErr
+}
+
+// SetLocked mock implementation of locking or unlocking a user.
+func (ur *MockUserRepo) SetLocked(userID string, locked bool) error {
+	ur.SetLockedArgUserID = userID
+	ur.SetLockedArgLocked = locked
+	ur.SetLockedInvocation++
+	return ur.SetLockedErr
+}
@@ -2,6 +2,10 @@ package repository
 
 import (
 	"database/sql"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"testing"
 	"time"
 
@@ -139,3 +143,45 @@ func TestCreateWatchlists(t *testing.T) {
 		}
 	}
 }
+
+var (
+	createTableRegexp   = regexp.MustCompile(`(?s)CREATE TABLE (\w+) \((.*?)\n\);`)
+	userReferenceRegexp = regexp.MustCompile(`(?m)^\s*(\w+) VARCHAR\(50\).*REFERENCES app_user\(id\)`)
+)
+
+// TestPurgeUsersQueries checks that every column referencing a user, such as the creator of a
+// service account, is cleared before purged users are deleted, since the deletion would otherwise fail.
+func TestPurgeUsersQueries(t *testing.T) {
+	assert := assert.New(t)
+
+	files, err := filepath.Glob("../../cmd/migrations/*.sql")
+	assert.NoError(err)
+	assert.NotEmpty(files)
+
+	last := len(purgeUsersQueries) - 1
+	assert.Equal("DELETE FROM app_user WHERE id = ANY($1)", purgeUsersQueries[last])
+
+	references := 0
+	for _, file := range files {
+		migration, err := ioutil.ReadFile(file)
+		assert.NoError(err)
+
+		for _, table := range createTableRegexp.FindAllStringSubmatch(string(migration), -1) {
+			for _, column := range userReferenceRegexp.FindAllStringSubmatch(table[2], -1) {
+				references++
+				assert.True(purgesReference(purgeUsersQueries[:last], table[1], column[1]), table[1]+"."+column[1])
+			}
+		}
+	}
+	assert.True(references >= 8)
+}
+
+func purgesReference(queries []string, table, column string) bool {
+	for _, query := range queries {
+		if strings.Contains(query, " "+table+" ") && strings.Contains(query, "WHERE "+column+" = ANY($1)") {
+			return true
+		}
+	}
+
+	return false
+}
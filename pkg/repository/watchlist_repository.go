@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/lib/pq"
+	"github.com/mimir-news/directory/pkg/domain"
 	"github.com/mimir-news/pkg/dbutil"
 	"github.com/mimir-news/pkg/schema/stock"
 	"github.com/mimir-news/pkg/schema/user"
@@ -34,6 +35,7 @@ type WatchlistRepo interface {
 	AddStock(userID, symbol, watchlistID string) error
 	DeleteStock(userID, symbol, watchlistID string) error
 	Delete(userID, watchlistID string) error
+	FindByUserID(userID string) ([]domain.ExportedWatchlist, error)
 }
 
 // NewWatchlistRepo creates a new watchlist using the default implementation.
@@ -187,6 +189,48 @@ func (wr *pgWatchlistRepo) Delete(userID, watchlistID string) error {
 	return tx.Commit()
 }
 
+const findUserWatchlistMembersQuery = `
+	SELECT w.id, w.name, w.created_at, m.symbol, s.name, m.created_at
+	FROM watchlist w
+	LEFT JOIN watchlist_member m ON m.watchlist_id = w.id
+	LEFT JOIN stock s ON s.symbol = m.symbol
+	WHERE w.user_id = $1
+	ORDER BY w.created_at, w.id, m.created_at`
+
+// FindByUserID gets all watchlists of a user along with when each stock was added to them.
+func (wr *pgWatchlistRepo) FindByUserID(userID string) ([]domain.ExportedWatchlist, error) {
+	rows, err := wr.db.Query(findUserWatchlistMembersQuery, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	watchlists := make([]domain.ExportedWatchlist, 0)
+	for rows.Next() {
+		var w domain.ExportedWatchlist
+		var symbol, name sql.NullString
+		var addedAt pq.NullTime
+		err = rows.Scan(&w.ID, &w.Name, &w.CreatedAt, &symbol, &name, &addedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		last := len(watchlists) - 1
+		if last < 0 || watchlists[last].ID != w.ID {
+			w.Members = make([]domain.ExportedWatchlistMember, 0)
+			watchlists = append(watchlists, w)
+			last++
+		}
+
+		if symbol.Valid {
+			member := domain.ExportedWatchlistMember{Symbol: symbol.String, Name: name.String, AddedAt: addedAt.Time}
+			watchlists[last].Members = append(watchlists[last].Members, member)
+		}
+	}
+
+	return watchlists, rows.Err()
+}
+
 const deleteStocksQuery = `
 	DELETE FROM watchlist_member WHERE watchlist_id = $1`
 
@@ -271,6 +315,10 @@ type MockWatchlistRepo struct {
 	DeleteErr            error
 	DeleteArgUserID      string
 	DeleteArgWatchlistID string
+
+	FindByUserIDWatchlists []domain.ExportedWatchlist
+	FindByUserIDErr        error
+	FindByUserIDArg        string
 }
 
 // UnsetArgs unsets all recorded arguments.
@@ -291,6 +339,8 @@ func (wr *MockWatchlistRepo) UnsetArgs() {
 
 	wr.DeleteArgUserID = ""
 	wr.DeleteArgWatchlistID = ""
+
+	wr.FindByUserIDArg = ""
 }
 
 // Get mock implemntation of Get.
@@ -334,3 +384,10 @@ func (wr *MockWatchlistRepo) Delete(userID, watchlistID string) error {
 
 	return wr.DeleteErr
 }
+
+// FindByUserID mock implementation of FindByUserID.
+func (wr *MockWatchlistRepo) FindByUserID(userID string) ([]domain.ExportedWatchlist, error) {
+	wr.FindByUserIDArg = userID
+
+	return wr.FindByUserIDWatchlists, wr.FindByUserIDErr
+}
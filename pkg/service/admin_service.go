@@ -0,0 +1,361 @@
+package service
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/directory/pkg/repository"
+	"github.com/mimir-news/pkg/httputil"
+)
+
+const maxAdminSearchResults = 50
+
+// AdminService service responsible for letting admins manage the accounts of other users.
+// Every action taken by an admin is recorded as a security event.
+type AdminService interface {
+	SearchUsers(actor domain.Actor, emailPrefix string) ([]domain.AdminUser, error)
+	GetUser(actor domain.Actor, userID string) (domain.AdminUser, error)
+	Lock(actor domain.Actor, userID string) error
+	Unlock(actor domain.Actor, userID string) error
+	Logout(actor domain.Actor, userID string) error
+	ResetPassword(actor domain.Actor, userID string) error
+	ExportEvents(actor domain.Actor, userID string, w io.Writer) error
+	CreateServiceAccount(actor domain.Actor, req domain.ServiceAccountRequest) (domain.ServiceAccount, error)
+	ListServiceAccounts(actor domain.Actor) ([]domain.ServiceAccount, error)
+	DeleteServiceAccount(actor domain.Actor, accountID string) error
+	CreateServiceAccountKey(actor domain.Actor, accountID string, req domain.APIKeyRequest) (domain.CreatedAPIKey, error)
+	ListServiceAccountKeys(actor domain.Actor, accountID string) ([]domain.APIKeyInfo, error)
+	RevokeServiceAccountKey(actor domain.Actor, accountID, keyID string) error
+}
+
+// NewAdminService creates a new AdminService using the default implementation.
+func NewAdminService(
+	passwordResetSvc PasswordResetService, apiKeySvc APIKeyService, userRepo repository.UserRepo,
+	sessionRepo repository.SessionRepo, serviceAccountRepo repository.ServiceAccountRepo,
+	eventRepo repository.AuditEventRepo, events SecurityEventRecorder) AdminService {
+	return &adminSvc{
+		passwordResetSvc:   passwordResetSvc,
+		apiKeySvc:          apiKeySvc,
+		userRepo:           userRepo,
+		sessionRepo:        sessionRepo,
+		serviceAccountRepo: serviceAccountRepo,
+		eventRepo:          eventRepo,
+		events:             events,
+	}
+}
+
+type adminSvc struct {
+	passwordResetSvc   PasswordResetService
+	apiKeySvc          APIKeyService
+	userRepo           repository.UserRepo
+	sessionRepo        repository.SessionRepo
+	serviceAccountRepo repository.ServiceAccountRepo
+	eventRepo          repository.AuditEventRepo
+	events             SecurityEventRecorder
+}
+
+// SearchUsers finds users whose email starts with the given prefix.
+func (as *adminSvc) SearchUsers(actor domain.Actor, emailPrefix string) ([]domain.AdminUser, error) {
+	emailPrefix = strings.TrimSpace(emailPrefix)
+	if emailPrefix == "" {
+		return nil, httputil.ErrBadRequest()
+	}
+
+	err := as.authorize(actor)
+	if err != nil {
+		return nil, err
+	}
+
+	users, err := as.userRepo.SearchByEmail(emailPrefix, maxAdminSearchResults)
+	if err != nil {
+		return nil, err
+	}
+
+	at := now()
+	adminUsers := make([]domain.AdminUser, 0, len(users))
+	for _, u := range users {
+		adminUsers = append(adminUsers, domain.NewAdminUser(u, at))
+	}
+
+	return adminUsers, as.record(domain.AdminUserSearchEvent, actor, "")
+}
+
+// GetUser gets a user along with the users watchlists and active sessions.
+func (as *adminSvc) GetUser(actor domain.Actor, userID string) (domain.AdminUser, error) {
+	u, err := as.findUser(actor, userID)
+	if err != nil {
+		return domain.AdminUser{}, err
+	}
+
+	u.User.Watchlists, err = as.userRepo.FindWatchlists(userID)
+	if err != nil {
+		return domain.AdminUser{}, err
+	}
+
+	sessions, err := as.sessionRepo.FindByUserID(userID)
+	if err != nil {
+		return domain.AdminUser{}, err
+	}
+
+	adminUser := domain.NewAdminUser(u, now())
+	adminUser.Sessions = make([]domain.SessionInfo, 0, len(sessions))
+	for _, s := range sessions {
+		adminUser.Sessions = append(adminUser.Sessions, s.Info())
+	}
+
+	return adminUser, as.record(domain.AdminUserViewEvent, actor, userID)
+}
+
+// Lock locks the account of a user until it is unlocked and ends all of the users sessions.
+// Admins can not lock their own account, since no admin may be left to unlock it.
+func (as *adminSvc) Lock(actor domain.Actor, userID string) error {
+	if actor.UserID == userID {
+		return errCannotLockOwnAccount()
+	}
+
+	_, err := as.findUser(actor, userID)
+	if err != nil {
+		return err
+	}
+
+	err = as.userRepo.SetLocked(userID, true)
+	if err != nil {
+		return err
+	}
+
+	err = invalidateSessions(as.userRepo, as.sessionRepo, userID)
+	if err != nil {
+		return err
+	}
+
+	return as.record(domain.AdminUserLockEvent, actor, userID)
+}
+
+// Unlock unlocks the account of a user and clears any temporary lockout caused by failed logins.
+// Deleted accounts can not be unlocked, users restore them by logging in before they are purged.
+func (as *adminSvc) Unlock(actor domain.Actor, userID string) error {
+	u, err := as.findUser(actor, userID)
+	if err != nil {
+		return err
+	}
+
+	if u.IsDeleted() {
+		return errAccountDeleted()
+	}
+
+	err = as.userRepo.SetLocked(userID, false)
+	if err != nil {
+		return err
+	}
+
+	err = as.userRepo.ResetLoginFailures(userID)
+	if err != nil {
+		return err
+	}
+
+	return as.record(domain.AdminUserUnlockEvent, actor, userID)
+}
+
+// Logout ends all sessions of a user.
+func (as *adminSvc) Logout(actor domain.Actor, userID string) error {
+	_, err := as.findUser(actor, userID)
+	if err != nil {
+		return err
+	}
+
+	err = invalidateSessions(as.userRepo, as.sessionRepo, userID)
+	if err != nil {
+		return err
+	}
+
+	return as.record(domain.AdminUserLogoutEvent, actor, userID)
+}
+
+// ResetPassword mails a password reset key to a user, the users current password keeps working until it is reset.
+func (as *adminSvc) ResetPassword(actor domain.Actor, userID string) error {
+	u, err := as.findUser(actor, userID)
+	if err != nil {
+		return err
+	}
+
+	if u.User.Email == "" {
+		return errNoEmailAddress()
+	}
+
+	err = as.passwordResetSvc.RequestReset(u.User.Email)
+	if err != nil {
+		return err
+	}
+
+	return as.record(domain.AdminPasswordResetEvent, actor, userID)
+}
+
+// ExportEvents writes all security events of a user to w as JSON lines, oldest first.
+// The export is recorded before it starts, since it may be cut short once events have been written.
+func (as *adminSvc) ExportEvents(actor domain.Actor, userID string, w io.Writer) error {
+	err := as.authorize(actor)
+	if err != nil {
+		return err
+	}
+
+	err = as.record(domain.AdminEventExportEvent, actor, userID)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	return as.eventRepo.FindAllByUserID(userID, func(e domain.SecurityEvent) error {
+		return enc.Encode(e.Info())
+	})
+}
+
+// CreateServiceAccount creates a named service account, which can only be used through API keys created by admins.
+func (as *adminSvc) CreateServiceAccount(
+	actor domain.Actor, req domain.ServiceAccountRequest) (domain.ServiceAccount, error) {
+	err := as.authorize(actor)
+	if err != nil {
+		return domain.ServiceAccount{}, err
+	}
+
+	account := domain.NewServiceAccount(req.Name, actor.UserID)
+	err = as.serviceAccountRepo.Save(account)
+	if err != nil {
+		return domain.ServiceAccount{}, err
+	}
+
+	return account, as.record(domain.AdminServiceAccountCreateEvent, actor, account.ID)
+}
+
+// ListServiceAccounts lists all service accounts.
+func (as *adminSvc) ListServiceAccounts(actor domain.Actor) ([]domain.ServiceAccount, error) {
+	err := as.authorize(actor)
+	if err != nil {
+		return nil, err
+	}
+
+	return as.serviceAccountRepo.FindAll()
+}
+
+// DeleteServiceAccount deletes a service account and revokes its API keys.
+// The account is purged along with its watchlists once the deletion grace period has passed.
+func (as *adminSvc) DeleteServiceAccount(actor domain.Actor, accountID string) error {
+	err := as.findServiceAccount(actor, accountID)
+	if err != nil {
+		return err
+	}
+
+	err = as.userRepo.Delete(accountID)
+	if err != nil {
+		return err
+	}
+
+	return as.record(domain.AdminServiceAccountDeleteEvent, actor, accountID)
+}
+
+// CreateServiceAccountKey creates an API key for a service account.
+func (as *adminSvc) CreateServiceAccountKey(
+	actor domain.Actor, accountID string, req domain.APIKeyRequest) (domain.CreatedAPIKey, error) {
+	err := as.findServiceAccount(actor, accountID)
+	if err != nil {
+		return domain.CreatedAPIKey{}, err
+	}
+
+	key, err := as.apiKeySvc.Create(accountID, req)
+	if err != nil {
+		return domain.CreatedAPIKey{}, err
+	}
+
+	return key, as.record(domain.AdminAPIKeyCreateEvent, actor, accountID)
+}
+
+// ListServiceAccountKeys lists the API keys of a service account which have not been revoked or expired.
+func (as *adminSvc) ListServiceAccountKeys(actor domain.Actor, accountID string) ([]domain.APIKeyInfo, error) {
+	err := as.findServiceAccount(actor, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	return as.apiKeySvc.List(accountID)
+}
+
+// RevokeServiceAccountKey revokes an API key belonging to a service account.
+func (as *adminSvc) RevokeServiceAccountKey(actor domain.Actor, accountID, keyID string) error {
+	err := as.findServiceAccount(actor, accountID)
+	if err != nil {
+		return err
+	}
+
+	err = as.apiKeySvc.Revoke(accountID, keyID)
+	if err != nil {
+		return err
+	}
+
+	return as.record(domain.AdminAPIKeyRevokeEvent, actor, accountID)
+}
+
+// findServiceAccount checks that the actor is an admin and that the account exists, so that admins
+// can only manage the API keys of service accounts and not those of users.
+func (as *adminSvc) findServiceAccount(actor domain.Actor, accountID string) error {
+	err := as.authorize(actor)
+	if err != nil {
+		return err
+	}
+
+	_, err = as.serviceAccountRepo.Find(accountID)
+	if err == repository.ErrNoSuchServiceAccount {
+		return httputil.ErrNotFound()
+	}
+
+	return err
+}
+
+// findUser checks that the actor is an admin and finds the user that the action is taken on.
+func (as *adminSvc) findUser(actor domain.Actor, userID string) (domain.FullUser, error) {
+	err := as.authorize(actor)
+	if err != nil {
+		return domain.FullUser{}, err
+	}
+
+	u, err := as.userRepo.Find(userID)
+	if err == repository.ErrNoSuchUser {
+		return domain.FullUser{}, httputil.ErrNotFound()
+	}
+
+	return u, err
+}
+
+// authorize checks that the actor is still a stored admin, since the role
+// in the actors token may have been issued before the user stopped being an admin.
+func (as *adminSvc) authorize(actor domain.Actor) error {
+	admin, err := as.userRepo.Find(actor.UserID)
+	if err == repository.ErrNoSuchUser {
+		return httputil.ErrForbidden()
+	} else if err != nil {
+		return err
+	}
+
+	if admin.User.Role != domain.AdminRole || admin.User.Email == "" || admin.IsDeleted() || admin.IsLocked(now()) {
+		return httputil.ErrForbidden()
+	}
+
+	return nil
+}
+
+func (as *adminSvc) record(eventType string, actor domain.Actor, userID string) error {
+	return as.events.Record(domain.NewAdminEvent(eventType, actor, userID))
+}
+
+func errCannotLockOwnAccount() error {
+	return httputil.NewError("Admins can not lock their own account", http.StatusBadRequest)
+}
+
+func errAccountDeleted() error {
+	return httputil.NewError("Account has been deleted", http.StatusConflict)
+}
+
+func errNoEmailAddress() error {
+	return httputil.NewError("User has no email address", http.StatusConflict)
+}
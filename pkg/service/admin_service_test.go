@@ -0,0 +1,286 @@
+package service_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/directory/pkg/repository"
+	"github.com/mimir-news/directory/pkg/service"
+	"github.com/mimir-news/pkg/httputil/auth"
+	"github.com/mimir-news/pkg/schema/user"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminGetAndSearchUsers(t *testing.T) {
+	assert := assert.New(t)
+
+	admin, target := getTestAdminUsers()
+	target.LoginFailures.LockedUntil = time.Now().UTC().Add(time.Hour)
+	userRepo := newAdminTestUserRepo(admin, target)
+	userRepo.FindWatchlistsRes = []user.Watchlist{{ID: "list-1", Name: "My list"}}
+	userRepo.SearchByEmailRes = []domain.FullUser{target}
+	sessionRepo := &repository.MockSessionRepo{
+		FindByUserIDSessions: []domain.Session{
+			domain.NewSession(target.User.ID, domain.ClientInfo{UserAgent: "test-agent", IP: "10.0.0.1"}),
+		},
+	}
+	events := &service.MockEventRecorder{}
+	adminSvc := service.NewAdminService(nil, nil, userRepo, sessionRepo, nil, nil, events)
+	actor := domain.Actor{UserID: admin.User.ID, Client: domain.ClientInfo{IP: "10.0.0.2"}}
+
+	u, err := adminSvc.GetUser(actor, target.User.ID)
+	assert.NoError(err)
+	assert.Equal(target.User.ID, u.User.ID)
+	assert.Len(u.User.Watchlists, 1)
+	assert.Len(u.Sessions, 1)
+	assert.Equal("10.0.0.1", u.Sessions[0].ClientIP)
+	assert.NotNil(u.LockedUntil)
+	assert.Equal(target.User.ID, sessionRepo.FindByUserIDArg)
+	assert.Len(events.Events, 1)
+	assert.Equal(domain.AdminUserViewEvent, events.Events[0].Type)
+	assert.Equal(admin.User.ID, events.Events[0].ActorID)
+	assert.Equal(target.User.ID, events.Events[0].UserID)
+	assert.Equal("10.0.0.2", events.Events[0].Client.IP)
+
+	_, err = adminSvc.GetUser(actor, "missing-user")
+	assertHTTPStatus(assert, http.StatusNotFound, err)
+	assert.Len(events.Events, 1)
+
+	users, err := adminSvc.SearchUsers(actor, " Target@ ")
+	assert.NoError(err)
+	assert.Len(users, 1)
+	assert.Equal("Target@", userRepo.SearchByEmailArgPrefix)
+	assert.True(userRepo.SearchByEmailArgLimit > 0)
+	assert.Empty(users[0].Sessions)
+	assert.Len(events.Events, 2)
+	assert.Equal(domain.AdminUserSearchEvent, events.Events[1].Type)
+	assert.Equal("", events.Events[1].UserID)
+
+	_, err = adminSvc.SearchUsers(actor, "")
+	assertHTTPStatus(assert, http.StatusBadRequest, err)
+	assert.Equal(1, userRepo.SearchByEmailInvocation)
+
+	_, err = adminSvc.GetUser(domain.Actor{UserID: target.User.ID}, target.User.ID)
+	assertHTTPStatus(assert, http.StatusForbidden, err)
+	assert.Len(events.Events, 2)
+}
+
+func TestAdminLockAndUnlock(t *testing.T) {
+	assert := assert.New(t)
+
+	admin, target := getTestAdminUsers()
+	userRepo := newAdminTestUserRepo(admin, target)
+	sessionRepo := &repository.MockSessionRepo{}
+	events := &service.MockEventRecorder{}
+	adminSvc := service.NewAdminService(nil, nil, userRepo, sessionRepo, nil, nil, events)
+	actor := domain.Actor{UserID: admin.User.ID}
+
+	err := adminSvc.Lock(actor, target.User.ID)
+	assert.NoError(err)
+	assert.Equal(target.User.ID, userRepo.SetLockedArgUserID)
+	assert.True(userRepo.SetLockedArgLocked)
+	assert.Equal(target.User.ID, userRepo.InvalidateSessionsArgUserID)
+	assert.Equal(target.User.ID, sessionRepo.DeleteByUserIDArg)
+	assert.Len(events.Events, 1)
+	assert.Equal(domain.AdminUserLockEvent, events.Events[0].Type)
+
+	err = adminSvc.Lock(actor, admin.User.ID)
+	assertHTTPStatus(assert, http.StatusBadRequest, err)
+	assert.Equal(1, userRepo.SetLockedInvocation)
+
+	err = adminSvc.Unlock(actor, target.User.ID)
+	assert.NoError(err)
+	assert.False(userRepo.SetLockedArgLocked)
+	assert.Equal(target.User.ID, userRepo.ResetLoginFailuresArg)
+	assert.Len(events.Events, 2)
+	assert.Equal(domain.AdminUserUnlockEvent, events.Events[1].Type)
+
+	deleted := target
+	deleted.DeletedAt = time.Now().UTC()
+	userRepo.users[target.User.ID] = deleted
+	err = adminSvc.Unlock(actor, target.User.ID)
+	assertHTTPStatus(assert, http.StatusConflict, err)
+	assert.Equal(2, userRepo.SetLockedInvocation)
+
+	lockedAdmin := admin
+	lockedAdmin.Locked = true
+	userRepo.users[admin.User.ID] = lockedAdmin
+	err = adminSvc.Lock(actor, target.User.ID)
+	assertHTTPStatus(assert, http.StatusForbidden, err)
+	assert.Equal(2, userRepo.SetLockedInvocation)
+	assert.Len(events.Events, 2)
+}
+
+func TestAdminLogoutAndResetPassword(t *testing.T) {
+	assert := assert.New(t)
+
+	admin, target := getTestAdminUsers()
+	userRepo := newAdminTestUserRepo(admin, target)
+	userRepo.FindByEmailUser = target
+	sessionRepo := &repository.MockSessionRepo{}
+	mailer := &service.MockMailer{}
+	credentialRepo := &repository.MockOneTimeCredentialRepo{}
+	passwordResetSvc := service.NewPasswordResetService(nil, mailer, userRepo, sessionRepo, credentialRepo, nil)
+	events := &service.MockEventRecorder{}
+	adminSvc := service.NewAdminService(passwordResetSvc, nil, userRepo, sessionRepo, nil, nil, events)
+	actor := domain.Actor{UserID: admin.User.ID}
+
+	err := adminSvc.Logout(actor, target.User.ID)
+	assert.NoError(err)
+	assert.Equal(target.User.ID, userRepo.InvalidateSessionsArgUserID)
+	assert.Equal(target.User.ID, sessionRepo.DeleteByUserIDArg)
+	assert.Equal(0, userRepo.SetLockedInvocation)
+	assert.Len(events.Events, 1)
+	assert.Equal(domain.AdminUserLogoutEvent, events.Events[0].Type)
+
+	err = adminSvc.ResetPassword(actor, target.User.ID)
+	assert.NoError(err)
+	assert.Equal(target.User.Email, userRepo.FindByEmailArg)
+	assert.Equal(domain.PasswordResetCredential, credentialRepo.SaveArg.Purpose)
+	assert.Len(mailer.Outbox, 1)
+	assert.Equal(target.User.Email, mailer.Outbox[0].To)
+	assert.Len(events.Events, 2)
+	assert.Equal(domain.AdminPasswordResetEvent, events.Events[1].Type)
+
+	// Mail failures are only logged by the password reset service.
+	mailer.SendErr = testError
+	err = adminSvc.ResetPassword(actor, target.User.ID)
+	assert.NoError(err)
+	assert.Len(events.Events, 3)
+
+	guest := domain.NewGuest(nil)
+	userRepo.users[guest.User.ID] = guest
+	err = adminSvc.ResetPassword(actor, guest.User.ID)
+	assertHTTPStatus(assert, http.StatusConflict, err)
+	assert.Len(events.Events, 3)
+}
+
+// adminTestUserRepo user repo which finds users by id, so that admins and the users they act on can differ.
+func TestAdminServiceAccounts(t *testing.T) {
+	assert := assert.New(t)
+
+	admin, target := getTestAdminUsers()
+	userRepo := newAdminTestUserRepo(admin, target)
+	accountRepo := &repository.MockServiceAccountRepo{}
+	keyRepo := &repository.MockAPIKeyRepo{}
+	verificationSvc := service.NewEmailVerificationService(
+		service.BlockUnverified, &service.MockMailer{}, userRepo, &repository.MockOneTimeCredentialRepo{})
+	apiKeySvc := service.NewAPIKeyService(verificationSvc, userRepo, keyRepo)
+	events := &service.MockEventRecorder{}
+	adminSvc := service.NewAdminService(nil, apiKeySvc, userRepo, nil, accountRepo, nil, events)
+	actor := domain.Actor{UserID: admin.User.ID}
+
+	account, err := adminSvc.CreateServiceAccount(actor, domain.ServiceAccountRequest{Name: " importer "})
+	assert.NoError(err)
+	assert.Equal("importer", account.Name)
+	assert.Equal(admin.User.ID, account.CreatedBy)
+	assert.Equal(account, accountRepo.SaveArg)
+	assert.Len(events.Events, 1)
+	assert.Equal(domain.AdminServiceAccountCreateEvent, events.Events[0].Type)
+	assert.Equal(account.ID, events.Events[0].UserID)
+
+	accountRepo.FindAllAccounts = []domain.ServiceAccount{account}
+	accounts, err := adminSvc.ListServiceAccounts(actor)
+	assert.NoError(err)
+	assert.Equal([]domain.ServiceAccount{account}, accounts)
+
+	// API keys of service accounts can be used without an email address.
+	userRepo.users[account.ID] = domain.FullUser{
+		User: user.User{ID: account.ID, Role: domain.ServiceAccountRole, CreatedAt: account.CreatedAt},
+	}
+	accountRepo.FindAccount = account
+	req := domain.APIKeyRequest{Name: "importer", Scopes: []string{domain.WatchlistsReadScope}, ValidForDays: 30}
+	created, err := adminSvc.CreateServiceAccountKey(actor, account.ID, req)
+	assert.NoError(err)
+	assert.Equal(account.ID, accountRepo.FindArg)
+	assert.Equal(account.ID, keyRepo.SaveArg.UserID)
+	assert.Len(events.Events, 2)
+	assert.Equal(domain.AdminAPIKeyCreateEvent, events.Events[1].Type)
+	assert.Equal(account.ID, events.Events[1].UserID)
+
+	keyRepo.FindByPrefixKey = keyRepo.SaveArg
+	authKey, err := apiKeySvc.Authenticate(created.Key)
+	assert.NoError(err)
+	assert.Equal(account.ID, authKey.UserID)
+	assert.Equal(domain.ServiceAccountRole, authKey.Role)
+
+	keyRepo.FindByUserIDKeys = []domain.APIKey{keyRepo.SaveArg}
+	keys, err := adminSvc.ListServiceAccountKeys(actor, account.ID)
+	assert.NoError(err)
+	assert.Len(keys, 1)
+	assert.Equal(account.ID, keyRepo.FindByUserIDArg)
+
+	err = adminSvc.RevokeServiceAccountKey(actor, account.ID, created.Info.ID)
+	assert.NoError(err)
+	assert.Equal(account.ID, keyRepo.RevokeArgUserID)
+	assert.Equal(created.Info.ID, keyRepo.RevokeArgKeyID)
+	assert.Len(events.Events, 3)
+	assert.Equal(domain.AdminAPIKeyRevokeEvent, events.Events[2].Type)
+
+	err = adminSvc.DeleteServiceAccount(actor, account.ID)
+	assert.NoError(err)
+	assert.Equal(account.ID, userRepo.DeleteArg)
+	assert.Len(events.Events, 4)
+	assert.Equal(domain.AdminServiceAccountDeleteEvent, events.Events[3].Type)
+	assert.Equal(account.ID, events.Events[3].UserID)
+
+	// Admins can not manage the API keys of users.
+	keyRepo.UnsetArgs()
+	accountRepo.FindErr = repository.ErrNoSuchServiceAccount
+	_, err = adminSvc.CreateServiceAccountKey(actor, target.User.ID, req)
+	assertHTTPStatus(assert, http.StatusNotFound, err)
+	err = adminSvc.RevokeServiceAccountKey(actor, target.User.ID, "key-id")
+	assertHTTPStatus(assert, http.StatusNotFound, err)
+	userRepo.DeleteArg = ""
+	err = adminSvc.DeleteServiceAccount(actor, target.User.ID)
+	assertHTTPStatus(assert, http.StatusNotFound, err)
+	assert.Equal(0, keyRepo.SaveInvocation)
+	assert.Equal(0, keyRepo.RevokeInvocation)
+	assert.Equal("", userRepo.DeleteArg)
+	assert.Len(events.Events, 4)
+
+	// Only admins can manage service accounts.
+	accountRepo.UnsetArgs()
+	_, err = adminSvc.CreateServiceAccount(domain.Actor{UserID: target.User.ID}, domain.ServiceAccountRequest{Name: "other"})
+	assertHTTPStatus(assert, http.StatusForbidden, err)
+	_, err = adminSvc.ListServiceAccounts(domain.Actor{UserID: target.User.ID})
+	assertHTTPStatus(assert, http.StatusForbidden, err)
+	assert.Equal(0, accountRepo.SaveInvocation)
+	assert.Equal(0, accountRepo.FindAllInvocation)
+}
+
+type adminTestUserRepo struct {
+	*repository.MockUserRepo
+	users map[string]domain.FullUser
+}
+
+func newAdminTestUserRepo(users ...domain.FullUser) *adminTestUserRepo {
+	r := &adminTestUserRepo{
+		MockUserRepo: &repository.MockUserRepo{},
+		users:        make(map[string]domain.FullUser),
+	}
+	for _, u := range users {
+		r.users[u.User.ID] = u
+	}
+
+	return r
+}
+
+func (r *adminTestUserRepo) Find(userID string) (domain.FullUser, error) {
+	u, ok := r.users[userID]
+	if !ok {
+		return domain.FullUser{}, repository.ErrNoSuchUser
+	}
+
+	return u, nil
+}
+
+func getTestAdminUsers() (domain.FullUser, domain.FullUser) {
+	admin := domain.NewUser(domain.StoredCredentials{Email: "admin@mail.com"}, nil)
+	admin.User.Role = domain.AdminRole
+	target := domain.NewUser(domain.StoredCredentials{Email: "target@mail.com"}, nil)
+	target.User.Role = auth.UserRole
+	return admin, target
+}
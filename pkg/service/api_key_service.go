@@ -0,0 +1,185 @@
+package service
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/directory/pkg/repository"
+	"github.com/mimir-news/pkg/httputil"
+)
+
+// APIKeyPrefix prefix of all API keys, which makes them easy to recognize in configuration and logs.
+const APIKeyPrefix = "mdk"
+
+const (
+	apiKeyPrefixLength       = 12
+	apiKeySeparator          = "_"
+	maxAPIKeysPerUser        = 25
+	apiKeyLastUsedResolution = time.Minute
+)
+
+// APIKeyService service responsible for handling API keys.
+type APIKeyService interface {
+	Create(userID string, req domain.APIKeyRequest) (domain.CreatedAPIKey, error)
+	List(userID string) ([]domain.APIKeyInfo, error)
+	Revoke(userID, keyID string) error
+	Authenticate(key string) (domain.AuthenticatedKey, error)
+}
+
+// NewAPIKeyService creates a new APIKeyService using the default implementation.
+func NewAPIKeyService(
+	verificationSvc EmailVerificationService, userRepo repository.UserRepo, keyRepo repository.APIKeyRepo) APIKeyService {
+	return &apiKeySvc{
+		verificationSvc: verificationSvc,
+		userRepo:        userRepo,
+		keyRepo:         keyRepo,
+	}
+}
+
+type apiKeySvc struct {
+	verificationSvc EmailVerificationService
+	userRepo        repository.UserRepo
+	keyRepo         repository.APIKeyRepo
+}
+
+// Create creates an API key for a user or service account. The plaintext key is only returned here, only its hash is stored.
+func (ks *apiKeySvc) Create(userID string, req domain.APIKeyRequest) (domain.CreatedAPIKey, error) {
+	u, err := ks.userRepo.Find(userID)
+	if err == repository.ErrNoSuchUser {
+		return domain.CreatedAPIKey{}, httputil.ErrNotFound()
+	} else if err != nil {
+		return domain.CreatedAPIKey{}, err
+	}
+
+	if !canHaveAPIKeys(u) {
+		return domain.CreatedAPIKey{}, httputil.ErrForbidden()
+	}
+
+	keys, err := ks.keyRepo.FindByUserID(userID)
+	if err != nil {
+		return domain.CreatedAPIKey{}, err
+	}
+
+	if len(keys) >= maxAPIKeysPerUser {
+		return domain.CreatedAPIKey{}, errTooManyAPIKeys()
+	}
+
+	prefix, err := generateKey()
+	if err != nil {
+		return domain.CreatedAPIKey{}, err
+	}
+	prefix = prefix[:apiKeyPrefixLength]
+
+	secret, err := generateKey()
+	if err != nil {
+		return domain.CreatedAPIKey{}, err
+	}
+
+	key := domain.NewAPIKey(userID, req.Name, prefix, hashKey(secret, prefix), req.Scopes, req.Validity())
+	err = ks.keyRepo.Save(key)
+	if err != nil {
+		return domain.CreatedAPIKey{}, err
+	}
+
+	return domain.CreatedAPIKey{
+		Key:  strings.Join([]string{APIKeyPrefix, prefix, secret}, apiKeySeparator),
+		Info: key.Info(),
+	}, nil
+}
+
+// List lists the API keys of a user which have not been revoked or expired.
+func (ks *apiKeySvc) List(userID string) ([]domain.APIKeyInfo, error) {
+	keys, err := ks.keyRepo.FindByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]domain.APIKeyInfo, 0, len(keys))
+	for _, k := range keys {
+		infos = append(infos, k.Info())
+	}
+
+	return infos, nil
+}
+
+// Revoke revokes an API key belonging to a user.
+func (ks *apiKeySvc) Revoke(userID, keyID string) error {
+	err := ks.keyRepo.Revoke(userID, keyID)
+	if err == repository.ErrNoSuchAPIKey {
+		return httputil.ErrNotFound()
+	}
+
+	return err
+}
+
+// Authenticate checks an API key and returns its owner and scopes.
+// The time the key was used is recorded, at most once per minute to avoid a write on every request.
+func (ks *apiKeySvc) Authenticate(rawKey string) (domain.AuthenticatedKey, error) {
+	parts := strings.Split(rawKey, apiKeySeparator)
+	if len(parts) != 3 || parts[0] != APIKeyPrefix || len(parts[1]) != apiKeyPrefixLength {
+		return domain.AuthenticatedKey{}, httputil.ErrUnauthorized()
+	}
+	prefix, secret := parts[1], parts[2]
+
+	key, err := ks.keyRepo.FindByPrefix(prefix)
+	if err == repository.ErrNoSuchAPIKey {
+		return domain.AuthenticatedKey{}, httputil.ErrUnauthorized()
+	} else if err != nil {
+		return domain.AuthenticatedKey{}, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashKey(secret, prefix)), []byte(key.KeyHash)) != 1 {
+		return domain.AuthenticatedKey{}, httputil.ErrUnauthorized()
+	}
+
+	usedAt := now()
+	if !key.Valid(usedAt) {
+		return domain.AuthenticatedKey{}, httputil.ErrUnauthorized()
+	}
+
+	u, err := ks.userRepo.Find(key.UserID)
+	if err == repository.ErrNoSuchUser {
+		return domain.AuthenticatedKey{}, httputil.ErrUnauthorized()
+	} else if err != nil {
+		return domain.AuthenticatedKey{}, err
+	}
+
+	if !canHaveAPIKeys(u) {
+		return domain.AuthenticatedKey{}, httputil.ErrUnauthorized()
+	}
+
+	if u.IsLocked(usedAt) {
+		return domain.AuthenticatedKey{}, errAccountLocked()
+	}
+
+	role, err := ks.verificationSvc.AuthorizedRole(u)
+	if err != nil {
+		return domain.AuthenticatedKey{}, err
+	}
+
+	if usedAt.Sub(key.LastUsedAt) >= apiKeyLastUsedResolution {
+		err = ks.keyRepo.UpdateLastUsed(key.ID, usedAt)
+		if err != nil {
+			return domain.AuthenticatedKey{}, err
+		}
+	}
+
+	return domain.AuthenticatedKey{
+		UserID: u.User.ID,
+		Role:   role,
+		Scopes: key.Scopes,
+	}, nil
+}
+
+// canHaveAPIKeys checks if a user may own API keys, which guests and users whose email has been removed may not.
+// Service accounts have no email since they are only used through API keys.
+func canHaveAPIKeys(u domain.FullUser) bool {
+	return (u.User.Email != "" || u.IsServiceAccount()) && !u.IsGuest() && !u.IsDeleted()
+}
+
+func errTooManyAPIKeys() error {
+	return httputil.NewError("Too many API keys", http.StatusConflict)
+}
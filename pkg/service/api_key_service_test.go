@@ -0,0 +1,150 @@
+package service_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/directory/pkg/repository"
+	"github.com/mimir-news/directory/pkg/service"
+	"github.com/mimir-news/pkg/httputil/auth"
+	"github.com/mimir-news/pkg/id"
+	"github.com/mimir-news/pkg/schema/user"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateAndAuthenticateAPIKey(t *testing.T) {
+	assert := assert.New(t)
+
+	userID := id.New()
+	storedUser := domain.FullUser{
+		User:          user.User{ID: userID, Email: "mail@mail.com", Role: auth.UserRole},
+		EmailVerified: true,
+	}
+	userRepo := &repository.MockUserRepo{FindUser: storedUser}
+	keyRepo := &repository.MockAPIKeyRepo{}
+	verificationSvc := service.NewEmailVerificationService(
+		service.BlockUnverified, &service.MockMailer{}, userRepo, &repository.MockOneTimeCredentialRepo{})
+	apiKeySvc := service.NewAPIKeyService(verificationSvc, userRepo, keyRepo)
+
+	req := domain.APIKeyRequest{
+		Name:         "import script",
+		Scopes:       []string{domain.WatchlistsReadScope},
+		ValidForDays: 30,
+	}
+	created, err := apiKeySvc.Create(userID, req)
+	assert.NoError(err)
+	assert.True(strings.HasPrefix(created.Key, service.APIKeyPrefix+"_"+created.Info.Prefix+"_"))
+	assert.Equal(1, keyRepo.SaveInvocation)
+	storedKey := keyRepo.SaveArg
+	assert.Equal(userID, storedKey.UserID)
+	assert.Equal(created.Info.Prefix, storedKey.Prefix)
+	assert.Equal(req.Scopes, storedKey.Scopes)
+	assert.NotEmpty(storedKey.KeyHash)
+	assert.False(strings.Contains(created.Key, storedKey.KeyHash))
+	assert.True(storedKey.ExpiresAt.After(time.Now().Add(29 * 24 * time.Hour)))
+
+	keyRepo.FindByPrefixKey = storedKey
+	authKey, err := apiKeySvc.Authenticate(created.Key)
+	assert.NoError(err)
+	assert.Equal(userID, authKey.UserID)
+	assert.Equal(auth.UserRole, authKey.Role)
+	assert.Equal(req.Scopes, authKey.Scopes)
+	assert.Equal(storedKey.Prefix, keyRepo.FindByPrefixArg)
+	assert.Equal(1, keyRepo.UpdateLastUsedInvocation)
+	assert.Equal(storedKey.ID, keyRepo.UpdateLastUsedArgKeyID)
+
+	// The last used time is not updated on every request.
+	keyRepo.FindByPrefixKey.LastUsedAt = keyRepo.UpdateLastUsedArgAt
+	_, err = apiKeySvc.Authenticate(created.Key)
+	assert.NoError(err)
+	assert.Equal(1, keyRepo.UpdateLastUsedInvocation)
+
+	_, err = apiKeySvc.Authenticate(created.Key + "0")
+	assertHTTPStatus(assert, http.StatusUnauthorized, err)
+
+	_, err = apiKeySvc.Authenticate("not-an-api-key")
+	assertHTTPStatus(assert, http.StatusUnauthorized, err)
+
+	keyRepo.FindByPrefixKey.RevokedAt = time.Now().UTC()
+	_, err = apiKeySvc.Authenticate(created.Key)
+	assertHTTPStatus(assert, http.StatusUnauthorized, err)
+
+	keyRepo.FindByPrefixKey.RevokedAt = time.Time{}
+	keyRepo.FindByPrefixKey.ExpiresAt = time.Now().UTC().Add(-time.Minute)
+	_, err = apiKeySvc.Authenticate(created.Key)
+	assertHTTPStatus(assert, http.StatusUnauthorized, err)
+
+	keyRepo.FindByPrefixKey.ExpiresAt = storedKey.ExpiresAt
+	userRepo.FindUser.Locked = true
+	_, err = apiKeySvc.Authenticate(created.Key)
+	assertHTTPStatus(assert, http.StatusLocked, err)
+
+	userRepo.FindUser.Locked = false
+	userRepo.FindUser.EmailVerified = false
+	_, err = apiKeySvc.Authenticate(created.Key)
+	assertHTTPStatus(assert, http.StatusForbidden, err)
+
+	keyRepo.FindByPrefixErr = repository.ErrNoSuchAPIKey
+	_, err = apiKeySvc.Authenticate(created.Key)
+	assertHTTPStatus(assert, http.StatusUnauthorized, err)
+}
+
+func TestCreateAPIKeyRestrictions(t *testing.T) {
+	assert := assert.New(t)
+
+	userID := id.New()
+	req := domain.APIKeyRequest{Name: "script", Scopes: []string{domain.UsersReadScope}, ValidForDays: 1}
+	userRepo := &repository.MockUserRepo{
+		FindUser: domain.NewGuest(nil),
+	}
+	keyRepo := &repository.MockAPIKeyRepo{}
+	verificationSvc := service.NewEmailVerificationService(
+		service.AllowUnverified, &service.MockMailer{}, userRepo, &repository.MockOneTimeCredentialRepo{})
+	apiKeySvc := service.NewAPIKeyService(verificationSvc, userRepo, keyRepo)
+
+	_, err := apiKeySvc.Create(userID, req)
+	assertHTTPStatus(assert, http.StatusForbidden, err)
+
+	userRepo.FindErr = repository.ErrNoSuchUser
+	_, err = apiKeySvc.Create(userID, req)
+	assertHTTPStatus(assert, http.StatusNotFound, err)
+
+	userRepo.FindErr = nil
+	userRepo.FindUser = domain.FullUser{User: user.User{ID: userID, Email: "mail@mail.com", Role: auth.UserRole}}
+	keyRepo.FindByUserIDKeys = make([]domain.APIKey, 25)
+	_, err = apiKeySvc.Create(userID, req)
+	assertHTTPStatus(assert, http.StatusConflict, err)
+	assert.Equal(0, keyRepo.SaveInvocation)
+
+	keyRepo.RevokeErr = repository.ErrNoSuchAPIKey
+	err = apiKeySvc.Revoke(userID, "key-id")
+	assertHTTPStatus(assert, http.StatusNotFound, err)
+	assert.Equal(userID, keyRepo.RevokeArgUserID)
+	assert.Equal("key-id", keyRepo.RevokeArgKeyID)
+}
+
+func TestAPIKeyRequestValid(t *testing.T) {
+	assert := assert.New(t)
+
+	valid := domain.APIKeyRequest{Name: "script", Scopes: []string{domain.WatchlistsWriteScope}, ValidForDays: 365}
+	assert.True(valid.Valid())
+
+	unknownScope := valid
+	unknownScope.Scopes = []string{"users:write"}
+	assert.False(unknownScope.Valid())
+
+	tooLong := valid
+	tooLong.ValidForDays = 366
+	assert.False(tooLong.Valid())
+
+	noExpiry := valid
+	noExpiry.ValidForDays = 0
+	assert.False(noExpiry.Valid())
+
+	unnamed := valid
+	unnamed.Name = ""
+	assert.False(unnamed.Valid())
+}
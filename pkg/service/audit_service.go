@@ -0,0 +1,54 @@
+package service
+
+import (
+	"time"
+
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/directory/pkg/repository"
+	"github.com/mimir-news/pkg/httputil"
+)
+
+const (
+	defaultEventPageSize = 50
+	maxEventPageSize     = 200
+)
+
+// AuditService service responsible for letting users read the security events of their accounts.
+type AuditService interface {
+	List(userID string, before time.Time, limit int) ([]domain.EventInfo, error)
+}
+
+// NewAuditService creates a new AuditService using the default implementation.
+func NewAuditService(eventRepo repository.AuditEventRepo) AuditService {
+	return &auditSvc{
+		eventRepo: eventRepo,
+	}
+}
+
+type auditSvc struct {
+	eventRepo repository.AuditEventRepo
+}
+
+// List lists the latest events of a user which occurred before the given time, newest first.
+// A zero before time lists the latest events and a zero limit uses the default page size.
+func (au *auditSvc) List(userID string, before time.Time, limit int) ([]domain.EventInfo, error) {
+	if limit == 0 {
+		limit = defaultEventPageSize
+	}
+
+	if limit < 0 || limit > maxEventPageSize {
+		return nil, httputil.ErrBadRequest()
+	}
+
+	events, err := au.eventRepo.FindByUserID(userID, before, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]domain.EventInfo, 0, len(events))
+	for _, e := range events {
+		infos = append(infos, e.Info())
+	}
+
+	return infos, nil
+}
@@ -0,0 +1,71 @@
+package service_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/directory/pkg/repository"
+	"github.com/mimir-news/directory/pkg/service"
+	"github.com/mimir-news/pkg/id"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListEvents(t *testing.T) {
+	assert := assert.New(t)
+
+	userID := id.New()
+	client := domain.ClientInfo{UserAgent: "test-agent/1.0", IP: "10.0.0.1"}
+	event := domain.NewAccountEvent(domain.LoginSuccessEvent, userID, id.New(), client)
+	eventRepo := &repository.MockAuditEventRepo{
+		FindByUserIDEvents: []domain.SecurityEvent{event},
+	}
+	auditSvc := service.NewAuditService(eventRepo)
+
+	events, err := auditSvc.List(userID, time.Time{}, 0)
+	assert.NoError(err)
+	assert.Len(events, 1)
+	assert.Equal(event.ID, events[0].ID)
+	assert.Equal(userID, events[0].UserID)
+	assert.Equal(userID, events[0].ActorID)
+	assert.Equal(event.SessionID, events[0].SessionID)
+	assert.Equal(client.IP, events[0].ClientIP)
+	assert.Equal(client.UserAgent, events[0].UserAgent)
+	assert.Equal(userID, eventRepo.FindByUserIDArgUserID)
+	assert.True(eventRepo.FindByUserIDArgLimit > 0)
+
+	before := time.Now().UTC().Add(-time.Hour)
+	_, err = auditSvc.List(userID, before, 10)
+	assert.NoError(err)
+	assert.Equal(before, eventRepo.FindByUserIDArgBefore)
+	assert.Equal(10, eventRepo.FindByUserIDArgLimit)
+
+	eventRepo.UnsetArgs()
+	_, err = auditSvc.List(userID, before, -1)
+	assertHTTPStatus(assert, http.StatusBadRequest, err)
+	_, err = auditSvc.List(userID, before, 100000)
+	assertHTTPStatus(assert, http.StatusBadRequest, err)
+	assert.Equal(0, eventRepo.FindByUserIDInvocation)
+
+	eventRepo.FindByUserIDErr = testError
+	_, err = auditSvc.List(userID, before, 10)
+	assert.Equal(testError, err)
+}
+
+func TestAuditEventRecorder(t *testing.T) {
+	assert := assert.New(t)
+
+	eventRepo := &repository.MockAuditEventRepo{}
+	recorder := service.NewAuditEventRecorder(eventRepo)
+
+	event := domain.NewSecurityEvent(domain.LoginFailureEvent, id.New(), "", domain.ClientInfo{IP: "10.0.0.1"})
+	err := recorder.Record(event)
+	assert.NoError(err)
+	assert.Equal(event, eventRepo.SaveArg)
+
+	eventRepo.SaveErr = testError
+	err = recorder.Record(event)
+	assert.Equal(testError, err)
+	assert.Equal(2, eventRepo.SaveInvocation)
+}
@@ -0,0 +1,48 @@
+package service
+
+import (
+	"strings"
+
+	"github.com/mimir-news/directory/pkg/domain"
+	"gopkg.in/square/go-jose.v2"
+)
+
+// JWKSPath path where the public token keys are published.
+const JWKSPath = "/.well-known/jwks.json"
+
+var tokenClaimsSupported = []string{"iss", "sub", "jti", "iat", "exp", "user"}
+
+// DiscoveryService service responsible for publishing the metadata and keys needed to verify tokens.
+type DiscoveryService interface {
+	OpenIDConfiguration() domain.OpenIDConfiguration
+	PublicKeys() jose.JSONWebKeySet
+}
+
+// NewDiscoveryService creates a new DiscoveryService using the default implementation.
+func NewDiscoveryService(keyring TokenKeyring, issuerURL string) DiscoveryService {
+	return &discoverySvc{
+		keyring:   keyring,
+		issuerURL: issuerURL,
+	}
+}
+
+type discoverySvc struct {
+	keyring   TokenKeyring
+	issuerURL string
+}
+
+// OpenIDConfiguration returns the discovery document of the directory.
+func (ds *discoverySvc) OpenIDConfiguration() domain.OpenIDConfiguration {
+	return domain.OpenIDConfiguration{
+		Issuer:                           ds.issuerURL,
+		JWKSURI:                          strings.TrimSuffix(ds.issuerURL, "/") + JWKSPath,
+		SubjectTypesSupported:            []string{"public"},
+		IDTokenSigningAlgValuesSupported: ds.keyring.Algorithms(),
+		ClaimsSupported:                  tokenClaimsSupported,
+	}
+}
+
+// PublicKeys returns the public keys which tokens can be verified with, including keys being rotated in or out.
+func (ds *discoverySvc) PublicKeys() jose.JSONWebKeySet {
+	return ds.keyring.PublicKeys()
+}
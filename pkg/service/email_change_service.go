@@ -0,0 +1,253 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/directory/pkg/repository"
+	"github.com/mimir-news/pkg/httputil"
+)
+
+const (
+	emailChangeTTL = 24 * time.Hour
+	emailRevertTTL = 7 * 24 * time.Hour
+)
+
+// EmailChangeService service responsible for changing the email addresses of users.
+type EmailChangeService interface {
+	RequestChange(userID string, change domain.EmailChange) error
+	Confirm(userID, key string, client domain.ClientInfo) error
+	Revert(userID, key string, client domain.ClientInfo) error
+}
+
+// NewEmailChangeService creates a new EmailChangeService using the default implementation.
+func NewEmailChangeService(
+	pwdSvc *PasswordService, mailer Mailer, userRepo repository.UserRepo,
+	sessionRepo repository.SessionRepo, credentialRepo repository.OneTimeCredentialRepo,
+	events SecurityEventRecorder) EmailChangeService {
+	return &emailChangeSvc{
+		passwordSvc:    pwdSvc,
+		mailer:         mailer,
+		userRepo:       userRepo,
+		sessionRepo:    sessionRepo,
+		credentialRepo: credentialRepo,
+		events:         events,
+	}
+}
+
+type emailChangeSvc struct {
+	passwordSvc    *PasswordService
+	mailer         Mailer
+	userRepo       repository.UserRepo
+	sessionRepo    repository.SessionRepo
+	credentialRepo repository.OneTimeCredentialRepo
+	events         SecurityEventRecorder
+}
+
+// RequestChange verifies the current password of a user and mails a confirmation key
+// to the new address. The email of the user is not changed until the key is confirmed.
+func (cs *emailChangeSvc) RequestChange(userID string, change domain.EmailChange) error {
+	u, err := cs.findUser(userID)
+	if err == repository.ErrNoSuchUser {
+		return httputil.ErrNotFound()
+	} else if err != nil {
+		return err
+	}
+
+	err = cs.passwordSvc.VerifyUser(u, change.Password)
+	if err == ErrAccountLocked {
+		return errAccountLocked()
+	} else if err == ErrInvalidCredentials {
+		return httputil.ErrForbidden()
+	} else if err != nil {
+		return err
+	}
+
+	if change.Email == u.User.Email {
+		return httputil.ErrBadRequest()
+	}
+
+	err = cs.ensureEmailAvailable(change.Email, userID)
+	if err != nil {
+		return err
+	}
+
+	key, credential, err := cs.issueCredential(domain.EmailChangeCredential, userID, change.Email, emailChangeTTL)
+	if err != nil {
+		return err
+	}
+
+	return cs.mailer.Send(newEmailChangeEmail(change.Email, key, credential.ValidTo))
+}
+
+// Confirm changes the email of a user to the address that the confirmation key was sent to,
+// invalidates all of the users sessions and mails a key that undoes the change to the old address.
+func (cs *emailChangeSvc) Confirm(userID, key string, client domain.ClientInfo) error {
+	credential, err := cs.findValidCredential(domain.EmailChangeCredential, userID, key)
+	if err != nil {
+		return err
+	}
+
+	u, err := cs.findUser(userID)
+	if err == repository.ErrNoSuchUser {
+		return errInvalidEmailChangeKey()
+	} else if err != nil {
+		return err
+	}
+
+	oldEmail := u.User.Email
+	err = cs.changeEmail(u, credential, domain.NewAccountEvent(domain.EmailChangeEvent, userID, "", client))
+	if err != nil {
+		return err
+	}
+
+	revertKey, revertCredential, err := cs.issueCredential(domain.EmailRevertCredential, userID, oldEmail, emailRevertTTL)
+	if err != nil {
+		return err
+	}
+
+	return cs.mailer.Send(newEmailRevertEmail(oldEmail, credential.Email, revertKey, revertCredential.ValidTo))
+}
+
+// Revert changes the email of a user back to the address that the revert key was sent to
+// and invalidates all of the users sessions.
+func (cs *emailChangeSvc) Revert(userID, key string, client domain.ClientInfo) error {
+	credential, err := cs.findValidCredential(domain.EmailRevertCredential, userID, key)
+	if err != nil {
+		return err
+	}
+
+	u, err := cs.findUser(userID)
+	if err == repository.ErrNoSuchUser {
+		return errInvalidEmailChangeKey()
+	} else if err != nil {
+		return err
+	}
+
+	return cs.changeEmail(u, credential, domain.NewAccountEvent(domain.EmailChangeRevertEvent, userID, "", client))
+}
+
+// changeEmail consumes an email change credential and switches the users email to the address it was issued for.
+// Receiving the key at the address proves ownership of it. The given event is recorded once the email is changed.
+func (cs *emailChangeSvc) changeEmail(u domain.FullUser, credential domain.OneTimeCredential, event domain.SecurityEvent) error {
+	err := cs.ensureEmailAvailable(credential.Email, u.User.ID)
+	if err != nil {
+		return err
+	}
+
+	err = cs.credentialRepo.MarkUsed(credential.ID)
+	if err == repository.ErrNoSuchCredential {
+		return errInvalidEmailChangeKey()
+	} else if err != nil {
+		return err
+	}
+
+	u.User.Email = credential.Email
+	u.Credentials.Email = credential.Email
+	u.EmailVerified = true
+	err = cs.userRepo.Save(u)
+	if err == repository.ErrEmailTaken {
+		return errEmailTaken()
+	} else if err != nil {
+		return err
+	}
+
+	err = invalidateSessions(cs.userRepo, cs.sessionRepo, u.User.ID)
+	if err != nil {
+		return err
+	}
+
+	return cs.events.Record(event)
+}
+
+// findUser finds a user which has not been deleted.
+func (cs *emailChangeSvc) findUser(userID string) (domain.FullUser, error) {
+	u, err := cs.userRepo.Find(userID)
+	if err != nil {
+		return domain.FullUser{}, err
+	}
+
+	if u.User.Email == "" {
+		return domain.FullUser{}, repository.ErrNoSuchUser
+	}
+
+	return u, nil
+}
+
+// ensureEmailAvailable checks that an email address is not used by any other user.
+func (cs *emailChangeSvc) ensureEmailAvailable(email, userID string) error {
+	owner, err := cs.userRepo.FindByEmail(email)
+	if err == repository.ErrNoSuchUser {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if owner.User.ID != userID {
+		return errEmailTaken()
+	}
+
+	return nil
+}
+
+func (cs *emailChangeSvc) issueCredential(purpose, userID, email string, ttl time.Duration) (string, domain.OneTimeCredential, error) {
+	key, err := generateKey()
+	if err != nil {
+		return "", domain.OneTimeCredential{}, err
+	}
+
+	credential := domain.NewOneTimeCredential(purpose, hashKey(key), userID, ttl)
+	credential.Email = email
+	err = cs.credentialRepo.Save(credential)
+	if err != nil {
+		return "", domain.OneTimeCredential{}, err
+	}
+
+	return key, credential, nil
+}
+
+func (cs *emailChangeSvc) findValidCredential(purpose, userID, key string) (domain.OneTimeCredential, error) {
+	credential, err := cs.credentialRepo.FindByKey(purpose, hashKey(key))
+	if err == repository.ErrNoSuchCredential {
+		return domain.OneTimeCredential{}, errInvalidEmailChangeKey()
+	} else if err != nil {
+		return domain.OneTimeCredential{}, err
+	}
+
+	if !credential.Valid() || credential.Purpose != purpose || credential.UserID != userID || credential.Email == "" {
+		return domain.OneTimeCredential{}, errInvalidEmailChangeKey()
+	}
+
+	return credential, nil
+}
+
+func newEmailChangeEmail(to, key string, validTo time.Time) domain.Email {
+	return domain.Email{
+		To:      to,
+		Subject: "Confirm your new email address",
+		Body: fmt.Sprintf(
+			"Use the following key to confirm your new email address: %s\n\nThe key is valid until %s.",
+			key, validTo.Format(time.RFC1123)),
+	}
+}
+
+func newEmailRevertEmail(to, newEmail, key string, validTo time.Time) domain.Email {
+	return domain.Email{
+		To:      to,
+		Subject: "Your email address has been changed",
+		Body: fmt.Sprintf(
+			"The email address of your account has been changed to %s.\n\n"+
+				"If you did not make this change, use the following key to undo it: %s\n\nThe key is valid until %s.",
+			newEmail, key, validTo.Format(time.RFC1123)),
+	}
+}
+
+func errEmailTaken() error {
+	return httputil.NewError("Email is already in use", http.StatusConflict)
+}
+
+func errInvalidEmailChangeKey() error {
+	return httputil.NewError("Invalid or expired email change key", http.StatusForbidden)
+}
@@ -0,0 +1,245 @@
+package service_test
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/directory/pkg/repository"
+	"github.com/mimir-news/directory/pkg/service"
+	"github.com/mimir-news/pkg/httputil/auth"
+	"github.com/mimir-news/pkg/id"
+	"github.com/mimir-news/pkg/schema/user"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestEmailChange(t *testing.T) {
+	assert := assert.New(t)
+
+	userID := id.New()
+	oldEmail := "mail@mail.com"
+	newEmail := "new.mail@mail.com"
+	storedUser := domain.FullUser{
+		User: user.User{
+			ID:    userID,
+			Email: oldEmail,
+		},
+		Credentials: domain.StoredCredentials{
+			Email:    oldEmail,
+			Password: "S5UeZOWCDkIfP/5LUDpyhIY0l6+aow+CmkBEVtHqpebhe04vb6kDbPaD/wo05fs6x1lvJfI/6YZ66zbQ8X2lHaEThp4f1Zl0exk7j/wow740KbWZHf9DSA==", // Hashed and encrypted password.
+			Salt:     "3MQEKd3NVnU+WQFQxo8JpYWrTrqXOiwro4MwLwnsckWXinE=",                                                                         // Encrypted salt
+		},
+		EmailVerified: true,
+	}
+
+	userRepo := &repository.MockUserRepo{
+		FindUser:       storedUser,
+		FindByEmailErr: repository.ErrNoSuchUser,
+	}
+	sessionRepo := &repository.MockSessionRepo{}
+	credentialRepo := &repository.MockOneTimeCredentialRepo{}
+	mailer := &service.MockMailer{}
+	passwordSvc := service.NewPasswordService(userRepo, "my-pepper", service.NewEncryptionKeyring("my-encryption-key"), service.DefaultHashingConfig, service.DefaultLockoutPolicy)
+	changeSvc := service.NewEmailChangeService(passwordSvc, mailer, userRepo, sessionRepo, credentialRepo, &service.MockEventRecorder{})
+
+	change := domain.EmailChange{
+		Email:    newEmail,
+		Password: "super-secret-password",
+	}
+
+	err := changeSvc.RequestChange(userID, change)
+	assert.NoError(err)
+	assert.Equal(userID, userRepo.FindArg)
+	assert.Equal(newEmail, userRepo.FindByEmailArg)
+	savedCredential := credentialRepo.SaveArg
+	assert.Equal(domain.EmailChangeCredential, savedCredential.Purpose)
+	assert.Equal(userID, savedCredential.UserID)
+	assert.Equal(newEmail, savedCredential.Email)
+	assert.True(savedCredential.ValidTo.After(time.Now().UTC()))
+	assert.NotEqual(newEmail, userRepo.SaveArg.User.Email)
+	assert.Equal(0, userRepo.InvalidateSessionsInvocation)
+
+	email, ok := mailer.LastSent()
+	assert.True(ok)
+	assert.Equal(newEmail, email.To)
+	assert.False(strings.Contains(email.Body, savedCredential.Key))
+
+	// Wrong passwords should be rejected.
+	credentialRepo.UnsetArgs()
+	mailer.Outbox = nil
+	err = changeSvc.RequestChange(userID, domain.EmailChange{Email: newEmail, Password: "wrong-password"})
+	assertHTTPStatus(assert, http.StatusForbidden, err)
+	assert.Equal(0, credentialRepo.SaveInvocation)
+	assert.Equal(0, len(mailer.Outbox))
+
+	// Emails used by other users should be rejected.
+	userRepo.FindByEmailErr = nil
+	userRepo.FindByEmailUser = domain.FullUser{
+		User: user.User{
+			ID:    id.New(),
+			Email: newEmail,
+		},
+	}
+	err = changeSvc.RequestChange(userID, change)
+	assertHTTPStatus(assert, http.StatusConflict, err)
+	assert.Equal(0, credentialRepo.SaveInvocation)
+	assert.Equal(0, len(mailer.Outbox))
+
+	// Changing to the current email should be rejected.
+	err = changeSvc.RequestChange(userID, domain.EmailChange{Email: oldEmail, Password: change.Password})
+	assertHTTPStatus(assert, http.StatusBadRequest, err)
+	assert.Equal(0, credentialRepo.SaveInvocation)
+
+	// Deleted users should not be found.
+	userRepo.FindUser = domain.FullUser{User: user.User{ID: userID}}
+	err = changeSvc.RequestChange(userID, change)
+	assertHTTPStatus(assert, http.StatusNotFound, err)
+	assert.Equal(0, credentialRepo.SaveInvocation)
+}
+
+func TestConfirmEmailChange(t *testing.T) {
+	assert := assert.New(t)
+
+	key := "my-change-key"
+	userID := id.New()
+	oldEmail := "mail@mail.com"
+	newEmail := "new.mail@mail.com"
+	storedUser := domain.FullUser{
+		User: user.User{
+			ID:    userID,
+			Email: oldEmail,
+		},
+		Credentials: domain.StoredCredentials{
+			Email:    oldEmail,
+			Password: "hashed-and-encrypted-password",
+			Salt:     "encrypted-salt",
+		},
+	}
+	credential := domain.NewOneTimeCredential(domain.EmailChangeCredential, fmt.Sprintf("%x", auth.HashKey(key)), userID, time.Hour)
+	credential.Email = newEmail
+
+	userRepo := &repository.MockUserRepo{
+		FindUser:       storedUser,
+		FindByEmailErr: repository.ErrNoSuchUser,
+	}
+	sessionRepo := &repository.MockSessionRepo{}
+	credentialRepo := &repository.MockOneTimeCredentialRepo{
+		FindByKeyCredential: credential,
+	}
+	mailer := &service.MockMailer{}
+	changeSvc := service.NewEmailChangeService(nil, mailer, userRepo, sessionRepo, credentialRepo, &service.MockEventRecorder{})
+
+	err := changeSvc.Confirm(userID, key, domain.ClientInfo{})
+	assert.NoError(err)
+	assert.Equal(domain.EmailChangeCredential, credentialRepo.FindByKeyArgPurpose)
+	assert.Equal(credential.Key, credentialRepo.FindByKeyArg)
+	assert.Equal(credential.ID, credentialRepo.MarkUsedArg)
+	savedUser := userRepo.SaveArg
+	assert.Equal(userID, savedUser.User.ID)
+	assert.Equal(newEmail, savedUser.User.Email)
+	assert.Equal(newEmail, savedUser.Credentials.Email)
+	assert.Equal(storedUser.Credentials.Password, savedUser.Credentials.Password)
+	assert.True(savedUser.EmailVerified)
+	assert.Equal(userID, userRepo.InvalidateSessionsArgUserID)
+	assert.Equal(userID, sessionRepo.DeleteByUserIDArg)
+
+	revertCredential := credentialRepo.SaveArg
+	assert.Equal(domain.EmailRevertCredential, revertCredential.Purpose)
+	assert.Equal(userID, revertCredential.UserID)
+	assert.Equal(oldEmail, revertCredential.Email)
+	email, ok := mailer.LastSent()
+	assert.True(ok)
+	assert.Equal(oldEmail, email.To)
+	assert.True(strings.Contains(email.Body, newEmail))
+
+	// Emails taken since the change was requested should be rejected.
+	credentialRepo.UnsetArgs()
+	userRepo.SaveArg = domain.FullUser{}
+	userRepo.FindByEmailErr = nil
+	userRepo.FindByEmailUser = domain.FullUser{User: user.User{ID: id.New(), Email: newEmail}}
+	err = changeSvc.Confirm(userID, key, domain.ClientInfo{})
+	assertHTTPStatus(assert, http.StatusConflict, err)
+	assert.Equal(0, credentialRepo.MarkUsedInvocation)
+	assert.Equal("", userRepo.SaveArg.User.ID)
+
+	userRepo.FindByEmailErr = repository.ErrNoSuchUser
+	userRepo.SaveErr = repository.ErrEmailTaken
+	err = changeSvc.Confirm(userID, key, domain.ClientInfo{})
+	assertHTTPStatus(assert, http.StatusConflict, err)
+	userRepo.SaveErr = nil
+
+	// Keys issued to other users should be rejected.
+	credentialRepo.UnsetArgs()
+	err = changeSvc.Confirm(id.New(), key, domain.ClientInfo{})
+	assertHTTPStatus(assert, http.StatusForbidden, err)
+	assert.Equal(0, credentialRepo.MarkUsedInvocation)
+
+	// Used keys should be rejected.
+	usedCredential := credential
+	usedCredential.HasBeenUsed = true
+	credentialRepo.FindByKeyCredential = usedCredential
+	err = changeSvc.Confirm(userID, key, domain.ClientInfo{})
+	assertHTTPStatus(assert, http.StatusForbidden, err)
+	assert.Equal(0, credentialRepo.MarkUsedInvocation)
+
+	// Unknown keys should be rejected.
+	credentialRepo.FindByKeyErr = repository.ErrNoSuchCredential
+	err = changeSvc.Confirm(userID, "wrong-key", domain.ClientInfo{})
+	assertHTTPStatus(assert, http.StatusForbidden, err)
+	assert.Equal(0, credentialRepo.MarkUsedInvocation)
+}
+
+func TestRevertEmailChange(t *testing.T) {
+	assert := assert.New(t)
+
+	key := "my-revert-key"
+	userID := id.New()
+	oldEmail := "mail@mail.com"
+	newEmail := "new.mail@mail.com"
+	storedUser := domain.FullUser{
+		User: user.User{
+			ID:    userID,
+			Email: newEmail,
+		},
+		Credentials: domain.StoredCredentials{
+			Email: newEmail,
+		},
+		EmailVerified: true,
+	}
+	credential := domain.NewOneTimeCredential(domain.EmailRevertCredential, fmt.Sprintf("%x", auth.HashKey(key)), userID, time.Hour)
+	credential.Email = oldEmail
+
+	userRepo := &repository.MockUserRepo{
+		FindUser:       storedUser,
+		FindByEmailErr: repository.ErrNoSuchUser,
+	}
+	sessionRepo := &repository.MockSessionRepo{}
+	credentialRepo := &repository.MockOneTimeCredentialRepo{
+		FindByKeyCredential: credential,
+	}
+	mailer := &service.MockMailer{}
+	changeSvc := service.NewEmailChangeService(nil, mailer, userRepo, sessionRepo, credentialRepo, &service.MockEventRecorder{})
+
+	err := changeSvc.Revert(userID, key, domain.ClientInfo{})
+	assert.NoError(err)
+	assert.Equal(domain.EmailRevertCredential, credentialRepo.FindByKeyArgPurpose)
+	assert.Equal(credential.ID, credentialRepo.MarkUsedArg)
+	assert.Equal(oldEmail, userRepo.SaveArg.User.Email)
+	assert.Equal(oldEmail, userRepo.SaveArg.Credentials.Email)
+	assert.Equal(userID, userRepo.InvalidateSessionsArgUserID)
+	assert.Equal(userID, sessionRepo.DeleteByUserIDArg)
+	assert.Equal(0, credentialRepo.SaveInvocation)
+	assert.Equal(0, len(mailer.Outbox))
+
+	// Expired keys should be rejected.
+	credentialRepo.UnsetArgs()
+	expiredCredential := credential
+	expiredCredential.ValidTo = time.Now().UTC().Add(-1 * time.Minute)
+	credentialRepo.FindByKeyCredential = expiredCredential
+	err = changeSvc.Revert(userID, key, domain.ClientInfo{})
+	assertHTTPStatus(assert, http.StatusForbidden, err)
+	assert.Equal(0, credentialRepo.MarkUsedInvocation)
+}
@@ -0,0 +1,270 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/directory/pkg/repository"
+	"github.com/mimir-news/pkg/httputil"
+)
+
+const (
+	emailLoginLinkTTL      = 15 * time.Minute
+	emailLoginCodeTTL      = 10 * time.Minute
+	emailLoginMaxAttempts  = 5
+	emailLoginCodeDigits   = 6
+	emailLoginCodeModulus  = 1000000
+	emailLoginLinkTokenKey = "token"
+)
+
+// EmailLoginService service responsible for passwordless login using links or codes sent by email.
+type EmailLoginService interface {
+	RequestLogin(request domain.EmailLoginRequest) error
+	Redeem(login domain.EmailLogin, client domain.ClientInfo) (domain.FullUser, error)
+}
+
+// NewEmailLoginService creates a new EmailLoginService using the default implementation.
+// Login links are created by adding the login token as a query parameter to the loginURL.
+func NewEmailLoginService(
+	loginURL string, pwdSvc *PasswordService, mailer Mailer,
+	userRepo repository.UserRepo, credentialRepo repository.OneTimeCredentialRepo, events SecurityEventRecorder) EmailLoginService {
+	return &emailLoginSvc{
+		loginURL:       loginURL,
+		passwordSvc:    pwdSvc,
+		mailer:         mailer,
+		userRepo:       userRepo,
+		credentialRepo: credentialRepo,
+		events:         events,
+	}
+}
+
+type emailLoginSvc struct {
+	loginURL       string
+	passwordSvc    *PasswordService
+	mailer         Mailer
+	userRepo       repository.UserRepo
+	credentialRepo repository.OneTimeCredentialRepo
+	events         SecurityEventRecorder
+}
+
+// RequestLogin mails a login link or code to the user with the given email.
+// No error is returned if the user does not exist in order to not reveal which emails are registered.
+func (ls *emailLoginSvc) RequestLogin(request domain.EmailLoginRequest) error {
+	u, err := ls.userRepo.FindByEmail(request.Email)
+	if err == repository.ErrNoSuchUser {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if request.Method == domain.EmailLoginCode {
+		return ls.sendCode(u)
+	}
+
+	return ls.sendLink(u)
+}
+
+// Redeem consumes a login token or code and returns the user it was issued to.
+// Receiving the token or code proves ownership of the email address.
+func (ls *emailLoginSvc) Redeem(login domain.EmailLogin, client domain.ClientInfo) (domain.FullUser, error) {
+	var credential domain.OneTimeCredential
+	var err error
+	if login.Token != "" {
+		credential, err = ls.findLinkCredential(login.Token)
+	} else {
+		credential, err = ls.findCodeCredential(login.Email, login.Code, client)
+	}
+	if err != nil {
+		return domain.FullUser{}, err
+	}
+
+	u, err := ls.userRepo.Find(credential.UserID)
+	if err == repository.ErrNoSuchUser {
+		return domain.FullUser{}, errInvalidEmailLogin()
+	} else if err != nil {
+		return domain.FullUser{}, err
+	}
+
+	if u.User.Email == "" {
+		return domain.FullUser{}, errInvalidEmailLogin()
+	}
+
+	if u.IsLocked(now()) {
+		return domain.FullUser{}, errAccountLocked()
+	}
+
+	err = ls.credentialRepo.MarkUsed(credential.ID)
+	if err == repository.ErrNoSuchCredential {
+		return domain.FullUser{}, errInvalidEmailLogin()
+	} else if err != nil {
+		return domain.FullUser{}, err
+	}
+
+	err = ls.passwordSvc.ResetLoginFailures(u)
+	if err != nil {
+		return domain.FullUser{}, err
+	}
+
+	if !u.EmailVerified {
+		u.EmailVerified = true
+		err = ls.userRepo.Save(u)
+		if err != nil {
+			return domain.FullUser{}, err
+		}
+	}
+
+	return u, nil
+}
+
+func (ls *emailLoginSvc) sendLink(u domain.FullUser) error {
+	token, err := generateKey()
+	if err != nil {
+		return err
+	}
+
+	credential := domain.NewOneTimeCredential(domain.EmailLoginLinkCredential, hashKey(token), u.User.ID, emailLoginLinkTTL)
+	err = ls.credentialRepo.Save(credential)
+	if err != nil {
+		return err
+	}
+
+	link, err := ls.createLink(token)
+	if err != nil {
+		return err
+	}
+
+	return ls.mailer.Send(newEmailLoginLinkEmail(u.User.Email, link, credential.ValidTo))
+}
+
+// sendCode issues a short login code. Since codes are short they are bound to the user
+// they were issued to and only the latest code of a user can be used.
+func (ls *emailLoginSvc) sendCode(u domain.FullUser) error {
+	code, err := generateLoginCode()
+	if err != nil {
+		return err
+	}
+
+	credential := domain.NewOneTimeCredential(domain.EmailLoginCodeCredential, hashKey(code, u.User.ID), u.User.ID, emailLoginCodeTTL)
+	err = ls.credentialRepo.Save(credential)
+	if err != nil {
+		return err
+	}
+
+	return ls.mailer.Send(newEmailLoginCodeEmail(u.User.Email, code, credential.ValidTo))
+}
+
+func (ls *emailLoginSvc) findLinkCredential(token string) (domain.OneTimeCredential, error) {
+	credential, err := ls.credentialRepo.FindByKey(domain.EmailLoginLinkCredential, hashKey(token))
+	if err == repository.ErrNoSuchCredential {
+		return domain.OneTimeCredential{}, errInvalidEmailLogin()
+	} else if err != nil {
+		return domain.OneTimeCredential{}, err
+	}
+
+	if !credential.Valid() || credential.Purpose != domain.EmailLoginLinkCredential {
+		return domain.OneTimeCredential{}, errInvalidEmailLogin()
+	}
+
+	return credential, nil
+}
+
+// findCodeCredential finds the latest login code of a user and checks the provided code against it.
+// Attempts are counted before the code is checked so that concurrent guesses cannot exceed the attempt limit
+// of the code, wrong codes also count towards the lockout policy of the user and are recorded as failed logins.
+func (ls *emailLoginSvc) findCodeCredential(email, code string, client domain.ClientInfo) (domain.OneTimeCredential, error) {
+	u, err := ls.userRepo.FindByEmail(email)
+	if err == repository.ErrNoSuchUser {
+		return domain.OneTimeCredential{}, errInvalidEmailLogin()
+	} else if err != nil {
+		return domain.OneTimeCredential{}, err
+	}
+
+	if u.IsLocked(now()) {
+		return domain.OneTimeCredential{}, errAccountLocked()
+	}
+
+	credential, err := ls.credentialRepo.FindLatest(domain.EmailLoginCodeCredential, u.User.ID)
+	if err == repository.ErrNoSuchCredential {
+		return domain.OneTimeCredential{}, errInvalidEmailLogin()
+	} else if err != nil {
+		return domain.OneTimeCredential{}, err
+	}
+
+	if !credential.Valid() || credential.UserID != u.User.ID {
+		return domain.OneTimeCredential{}, errInvalidEmailLogin()
+	}
+
+	err = ls.credentialRepo.RecordAttempt(credential.ID, emailLoginMaxAttempts)
+	if err == repository.ErrNoSuchCredential {
+		return domain.OneTimeCredential{}, errInvalidEmailLogin()
+	} else if err != nil {
+		return domain.OneTimeCredential{}, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashKey(code, u.User.ID)), []byte(credential.Key)) != 1 {
+		err = ls.passwordSvc.RecordFailedLogin(u.User.ID)
+		if err != nil {
+			return domain.OneTimeCredential{}, err
+		}
+
+		err = ls.events.Record(domain.NewSecurityEvent(domain.LoginFailureEvent, u.User.ID, "", client))
+		if err != nil {
+			return domain.OneTimeCredential{}, err
+		}
+		return domain.OneTimeCredential{}, errInvalidEmailLogin()
+	}
+
+	return credential, nil
+}
+
+func (ls *emailLoginSvc) createLink(token string) (string, error) {
+	link, err := url.Parse(ls.loginURL)
+	if err != nil {
+		return "", err
+	}
+
+	query := link.Query()
+	query.Set(emailLoginLinkTokenKey, token)
+	link.RawQuery = query.Encode()
+	return link.String(), nil
+}
+
+// generateLoginCode generates a random numeric login code.
+func generateLoginCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(emailLoginCodeModulus))
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%0*d", emailLoginCodeDigits, n.Int64()), nil
+}
+
+func newEmailLoginLinkEmail(to, link string, validTo time.Time) domain.Email {
+	return domain.Email{
+		To:      to,
+		Subject: "Log in to your account",
+		Body: fmt.Sprintf(
+			"Use the following link to log in: %s\n\nThe link is valid until %s.",
+			link, validTo.Format(time.RFC1123)),
+	}
+}
+
+func newEmailLoginCodeEmail(to, code string, validTo time.Time) domain.Email {
+	return domain.Email{
+		To:      to,
+		Subject: "Your login code",
+		Body: fmt.Sprintf(
+			"Use the following code to log in: %s\n\nThe code is valid until %s.",
+			code, validTo.Format(time.RFC1123)),
+	}
+}
+
+func errInvalidEmailLogin() error {
+	return httputil.NewError("Invalid or expired login link or code", http.StatusUnauthorized)
+}
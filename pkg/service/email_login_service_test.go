@@ -0,0 +1,189 @@
+package service_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/directory/pkg/repository"
+	"github.com/mimir-news/directory/pkg/service"
+	"github.com/mimir-news/pkg/httputil/auth"
+	"github.com/mimir-news/pkg/id"
+	"github.com/mimir-news/pkg/schema/user"
+	"github.com/stretchr/testify/assert"
+)
+
+const testEmailLoginURL = "https://example.com/login/email"
+
+func TestRequestEmailLogin(t *testing.T) {
+	assert := assert.New(t)
+
+	userID := id.New()
+	email := "mail@mail.com"
+	userRepo := &repository.MockUserRepo{
+		FindByEmailUser: domain.FullUser{
+			User: user.User{
+				ID:    userID,
+				Email: email,
+			},
+		},
+	}
+	credentialRepo := &repository.MockOneTimeCredentialRepo{}
+	mailer := &service.MockMailer{}
+	loginSvc := service.NewEmailLoginService(testEmailLoginURL, nil, mailer, userRepo, credentialRepo, nil)
+
+	// Links should be sent if no method is provided.
+	err := loginSvc.RequestLogin(domain.EmailLoginRequest{Email: email})
+	assert.NoError(err)
+	assert.Equal(email, userRepo.FindByEmailArg)
+	linkCredential := credentialRepo.SaveArg
+	assert.Equal(domain.EmailLoginLinkCredential, linkCredential.Purpose)
+	assert.Equal(userID, linkCredential.UserID)
+	assert.True(linkCredential.ValidTo.After(time.Now().UTC()))
+	sent, ok := mailer.LastSent()
+	assert.True(ok)
+	assert.Equal(email, sent.To)
+	link := extractLink(sent.Body)
+	assert.True(strings.HasPrefix(link, testEmailLoginURL+"?token="))
+	parsedLink, err := url.Parse(link)
+	assert.NoError(err)
+	token := parsedLink.Query().Get("token")
+	assert.Equal(fmt.Sprintf("%x", auth.HashKey(token)), linkCredential.Key)
+
+	// Codes should be bound to the user.
+	credentialRepo.UnsetArgs()
+	err = loginSvc.RequestLogin(domain.EmailLoginRequest{Email: email, Method: domain.EmailLoginCode})
+	assert.NoError(err)
+	codeCredential := credentialRepo.SaveArg
+	assert.Equal(domain.EmailLoginCodeCredential, codeCredential.Purpose)
+	assert.Equal(userID, codeCredential.UserID)
+	sent, ok = mailer.LastSent()
+	assert.True(ok)
+	code := regexp.MustCompile("[0-9]{6}").FindString(sent.Body)
+	assert.Equal(fmt.Sprintf("%x", auth.HashKey(code, userID)), codeCredential.Key)
+
+	// Unknown emails should not be revealed.
+	credentialRepo.UnsetArgs()
+	mailer.Outbox = nil
+	userRepo.FindByEmailErr = repository.ErrNoSuchUser
+	err = loginSvc.RequestLogin(domain.EmailLoginRequest{Email: "unknown@mail.com"})
+	assert.NoError(err)
+	assert.Equal(0, credentialRepo.SaveInvocation)
+	assert.Equal(0, len(mailer.Outbox))
+}
+
+func TestRedeemEmailLogin(t *testing.T) {
+	assert := assert.New(t)
+
+	userID := id.New()
+	email := "mail@mail.com"
+	token := "my-login-token"
+	code := "123456"
+	storedUser := domain.FullUser{
+		User: user.User{
+			ID:    userID,
+			Email: email,
+		},
+	}
+	linkCredential := domain.NewOneTimeCredential(domain.EmailLoginLinkCredential, fmt.Sprintf("%x", auth.HashKey(token)), userID, time.Minute)
+	codeCredential := domain.NewOneTimeCredential(domain.EmailLoginCodeCredential, fmt.Sprintf("%x", auth.HashKey(code, userID)), userID, time.Minute)
+
+	userRepo := &repository.MockUserRepo{
+		FindUser:        storedUser,
+		FindByEmailUser: storedUser,
+	}
+	credentialRepo := &repository.MockOneTimeCredentialRepo{
+		FindByKeyCredential:  linkCredential,
+		FindLatestCredential: codeCredential,
+	}
+	passwordSvc := service.NewPasswordService(
+		userRepo, "my-pepper", service.NewEncryptionKeyring("my-encryption-key"), service.DefaultHashingConfig, service.DefaultLockoutPolicy)
+	events := &service.MockEventRecorder{}
+	loginSvc := service.NewEmailLoginService(testEmailLoginURL, passwordSvc, nil, userRepo, credentialRepo, events)
+	client := domain.ClientInfo{IP: "10.0.0.1", UserAgent: "test-agent"}
+
+	u, err := loginSvc.Redeem(domain.EmailLogin{Token: token}, client)
+	assert.NoError(err)
+	assert.Equal(userID, u.User.ID)
+	assert.Equal(domain.EmailLoginLinkCredential, credentialRepo.FindByKeyArgPurpose)
+	assert.Equal(linkCredential.Key, credentialRepo.FindByKeyArg)
+	assert.Equal(linkCredential.ID, credentialRepo.MarkUsedArg)
+	assert.True(userRepo.SaveArg.EmailVerified)
+
+	credentialRepo.UnsetArgs()
+	u, err = loginSvc.Redeem(domain.EmailLogin{Email: email, Code: code}, client)
+	assert.NoError(err)
+	assert.Equal(userID, u.User.ID)
+	assert.Equal(domain.EmailLoginCodeCredential, credentialRepo.FindLatestArgPurpose)
+	assert.Equal(userID, credentialRepo.FindLatestArgUserID)
+	assert.Equal(codeCredential.ID, credentialRepo.MarkUsedArg)
+	assert.Equal(codeCredential.ID, credentialRepo.RecordAttemptArg)
+	assert.Equal(5, credentialRepo.RecordAttemptArgMax)
+	assert.Equal(0, userRepo.RecordFailedLoginInvocation)
+	assert.Equal(0, len(events.Events))
+
+	// Wrong codes should be counted as failed attempts and failed logins.
+	credentialRepo.UnsetArgs()
+	_, err = loginSvc.Redeem(domain.EmailLogin{Email: email, Code: "654321"}, client)
+	assertHTTPStatus(assert, http.StatusUnauthorized, err)
+	assert.Equal(codeCredential.ID, credentialRepo.RecordAttemptArg)
+	assert.Equal(1, userRepo.RecordFailedLoginInvocation)
+	assert.Equal(userID, userRepo.RecordFailedLoginArgUserID)
+	assert.Equal(0, credentialRepo.MarkUsedInvocation)
+	assert.Equal(1, len(events.Events))
+	assert.Equal(domain.LoginFailureEvent, events.Events[0].Type)
+	assert.Equal(userID, events.Events[0].UserID)
+	assert.Equal(client, events.Events[0].Client)
+
+	// Codes should not be checked once the attempt limit is reached.
+	credentialRepo.UnsetArgs()
+	userRepo.RecordFailedLoginInvocation = 0
+	credentialRepo.RecordAttemptErr = repository.ErrNoSuchCredential
+	_, err = loginSvc.Redeem(domain.EmailLogin{Email: email, Code: "654321"}, client)
+	assertHTTPStatus(assert, http.StatusUnauthorized, err)
+	assert.Equal(0, userRepo.RecordFailedLoginInvocation)
+	_, err = loginSvc.Redeem(domain.EmailLogin{Email: email, Code: code}, client)
+	assertHTTPStatus(assert, http.StatusUnauthorized, err)
+	assert.Equal(0, credentialRepo.MarkUsedInvocation)
+	credentialRepo.RecordAttemptErr = nil
+
+	// Locked users should not be logged in.
+	lockedUser := storedUser
+	lockedUser.LoginFailures.LockedUntil = time.Now().UTC().Add(time.Minute)
+	userRepo.FindUser = lockedUser
+	_, err = loginSvc.Redeem(domain.EmailLogin{Token: token}, client)
+	assertHTTPStatus(assert, http.StatusLocked, err)
+	assert.Equal(0, credentialRepo.MarkUsedInvocation)
+	userRepo.FindUser = storedUser
+
+	credentialRepo.UnsetArgs()
+	userRepo.FindByEmailUser = lockedUser
+	_, err = loginSvc.Redeem(domain.EmailLogin{Email: email, Code: code}, client)
+	assertHTTPStatus(assert, http.StatusLocked, err)
+	assert.Equal(0, credentialRepo.RecordAttemptInvocation)
+	assert.Equal(0, credentialRepo.MarkUsedInvocation)
+	userRepo.FindByEmailUser = storedUser
+
+	// Expired links should be rejected.
+	expiredCredential := linkCredential
+	expiredCredential.ValidTo = time.Now().UTC().Add(-1 * time.Minute)
+	credentialRepo.FindByKeyCredential = expiredCredential
+	_, err = loginSvc.Redeem(domain.EmailLogin{Token: token}, client)
+	assertHTTPStatus(assert, http.StatusUnauthorized, err)
+	assert.Equal(0, credentialRepo.MarkUsedInvocation)
+
+	// Unknown links should be rejected.
+	credentialRepo.FindByKeyErr = repository.ErrNoSuchCredential
+	_, err = loginSvc.Redeem(domain.EmailLogin{Token: "wrong-token"}, client)
+	assertHTTPStatus(assert, http.StatusUnauthorized, err)
+	assert.Equal(0, credentialRepo.MarkUsedInvocation)
+}
+
+func extractLink(body string) string {
+	return regexp.MustCompile(`https://\S+`).FindString(body)
+}
@@ -0,0 +1,162 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/directory/pkg/repository"
+	"github.com/mimir-news/pkg/httputil"
+)
+
+const emailVerificationTTL = 48 * time.Hour
+
+// EmailVerificationPolicy decides what users with unverified email addresses are allowed to do.
+type EmailVerificationPolicy string
+
+// Email verification policies.
+const (
+	AllowUnverified EmailVerificationPolicy = "ALLOW"
+	LimitUnverified EmailVerificationPolicy = "LIMIT"
+	BlockUnverified EmailVerificationPolicy = "BLOCK"
+)
+
+// EmailVerificationService service responsible for verifying user email addresses.
+type EmailVerificationService interface {
+	SendVerification(u domain.FullUser) error
+	ResendVerification(userID string) error
+	Verify(userID, key string) error
+	AuthorizedRole(u domain.FullUser) (string, error)
+}
+
+// NewEmailVerificationService creates a new EmailVerificationService using the default implementation.
+func NewEmailVerificationService(
+	policy EmailVerificationPolicy, mailer Mailer, userRepo repository.UserRepo,
+	credentialRepo repository.OneTimeCredentialRepo) EmailVerificationService {
+	return &emailVerificationSvc{
+		policy:         policy,
+		mailer:         mailer,
+		userRepo:       userRepo,
+		credentialRepo: credentialRepo,
+	}
+}
+
+type emailVerificationSvc struct {
+	policy         EmailVerificationPolicy
+	mailer         Mailer
+	userRepo       repository.UserRepo
+	credentialRepo repository.OneTimeCredentialRepo
+}
+
+// SendVerification issues a verification key bound to the users current email address and mails it to that address.
+func (vs *emailVerificationSvc) SendVerification(u domain.FullUser) error {
+	key, err := generateKey()
+	if err != nil {
+		return err
+	}
+
+	hashedKey := hashKey(key, u.User.Email)
+	credential := domain.NewOneTimeCredential(domain.EmailVerificationCredential, hashedKey, u.User.ID, emailVerificationTTL)
+	err = vs.credentialRepo.Save(credential)
+	if err != nil {
+		return err
+	}
+
+	return vs.mailer.Send(newEmailVerificationEmail(u.User.Email, key, credential.ValidTo))
+}
+
+// ResendVerification issues a new verification key to a user whose email address has not been verified.
+// No error is returned if the user does not exist, is already verified or if the email could not be sent
+// in order to not reveal which users are registered.
+func (vs *emailVerificationSvc) ResendVerification(userID string) error {
+	u, err := vs.userRepo.Find(userID)
+	if err == repository.ErrNoSuchUser {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if u.User.Email == "" || u.EmailVerified || u.IsDeleted() {
+		return nil
+	}
+
+	err = vs.SendVerification(u)
+	if err != nil {
+		log.Printf("Failed to resend email verification to user %s: %s\n", userID, err)
+	}
+
+	return nil
+}
+
+// Verify marks the email address of a user as verified if a valid verification key is provided.
+func (vs *emailVerificationSvc) Verify(userID, key string) error {
+	u, err := vs.userRepo.Find(userID)
+	if err == repository.ErrNoSuchUser {
+		return errInvalidVerificationKey()
+	} else if err != nil {
+		return err
+	}
+
+	if u.User.Email == "" {
+		return errInvalidVerificationKey()
+	}
+
+	if u.EmailVerified {
+		return nil
+	}
+
+	credential, err := vs.credentialRepo.FindByKey(domain.EmailVerificationCredential, hashKey(key, u.User.Email))
+	if err == repository.ErrNoSuchCredential {
+		return errInvalidVerificationKey()
+	} else if err != nil {
+		return err
+	}
+
+	if !credential.Valid() || credential.UserID != u.User.ID {
+		return errInvalidVerificationKey()
+	}
+
+	err = vs.credentialRepo.MarkUsed(credential.ID)
+	if err == repository.ErrNoSuchCredential {
+		return errInvalidVerificationKey()
+	} else if err != nil {
+		return err
+	}
+
+	u.EmailVerified = true
+	return vs.userRepo.Save(u)
+}
+
+// AuthorizedRole returns the role that a user should be issued according to the verification policy.
+// Guests and service accounts have no email address to verify and keep their role.
+func (vs *emailVerificationSvc) AuthorizedRole(u domain.FullUser) (string, error) {
+	if u.EmailVerified || u.IsGuest() || u.IsServiceAccount() || vs.policy == AllowUnverified {
+		return u.User.Role, nil
+	}
+
+	if vs.policy == BlockUnverified {
+		return "", errEmailNotVerified()
+	}
+
+	return domain.UnverifiedRole, nil
+}
+
+func newEmailVerificationEmail(to, key string, validTo time.Time) domain.Email {
+	return domain.Email{
+		To:      to,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf(
+			"Use the following key to verify your email address: %s\n\nThe key is valid until %s.",
+			key, validTo.Format(time.RFC1123)),
+	}
+}
+
+func errInvalidVerificationKey() error {
+	return httputil.NewError("Invalid or expired email verification key", http.StatusForbidden)
+}
+
+func errEmailNotVerified() error {
+	return httputil.NewError("Email address has not been verified", http.StatusForbidden)
+}
@@ -0,0 +1,228 @@
+package service_test
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/directory/pkg/repository"
+	"github.com/mimir-news/directory/pkg/service"
+	"github.com/mimir-news/pkg/httputil/auth"
+	"github.com/mimir-news/pkg/id"
+	"github.com/mimir-news/pkg/schema/user"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendEmailVerification(t *testing.T) {
+	assert := assert.New(t)
+
+	u := domain.FullUser{
+		User: user.User{
+			ID:    id.New(),
+			Email: "mail@mail.com",
+		},
+	}
+
+	credentialRepo := &repository.MockOneTimeCredentialRepo{}
+	mailer := &service.MockMailer{}
+	verificationSvc := service.NewEmailVerificationService(service.LimitUnverified, mailer, nil, credentialRepo)
+
+	err := verificationSvc.SendVerification(u)
+	assert.NoError(err)
+	savedCredential := credentialRepo.SaveArg
+	assert.Equal(u.User.ID, savedCredential.UserID)
+	assert.Equal(domain.EmailVerificationCredential, savedCredential.Purpose)
+	assert.True(savedCredential.Valid())
+
+	email, ok := mailer.LastSent()
+	assert.True(ok)
+	assert.Equal(u.User.Email, email.To)
+	key := extractVerificationKey(email.Body)
+	assert.Equal(fmt.Sprintf("%x", auth.HashKey(key, u.User.Email)), savedCredential.Key)
+
+	credentialRepo.SaveErr = testError
+	mailer.Outbox = nil
+	err = verificationSvc.SendVerification(u)
+	assert.Equal(testError, err)
+	assert.Equal(0, len(mailer.Outbox))
+}
+
+func TestResendEmailVerification(t *testing.T) {
+	assert := assert.New(t)
+
+	storedUser := domain.FullUser{
+		User: user.User{
+			ID:    id.New(),
+			Email: "mail@mail.com",
+		},
+	}
+
+	userRepo := &repository.MockUserRepo{
+		FindUser: storedUser,
+	}
+	credentialRepo := &repository.MockOneTimeCredentialRepo{}
+	mailer := &service.MockMailer{}
+	verificationSvc := service.NewEmailVerificationService(service.BlockUnverified, mailer, userRepo, credentialRepo)
+
+	err := verificationSvc.ResendVerification(storedUser.User.ID)
+	assert.NoError(err)
+	assert.Equal(storedUser.User.ID, userRepo.FindArg)
+	assert.Equal(storedUser.User.ID, credentialRepo.SaveArg.UserID)
+	email, ok := mailer.LastSent()
+	assert.True(ok)
+	assert.Equal(storedUser.User.Email, email.To)
+
+	// Failures to send the email should not reveal that the user exists.
+	credentialRepo.UnsetArgs()
+	mailer.SendErr = testError
+	err = verificationSvc.ResendVerification(storedUser.User.ID)
+	assert.NoError(err)
+	assert.Equal(1, credentialRepo.SaveInvocation)
+	mailer.SendErr = nil
+
+	// Verified, deleted and unknown users should not be sent verification keys.
+	verifiedUser := storedUser
+	verifiedUser.EmailVerified = true
+	deletedUser := storedUser
+	deletedUser.DeletedAt = time.Now().UTC()
+	for _, u := range []domain.FullUser{verifiedUser, deletedUser, domain.NewGuest(nil)} {
+		credentialRepo.UnsetArgs()
+		mailer.Outbox = nil
+		userRepo.FindUser = u
+		err = verificationSvc.ResendVerification(u.User.ID)
+		assert.NoError(err)
+		assert.Equal(0, credentialRepo.SaveInvocation)
+		assert.Equal(0, len(mailer.Outbox))
+	}
+
+	userRepo.FindErr = repository.ErrNoSuchUser
+	err = verificationSvc.ResendVerification("unknown-id")
+	assert.NoError(err)
+	assert.Equal(0, credentialRepo.SaveInvocation)
+
+	userRepo.FindErr = testError
+	err = verificationSvc.ResendVerification(storedUser.User.ID)
+	assert.Equal(testError, err)
+}
+
+func TestVerifyEmail(t *testing.T) {
+	assert := assert.New(t)
+
+	key := "my-verification-key"
+	userID := id.New()
+	email := "mail@mail.com"
+	storedUser := domain.FullUser{
+		User: user.User{
+			ID:    userID,
+			Email: email,
+		},
+	}
+	hashedKey := fmt.Sprintf("%x", auth.HashKey(key, email))
+	credential := domain.NewOneTimeCredential(domain.EmailVerificationCredential, hashedKey, userID, time.Hour)
+
+	userRepo := &repository.MockUserRepo{
+		FindUser: storedUser,
+	}
+	credentialRepo := &repository.MockOneTimeCredentialRepo{
+		FindByKeyCredential: credential,
+	}
+	verificationSvc := service.NewEmailVerificationService(service.LimitUnverified, nil, userRepo, credentialRepo)
+
+	err := verificationSvc.Verify(userID, key)
+	assert.NoError(err)
+	assert.Equal(userID, userRepo.FindArg)
+	assert.Equal(domain.EmailVerificationCredential, credentialRepo.FindByKeyArgPurpose)
+	assert.Equal(hashedKey, credentialRepo.FindByKeyArg)
+	assert.Equal(credential.ID, credentialRepo.MarkUsedArg)
+	assert.True(userRepo.SaveArg.EmailVerified)
+	assert.Equal(userID, userRepo.SaveArg.User.ID)
+
+	// Test verifying with a key issued to another user.
+	credentialRepo.UnsetArgs()
+	userRepo.SaveArg = domain.FullUser{}
+	otherCredential := credential
+	otherCredential.UserID = id.New()
+	credentialRepo.FindByKeyCredential = otherCredential
+	err = verificationSvc.Verify(userID, key)
+	assertHTTPStatus(assert, http.StatusForbidden, err)
+	assert.Equal(0, credentialRepo.MarkUsedInvocation)
+	assert.False(userRepo.SaveArg.EmailVerified)
+
+	// Test verifying with an expired key.
+	credentialRepo.UnsetArgs()
+	expiredCredential := credential
+	expiredCredential.ValidTo = time.Now().UTC().Add(-1 * time.Minute)
+	credentialRepo.FindByKeyCredential = expiredCredential
+	err = verificationSvc.Verify(userID, key)
+	assertHTTPStatus(assert, http.StatusForbidden, err)
+	assert.Equal(0, credentialRepo.MarkUsedInvocation)
+
+	// Test verifying with an unknown key.
+	credentialRepo.UnsetArgs()
+	credentialRepo.FindByKeyErr = repository.ErrNoSuchCredential
+	err = verificationSvc.Verify(userID, "wrong-key")
+	assertHTTPStatus(assert, http.StatusForbidden, err)
+	assert.Equal(0, credentialRepo.MarkUsedInvocation)
+
+	// Test verifying an already verified user.
+	credentialRepo.UnsetArgs()
+	verifiedUser := storedUser
+	verifiedUser.EmailVerified = true
+	userRepo.FindUser = verifiedUser
+	err = verificationSvc.Verify(userID, key)
+	assert.NoError(err)
+	assert.Equal(0, credentialRepo.FindByKeyInvocation)
+
+	// Test verifying a missing user.
+	userRepo.FindErr = repository.ErrNoSuchUser
+	err = verificationSvc.Verify(userID, key)
+	assertHTTPStatus(assert, http.StatusForbidden, err)
+}
+
+func TestEmailVerificationAuthorizedRole(t *testing.T) {
+	assert := assert.New(t)
+
+	unverifiedUser := domain.FullUser{
+		User: user.User{
+			ID:   id.New(),
+			Role: auth.UserRole,
+		},
+	}
+	verifiedUser := unverifiedUser
+	verifiedUser.EmailVerified = true
+
+	allowSvc := service.NewEmailVerificationService(service.AllowUnverified, nil, nil, nil)
+	role, err := allowSvc.AuthorizedRole(unverifiedUser)
+	assert.NoError(err)
+	assert.Equal(auth.UserRole, role)
+
+	limitSvc := service.NewEmailVerificationService(service.LimitUnverified, nil, nil, nil)
+	role, err = limitSvc.AuthorizedRole(unverifiedUser)
+	assert.NoError(err)
+	assert.Equal(domain.UnverifiedRole, role)
+	role, err = limitSvc.AuthorizedRole(verifiedUser)
+	assert.NoError(err)
+	assert.Equal(auth.UserRole, role)
+
+	blockSvc := service.NewEmailVerificationService(service.BlockUnverified, nil, nil, nil)
+	_, err = blockSvc.AuthorizedRole(unverifiedUser)
+	assertHTTPStatus(assert, http.StatusForbidden, err)
+	role, err = blockSvc.AuthorizedRole(verifiedUser)
+	assert.NoError(err)
+	assert.Equal(auth.UserRole, role)
+
+	// Guests have no email to verify and should keep their role.
+	role, err = blockSvc.AuthorizedRole(domain.NewGuest(nil))
+	assert.NoError(err)
+	assert.Equal(domain.GuestRole, role)
+}
+
+func extractVerificationKey(body string) string {
+	prefix := "verify your email address: "
+	start := strings.Index(body, prefix) + len(prefix)
+	end := strings.Index(body[start:], "\n")
+	return body[start : start+end]
+}
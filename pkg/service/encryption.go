@@ -0,0 +1,142 @@
+package service
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/mimir-news/pkg/httputil/auth"
+)
+
+// LegacyKeyID id of the encryption key used before keys were identified.
+// Ciphertexts encrypted with the legacy key do not contain a key id.
+const LegacyKeyID = ""
+
+// keyIDSeparator separates the key id from the encoded ciphertext.
+const keyIDSeparator = ":"
+
+// EncryptionKeyring set of encryption keys identified by key ids. New secrets are
+// encrypted with the active key while every key in the keyring can be used for decryption.
+type EncryptionKeyring struct {
+	ActiveKeyID string
+	Keys        map[string]string
+}
+
+// NewEncryptionKeyring creates a keyring with a single legacy key.
+func NewEncryptionKeyring(key string) EncryptionKeyring {
+	return EncryptionKeyring{
+		ActiveKeyID: LegacyKeyID,
+		Keys: map[string]string{
+			LegacyKeyID: key,
+		},
+	}
+}
+
+// Valid checks that the active key is present and that key ids are well formed.
+func (k EncryptionKeyring) Valid() error {
+	if _, ok := k.Keys[k.ActiveKeyID]; !ok {
+		return fmt.Errorf("No encryption key with id: %s", k.ActiveKeyID)
+	}
+
+	for keyID, key := range k.Keys {
+		if strings.Contains(keyID, keyIDSeparator) {
+			return fmt.Errorf("Encryption key id must not contain %s: %s", keyIDSeparator, keyID)
+		}
+		if key == "" {
+			return fmt.Errorf("No value for encryption key with id: %s", keyID)
+		}
+	}
+
+	return nil
+}
+
+// encryptionScheme symetric encryption scheme used for encryption and decryption of secrets.
+// Ciphertexts are prefixed with the id of the key used to encrypt them, <key id>:<base64 ciphertext>.
+type encryptionScheme struct {
+	activeKeyID string
+	hashedKeys  map[string][]byte
+	encryptor   auth.Encryptor
+	decryptor   auth.Decryptor
+}
+
+func newEncryptionScheme(keyring EncryptionKeyring) encryptionScheme {
+	hashedKeys := make(map[string][]byte)
+	for keyID, key := range keyring.Keys {
+		hashedKeys[keyID] = auth.HashKey(key)
+	}
+
+	return encryptionScheme{
+		activeKeyID: keyring.ActiveKeyID,
+		hashedKeys:  hashedKeys,
+		encryptor:   auth.NewAESEncryptor(),
+		decryptor:   auth.NewAESDecryptor(),
+	}
+}
+
+// encrypt encrypts a string with the active key and returns its base64 encoded ciphertext tagged with the key id.
+func (e encryptionScheme) encrypt(plaintext string) (string, error) {
+	hashedKey, ok := e.hashedKeys[e.activeKeyID]
+	if !ok {
+		return "", fmt.Errorf("No encryption key with id: %s", e.activeKeyID)
+	}
+
+	ciphertext, err := e.encryptor.Encrypt(hashedKey, []byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+
+	encodedCiphertext := base64.StdEncoding.EncodeToString(ciphertext)
+	if e.activeKeyID == LegacyKeyID {
+		return encodedCiphertext, nil
+	}
+
+	return e.activeKeyID + keyIDSeparator + encodedCiphertext, nil
+}
+
+// decrypt decodes and decrypts ciphertext using the key it was encrypted with and returns the plaintext.
+func (e encryptionScheme) decrypt(ciphertext string) (string, error) {
+	keyID, encodedCiphertext := parseKeyID(ciphertext)
+	hashedKey, ok := e.hashedKeys[keyID]
+	if !ok {
+		return "", fmt.Errorf("No encryption key with id: %s", keyID)
+	}
+
+	decodedCiphertext, err := base64.StdEncoding.DecodeString(encodedCiphertext)
+	if err != nil {
+		return "", err
+	}
+
+	plaintext, err := e.decryptor.Decrypt(hashedKey, decodedCiphertext)
+	if err != nil {
+		return "", err
+	}
+
+	return string(plaintext), nil
+}
+
+// reencrypt decrypts a ciphertext and encrypts it with the active key.
+func (e encryptionScheme) reencrypt(ciphertext string) (string, error) {
+	plaintext, err := e.decrypt(ciphertext)
+	if err != nil {
+		return "", err
+	}
+
+	return e.encrypt(plaintext)
+}
+
+// usesActiveKey checks if a ciphertext was encrypted with the active key.
+func (e encryptionScheme) usesActiveKey(ciphertext string) bool {
+	keyID, _ := parseKeyID(ciphertext)
+	return keyID == e.activeKeyID
+}
+
+// parseKeyID splits a ciphertext into key id and encoded ciphertext.
+// Base64 does not use the separator so ciphertexts without it were encrypted with the legacy key.
+func parseKeyID(ciphertext string) (string, string) {
+	parts := strings.SplitN(ciphertext, keyIDSeparator, 2)
+	if len(parts) != 2 {
+		return LegacyKeyID, ciphertext
+	}
+
+	return parts[0], parts[1]
+}
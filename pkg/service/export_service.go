@@ -0,0 +1,121 @@
+package service
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io"
+
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/directory/pkg/repository"
+	"github.com/mimir-news/pkg/httputil"
+)
+
+// ExportService service responsible for exporting the data stored about users.
+type ExportService interface {
+	Export(userID string, client domain.ClientInfo) (domain.UserExport, error)
+}
+
+// NewExportService creates a new ExportService using the default implementation.
+func NewExportService(
+	userRepo repository.UserRepo, sessionRepo repository.SessionRepo, listRepo repository.WatchlistRepo,
+	eventRepo repository.AuditEventRepo, events SecurityEventRecorder) ExportService {
+	return &exportSvc{
+		userRepo:    userRepo,
+		sessionRepo: sessionRepo,
+		listRepo:    listRepo,
+		eventRepo:   eventRepo,
+		events:      events,
+	}
+}
+
+type exportSvc struct {
+	userRepo    repository.UserRepo
+	sessionRepo repository.SessionRepo
+	listRepo    repository.WatchlistRepo
+	eventRepo   repository.AuditEventRepo
+	events      SecurityEventRecorder
+}
+
+// Export collects the profile, watchlists, session history and security events of a user.
+// The export is recorded as a security event once it has been collected, so it is part of the next export.
+func (es *exportSvc) Export(userID string, client domain.ClientInfo) (domain.UserExport, error) {
+	u, err := es.userRepo.Find(userID)
+	if err == repository.ErrNoSuchUser {
+		return domain.UserExport{}, httputil.ErrNotFound()
+	} else if err != nil {
+		return domain.UserExport{}, err
+	}
+
+	watchlists, err := es.listRepo.FindByUserID(userID)
+	if err != nil {
+		return domain.UserExport{}, err
+	}
+
+	sessions, err := es.sessionRepo.FindAllByUserID(userID)
+	if err != nil {
+		return domain.UserExport{}, err
+	}
+
+	export := domain.UserExport{
+		Profile:    domain.NewExportedProfile(u),
+		Watchlists: watchlists,
+		Sessions:   make([]domain.ExportedSession, 0, len(sessions)),
+		Events:     make([]domain.EventInfo, 0),
+		ExportedAt: now(),
+	}
+
+	for _, s := range sessions {
+		export.Sessions = append(export.Sessions, domain.NewExportedSession(s))
+	}
+
+	err = es.eventRepo.FindAllByUserID(userID, func(e domain.SecurityEvent) error {
+		export.Events = append(export.Events, e.Info())
+		return nil
+	})
+	if err != nil {
+		return domain.UserExport{}, err
+	}
+
+	err = es.events.Record(domain.NewAccountEvent(domain.DataExportEvent, userID, "", client))
+	if err != nil {
+		return domain.UserExport{}, err
+	}
+
+	return export, nil
+}
+
+// WriteExportArchive writes an export to w as a ZIP archive with one JSON file for each part of the export.
+func WriteExportArchive(w io.Writer, export domain.UserExport) error {
+	archive := zip.NewWriter(w)
+	files := []struct {
+		name    string
+		content interface{}
+	}{
+		{name: "profile.json", content: export.Profile},
+		{name: "watchlists.json", content: export.Watchlists},
+		{name: "sessions.json", content: export.Sessions},
+		{name: "events.json", content: export.Events},
+	}
+
+	for _, file := range files {
+		header := &zip.FileHeader{
+			Name:     file.name,
+			Method:   zip.Deflate,
+			Modified: export.ExportedAt,
+		}
+
+		fw, err := archive.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(file.content)
+		if err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
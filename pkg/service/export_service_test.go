@@ -0,0 +1,118 @@
+package service_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/directory/pkg/repository"
+	"github.com/mimir-news/directory/pkg/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExport(t *testing.T) {
+	assert := assert.New(t)
+
+	u := domain.NewUser(domain.StoredCredentials{Email: "mail@mail.com", Password: "password", Salt: "salt"}, nil)
+	u.TOTP = domain.TOTP{Secret: "totp-secret", Enabled: true}
+	userRepo := &repository.MockUserRepo{FindUser: u}
+	addedAt := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	listRepo := &repository.MockWatchlistRepo{
+		FindByUserIDWatchlists: []domain.ExportedWatchlist{
+			{
+				ID:   "list-1",
+				Name: "My list",
+				Members: []domain.ExportedWatchlistMember{
+					{Symbol: "AAPL", Name: "Apple Inc.", AddedAt: addedAt},
+				},
+			},
+		},
+	}
+	active := domain.NewSession(u.User.ID, domain.ClientInfo{IP: "10.0.0.1"})
+	ended := domain.NewSession(u.User.ID, domain.ClientInfo{IP: "10.0.0.2"})
+	ended.Active = false
+	ended.DeletedAt = addedAt
+	sessionRepo := &repository.MockSessionRepo{
+		FindAllByUserIDSessions: []domain.Session{active, ended},
+	}
+	eventRepo := &repository.MockAuditEventRepo{
+		FindAllByUserIDEvents: []domain.SecurityEvent{
+			domain.NewAccountEvent(domain.LoginSuccessEvent, u.User.ID, active.ID, domain.ClientInfo{}),
+		},
+	}
+	events := &service.MockEventRecorder{}
+	exportSvc := service.NewExportService(userRepo, sessionRepo, listRepo, eventRepo, events)
+
+	export, err := exportSvc.Export(u.User.ID, domain.ClientInfo{IP: "10.0.0.3"})
+	assert.NoError(err)
+	assert.Equal(u.User.ID, export.Profile.ID)
+	assert.Equal("mail@mail.com", export.Profile.Email)
+	assert.True(export.Profile.MFAEnabled)
+	assert.Len(export.Watchlists, 1)
+	assert.Equal(addedAt, export.Watchlists[0].Members[0].AddedAt)
+	assert.Equal(u.User.ID, listRepo.FindByUserIDArg)
+	assert.Len(export.Sessions, 2)
+	assert.True(export.Sessions[0].Active)
+	assert.Nil(export.Sessions[0].EndedAt)
+	assert.False(export.Sessions[1].Active)
+	assert.Equal(addedAt, *export.Sessions[1].EndedAt)
+	assert.Equal(u.User.ID, sessionRepo.FindAllByUserIDArg)
+	assert.Len(export.Events, 1)
+	assert.Equal(domain.LoginSuccessEvent, export.Events[0].Type)
+	assert.Equal(u.User.ID, eventRepo.FindAllByUserIDArg)
+	assert.Len(events.Events, 1)
+	assert.Equal(domain.DataExportEvent, events.Events[0].Type)
+	assert.Equal("10.0.0.3", events.Events[0].Client.IP)
+
+	// Credentials and secrets are not exported.
+	content, err := json.Marshal(export)
+	assert.NoError(err)
+	assert.NotContains(string(content), "totp-secret")
+	assert.NotContains(string(content), "password")
+	assert.NotContains(string(content), active.RefreshToken)
+
+	eventRepo.FindAllByUserIDErr = testError
+	_, err = exportSvc.Export(u.User.ID, domain.ClientInfo{})
+	assert.Equal(testError, err)
+	assert.Len(events.Events, 1)
+
+	userRepo.FindErr = repository.ErrNoSuchUser
+	_, err = exportSvc.Export(u.User.ID, domain.ClientInfo{})
+	assertHTTPStatus(assert, http.StatusNotFound, err)
+}
+
+func TestWriteExportArchive(t *testing.T) {
+	assert := assert.New(t)
+
+	export := domain.UserExport{
+		Profile:    domain.ExportedProfile{ID: "user-id", Email: "mail@mail.com"},
+		Watchlists: []domain.ExportedWatchlist{{ID: "list-1", Name: "My list"}},
+		Sessions:   []domain.ExportedSession{},
+		Events:     []domain.EventInfo{{ID: "event-id", Type: domain.DataExportEvent}},
+		ExportedAt: time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC),
+	}
+
+	var buf bytes.Buffer
+	err := service.WriteExportArchive(&buf, export)
+	assert.NoError(err)
+
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	assert.NoError(err)
+	names := make([]string, 0)
+	for _, f := range archive.File {
+		names = append(names, f.Name)
+	}
+	assert.Equal([]string{"profile.json", "watchlists.json", "sessions.json", "events.json"}, names)
+
+	f, err := archive.File[0].Open()
+	assert.NoError(err)
+	defer f.Close()
+	var profile domain.ExportedProfile
+	err = json.NewDecoder(f).Decode(&profile)
+	assert.NoError(err)
+	assert.Equal(export.Profile, profile)
+}
@@ -0,0 +1,212 @@
+package service
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"time"
+
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/directory/pkg/repository"
+	"github.com/mimir-news/pkg/httputil"
+	"github.com/mimir-news/pkg/httputil/auth"
+	"github.com/mimir-news/pkg/schema/user"
+)
+
+// ExternalLoginStateTTL time that users have to complete a login after they are redirected to a provider.
+const ExternalLoginStateTTL = 10 * time.Minute
+
+// ExternalLoginService service responsible for login with external OpenID Connect providers.
+type ExternalLoginService interface {
+	AuthorizationURL(provider string) (string, string, error)
+	Redeem(provider string, callback domain.ExternalLoginCallback) (domain.FullUser, error)
+}
+
+// NewExternalLoginService creates a new ExternalLoginService using the default implementation.
+func NewExternalLoginService(
+	providers []OIDCProviderConfig, userRepo repository.UserRepo, identityRepo repository.ExternalIdentityRepo) ExternalLoginService {
+	client := &http.Client{Timeout: oidcHTTPTimeout}
+	oidcProviders := make(map[string]*oidcProvider)
+	for _, config := range providers {
+		oidcProviders[config.Name] = newOIDCProvider(config, client)
+	}
+
+	return &externalLoginSvc{
+		providers:    oidcProviders,
+		userRepo:     userRepo,
+		identityRepo: identityRepo,
+	}
+}
+
+type externalLoginSvc struct {
+	providers    map[string]*oidcProvider
+	userRepo     repository.UserRepo
+	identityRepo repository.ExternalIdentityRepo
+}
+
+// AuthorizationURL starts a login with a provider and returns the url that the user should be redirected to,
+// along with a binding which must be kept by the browser and presented when the user returns.
+// The state, nonce and PKCE code verifier of the login are stored until the user returns.
+func (ls *externalLoginSvc) AuthorizationURL(provider string) (string, string, error) {
+	p, ok := ls.providers[provider]
+	if !ok {
+		return "", "", httputil.ErrNotFound()
+	}
+
+	state, err := generateKey()
+	if err != nil {
+		return "", "", err
+	}
+
+	binding, err := generateKey()
+	if err != nil {
+		return "", "", err
+	}
+
+	nonce, err := generateKey()
+	if err != nil {
+		return "", "", err
+	}
+
+	codeVerifier, err := generateKey()
+	if err != nil {
+		return "", "", err
+	}
+
+	authURL, err := p.authorizationURL(state, nonce, codeVerifier)
+	if err != nil {
+		return "", "", err
+	}
+
+	loginState := domain.NewExternalLoginState(
+		hashKey(state), hashKey(binding), provider, codeVerifier, nonce, ExternalLoginStateTTL)
+	err = ls.identityRepo.SaveLoginState(loginState)
+	if err != nil {
+		return "", "", err
+	}
+
+	return authURL, binding, nil
+}
+
+// Redeem completes a login with a provider and returns the user linked to the external identity.
+// Users are created, or linked by email, the first time they log in if the provider has verified their email.
+// Existing users are only linked if they have verified the email as well.
+func (ls *externalLoginSvc) Redeem(provider string, callback domain.ExternalLoginCallback) (domain.FullUser, error) {
+	p, ok := ls.providers[provider]
+	if !ok {
+		return domain.FullUser{}, httputil.ErrNotFound()
+	}
+
+	loginState, err := ls.identityRepo.ConsumeLoginState(hashKey(callback.State))
+	if err == repository.ErrNoSuchExternalLoginState {
+		return domain.FullUser{}, errExternalLoginFailed()
+	} else if err != nil {
+		return domain.FullUser{}, err
+	}
+
+	if !loginState.Valid() || loginState.Provider != provider || callback.Error != "" {
+		return domain.FullUser{}, errExternalLoginFailed()
+	}
+
+	// Logins can only be completed by the browser that started them, so that leaked callback
+	// urls can not be redeemed and users can not be logged in to an account started by someone else.
+	if loginState.BindingHash == "" || callback.Binding == "" ||
+		subtle.ConstantTimeCompare([]byte(hashKey(callback.Binding)), []byte(loginState.BindingHash)) != 1 {
+		return domain.FullUser{}, errExternalLoginFailed()
+	}
+
+	idToken, err := p.exchangeCode(callback.Code, loginState.CodeVerifier)
+	if err != nil {
+		return domain.FullUser{}, err
+	}
+
+	claims, err := p.verifyIDToken(idToken, loginState.Nonce)
+	if err != nil {
+		return domain.FullUser{}, err
+	}
+
+	u, err := ls.findOrCreateUser(provider, claims)
+	if err != nil {
+		return domain.FullUser{}, err
+	}
+
+	if u.IsLocked(now()) {
+		return domain.FullUser{}, errAccountLocked()
+	}
+
+	return u, nil
+}
+
+func (ls *externalLoginSvc) findOrCreateUser(provider string, claims domain.ExternalClaims) (domain.FullUser, error) {
+	identity, err := ls.identityRepo.Find(provider, claims.Subject)
+	if err == nil {
+		return ls.findLinkedUser(identity)
+	} else if err != repository.ErrNoSuchExternalIdentity {
+		return domain.FullUser{}, err
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		return domain.FullUser{}, errExternalEmailNotVerified()
+	}
+
+	u, err := ls.userRepo.FindByEmail(claims.Email)
+	if err == repository.ErrNoSuchUser {
+		u, err = ls.createUser(claims.Email)
+	} else if err == nil && !u.EmailVerified {
+		// The owner of an unverified account may not own its email, so linking
+		// it could hand the account of the identity owner to whoever registered it.
+		err = errExternalLinkUnverified()
+	}
+	if err != nil {
+		return domain.FullUser{}, err
+	}
+
+	err = ls.identityRepo.Save(domain.NewExternalIdentity(provider, claims.Subject, claims.Email, u.User.ID))
+	if err == repository.ErrExternalIdentityLinked {
+		return domain.FullUser{}, errExternalLoginFailed()
+	} else if err != nil {
+		return domain.FullUser{}, err
+	}
+
+	return u, nil
+}
+
+func (ls *externalLoginSvc) findLinkedUser(identity domain.ExternalIdentity) (domain.FullUser, error) {
+	u, err := ls.userRepo.Find(identity.UserID)
+	if err == repository.ErrNoSuchUser {
+		return domain.FullUser{}, errExternalLoginFailed()
+	} else if err != nil {
+		return domain.FullUser{}, err
+	}
+
+	return u, nil
+}
+
+// createUser creates a user without a password, the email has been verified by the provider.
+func (ls *externalLoginSvc) createUser(email string) (domain.FullUser, error) {
+	u := domain.FullUser{
+		User:          user.New(email, auth.UserRole, nil),
+		Credentials:   domain.StoredCredentials{Email: email},
+		EmailVerified: true,
+	}
+
+	err := ls.userRepo.Save(u)
+	if err == repository.ErrEmailTaken {
+		return domain.FullUser{}, errUserAlreadyExists()
+	} else if err != nil {
+		return domain.FullUser{}, err
+	}
+
+	return u, nil
+}
+
+func errExternalLoginFailed() error {
+	return httputil.NewError("External login failed", http.StatusUnauthorized)
+}
+
+func errExternalLinkUnverified() error {
+	return httputil.NewError("An account with an unverified email already exists", http.StatusConflict)
+}
+
+func errExternalEmailNotVerified() error {
+	return httputil.NewError("External identity has no verified email", http.StatusForbidden)
+}
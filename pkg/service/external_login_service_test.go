@@ -0,0 +1,340 @@
+package service_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/directory/pkg/repository"
+	"github.com/mimir-news/directory/pkg/service"
+	"github.com/mimir-news/pkg/httputil/auth"
+	"github.com/mimir-news/pkg/id"
+	"github.com/mimir-news/pkg/schema/user"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const (
+	testOIDCClientID    = "directory-client"
+	testOIDCRedirectURL = "https://example.com/login/oidc/test/callback"
+)
+
+func TestExternalLoginAuthorizationURL(t *testing.T) {
+	assert := assert.New(t)
+
+	issuer := newTestIssuer(t)
+	defer issuer.server.Close()
+	identityRepo := &repository.MockExternalIdentityRepo{}
+	loginSvc := service.NewExternalLoginService(issuer.providers(), nil, identityRepo)
+
+	authURL, binding, err := loginSvc.AuthorizationURL("test")
+	assert.NoError(err)
+	assert.NotEmpty(binding)
+	assert.True(strings.HasPrefix(authURL, issuer.server.URL+"/authorize?"))
+	parsedURL, err := url.Parse(authURL)
+	assert.NoError(err)
+	query := parsedURL.Query()
+	assert.Equal("code", query.Get("response_type"))
+	assert.Equal(testOIDCClientID, query.Get("client_id"))
+	assert.Equal(testOIDCRedirectURL, query.Get("redirect_uri"))
+	assert.Equal("openid email", query.Get("scope"))
+	assert.Equal("S256", query.Get("code_challenge_method"))
+
+	loginState := identityRepo.SaveLoginStateArg
+	assert.Equal("test", loginState.Provider)
+	assert.Equal(fmt.Sprintf("%x", auth.HashKey(query.Get("state"))), loginState.StateHash)
+	assert.Equal(fmt.Sprintf("%x", auth.HashKey(binding)), loginState.BindingHash)
+	assert.NotEqual(query.Get("state"), binding)
+	assert.Equal(query.Get("nonce"), loginState.Nonce)
+	assert.Equal(s256(loginState.CodeVerifier), query.Get("code_challenge"))
+	assert.True(loginState.Valid())
+
+	// Unknown providers should not be found.
+	identityRepo.UnsetArgs()
+	_, _, err = loginSvc.AuthorizationURL("unknown")
+	assertHTTPStatus(assert, http.StatusNotFound, err)
+	assert.Equal(0, identityRepo.SaveLoginStateInvocation)
+}
+
+func TestRedeemExternalLogin(t *testing.T) {
+	assert := assert.New(t)
+
+	issuer := newTestIssuer(t)
+	defer issuer.server.Close()
+	userRepo := &repository.MockUserRepo{
+		FindByEmailErr: repository.ErrNoSuchUser,
+	}
+	identityRepo := &repository.MockExternalIdentityRepo{
+		FindErr: repository.ErrNoSuchExternalIdentity,
+	}
+	loginSvc := service.NewExternalLoginService(issuer.providers(), userRepo, identityRepo)
+
+	// New users should be created along with their external identity.
+	callback := startTestExternalLogin(assert, loginSvc, identityRepo, issuer)
+	u, err := loginSvc.Redeem("test", callback)
+	assert.NoError(err)
+	assert.Equal(issuer.email, u.User.Email)
+	assert.Equal(auth.UserRole, u.User.Role)
+	assert.True(u.EmailVerified)
+	assert.Equal(u.User.ID, userRepo.SaveArg.User.ID)
+	assert.Equal("", userRepo.SaveArg.Credentials.Password)
+	assert.Equal(fmt.Sprintf("%x", auth.HashKey(callback.State)), identityRepo.ConsumeLoginStateArg)
+	assert.Equal("test", identityRepo.FindArgProvider)
+	assert.Equal(issuer.subject, identityRepo.FindArgSubject)
+	assert.Equal(u.User.ID, identityRepo.SaveArg.UserID)
+	assert.Equal(issuer.subject, identityRepo.SaveArg.Subject)
+	assert.Equal("test", identityRepo.SaveArg.Provider)
+
+	// Linked identities should log in the linked user.
+	storedUser := domain.FullUser{
+		User: user.User{
+			ID:    id.New(),
+			Email: "other@mail.com",
+			Role:  auth.UserRole,
+		},
+	}
+	userRepo.SaveArg = domain.FullUser{}
+	userRepo.FindUser = storedUser
+	identityRepo.FindErr = nil
+	identityRepo.FindIdentity = domain.NewExternalIdentity("test", issuer.subject, issuer.email, storedUser.User.ID)
+	callback = startTestExternalLogin(assert, loginSvc, identityRepo, issuer)
+	u, err = loginSvc.Redeem("test", callback)
+	assert.NoError(err)
+	assert.Equal(storedUser.User.ID, u.User.ID)
+	assert.Equal(storedUser.User.ID, userRepo.FindArg)
+	assert.Equal("", userRepo.SaveArg.User.ID)
+	assert.Equal(0, identityRepo.SaveInvocation)
+
+	// Locked users should not be logged in.
+	lockedUser := storedUser
+	lockedUser.Locked = true
+	userRepo.FindUser = lockedUser
+	callback = startTestExternalLogin(assert, loginSvc, identityRepo, issuer)
+	_, err = loginSvc.Redeem("test", callback)
+	assertHTTPStatus(assert, http.StatusLocked, err)
+
+	// Existing users should be linked by email if both the provider and the user have verified the email.
+	verifiedUser := storedUser
+	verifiedUser.EmailVerified = true
+	identityRepo.FindErr = repository.ErrNoSuchExternalIdentity
+	userRepo.FindByEmailErr = nil
+	userRepo.FindByEmailUser = verifiedUser
+	callback = startTestExternalLogin(assert, loginSvc, identityRepo, issuer)
+	u, err = loginSvc.Redeem("test", callback)
+	assert.NoError(err)
+	assert.Equal(storedUser.User.ID, u.User.ID)
+	assert.Equal(issuer.email, userRepo.FindByEmailArg)
+	assert.Equal(storedUser.User.ID, identityRepo.SaveArg.UserID)
+	assert.Equal("", userRepo.SaveArg.User.ID)
+
+	// Users that have not verified their email should not be linked, since they may not own it.
+	userRepo.FindByEmailUser = storedUser
+	callback = startTestExternalLogin(assert, loginSvc, identityRepo, issuer)
+	_, err = loginSvc.Redeem("test", callback)
+	assertHTTPStatus(assert, http.StatusConflict, err)
+	assert.Equal(0, identityRepo.SaveInvocation)
+	assert.Equal("", userRepo.SaveArg.User.ID)
+	userRepo.FindByEmailUser = verifiedUser
+
+	// Unverified emails should neither be linked nor used to create users.
+	issuer.emailVerified = false
+	callback = startTestExternalLogin(assert, loginSvc, identityRepo, issuer)
+	_, err = loginSvc.Redeem("test", callback)
+	assertHTTPStatus(assert, http.StatusForbidden, err)
+	assert.Equal(0, identityRepo.SaveInvocation)
+	issuer.emailVerified = true
+
+	// ID tokens with the wrong nonce should be rejected.
+	callback = startTestExternalLogin(assert, loginSvc, identityRepo, issuer)
+	issuer.nonces[callback.Code] = "wrong-nonce"
+	_, err = loginSvc.Redeem("test", callback)
+	assertHTTPStatus(assert, http.StatusUnauthorized, err)
+	assert.Equal(0, identityRepo.SaveInvocation)
+
+	// ID tokens signed with an unknown key should be rejected.
+	callback = startTestExternalLogin(assert, loginSvc, identityRepo, issuer)
+	issuer.signingKey, _ = rsa.GenerateKey(rand.Reader, 2048)
+	_, err = loginSvc.Redeem("test", callback)
+	assertHTTPStatus(assert, http.StatusUnauthorized, err)
+	assert.Equal(0, identityRepo.SaveInvocation)
+	issuer.signingKey = issuer.key
+
+	// Codes should not be redeemable without the matching PKCE code verifier.
+	callback = startTestExternalLogin(assert, loginSvc, identityRepo, issuer)
+	identityRepo.ConsumeLoginStateState.CodeVerifier = "wrong-code-verifier"
+	_, err = loginSvc.Redeem("test", callback)
+	assertHTTPStatus(assert, http.StatusUnauthorized, err)
+	assert.Equal(0, identityRepo.SaveInvocation)
+
+	// Expired login states should be rejected.
+	callback = startTestExternalLogin(assert, loginSvc, identityRepo, issuer)
+	identityRepo.ConsumeLoginStateState.ValidTo = time.Now().UTC().Add(-time.Minute)
+	_, err = loginSvc.Redeem("test", callback)
+	assertHTTPStatus(assert, http.StatusUnauthorized, err)
+
+	// Login states should only be redeemable at the provider they were issued for.
+	callback = startTestExternalLogin(assert, loginSvc, identityRepo, issuer)
+	identityRepo.ConsumeLoginStateState.Provider = "other"
+	_, err = loginSvc.Redeem("test", callback)
+	assertHTTPStatus(assert, http.StatusUnauthorized, err)
+
+	// Logins can only be completed with the binding of the browser that started them.
+	callback = startTestExternalLogin(assert, loginSvc, identityRepo, issuer)
+	callback.Binding = "other-binding"
+	_, err = loginSvc.Redeem("test", callback)
+	assertHTTPStatus(assert, http.StatusUnauthorized, err)
+	callback.Binding = ""
+	_, err = loginSvc.Redeem("test", callback)
+	assertHTTPStatus(assert, http.StatusUnauthorized, err)
+	identityRepo.ConsumeLoginStateState.BindingHash = ""
+	callback.Binding = "other-binding"
+	_, err = loginSvc.Redeem("test", callback)
+	assertHTTPStatus(assert, http.StatusUnauthorized, err)
+	assert.Equal(0, identityRepo.SaveInvocation)
+
+	// Unknown login states should be rejected.
+	identityRepo.ConsumeLoginStateErr = repository.ErrNoSuchExternalLoginState
+	_, err = loginSvc.Redeem("test", domain.ExternalLoginCallback{Code: "some-code", State: "unknown-state"})
+	assertHTTPStatus(assert, http.StatusUnauthorized, err)
+	assert.Equal(0, identityRepo.SaveInvocation)
+}
+
+// startTestExternalLogin starts a login and lets the test issuer authorize it, returning the callback parameters.
+func startTestExternalLogin(
+	assert *assert.Assertions, loginSvc service.ExternalLoginService,
+	identityRepo *repository.MockExternalIdentityRepo, issuer *testIssuer) domain.ExternalLoginCallback {
+	identityRepo.UnsetArgs()
+	authURL, binding, err := loginSvc.AuthorizationURL("test")
+	assert.NoError(err)
+	identityRepo.ConsumeLoginStateState = identityRepo.SaveLoginStateArg
+
+	parsedURL, err := url.Parse(authURL)
+	assert.NoError(err)
+	query := parsedURL.Query()
+	code := id.New()
+	issuer.challenges[code] = query.Get("code_challenge")
+	issuer.nonces[code] = query.Get("nonce")
+	return domain.ExternalLoginCallback{Code: code, State: query.Get("state"), Binding: binding}
+}
+
+// testIssuer stand-in OpenID Connect provider serving discovery, signing keys and a token endpoint.
+type testIssuer struct {
+	server        *httptest.Server
+	key           *rsa.PrivateKey
+	signingKey    *rsa.PrivateKey
+	keyID         string
+	subject       string
+	email         string
+	emailVerified bool
+	challenges    map[string]string
+	nonces        map[string]string
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issuer := &testIssuer{
+		key:           key,
+		signingKey:    key,
+		keyID:         id.New(),
+		subject:       id.New(),
+		email:         "mail@mail.com",
+		emailVerified: true,
+		challenges:    make(map[string]string),
+		nonces:        make(map[string]string),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", issuer.handleDiscovery)
+	mux.HandleFunc("/jwks", issuer.handleJWKS)
+	mux.HandleFunc("/token", issuer.handleToken)
+	issuer.server = httptest.NewServer(mux)
+	return issuer
+}
+
+func (i *testIssuer) providers() []service.OIDCProviderConfig {
+	return []service.OIDCProviderConfig{
+		{
+			Name:         "test",
+			Issuer:       i.server.URL,
+			ClientID:     testOIDCClientID,
+			ClientSecret: "client-secret",
+			RedirectURL:  testOIDCRedirectURL,
+		},
+	}
+}
+
+func (i *testIssuer) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]string{
+		"issuer":                 i.server.URL,
+		"authorization_endpoint": i.server.URL + "/authorize",
+		"token_endpoint":         i.server.URL + "/token",
+		"jwks_uri":               i.server.URL + "/jwks",
+	})
+}
+
+func (i *testIssuer) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(jose.JSONWebKeySet{
+		Keys: []jose.JSONWebKey{
+			{Key: &i.key.PublicKey, KeyID: i.keyID, Algorithm: string(jose.RS256), Use: "sig"},
+		},
+	})
+}
+
+// handleToken issues an ID token if the code was issued and the PKCE code verifier matches its challenge.
+func (i *testIssuer) handleToken(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	code := r.PostForm.Get("code")
+	challenge, ok := i.challenges[code]
+	if !ok || r.PostForm.Get("client_id") != testOIDCClientID || r.PostForm.Get("redirect_uri") != testOIDCRedirectURL ||
+		s256(r.PostForm.Get("code_verifier")) != challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	delete(i.challenges, code)
+
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.RS256, Key: jose.JSONWebKey{Key: i.signingKey, KeyID: i.keyID}}, nil)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	idToken, err := jwt.Signed(signer).Claims(jwt.Claims{
+		Issuer:   i.server.URL,
+		Subject:  i.subject,
+		Audience: jwt.Audience{testOIDCClientID},
+		IssuedAt: jwt.NewNumericDate(now),
+		Expiry:   jwt.NewNumericDate(now.Add(time.Hour)),
+	}).Claims(map[string]interface{}{
+		"nonce":          i.nonces[code],
+		"email":          i.email,
+		"email_verified": i.emailVerified,
+	}).CompactSerialize()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"id_token": idToken, "token_type": "Bearer"})
+}
+
+func s256(value string) string {
+	hash := sha256.Sum256([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
@@ -0,0 +1,62 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"net/smtp"
+
+	"github.com/mimir-news/directory/pkg/domain"
+)
+
+// Mailer interface for sending emails to users.
+type Mailer interface {
+	Send(email domain.Email) error
+}
+
+// NewSMTPMailer creates a new Mailer which sends emails through an SMTP server.
+func NewSMTPMailer(host, port, username, password, sender string) Mailer {
+	return &smtpMailer{
+		addr:   net.JoinHostPort(host, port),
+		auth:   smtp.PlainAuth("", username, password, host),
+		sender: sender,
+	}
+}
+
+type smtpMailer struct {
+	addr   string
+	auth   smtp.Auth
+	sender string
+}
+
+// Send sends an email through the configured SMTP server.
+func (m *smtpMailer) Send(email domain.Email) error {
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s\r\n",
+		m.sender, email.To, email.Subject, email.Body)
+
+	return smtp.SendMail(m.addr, m.auth, m.sender, []string{email.To}, []byte(msg))
+}
+
+// MockMailer mock implementation of Mailer which keeps sent emails in an in-memory outbox.
+type MockMailer struct {
+	Outbox  []domain.Email
+	SendErr error
+}
+
+// Send mock implementation of sending an email.
+func (m *MockMailer) Send(email domain.Email) error {
+	if m.SendErr != nil {
+		return m.SendErr
+	}
+
+	m.Outbox = append(m.Outbox, email)
+	return nil
+}
+
+// LastSent returns the last email put in the outbox.
+func (m *MockMailer) LastSent() (domain.Email, bool) {
+	if len(m.Outbox) == 0 {
+		return domain.Email{}, false
+	}
+
+	return m.Outbox[len(m.Outbox)-1], true
+}
@@ -0,0 +1,406 @@
+package service
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/directory/pkg/repository"
+	"github.com/mimir-news/pkg/httputil"
+)
+
+const (
+	totpIssuer          = "Mimir News"
+	mfaChallengeTTL     = 5 * time.Minute
+	recoveryCodeCount   = 10
+	recoveryCodeLength  = 5
+	recoveryCodeDivider = "-"
+)
+
+// MFAService service responsible for multi factor authentication using TOTP and recovery codes.
+type MFAService interface {
+	EnrollTOTP(userID, password string) (domain.TOTPEnrollment, error)
+	ConfirmTOTP(userID, code string) (domain.RecoveryCodes, error)
+	DisableTOTP(userID string, reauth domain.MFAReauthentication, client domain.ClientInfo) error
+	RegenerateRecoveryCodes(userID string, reauth domain.MFAReauthentication, client domain.ClientInfo) (domain.RecoveryCodes, error)
+	Challenge(userID string) (domain.MFAChallenge, error)
+	VerifyChallenge(verification domain.MFAVerification, client domain.ClientInfo) (domain.FullUser, error)
+}
+
+// NewMFAService creates a new MFAService using the default implementation.
+func NewMFAService(
+	pwdSvc *PasswordService, keyring EncryptionKeyring, userRepo repository.UserRepo,
+	recoveryCodeRepo repository.RecoveryCodeRepo, credentialRepo repository.OneTimeCredentialRepo,
+	events SecurityEventRecorder) MFAService {
+	return &mfaSvc{
+		passwordSvc:      pwdSvc,
+		encryptor:        newEncryptionScheme(keyring),
+		userRepo:         userRepo,
+		recoveryCodeRepo: recoveryCodeRepo,
+		credentialRepo:   credentialRepo,
+		events:           events,
+	}
+}
+
+type mfaSvc struct {
+	passwordSvc      *PasswordService
+	encryptor        encryptionScheme
+	userRepo         repository.UserRepo
+	recoveryCodeRepo repository.RecoveryCodeRepo
+	credentialRepo   repository.OneTimeCredentialRepo
+	events           SecurityEventRecorder
+}
+
+// EnrollTOTP verifies the current password of a user and generates and stores a new TOTP secret.
+// The secret is not used as a second factor until the enrollment has been confirmed.
+func (ms *mfaSvc) EnrollTOTP(userID, password string) (domain.TOTPEnrollment, error) {
+	u, err := ms.findUser(userID)
+	if err != nil {
+		return domain.TOTPEnrollment{}, err
+	}
+
+	if u.TOTP.Enabled {
+		return domain.TOTPEnrollment{}, errMFAAlreadyEnabled()
+	}
+
+	err = ms.verifyPassword(u, password)
+	if err != nil {
+		return domain.TOTPEnrollment{}, err
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return domain.TOTPEnrollment{}, err
+	}
+
+	encryptedSecret, err := ms.encryptor.encrypt(secret)
+	if err != nil {
+		return domain.TOTPEnrollment{}, err
+	}
+
+	err = ms.userRepo.SaveTOTPSecret(userID, encryptedSecret)
+	if err != nil {
+		return domain.TOTPEnrollment{}, err
+	}
+
+	return domain.TOTPEnrollment{
+		Secret: secret,
+		URI:    totpURI(totpIssuer, u.User.Email, secret),
+	}, nil
+}
+
+// ConfirmTOTP enables TOTP as a second factor if a valid code is provided
+// and issues a new set of recovery codes.
+func (ms *mfaSvc) ConfirmTOTP(userID, code string) (domain.RecoveryCodes, error) {
+	u, err := ms.findUser(userID)
+	if err != nil {
+		return domain.RecoveryCodes{}, err
+	}
+
+	if u.TOTP.Enabled {
+		return domain.RecoveryCodes{}, errMFAAlreadyEnabled()
+	}
+
+	if u.TOTP.Secret == "" {
+		return domain.RecoveryCodes{}, httputil.ErrNotFound()
+	}
+
+	err = ms.verifyTOTP(u, code)
+	if err == ErrInvalidCredentials {
+		return domain.RecoveryCodes{}, errInvalidMFACode()
+	} else if err != nil {
+		return domain.RecoveryCodes{}, err
+	}
+
+	err = ms.userRepo.EnableTOTP(userID)
+	if err != nil {
+		return domain.RecoveryCodes{}, err
+	}
+
+	return ms.issueRecoveryCodes(userID)
+}
+
+// DisableTOTP removes the TOTP secret and recovery codes of a user after its current password
+// and second factor have been verified. A new TOTP generator can be enrolled once disabled.
+func (ms *mfaSvc) DisableTOTP(userID string, reauth domain.MFAReauthentication, client domain.ClientInfo) error {
+	u, err := ms.findUser(userID)
+	if err != nil {
+		return err
+	}
+
+	err = ms.reauthenticate(u, reauth)
+	if err != nil {
+		return err
+	}
+
+	err = ms.userRepo.DisableTOTP(userID)
+	if err != nil {
+		return err
+	}
+
+	err = ms.recoveryCodeRepo.Replace(userID, nil)
+	if err != nil {
+		return err
+	}
+
+	return ms.events.Record(domain.NewAccountEvent(domain.MFADisableEvent, userID, "", client))
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of a user after its current password
+// and second factor have been verified.
+func (ms *mfaSvc) RegenerateRecoveryCodes(userID string, reauth domain.MFAReauthentication, client domain.ClientInfo) (domain.RecoveryCodes, error) {
+	u, err := ms.findUser(userID)
+	if err != nil {
+		return domain.RecoveryCodes{}, err
+	}
+
+	err = ms.reauthenticate(u, reauth)
+	if err != nil {
+		return domain.RecoveryCodes{}, err
+	}
+
+	recoveryCodes, err := ms.issueRecoveryCodes(userID)
+	if err != nil {
+		return domain.RecoveryCodes{}, err
+	}
+
+	return recoveryCodes, ms.events.Record(domain.NewAccountEvent(domain.RecoveryCodesRegenerateEvent, userID, "", client))
+}
+
+// Challenge issues an MFA challenge which must be completed with a second factor to finish a login.
+func (ms *mfaSvc) Challenge(userID string) (domain.MFAChallenge, error) {
+	token, err := generateKey()
+	if err != nil {
+		return domain.MFAChallenge{}, err
+	}
+
+	credential := domain.NewOneTimeCredential(domain.MFAChallengeCredential, hashKey(token), userID, mfaChallengeTTL)
+	err = ms.credentialRepo.Save(credential)
+	if err != nil {
+		return domain.MFAChallenge{}, err
+	}
+
+	return domain.MFAChallenge{
+		MFARequired:    true,
+		ChallengeToken: token,
+		ValidTo:        credential.ValidTo,
+	}, nil
+}
+
+// VerifyChallenge completes an MFA challenge and returns the user that it was issued to.
+// Failed attempts count towards the lockout policy of the user and are recorded as failed logins.
+func (ms *mfaSvc) VerifyChallenge(verification domain.MFAVerification, client domain.ClientInfo) (domain.FullUser, error) {
+	credential, err := ms.credentialRepo.FindByKey(domain.MFAChallengeCredential, hashKey(verification.ChallengeToken))
+	if err == repository.ErrNoSuchCredential {
+		return domain.FullUser{}, errInvalidMFAChallenge()
+	} else if err != nil {
+		return domain.FullUser{}, err
+	}
+
+	if !credential.Valid() || credential.Purpose != domain.MFAChallengeCredential {
+		return domain.FullUser{}, errInvalidMFAChallenge()
+	}
+
+	u, err := ms.userRepo.Find(credential.UserID)
+	if err == repository.ErrNoSuchUser {
+		return domain.FullUser{}, errInvalidMFAChallenge()
+	} else if err != nil {
+		return domain.FullUser{}, err
+	}
+
+	if u.User.Email == "" || !u.TOTP.Enabled {
+		return domain.FullUser{}, errInvalidMFAChallenge()
+	}
+
+	if u.IsLocked(now()) {
+		return domain.FullUser{}, errAccountLocked()
+	}
+
+	err = ms.verifySecondFactor(u, verification)
+	if err == ErrInvalidCredentials {
+		lockErr := ms.passwordSvc.RecordFailedLogin(u.User.ID)
+		if lockErr != nil {
+			return domain.FullUser{}, lockErr
+		}
+
+		eventErr := ms.events.Record(domain.NewSecurityEvent(domain.LoginFailureEvent, u.User.ID, "", client))
+		if eventErr != nil {
+			return domain.FullUser{}, eventErr
+		}
+		return domain.FullUser{}, errInvalidMFACode()
+	} else if err != nil {
+		return domain.FullUser{}, err
+	}
+
+	err = ms.credentialRepo.MarkUsed(credential.ID)
+	if err == repository.ErrNoSuchCredential {
+		return domain.FullUser{}, errInvalidMFAChallenge()
+	} else if err != nil {
+		return domain.FullUser{}, err
+	}
+
+	err = ms.passwordSvc.ResetLoginFailures(u)
+	if err != nil {
+		return domain.FullUser{}, err
+	}
+
+	return u, nil
+}
+
+// reauthenticate verifies the current password and second factor of a user with TOTP enabled.
+// Failed second factors count towards the lockout policy of the user.
+func (ms *mfaSvc) reauthenticate(u domain.FullUser, reauth domain.MFAReauthentication) error {
+	if !u.TOTP.Enabled {
+		return errMFANotEnabled()
+	}
+
+	err := ms.verifyPassword(u, reauth.Password)
+	if err != nil {
+		return err
+	}
+
+	err = ms.verifySecondFactor(u, domain.MFAVerification{Code: reauth.Code, RecoveryCode: reauth.RecoveryCode})
+	if err == ErrInvalidCredentials {
+		lockErr := ms.passwordSvc.RecordFailedLogin(u.User.ID)
+		if lockErr != nil {
+			return lockErr
+		}
+		return errInvalidMFACode()
+	} else if err != nil {
+		return err
+	}
+
+	return ms.passwordSvc.ResetLoginFailures(u)
+}
+
+// verifyPassword checks the current password of a user, failed attempts count towards the lockout policy of the user.
+func (ms *mfaSvc) verifyPassword(u domain.FullUser, password string) error {
+	err := ms.passwordSvc.VerifyUser(u, password)
+	if err == ErrAccountLocked {
+		return errAccountLocked()
+	} else if err == ErrInvalidCredentials {
+		return httputil.ErrForbidden()
+	}
+
+	return err
+}
+
+func (ms *mfaSvc) verifySecondFactor(u domain.FullUser, verification domain.MFAVerification) error {
+	if verification.RecoveryCode == "" {
+		return ms.verifyTOTP(u, verification.Code)
+	}
+
+	hashedCode := hashKey(normalizeRecoveryCode(verification.RecoveryCode), u.User.ID)
+	err := ms.recoveryCodeRepo.Use(u.User.ID, hashedCode)
+	if err == repository.ErrNoSuchRecoveryCode {
+		return ErrInvalidCredentials
+	}
+
+	return err
+}
+
+// verifyTOTP checks a TOTP code against the secret of a user, allowing for clock skew between the client and server.
+// Each time step can only be used once so that intercepted codes cannot be replayed.
+func (ms *mfaSvc) verifyTOTP(u domain.FullUser, code string) error {
+	secret, err := ms.encryptor.decrypt(u.TOTP.Secret)
+	if err != nil {
+		return err
+	}
+
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return err
+	}
+
+	currentStep := totpStep(now())
+	for step := currentStep - totpSkew; step <= currentStep+totpSkew; step++ {
+		if step <= u.TOTP.LastUsedStep {
+			continue
+		}
+
+		if subtle.ConstantTimeCompare([]byte(code), []byte(totpCode(key, step))) != 1 {
+			continue
+		}
+
+		err = ms.userRepo.UseTOTPStep(u.User.ID, step)
+		if err == repository.ErrTOTPStepUsed {
+			return ErrInvalidCredentials
+		}
+		return err
+	}
+
+	return ErrInvalidCredentials
+}
+
+// issueRecoveryCodes replaces the recovery codes of a user and returns the new codes in plaintext.
+func (ms *mfaSvc) issueRecoveryCodes(userID string) (domain.RecoveryCodes, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashedCodes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return domain.RecoveryCodes{}, err
+		}
+
+		codes = append(codes, code)
+		hashedCodes = append(hashedCodes, hashKey(normalizeRecoveryCode(code), userID))
+	}
+
+	err := ms.recoveryCodeRepo.Replace(userID, hashedCodes)
+	if err != nil {
+		return domain.RecoveryCodes{}, err
+	}
+
+	return domain.RecoveryCodes{Codes: codes}, nil
+}
+
+func (ms *mfaSvc) findUser(userID string) (domain.FullUser, error) {
+	u, err := ms.userRepo.Find(userID)
+	if err == repository.ErrNoSuchUser {
+		return domain.FullUser{}, httputil.ErrNotFound()
+	} else if err != nil {
+		return domain.FullUser{}, err
+	}
+
+	if u.User.Email == "" {
+		return domain.FullUser{}, httputil.ErrNotFound()
+	}
+
+	return u, nil
+}
+
+// generateRecoveryCode generates a random recovery code formatted as two groups of hex characters.
+func generateRecoveryCode() (string, error) {
+	key, err := generateKey()
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s%s%s", key[:recoveryCodeLength], recoveryCodeDivider, key[recoveryCodeLength:2*recoveryCodeLength]), nil
+}
+
+// normalizeRecoveryCode removes formatting from a recovery code.
+func normalizeRecoveryCode(code string) string {
+	normalized := strings.Replace(code, recoveryCodeDivider, "", -1)
+	normalized = strings.Replace(normalized, " ", "", -1)
+	return strings.ToLower(normalized)
+}
+
+func errMFAAlreadyEnabled() error {
+	return httputil.NewError("Multi factor authentication is already enabled", http.StatusConflict)
+}
+
+func errMFANotEnabled() error {
+	return httputil.NewError("Multi factor authentication is not enabled", http.StatusConflict)
+}
+
+func errInvalidMFAChallenge() error {
+	return httputil.NewError("Invalid or expired MFA challenge", http.StatusUnauthorized)
+}
+
+func errInvalidMFACode() error {
+	return httputil.NewError("Invalid MFA code", http.StatusUnauthorized)
+}
@@ -0,0 +1,406 @@
+package service_test
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/directory/pkg/repository"
+	"github.com/mimir-news/directory/pkg/service"
+	"github.com/mimir-news/pkg/httputil/auth"
+	"github.com/mimir-news/pkg/id"
+	"github.com/mimir-news/pkg/schema/user"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTOTPCode(t *testing.T) {
+	assert := assert.New(t)
+
+	// Test vectors from RFC 6238 truncated to 6 digits, the secret is the base32 encoding of "12345678901234567890".
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	cases := []struct {
+		at   int64
+		code string
+	}{
+		{at: 59, code: "287082"},
+		{at: 1111111109, code: "081804"},
+		{at: 1234567890, code: "005924"},
+		{at: 2000000000, code: "279037"},
+	}
+
+	for _, tc := range cases {
+		code, err := service.TOTPCode(secret, time.Unix(tc.at, 0))
+		assert.NoError(err)
+		assert.Equal(tc.code, code)
+	}
+
+	_, err := service.TOTPCode("not-base-32!", time.Now())
+	assert.Error(err)
+}
+
+func TestEnrollAndConfirmTOTP(t *testing.T) {
+	assert := assert.New(t)
+
+	userID := id.New()
+	storedUser := domain.FullUser{
+		User: user.User{
+			ID:    userID,
+			Email: "mail@mail.com",
+		},
+		Credentials: domain.StoredCredentials{
+			Email:    "mail@mail.com",
+			Password: "S5UeZOWCDkIfP/5LUDpyhIY0l6+aow+CmkBEVtHqpebhe04vb6kDbPaD/wo05fs6x1lvJfI/6YZ66zbQ8X2lHaEThp4f1Zl0exk7j/wow740KbWZHf9DSA==", // Hashed and encrypted password.
+			Salt:     "3MQEKd3NVnU+WQFQxo8JpYWrTrqXOiwro4MwLwnsckWXinE=",                                                                         // Encrypted salt
+		},
+	}
+
+	userRepo := &repository.MockUserRepo{
+		FindUser: storedUser,
+	}
+	recoveryCodeRepo := &repository.MockRecoveryCodeRepo{}
+	keyring := service.NewEncryptionKeyring("my-encryption-key")
+	passwordSvc := service.NewPasswordService(userRepo, "my-pepper", keyring, service.DefaultHashingConfig, service.DefaultLockoutPolicy)
+	mfaSvc := service.NewMFAService(passwordSvc, keyring, userRepo, recoveryCodeRepo, nil, nil)
+
+	// Enrolling without the current password should fail and count as a failed login.
+	_, err := mfaSvc.EnrollTOTP(userID, "wrong-password")
+	assertHTTPStatus(assert, http.StatusForbidden, err)
+	assert.Equal(0, userRepo.SaveTOTPSecretInvocation)
+	assert.Equal(userID, userRepo.RecordFailedLoginArgUserID)
+
+	enrollment, err := mfaSvc.EnrollTOTP(userID, "super-secret-password")
+	assert.NoError(err)
+	assert.Equal(userID, userRepo.FindArg)
+	assert.Equal(userID, userRepo.SaveTOTPSecretArgUserID)
+	assert.NotEqual("", enrollment.Secret)
+	assert.NotEqual(enrollment.Secret, userRepo.SaveTOTPSecretArgSecret)
+	assert.True(strings.HasPrefix(enrollment.URI, "otpauth://totp/"))
+	assert.True(strings.Contains(enrollment.URI, "secret="+enrollment.Secret))
+
+	// Confirming with a wrong code should not enable TOTP.
+	storedUser.TOTP.Secret = userRepo.SaveTOTPSecretArgSecret
+	userRepo.FindUser = storedUser
+	_, err = mfaSvc.ConfirmTOTP(userID, "000000x")
+	assertHTTPStatus(assert, http.StatusUnauthorized, err)
+	assert.Equal(0, userRepo.EnableTOTPInvocation)
+	assert.Equal(0, recoveryCodeRepo.ReplaceInvocation)
+
+	at := time.Now()
+	code, err := service.TOTPCode(enrollment.Secret, at)
+	assert.NoError(err)
+	recoveryCodes, err := mfaSvc.ConfirmTOTP(userID, code)
+	assert.NoError(err)
+	assert.Equal(userID, userRepo.UseTOTPStepArgUserID)
+	assert.Equal(at.Unix()/30, userRepo.UseTOTPStepArgStep)
+	assert.Equal(userID, userRepo.EnableTOTPArg)
+	assert.Equal(10, len(recoveryCodes.Codes))
+	assert.Equal(userID, recoveryCodeRepo.ReplaceArgUserID)
+	assert.Equal(len(recoveryCodes.Codes), len(recoveryCodeRepo.ReplaceArgCodes))
+	for i, recoveryCode := range recoveryCodes.Codes {
+		assert.NotEqual(recoveryCode, recoveryCodeRepo.ReplaceArgCodes[i])
+	}
+
+	// Codes whose time step has already been used should be rejected.
+	userRepo.UseTOTPStepErr = repository.ErrTOTPStepUsed
+	userRepo.EnableTOTPInvocation = 0
+	_, err = mfaSvc.ConfirmTOTP(userID, code)
+	assertHTTPStatus(assert, http.StatusUnauthorized, err)
+	assert.Equal(0, userRepo.EnableTOTPInvocation)
+
+	// Users with TOTP enabled should not be able to enroll again.
+	storedUser.TOTP.Enabled = true
+	userRepo.FindUser = storedUser
+	userRepo.SaveTOTPSecretInvocation = 0
+	_, err = mfaSvc.EnrollTOTP(userID, "super-secret-password")
+	assertHTTPStatus(assert, http.StatusConflict, err)
+	assert.Equal(0, userRepo.SaveTOTPSecretInvocation)
+
+	// Deleted users should not be found.
+	userRepo.FindUser = domain.FullUser{User: user.User{ID: userID}}
+	_, err = mfaSvc.EnrollTOTP(userID, "super-secret-password")
+	assertHTTPStatus(assert, http.StatusNotFound, err)
+}
+
+func TestVerifyMFAChallenge(t *testing.T) {
+	assert := assert.New(t)
+
+	userID := id.New()
+	keyring := service.NewEncryptionKeyring("my-encryption-key")
+	userRepo := &repository.MockUserRepo{
+		FindUser: domain.FullUser{
+			User: user.User{
+				ID:    userID,
+				Email: "mail@mail.com",
+			},
+			Credentials: domain.StoredCredentials{
+				Email:    "mail@mail.com",
+				Password: "S5UeZOWCDkIfP/5LUDpyhIY0l6+aow+CmkBEVtHqpebhe04vb6kDbPaD/wo05fs6x1lvJfI/6YZ66zbQ8X2lHaEThp4f1Zl0exk7j/wow740KbWZHf9DSA==", // Hashed and encrypted password.
+				Salt:     "3MQEKd3NVnU+WQFQxo8JpYWrTrqXOiwro4MwLwnsckWXinE=",                                                                         // Encrypted salt
+			},
+		},
+	}
+	recoveryCodeRepo := &repository.MockRecoveryCodeRepo{}
+	credentialRepo := &repository.MockOneTimeCredentialRepo{}
+	passwordSvc := service.NewPasswordService(userRepo, "my-pepper", keyring, service.DefaultHashingConfig, service.DefaultLockoutPolicy)
+	events := &service.MockEventRecorder{}
+	mfaSvc := service.NewMFAService(passwordSvc, keyring, userRepo, recoveryCodeRepo, credentialRepo, events)
+	client := domain.ClientInfo{IP: "10.0.0.1", UserAgent: "test-agent"}
+
+	enrollment, err := mfaSvc.EnrollTOTP(userID, "super-secret-password")
+	assert.NoError(err)
+	storedUser := userRepo.FindUser
+	storedUser.TOTP = domain.TOTP{
+		Secret:  userRepo.SaveTOTPSecretArgSecret,
+		Enabled: true,
+	}
+	storedUser.LoginFailures.Attempts = 2
+	userRepo.FindUser = storedUser
+
+	challenge, err := mfaSvc.Challenge(userID)
+	assert.NoError(err)
+	assert.True(challenge.MFARequired)
+	assert.NotEqual("", challenge.ChallengeToken)
+	credential := credentialRepo.SaveArg
+	assert.Equal(domain.MFAChallengeCredential, credential.Purpose)
+	assert.Equal(userID, credential.UserID)
+	assert.Equal(fmt.Sprintf("%x", auth.HashKey(challenge.ChallengeToken)), credential.Key)
+	assert.NotEqual(challenge.ChallengeToken, credential.Key)
+	assert.True(credential.ValidTo.Before(time.Now().UTC().Add(10 * time.Minute)))
+	credentialRepo.FindByKeyCredential = credential
+
+	code, err := service.TOTPCode(enrollment.Secret, time.Now())
+	assert.NoError(err)
+	u, err := mfaSvc.VerifyChallenge(domain.MFAVerification{ChallengeToken: challenge.ChallengeToken, Code: code}, client)
+	assert.NoError(err)
+	assert.Equal(userID, u.User.ID)
+	assert.Equal(credential.Key, credentialRepo.FindByKeyArg)
+	assert.Equal(credential.ID, credentialRepo.MarkUsedArg)
+	assert.Equal(userID, userRepo.UseTOTPStepArgUserID)
+	assert.Equal(userID, userRepo.ResetLoginFailuresArg)
+	assert.Equal(0, userRepo.RecordFailedLoginInvocation)
+	assert.Equal(0, len(events.Events))
+
+	// Recovery codes should be accepted in place of a TOTP code.
+	credentialRepo.UnsetArgs()
+	u, err = mfaSvc.VerifyChallenge(domain.MFAVerification{ChallengeToken: challenge.ChallengeToken, RecoveryCode: "ABCDE-12345"}, client)
+	assert.NoError(err)
+	assert.Equal(userID, u.User.ID)
+	assert.Equal(userID, recoveryCodeRepo.UseArgUserID)
+	assert.Equal(fmt.Sprintf("%x", auth.HashKey("abcde12345", userID)), recoveryCodeRepo.UseArgCode)
+	assert.Equal(credential.ID, credentialRepo.MarkUsedArg)
+
+	// Invalid codes should be rejected and counted as failed logins.
+	credentialRepo.UnsetArgs()
+	recoveryCodeRepo.UseErr = repository.ErrNoSuchRecoveryCode
+	_, err = mfaSvc.VerifyChallenge(domain.MFAVerification{ChallengeToken: challenge.ChallengeToken, RecoveryCode: "used-code"}, client)
+	assertHTTPStatus(assert, http.StatusUnauthorized, err)
+	assert.Equal(userID, userRepo.RecordFailedLoginArgUserID)
+	assert.Equal(0, credentialRepo.MarkUsedInvocation)
+	assert.Equal(1, len(events.Events))
+	assert.Equal(domain.LoginFailureEvent, events.Events[0].Type)
+	assert.Equal(userID, events.Events[0].UserID)
+	assert.Equal(client, events.Events[0].Client)
+
+	userRepo.UseTOTPStepErr = repository.ErrTOTPStepUsed
+	userRepo.RecordFailedLoginInvocation = 0
+	_, err = mfaSvc.VerifyChallenge(domain.MFAVerification{ChallengeToken: challenge.ChallengeToken, Code: code}, client)
+	assertHTTPStatus(assert, http.StatusUnauthorized, err)
+	assert.Equal(1, userRepo.RecordFailedLoginInvocation)
+	assert.Equal(0, credentialRepo.MarkUsedInvocation)
+
+	// Locked users should not be able to complete challenges.
+	lockedUser := storedUser
+	lockedUser.LoginFailures.LockedUntil = time.Now().UTC().Add(time.Minute)
+	userRepo.FindUser = lockedUser
+	userRepo.UseTOTPStepErr = nil
+	_, err = mfaSvc.VerifyChallenge(domain.MFAVerification{ChallengeToken: challenge.ChallengeToken, Code: code}, client)
+	assertHTTPStatus(assert, http.StatusLocked, err)
+	assert.Equal(0, credentialRepo.MarkUsedInvocation)
+	userRepo.FindUser = storedUser
+
+	// Used challenges should be rejected.
+	usedCredential := credential
+	usedCredential.HasBeenUsed = true
+	credentialRepo.FindByKeyCredential = usedCredential
+	_, err = mfaSvc.VerifyChallenge(domain.MFAVerification{ChallengeToken: challenge.ChallengeToken, Code: code}, client)
+	assertHTTPStatus(assert, http.StatusUnauthorized, err)
+	assert.Equal(0, credentialRepo.MarkUsedInvocation)
+
+	// Unknown challenges should be rejected.
+	credentialRepo.FindByKeyErr = repository.ErrNoSuchCredential
+	_, err = mfaSvc.VerifyChallenge(domain.MFAVerification{ChallengeToken: "wrong-token", Code: code}, client)
+	assertHTTPStatus(assert, http.StatusUnauthorized, err)
+}
+
+func TestDisableTOTPAndRegenerateRecoveryCodes(t *testing.T) {
+	assert := assert.New(t)
+
+	userID := id.New()
+	keyring := service.NewEncryptionKeyring("my-encryption-key")
+	userRepo := &repository.MockUserRepo{
+		FindUser: domain.FullUser{
+			User: user.User{
+				ID:    userID,
+				Email: "mail@mail.com",
+			},
+			Credentials: domain.StoredCredentials{
+				Email:    "mail@mail.com",
+				Password: "S5UeZOWCDkIfP/5LUDpyhIY0l6+aow+CmkBEVtHqpebhe04vb6kDbPaD/wo05fs6x1lvJfI/6YZ66zbQ8X2lHaEThp4f1Zl0exk7j/wow740KbWZHf9DSA==", // Hashed and encrypted password.
+				Salt:     "3MQEKd3NVnU+WQFQxo8JpYWrTrqXOiwro4MwLwnsckWXinE=",                                                                         // Encrypted salt
+			},
+		},
+	}
+	recoveryCodeRepo := &repository.MockRecoveryCodeRepo{}
+	passwordSvc := service.NewPasswordService(userRepo, "my-pepper", keyring, service.DefaultHashingConfig, service.DefaultLockoutPolicy)
+	events := &service.MockEventRecorder{}
+	mfaSvc := service.NewMFAService(passwordSvc, keyring, userRepo, recoveryCodeRepo, nil, events)
+	client := domain.ClientInfo{IP: "10.0.0.1", UserAgent: "test-agent"}
+
+	enrollment, err := mfaSvc.EnrollTOTP(userID, "super-secret-password")
+	assert.NoError(err)
+
+	// Recovery codes should not be regenerated before TOTP has been enabled.
+	reauth := domain.MFAReauthentication{Password: "super-secret-password", RecoveryCode: "ABCDE-12345"}
+	_, err = mfaSvc.RegenerateRecoveryCodes(userID, reauth, client)
+	assertHTTPStatus(assert, http.StatusConflict, err)
+	assert.Equal(0, recoveryCodeRepo.ReplaceInvocation)
+
+	storedUser := userRepo.FindUser
+	storedUser.TOTP = domain.TOTP{
+		Secret:  userRepo.SaveTOTPSecretArgSecret,
+		Enabled: true,
+	}
+	userRepo.FindUser = storedUser
+
+	recoveryCodes, err := mfaSvc.RegenerateRecoveryCodes(userID, reauth, client)
+	assert.NoError(err)
+	assert.Equal(10, len(recoveryCodes.Codes))
+	assert.Equal(fmt.Sprintf("%x", auth.HashKey("abcde12345", userID)), recoveryCodeRepo.UseArgCode)
+	assert.Equal(userID, recoveryCodeRepo.ReplaceArgUserID)
+	assert.Equal(len(recoveryCodes.Codes), len(recoveryCodeRepo.ReplaceArgCodes))
+	assert.Equal(1, len(events.Events))
+	assert.Equal(domain.RecoveryCodesRegenerateEvent, events.Events[0].Type)
+	assert.Equal(userID, events.Events[0].ActorID)
+	assert.Equal(client, events.Events[0].Client)
+
+	// Wrong passwords should be rejected before the second factor is used.
+	recoveryCodeRepo.UnsetArgs()
+	reauth.Password = "wrong-password"
+	_, err = mfaSvc.RegenerateRecoveryCodes(userID, reauth, client)
+	assertHTTPStatus(assert, http.StatusForbidden, err)
+	assert.Equal(0, recoveryCodeRepo.UseInvocation)
+	assert.Equal(0, recoveryCodeRepo.ReplaceInvocation)
+	assert.Equal(userID, userRepo.RecordFailedLoginArgUserID)
+
+	// Wrong second factors should be rejected and counted as failed logins.
+	userRepo.RecordFailedLoginInvocation = 0
+	err = mfaSvc.DisableTOTP(userID, domain.MFAReauthentication{Password: "super-secret-password", Code: "000000x"}, client)
+	assertHTTPStatus(assert, http.StatusUnauthorized, err)
+	assert.Equal(1, userRepo.RecordFailedLoginInvocation)
+	assert.Equal(0, userRepo.DisableTOTPInvocation)
+	assert.Equal(0, recoveryCodeRepo.ReplaceInvocation)
+
+	// Locked users should not be able to disable TOTP.
+	lockedUser := storedUser
+	lockedUser.LoginFailures.LockedUntil = time.Now().UTC().Add(time.Minute)
+	userRepo.FindUser = lockedUser
+	code, err := service.TOTPCode(enrollment.Secret, time.Now())
+	assert.NoError(err)
+	err = mfaSvc.DisableTOTP(userID, domain.MFAReauthentication{Password: "super-secret-password", Code: code}, client)
+	assertHTTPStatus(assert, http.StatusLocked, err)
+	assert.Equal(0, userRepo.DisableTOTPInvocation)
+	userRepo.FindUser = storedUser
+
+	// A valid password and TOTP code should disable TOTP and remove the recovery codes.
+	err = mfaSvc.DisableTOTP(userID, domain.MFAReauthentication{Password: "super-secret-password", Code: code}, client)
+	assert.NoError(err)
+	assert.Equal(userID, userRepo.UseTOTPStepArgUserID)
+	assert.Equal(userID, userRepo.DisableTOTPArg)
+	assert.Equal(userID, recoveryCodeRepo.ReplaceArgUserID)
+	assert.Equal(0, len(recoveryCodeRepo.ReplaceArgCodes))
+	assert.Equal(2, len(events.Events))
+	assert.Equal(domain.MFADisableEvent, events.Events[1].Type)
+	assert.Equal(userID, events.Events[1].ActorID)
+}
+
+func TestVerifyMFAChallengeAfterKeyRotation(t *testing.T) {
+	assert := assert.New(t)
+
+	userID := id.New()
+	userRepo := &repository.MockUserRepo{
+		FindUser: domain.FullUser{
+			User: user.User{
+				ID:    userID,
+				Email: "mail@mail.com",
+			},
+			Credentials: domain.StoredCredentials{
+				Email:    "mail@mail.com",
+				Password: "S5UeZOWCDkIfP/5LUDpyhIY0l6+aow+CmkBEVtHqpebhe04vb6kDbPaD/wo05fs6x1lvJfI/6YZ66zbQ8X2lHaEThp4f1Zl0exk7j/wow740KbWZHf9DSA==", // Hashed and encrypted password.
+				Salt:     "3MQEKd3NVnU+WQFQxo8JpYWrTrqXOiwro4MwLwnsckWXinE=",                                                                         // Encrypted salt
+			},
+		},
+	}
+	legacyKeyring := service.NewEncryptionKeyring("my-encryption-key")
+	legacyPasswordSvc := service.NewPasswordService(
+		userRepo, "my-pepper", legacyKeyring, service.DefaultHashingConfig, service.DefaultLockoutPolicy)
+	legacyMFASvc := service.NewMFAService(legacyPasswordSvc, legacyKeyring, userRepo, nil, nil, nil)
+	enrollment, err := legacyMFASvc.EnrollTOTP(userID, "super-secret-password")
+	assert.NoError(err)
+	legacySecret := userRepo.SaveTOTPSecretArgSecret
+
+	// TOTP secrets should be re-encrypted along with passwords and salts.
+	rotatingKeyring := service.EncryptionKeyring{
+		ActiveKeyID: "key-2",
+		Keys: map[string]string{
+			service.LegacyKeyID: "my-encryption-key",
+			"key-2":             "my-new-encryption-key",
+		},
+	}
+	rotatingPasswordSvc := service.NewPasswordService(
+		userRepo, "my-pepper", rotatingKeyring, service.DefaultHashingConfig, service.DefaultLockoutPolicy)
+	userRepo.ListCredentialsRes = []domain.UserCredentials{
+		{UserID: userID, TOTPSecret: legacySecret},
+	}
+	count, err := rotatingPasswordSvc.ReencryptCredentials(10)
+	assert.NoError(err)
+	assert.Equal(1, count)
+	assert.Equal(legacySecret, userRepo.ReplaceCredentialsArgOld.TOTPSecret)
+	assert.Equal("", userRepo.ReplaceCredentialsArgNew.Credentials.Password)
+	assert.True(strings.HasPrefix(userRepo.ReplaceCredentialsArgNew.TOTPSecret, "key-2:"))
+
+	// Challenges should be completed once the old key has been removed.
+	rotatedKeyring := service.EncryptionKeyring{
+		ActiveKeyID: "key-2",
+		Keys:        map[string]string{"key-2": "my-new-encryption-key"},
+	}
+	credentialRepo := &repository.MockOneTimeCredentialRepo{}
+	passwordSvc := service.NewPasswordService(
+		userRepo, "my-pepper", rotatedKeyring, service.DefaultHashingConfig, service.DefaultLockoutPolicy)
+	mfaSvc := service.NewMFAService(passwordSvc, rotatedKeyring, userRepo, &repository.MockRecoveryCodeRepo{}, credentialRepo, nil)
+	storedUser := userRepo.FindUser
+	storedUser.TOTP = domain.TOTP{
+		Secret:  userRepo.ReplaceCredentialsArgNew.TOTPSecret,
+		Enabled: true,
+	}
+	userRepo.FindUser = storedUser
+
+	challenge, err := mfaSvc.Challenge(userID)
+	assert.NoError(err)
+	credentialRepo.FindByKeyCredential = credentialRepo.SaveArg
+
+	code, err := service.TOTPCode(enrollment.Secret, time.Now())
+	assert.NoError(err)
+	u, err := mfaSvc.VerifyChallenge(domain.MFAVerification{ChallengeToken: challenge.ChallengeToken, Code: code}, domain.ClientInfo{})
+	assert.NoError(err)
+	assert.Equal(userID, u.User.ID)
+	assert.Equal(credentialRepo.SaveArg.ID, credentialRepo.MarkUsedArg)
+
+	// Secrets that were not re-encrypted can not be decrypted without the old key.
+	storedUser.TOTP.Secret = legacySecret
+	userRepo.FindUser = storedUser
+	_, err = mfaSvc.VerifyChallenge(domain.MFAVerification{ChallengeToken: challenge.ChallengeToken, Code: code}, domain.ClientInfo{})
+	assert.Error(err)
+}
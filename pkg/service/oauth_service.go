@@ -0,0 +1,238 @@
+package service
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/directory/pkg/repository"
+	"github.com/mimir-news/pkg/httputil"
+	"github.com/mimir-news/pkg/httputil/auth"
+)
+
+// ServiceClient credentials of a service that is allowed to introspect and revoke tokens.
+type ServiceClient struct {
+	ID     string `json:"id"`
+	Secret string `json:"secret"`
+}
+
+// Valid checks if the service client has an id and a secret.
+func (c ServiceClient) Valid() error {
+	if c.ID == "" || c.Secret == "" {
+		return fmt.Errorf("Service client %q must have an id and a secret", c.ID)
+	}
+
+	return nil
+}
+
+// OAuthService service responsible for introspection and revocation of tokens on behalf of other services.
+type OAuthService interface {
+	AuthenticateClient(clientID, secret string) error
+	Introspect(req domain.TokenRequest) (domain.TokenIntrospection, error)
+	Revoke(req domain.TokenRequest, client domain.ClientInfo) error
+}
+
+// NewOAuthService creates a new OAuthService using the default implementation.
+// The verifier should not allow any leeway since expired access tokens must be reported as inactive.
+func NewOAuthService(
+	clients []ServiceClient, verifier auth.Verifier, tokenHasher RefreshTokenHasher,
+	userRepo repository.UserRepo, sessionRepo repository.SessionRepo, events SecurityEventRecorder) OAuthService {
+	clientSecrets := make(map[string][sha256.Size]byte)
+	for _, client := range clients {
+		clientSecrets[client.ID] = sha256.Sum256([]byte(client.Secret))
+	}
+
+	return &oauthSvc{
+		clientSecrets: clientSecrets,
+		verifier:      verifier,
+		tokenHasher:   tokenHasher,
+		userRepo:      userRepo,
+		sessionRepo:   sessionRepo,
+		events:        events,
+	}
+}
+
+type oauthSvc struct {
+	clientSecrets map[string][sha256.Size]byte
+	verifier      auth.Verifier
+	tokenHasher   RefreshTokenHasher
+	userRepo      repository.UserRepo
+	sessionRepo   repository.SessionRepo
+	events        SecurityEventRecorder
+}
+
+// AuthenticateClient checks the credentials of a service client.
+func (oa *oauthSvc) AuthenticateClient(clientID, secret string) error {
+	expected, ok := oa.clientSecrets[clientID]
+	if !ok {
+		return httputil.ErrUnauthorized()
+	}
+
+	actual := sha256.Sum256([]byte(secret))
+	if subtle.ConstantTimeCompare(actual[:], expected[:]) != 1 {
+		return httputil.ErrUnauthorized()
+	}
+
+	return nil
+}
+
+// Introspect checks if an access or refresh token is active. A token is active if it is valid,
+// the session it was issued for has not been ended and the user has not been deleted or locked.
+func (oa *oauthSvc) Introspect(req domain.TokenRequest) (domain.TokenIntrospection, error) {
+	if req.TokenTypeHint == domain.RefreshTokenType {
+		introspection, err := oa.introspectRefreshToken(req.Token)
+		if err != nil || introspection.Active {
+			return introspection, err
+		}
+
+		return oa.introspectAccessToken(req.Token)
+	}
+
+	introspection, err := oa.introspectAccessToken(req.Token)
+	if err != nil || introspection.Active {
+		return introspection, err
+	}
+
+	return oa.introspectRefreshToken(req.Token)
+}
+
+// Revoke ends the session that an access or refresh token was issued for and records the revocation.
+// Revoking a token which is invalid or already revoked is not an error.
+func (oa *oauthSvc) Revoke(req domain.TokenRequest, client domain.ClientInfo) error {
+	session, err := oa.findTokenSession(req)
+	if err == repository.ErrNoSuchSession {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	err = oa.sessionRepo.Delete(session.ID)
+	if err == repository.ErrNoSuchSession {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return oa.events.Record(domain.NewSecurityEvent(domain.SessionRevocationEvent, session.UserID, session.ID, client))
+}
+
+func (oa *oauthSvc) introspectAccessToken(rawToken string) (domain.TokenIntrospection, error) {
+	token, err := oa.verifier.Verify(rawToken)
+	if err != nil {
+		return domain.InactiveToken, nil
+	}
+
+	if token.User.Role == auth.AnonymousRole {
+		return domain.TokenIntrospection{
+			Active:    true,
+			TokenType: domain.AccessTokenType,
+			Subject:   token.User.ID,
+			Role:      token.User.Role,
+		}, nil
+	}
+
+	session, err := oa.findSession(token)
+	if err == repository.ErrNoSuchSession {
+		return domain.InactiveToken, nil
+	} else if err != nil {
+		return domain.InactiveToken, err
+	}
+
+	return oa.introspectSession(session, domain.AccessTokenType, token.User.Role)
+}
+
+func (oa *oauthSvc) introspectRefreshToken(refreshToken string) (domain.TokenIntrospection, error) {
+	session, err := oa.findRefreshTokenSession(refreshToken)
+	if err == repository.ErrNoSuchSession {
+		return domain.InactiveToken, nil
+	} else if err != nil {
+		return domain.InactiveToken, err
+	}
+
+	if session.CreatedAt.Before(now().Add(-1 * year)) {
+		return domain.InactiveToken, nil
+	}
+
+	return oa.introspectSession(session, domain.RefreshTokenType, "")
+}
+
+func (oa *oauthSvc) introspectSession(session domain.Session, tokenType, role string) (domain.TokenIntrospection, error) {
+	if !session.Active {
+		return domain.InactiveToken, nil
+	}
+
+	u, err := oa.userRepo.Find(session.UserID)
+	if err == repository.ErrNoSuchUser {
+		return domain.InactiveToken, nil
+	} else if err != nil {
+		return domain.InactiveToken, err
+	}
+
+	if (u.User.Email == "" && !u.IsGuest()) || u.IsDeleted() {
+		return domain.InactiveToken, nil
+	}
+
+	if u.IsLocked(now()) || u.SessionRevoked(session) {
+		return domain.InactiveToken, nil
+	}
+
+	if role == "" {
+		role = u.User.Role
+	}
+
+	return domain.TokenIntrospection{
+		Active:    true,
+		TokenType: tokenType,
+		Subject:   u.User.ID,
+		Role:      role,
+		SessionID: session.ID,
+		IssuedAt:  session.CreatedAt.Unix(),
+	}, nil
+}
+
+// findTokenSession finds the session of an access or refresh token, starting with the hinted kind of token.
+func (oa *oauthSvc) findTokenSession(req domain.TokenRequest) (domain.Session, error) {
+	if req.TokenTypeHint == domain.RefreshTokenType {
+		session, err := oa.findRefreshTokenSession(req.Token)
+		if err != repository.ErrNoSuchSession {
+			return session, err
+		}
+
+		return oa.findAccessTokenSession(req.Token)
+	}
+
+	session, err := oa.findAccessTokenSession(req.Token)
+	if err != repository.ErrNoSuchSession {
+		return session, err
+	}
+
+	return oa.findRefreshTokenSession(req.Token)
+}
+
+func (oa *oauthSvc) findAccessTokenSession(rawToken string) (domain.Session, error) {
+	token, err := oa.verifier.Verify(rawToken)
+	if err != nil {
+		return domain.Session{}, repository.ErrNoSuchSession
+	}
+
+	return oa.findSession(token)
+}
+
+func (oa *oauthSvc) findRefreshTokenSession(refreshToken string) (domain.Session, error) {
+	return oa.sessionRepo.FindByRefreshTokenHash(oa.tokenHasher.Hash(refreshToken))
+}
+
+// findSession finds the session that an access token was issued for.
+func (oa *oauthSvc) findSession(token auth.Token) (domain.Session, error) {
+	session, err := oa.sessionRepo.Find(token.ID)
+	if err != nil {
+		return domain.Session{}, err
+	}
+
+	if session.UserID != token.User.ID {
+		return domain.Session{}, repository.ErrNoSuchSession
+	}
+
+	return session, nil
+}
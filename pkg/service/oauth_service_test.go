@@ -0,0 +1,186 @@
+package service_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/directory/pkg/repository"
+	"github.com/mimir-news/directory/pkg/service"
+	"github.com/mimir-news/pkg/httputil/auth"
+	"github.com/mimir-news/pkg/id"
+	"github.com/mimir-news/pkg/schema/user"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticateServiceClient(t *testing.T) {
+	assert := assert.New(t)
+
+	clients := []service.ServiceClient{{ID: "news-service", Secret: "news-secret"}}
+	oauthSvc := service.NewOAuthService(clients, nil, service.NewRefreshTokenHasher("refresh-key"), nil, nil, nil)
+
+	assert.NoError(oauthSvc.AuthenticateClient("news-service", "news-secret"))
+	assertHTTPStatus(assert, http.StatusUnauthorized, oauthSvc.AuthenticateClient("news-service", "wrong-secret"))
+	assertHTTPStatus(assert, http.StatusUnauthorized, oauthSvc.AuthenticateClient("other-service", "news-secret"))
+	assertHTTPStatus(assert, http.StatusUnauthorized, oauthSvc.AuthenticateClient("", ""))
+}
+
+func TestIntrospectToken(t *testing.T) {
+	assert := assert.New(t)
+
+	userID := id.New()
+	jwtCreds := auth.JWTCredentials{Issuer: "oauth_service_test", Secret: id.New()}
+	signer := auth.NewSigner(jwtCreds, time.Hour)
+	tokenHasher := service.NewRefreshTokenHasher("refresh-key")
+	session := domain.NewSession(userID, domain.ClientInfo{})
+	session.RefreshTokenHash = tokenHasher.Hash(session.RefreshToken)
+	storedUser := domain.FullUser{User: user.User{ID: userID, Email: "mail@mail.com", Role: auth.UserRole}}
+
+	userRepo := &repository.MockUserRepo{FindUser: storedUser}
+	sessionRepo := &repository.MockSessionRepo{
+		FindSession:                   session,
+		FindByRefreshTokenHashSession: session,
+	}
+	oauthSvc := service.NewOAuthService(nil, auth.NewVerifier(jwtCreds, 0), tokenHasher, userRepo, sessionRepo, nil)
+
+	accessToken, err := signer.Sign(session.ID, auth.User{ID: userID, Role: auth.UserRole})
+	assert.NoError(err)
+
+	introspection, err := oauthSvc.Introspect(domain.TokenRequest{Token: accessToken})
+	assert.NoError(err)
+	assert.True(introspection.Active)
+	assert.Equal(domain.AccessTokenType, introspection.TokenType)
+	assert.Equal(userID, introspection.Subject)
+	assert.Equal(auth.UserRole, introspection.Role)
+	assert.Equal(session.ID, introspection.SessionID)
+	assert.Equal(session.ID, sessionRepo.FindArg)
+	assert.Equal(0, sessionRepo.FindByRefreshTokenHashInvocation)
+
+	sessionRepo.UnsetArgs()
+	req := domain.TokenRequest{Token: session.RefreshToken, TokenTypeHint: domain.RefreshTokenType}
+	introspection, err = oauthSvc.Introspect(req)
+	assert.NoError(err)
+	assert.True(introspection.Active)
+	assert.Equal(domain.RefreshTokenType, introspection.TokenType)
+	assert.Equal(userID, introspection.Subject)
+	assert.Equal(session.RefreshTokenHash, sessionRepo.FindByRefreshTokenHashArg)
+	assert.Equal(0, sessionRepo.FindInvocation)
+
+	// A refresh token without a hint is looked up after failing to verify it as an access token.
+	sessionRepo.UnsetArgs()
+	introspection, err = oauthSvc.Introspect(domain.TokenRequest{Token: session.RefreshToken})
+	assert.NoError(err)
+	assert.True(introspection.Active)
+	assert.Equal(domain.RefreshTokenType, introspection.TokenType)
+
+	// Tokens of ended sessions are inactive.
+	sessionRepo.FindByRefreshTokenHashErr = repository.ErrNoSuchSession
+	sessionRepo.FindSession.Active = false
+	introspection, err = oauthSvc.Introspect(domain.TokenRequest{Token: accessToken})
+	assert.NoError(err)
+	assert.Equal(domain.InactiveToken, introspection)
+	sessionRepo.FindSession.Active = true
+
+	// Tokens of locked users are inactive.
+	userRepo.FindUser.Locked = true
+	introspection, err = oauthSvc.Introspect(domain.TokenRequest{Token: accessToken})
+	assert.NoError(err)
+	assert.False(introspection.Active)
+	userRepo.FindUser.Locked = false
+
+	// Tokens of deleted users are inactive.
+	userRepo.FindErr = repository.ErrNoSuchUser
+	introspection, err = oauthSvc.Introspect(domain.TokenRequest{Token: accessToken})
+	assert.NoError(err)
+	assert.False(introspection.Active)
+	userRepo.FindErr = nil
+
+	// Tokens issued before the users sessions were invalidated are inactive.
+	userRepo.FindUser.SessionsValidAfter = time.Now().UTC().Add(time.Minute)
+	introspection, err = oauthSvc.Introspect(domain.TokenRequest{Token: accessToken})
+	assert.NoError(err)
+	assert.False(introspection.Active)
+	userRepo.FindUser.SessionsValidAfter = time.Time{}
+
+	expiredToken, err := auth.NewSigner(jwtCreds, -time.Minute).Sign(session.ID, auth.User{ID: userID, Role: auth.UserRole})
+	assert.NoError(err)
+	introspection, err = oauthSvc.Introspect(domain.TokenRequest{Token: expiredToken})
+	assert.NoError(err)
+	assert.False(introspection.Active)
+
+	introspection, err = oauthSvc.Introspect(domain.TokenRequest{Token: "unknown-token"})
+	assert.NoError(err)
+	assert.False(introspection.Active)
+
+	sessionRepo.FindErr = testError
+	_, err = oauthSvc.Introspect(domain.TokenRequest{Token: accessToken})
+	assert.Equal(testError, err)
+}
+
+func TestRevokeToken(t *testing.T) {
+	assert := assert.New(t)
+
+	userID := id.New()
+	jwtCreds := auth.JWTCredentials{Issuer: "oauth_service_test", Secret: id.New()}
+	signer := auth.NewSigner(jwtCreds, time.Hour)
+	tokenHasher := service.NewRefreshTokenHasher("refresh-key")
+	session := domain.NewSession(userID, domain.ClientInfo{})
+	session.RefreshTokenHash = tokenHasher.Hash(session.RefreshToken)
+
+	sessionRepo := &repository.MockSessionRepo{
+		FindSession:                   session,
+		FindByRefreshTokenHashSession: session,
+	}
+	events := &service.MockEventRecorder{}
+	oauthSvc := service.NewOAuthService(nil, auth.NewVerifier(jwtCreds, 0), tokenHasher, nil, sessionRepo, events)
+	client := domain.ClientInfo{IP: "10.0.0.1", UserAgent: "test-service"}
+
+	accessToken, err := signer.Sign(session.ID, auth.User{ID: userID, Role: auth.UserRole})
+	assert.NoError(err)
+
+	err = oauthSvc.Revoke(domain.TokenRequest{Token: accessToken}, client)
+	assert.NoError(err)
+	assert.Equal(session.ID, sessionRepo.DeleteArg)
+	assert.Equal(0, sessionRepo.FindByRefreshTokenHashInvocation)
+	assert.Equal(1, len(events.Events))
+	assert.Equal(domain.SessionRevocationEvent, events.Events[0].Type)
+	assert.Equal(userID, events.Events[0].UserID)
+	assert.Equal("", events.Events[0].ActorID)
+	assert.Equal(session.ID, events.Events[0].SessionID)
+	assert.Equal(client, events.Events[0].Client)
+
+	sessionRepo.UnsetArgs()
+	err = oauthSvc.Revoke(domain.TokenRequest{Token: session.RefreshToken, TokenTypeHint: domain.RefreshTokenType}, client)
+	assert.NoError(err)
+	assert.Equal(session.ID, sessionRepo.DeleteArg)
+	assert.Equal(0, sessionRepo.FindInvocation)
+
+	// Revoking an already revoked token succeeds without recording a revocation.
+	sessionRepo.UnsetArgs()
+	sessionRepo.DeleteErr = repository.ErrNoSuchSession
+	events.Events = nil
+	err = oauthSvc.Revoke(domain.TokenRequest{Token: accessToken}, client)
+	assert.NoError(err)
+	assert.Equal(1, sessionRepo.DeleteInvocation)
+	assert.Equal(0, len(events.Events))
+
+	// Revoking an unknown token succeeds without deleting any session.
+	sessionRepo.UnsetArgs()
+	sessionRepo.DeleteErr = nil
+	sessionRepo.FindByRefreshTokenHashErr = repository.ErrNoSuchSession
+	err = oauthSvc.Revoke(domain.TokenRequest{Token: "unknown-token"}, client)
+	assert.NoError(err)
+	assert.Equal(0, sessionRepo.DeleteInvocation)
+
+	// Access tokens are not used to revoke sessions of other users.
+	sessionRepo.FindSession.UserID = id.New()
+	err = oauthSvc.Revoke(domain.TokenRequest{Token: accessToken}, client)
+	assert.NoError(err)
+	assert.Equal(0, sessionRepo.DeleteInvocation)
+
+	sessionRepo.FindSession.UserID = userID
+	sessionRepo.DeleteErr = testError
+	err = oauthSvc.Revoke(domain.TokenRequest{Token: accessToken}, client)
+	assert.Equal(testError, err)
+}
@@ -0,0 +1,337 @@
+package service
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mimir-news/directory/pkg/domain"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const (
+	oidcDiscoveryPath       = "/.well-known/openid-configuration"
+	oidcHTTPTimeout         = 10 * time.Second
+	oidcMaxResponseSize     = 1 << 20
+	oidcJWKSRefreshInterval = time.Minute
+)
+
+var oidcDefaultScopes = []string{"openid", "email"}
+
+// Signature algorithms accepted for ID tokens. Symmetric algorithms are not accepted
+// since the client secret would then be enough to forge ID tokens.
+var oidcSignatureAlgorithms = map[string]bool{
+	string(jose.RS256): true,
+	string(jose.RS384): true,
+	string(jose.RS512): true,
+	string(jose.PS256): true,
+	string(jose.PS384): true,
+	string(jose.PS512): true,
+	string(jose.ES256): true,
+	string(jose.ES384): true,
+	string(jose.ES512): true,
+	string(jose.EdDSA): true,
+}
+
+// OIDCProviderConfig configuration of an external OpenID Connect provider.
+// The name of the provider is used in login routes and to tell external identities apart.
+type OIDCProviderConfig struct {
+	Name         string   `json:"name"`
+	Issuer       string   `json:"issuer"`
+	ClientID     string   `json:"clientId"`
+	ClientSecret string   `json:"clientSecret"`
+	RedirectURL  string   `json:"redirectUrl"`
+	Scopes       []string `json:"scopes"`
+}
+
+// Valid checks if the provider config contains the values needed to log in with the provider.
+func (c OIDCProviderConfig) Valid() error {
+	if c.Name == "" || c.Issuer == "" || c.ClientID == "" || c.RedirectURL == "" {
+		return fmt.Errorf("OIDC provider %q must have a name, issuer, client id and redirect url", c.Name)
+	}
+
+	return nil
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type oidcTokenResponse struct {
+	IDToken string `json:"id_token"`
+}
+
+type oidcIDTokenClaims struct {
+	Nonce         string      `json:"nonce"`
+	Email         string      `json:"email"`
+	EmailVerified interface{} `json:"email_verified"`
+	AuthorizedBy  string      `json:"azp"`
+}
+
+// emailVerified checks the email_verified claim, which some providers send as a string.
+func (c oidcIDTokenClaims) emailVerified() bool {
+	return c.EmailVerified == true || c.EmailVerified == "true"
+}
+
+// oidcProvider client for an OpenID Connect provider. The provider metadata is discovered on first use
+// and the signing keys of the provider are cached and refetched when a token is signed with an unknown key.
+type oidcProvider struct {
+	config        OIDCProviderConfig
+	client        *http.Client
+	mu            sync.Mutex
+	discovery     *oidcDiscovery
+	keys          jose.JSONWebKeySet
+	keysFetchedAt time.Time
+}
+
+func newOIDCProvider(config OIDCProviderConfig, client *http.Client) *oidcProvider {
+	if len(config.Scopes) == 0 {
+		config.Scopes = oidcDefaultScopes
+	}
+
+	return &oidcProvider{
+		config: config,
+		client: client,
+	}
+}
+
+// authorizationURL creates the url of the providers authorization endpoint which the user should be redirected to.
+func (p *oidcProvider) authorizationURL(state, nonce, codeVerifier string) (string, error) {
+	discovery, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+
+	authURL, err := url.Parse(discovery.AuthorizationEndpoint)
+	if err != nil {
+		return "", err
+	}
+
+	query := authURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", p.config.ClientID)
+	query.Set("redirect_uri", p.config.RedirectURL)
+	query.Set("scope", strings.Join(p.config.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", pkceChallenge(codeVerifier))
+	query.Set("code_challenge_method", "S256")
+	authURL.RawQuery = query.Encode()
+	return authURL.String(), nil
+}
+
+// exchangeCode exchanges an authorization code for an ID token at the providers token endpoint.
+func (p *oidcProvider) exchangeCode(code, codeVerifier string) (string, error) {
+	discovery, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.config.RedirectURL)
+	form.Set("client_id", p.config.ClientID)
+	form.Set("code_verifier", codeVerifier)
+	if p.config.ClientSecret != "" {
+		form.Set("client_secret", p.config.ClientSecret)
+	}
+
+	res, err := p.client.PostForm(discovery.TokenEndpoint, form)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", errExternalLoginFailed()
+	}
+
+	var tokenRes oidcTokenResponse
+	err = decodeOIDCResponse(res, &tokenRes)
+	if err != nil {
+		return "", err
+	}
+
+	if tokenRes.IDToken == "" {
+		return "", errExternalLoginFailed()
+	}
+
+	return tokenRes.IDToken, nil
+}
+
+// verifyIDToken verifies the signature and claims of an ID token issued by the provider.
+func (p *oidcProvider) verifyIDToken(rawToken, nonce string) (domain.ExternalClaims, error) {
+	token, err := jwt.ParseSigned(rawToken)
+	if err != nil || len(token.Headers) != 1 {
+		return domain.ExternalClaims{}, errExternalLoginFailed()
+	}
+
+	header := token.Headers[0]
+	if !oidcSignatureAlgorithms[header.Algorithm] {
+		return domain.ExternalClaims{}, errExternalLoginFailed()
+	}
+
+	key, err := p.signingKey(header.KeyID)
+	if err != nil {
+		return domain.ExternalClaims{}, err
+	}
+
+	var claims jwt.Claims
+	var idClaims oidcIDTokenClaims
+	err = token.Claims(key.Key, &claims, &idClaims)
+	if err != nil {
+		return domain.ExternalClaims{}, errExternalLoginFailed()
+	}
+
+	err = claims.Validate(jwt.Expected{
+		Issuer:   p.config.Issuer,
+		Audience: jwt.Audience{p.config.ClientID},
+		Time:     now(),
+	})
+	if err != nil || claims.Subject == "" || claims.Expiry == nil {
+		return domain.ExternalClaims{}, errExternalLoginFailed()
+	}
+
+	if len(claims.Audience) > 1 && idClaims.AuthorizedBy != p.config.ClientID {
+		return domain.ExternalClaims{}, errExternalLoginFailed()
+	}
+
+	if subtle.ConstantTimeCompare([]byte(idClaims.Nonce), []byte(nonce)) != 1 {
+		return domain.ExternalClaims{}, errExternalLoginFailed()
+	}
+
+	return domain.ExternalClaims{
+		Subject:       claims.Subject,
+		Email:         idClaims.Email,
+		EmailVerified: idClaims.emailVerified(),
+	}, nil
+}
+
+// signingKey finds a signing key of the provider by key id. The keys are refetched if
+// no key with the id is cached, which happens when the provider has rotated its keys.
+func (p *oidcProvider) signingKey(keyID string) (jose.JSONWebKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key, ok := findSigningKey(p.keys, keyID)
+	if ok {
+		return key, nil
+	}
+
+	if now().Before(p.keysFetchedAt.Add(oidcJWKSRefreshInterval)) {
+		return jose.JSONWebKey{}, errExternalLoginFailed()
+	}
+
+	err := p.fetchKeys()
+	if err != nil {
+		return jose.JSONWebKey{}, err
+	}
+
+	key, ok = findSigningKey(p.keys, keyID)
+	if !ok {
+		return jose.JSONWebKey{}, errExternalLoginFailed()
+	}
+
+	return key, nil
+}
+
+// fetchKeys fetches the signing keys of the provider, must be called with the lock held.
+func (p *oidcProvider) fetchKeys() error {
+	discovery, err := p.discoverLocked()
+	if err != nil {
+		return err
+	}
+
+	res, err := p.client.Get(discovery.JWKSURI)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	var keys jose.JSONWebKeySet
+	err = decodeOIDCResponse(res, &keys)
+	if err != nil {
+		return err
+	}
+
+	p.keys = keys
+	p.keysFetchedAt = now()
+	return nil
+}
+
+func (p *oidcProvider) discover() (oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.discoverLocked()
+}
+
+// discoverLocked fetches the provider metadata if it has not already been fetched, must be called with the lock held.
+func (p *oidcProvider) discoverLocked() (oidcDiscovery, error) {
+	if p.discovery != nil {
+		return *p.discovery, nil
+	}
+
+	res, err := p.client.Get(strings.TrimSuffix(p.config.Issuer, "/") + oidcDiscoveryPath)
+	if err != nil {
+		return oidcDiscovery{}, err
+	}
+	defer res.Body.Close()
+
+	var discovery oidcDiscovery
+	err = decodeOIDCResponse(res, &discovery)
+	if err != nil {
+		return oidcDiscovery{}, err
+	}
+
+	if discovery.Issuer != p.config.Issuer {
+		return oidcDiscovery{}, fmt.Errorf("OIDC provider %s returned issuer %s, expected %s",
+			p.config.Name, discovery.Issuer, p.config.Issuer)
+	}
+
+	p.discovery = &discovery
+	return discovery, nil
+}
+
+func findSigningKey(keys jose.JSONWebKeySet, keyID string) (jose.JSONWebKey, bool) {
+	for _, key := range keys.Keys {
+		if (keyID == "" || key.KeyID == keyID) && key.Use != "enc" {
+			return key, true
+		}
+	}
+
+	return jose.JSONWebKey{}, false
+}
+
+func decodeOIDCResponse(res *http.Response, v interface{}) error {
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("OIDC request to %s failed with status %d", res.Request.URL, res.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, oidcMaxResponseSize))
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(body, v)
+}
+
+// pkceChallenge derives the S256 PKCE code challenge of a code verifier.
+func pkceChallenge(codeVerifier string) string {
+	hash := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
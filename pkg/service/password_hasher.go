@@ -0,0 +1,277 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/mimir-news/pkg/httputil/auth"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Supported password hashing algorithms.
+const (
+	Argon2idAlgorithm = "argon2id"
+	BcryptAlgorithm   = "bcrypt"
+)
+
+// DefaultHashingConfig password hashing configuration used unless configured otherwise.
+var DefaultHashingConfig = HashingConfig{
+	Algorithm: Argon2idAlgorithm,
+	Argon2id: Argon2idParams{
+		Memory:      64 * 1024,
+		Iterations:  3,
+		Parallelism: 2,
+		SaltLength:  16,
+		KeyLength:   32,
+	},
+	BcryptCost: 12,
+}
+
+// HashingConfig describes which algorithm and cost parameters new password hashes are created with.
+type HashingConfig struct {
+	Algorithm  string
+	Argon2id   Argon2idParams
+	BcryptCost int
+}
+
+// Lowest cost parameters that new password hashes may be created with.
+const (
+	minArgon2idMemory = 19 * 1024
+	minBcryptCost     = 10
+)
+
+// Valid checks that the algorithm is supported and that the cost parameters are not too low to be safe.
+func (c HashingConfig) Valid() error {
+	if c.Algorithm != Argon2idAlgorithm && c.Algorithm != BcryptAlgorithm {
+		return fmt.Errorf("Invalid password hashing algorithm: %s", c.Algorithm)
+	}
+
+	p := c.Argon2id
+	if p.Memory < minArgon2idMemory || p.Iterations < 1 || p.Parallelism < 1 || p.SaltLength < 16 || p.KeyLength < 16 {
+		return fmt.Errorf("Invalid argon2id parameters: memory=%d iterations=%d parallelism=%d",
+			p.Memory, p.Iterations, p.Parallelism)
+	}
+
+	if c.BcryptCost < minBcryptCost || c.BcryptCost > bcrypt.MaxCost {
+		return fmt.Errorf("Invalid bcrypt cost: %d", c.BcryptCost)
+	}
+
+	return nil
+}
+
+// Argon2idParams cost parameters for argon2id, memory is specified in KiB.
+type Argon2idParams struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// passwordHasher hashes passwords using a specific algorithm.
+type passwordHasher interface {
+	hash(password string) (string, error)
+	verify(password, hashedPassword string) error
+	outdated(hashedPassword string) bool
+}
+
+// hashingScheme hashes passwords with the configured algorithm and verifies passwords hashed
+// by any supported algorithm. Stored hashes are tagged with their algorithm in the format
+// $<algorithm>$<algorithm specific hash>. Untagged hashes were created by the legacy auth.Hasher.
+type hashingScheme struct {
+	algorithm string
+	pepper    string
+	hashers   map[string]passwordHasher
+	legacy    auth.Hasher
+}
+
+func newHashingScheme(pepper string, config HashingConfig) hashingScheme {
+	return hashingScheme{
+		algorithm: config.Algorithm,
+		pepper:    pepper,
+		hashers: map[string]passwordHasher{
+			Argon2idAlgorithm: argon2idHasher{params: config.Argon2id},
+			BcryptAlgorithm:   bcryptHasher{cost: config.BcryptCost},
+		},
+		legacy: auth.NewHasher(pepper),
+	}
+}
+
+// hash hashes a password using the configured algorithm and tags the hash with the algorithm name.
+func (s hashingScheme) hash(password string) (string, error) {
+	hasher, ok := s.hashers[s.algorithm]
+	if !ok {
+		return "", fmt.Errorf("Unsupported password hashing algorithm: %s", s.algorithm)
+	}
+
+	hashedPassword, err := hasher.hash(s.pepperPassword(password))
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("$%s$%s", s.algorithm, hashedPassword), nil
+}
+
+// verify checks a password against a stored hash, returns ErrInvalidCredentials on missmatch.
+func (s hashingScheme) verify(password, storedHash string) error {
+	algorithm, hashedPassword, tagged := parseHashTag(storedHash)
+	if !tagged {
+		err := s.legacy.Verify(password, storedHash)
+		if err == auth.ErrHashDoesNotMatch {
+			return ErrInvalidCredentials
+		}
+		return err
+	}
+
+	hasher, ok := s.hashers[algorithm]
+	if !ok {
+		return fmt.Errorf("Unsupported password hashing algorithm: %s", algorithm)
+	}
+
+	return hasher.verify(s.pepperPassword(password), hashedPassword)
+}
+
+// needsRehash checks if a stored hash was created with another algorithm or cost than the configured.
+func (s hashingScheme) needsRehash(storedHash string) bool {
+	algorithm, hashedPassword, tagged := parseHashTag(storedHash)
+	if !tagged || algorithm != s.algorithm {
+		return true
+	}
+
+	return s.hashers[algorithm].outdated(hashedPassword)
+}
+
+// pepperPassword binds a password to the secret pepper before it is hashed.
+func (s hashingScheme) pepperPassword(password string) string {
+	return fmt.Sprintf("%x", auth.HashKey(s.pepper, password))
+}
+
+// parseHashTag splits a tagged hash into its algorithm and algorithm specific hash.
+func parseHashTag(storedHash string) (string, string, bool) {
+	if !strings.HasPrefix(storedHash, "$") {
+		return "", "", false
+	}
+
+	parts := strings.SplitN(storedHash[1:], "$", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+
+	algorithm := parts[0]
+	if algorithm != Argon2idAlgorithm && algorithm != BcryptAlgorithm {
+		return "", "", false
+	}
+
+	return algorithm, parts[1], true
+}
+
+// argon2idHasher hashes passwords using argon2id. Hashes are encoded as
+// v=<version>$m=<memory>,t=<iterations>,p=<parallelism>$<base64 salt>$<base64 hash>.
+type argon2idHasher struct {
+	params Argon2idParams
+}
+
+func (h argon2idHasher) hash(password string) (string, error) {
+	salt := make([]byte, h.params.SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.params.Iterations, h.params.Memory, h.params.Parallelism, h.params.KeyLength)
+	return fmt.Sprintf("v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.params.Memory, h.params.Iterations, h.params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (h argon2idHasher) verify(password, hashedPassword string) error {
+	params, salt, key, err := decodeArgon2idHash(hashedPassword)
+	if err != nil {
+		return err
+	}
+
+	candidate := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	if subtle.ConstantTimeCompare(key, candidate) != 1 {
+		return ErrInvalidCredentials
+	}
+
+	return nil
+}
+
+func (h argon2idHasher) outdated(hashedPassword string) bool {
+	params, salt, _, err := decodeArgon2idHash(hashedPassword)
+	if err != nil {
+		return true
+	}
+
+	params.SaltLength = uint32(len(salt))
+	return params != h.params
+}
+
+func decodeArgon2idHash(hashedPassword string) (Argon2idParams, []byte, []byte, error) {
+	var params Argon2idParams
+	parts := strings.Split(hashedPassword, "$")
+	if len(parts) != 4 {
+		return params, nil, nil, fmt.Errorf("Invalid argon2id hash")
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[0], "v=%d", &version)
+	if err != nil {
+		return params, nil, nil, err
+	}
+	if version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("Unsupported argon2 version: %d", version)
+	}
+
+	_, err = fmt.Sscanf(parts[1], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism)
+	if err != nil {
+		return params, nil, nil, err
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return params, nil, nil, err
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return params, nil, nil, err
+	}
+
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	return params, salt, key, nil
+}
+
+// bcryptHasher hashes passwords using bcrypt, hashes are encoded in the standard bcrypt format.
+type bcryptHasher struct {
+	cost int
+}
+
+func (h bcryptHasher) hash(password string) (string, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), h.cost)
+	if err != nil {
+		return "", err
+	}
+
+	return string(hashedPassword), nil
+}
+
+func (h bcryptHasher) verify(password, hashedPassword string) error {
+	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return ErrInvalidCredentials
+	}
+
+	return err
+}
+
+func (h bcryptHasher) outdated(hashedPassword string) bool {
+	cost, err := bcrypt.Cost([]byte(hashedPassword))
+	return err != nil || cost != h.cost
+}
@@ -0,0 +1,178 @@
+package service
+
+import (
+	"crypto/rand"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/directory/pkg/repository"
+	"github.com/mimir-news/pkg/httputil"
+	"github.com/mimir-news/pkg/httputil/auth"
+	"github.com/mimir-news/pkg/schema/user"
+)
+
+const passwordResetTTL = 1 * time.Hour
+
+// PasswordResetService service responsible for resetting forgotten passwords.
+type PasswordResetService interface {
+	RequestReset(email string) error
+	Reset(key string, reset domain.PasswordReset, client domain.ClientInfo) error
+}
+
+// NewPasswordResetService creates a new PasswordResetService using the default implementation.
+func NewPasswordResetService(
+	pwdSvc *PasswordService, mailer Mailer, userRepo repository.UserRepo,
+	sessionRepo repository.SessionRepo, credentialRepo repository.OneTimeCredentialRepo,
+	events SecurityEventRecorder) PasswordResetService {
+	return &passwordResetSvc{
+		passwordSvc:    pwdSvc,
+		mailer:         mailer,
+		userRepo:       userRepo,
+		sessionRepo:    sessionRepo,
+		credentialRepo: credentialRepo,
+		events:         events,
+	}
+}
+
+type passwordResetSvc struct {
+	passwordSvc    *PasswordService
+	mailer         Mailer
+	userRepo       repository.UserRepo
+	sessionRepo    repository.SessionRepo
+	credentialRepo repository.OneTimeCredentialRepo
+	events         SecurityEventRecorder
+}
+
+// RequestReset creates a reset key for the user with the given email and mails it to the user.
+// No error is returned if the user does not exist, has been deleted or if the email could not be sent
+// in order to not reveal which emails are registered.
+func (rs *passwordResetSvc) RequestReset(email string) error {
+	u, err := rs.userRepo.FindByEmail(email)
+	if err == repository.ErrNoSuchUser {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if u.IsDeleted() {
+		return nil
+	}
+
+	key, err := generateKey()
+	if err != nil {
+		return err
+	}
+
+	credential := domain.NewOneTimeCredential(domain.PasswordResetCredential, hashKey(key), u.User.ID, passwordResetTTL)
+	err = rs.credentialRepo.Save(credential)
+	if err != nil {
+		return err
+	}
+
+	err = rs.mailer.Send(newPasswordResetEmail(u.User.Email, key, credential.ValidTo))
+	if err != nil {
+		log.Printf("Failed to send password reset to user %s: %s\n", u.User.ID, err)
+	}
+
+	return nil
+}
+
+// Reset sets a new password for the user that the reset key was issued to
+// and deactivates all of the users sessions. The password change is recorded as a security event.
+func (rs *passwordResetSvc) Reset(key string, reset domain.PasswordReset, client domain.ClientInfo) error {
+	if reset.New != reset.Repeated {
+		return errPasswordMissmatch()
+	}
+
+	credential, err := rs.findValidCredential(key)
+	if err != nil {
+		return err
+	}
+
+	u, err := rs.userRepo.Find(credential.UserID)
+	if err == repository.ErrNoSuchUser {
+		return errInvalidResetKey()
+	} else if err != nil {
+		return err
+	}
+
+	if u.User.Email == "" {
+		return errInvalidResetKey()
+	}
+
+	err = rs.credentialRepo.MarkUsed(credential.ID)
+	if err == repository.ErrNoSuchCredential {
+		return errInvalidResetKey()
+	} else if err != nil {
+		return err
+	}
+
+	newCreds, err := rs.passwordSvc.Create(user.Credentials{Email: u.User.Email, Password: reset.New})
+	if err != nil {
+		return err
+	}
+
+	// Receiving the reset key proves ownership of the email address.
+	u.Credentials = newCreds
+	u.EmailVerified = true
+	err = rs.userRepo.Save(u)
+	if err != nil {
+		return err
+	}
+
+	err = invalidateSessions(rs.userRepo, rs.sessionRepo, u.User.ID)
+	if err != nil {
+		return err
+	}
+
+	return rs.events.Record(domain.NewAccountEvent(domain.PasswordChangeEvent, u.User.ID, "", client))
+}
+
+func (rs *passwordResetSvc) findValidCredential(key string) (domain.OneTimeCredential, error) {
+	credential, err := rs.credentialRepo.FindByKey(domain.PasswordResetCredential, hashKey(key))
+	if err == repository.ErrNoSuchCredential {
+		return credential, errInvalidResetKey()
+	} else if err != nil {
+		return credential, err
+	}
+
+	if !credential.Valid() {
+		return credential, errInvalidResetKey()
+	}
+
+	return credential, nil
+}
+
+func newPasswordResetEmail(to, key string, validTo time.Time) domain.Email {
+	return domain.Email{
+		To:      to,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf(
+			"Use the following key to reset your password: %s\n\nThe key is valid until %s.",
+			key, validTo.Format(time.RFC1123)),
+	}
+}
+
+// generateKey generates a random key to hand out to a user.
+func generateKey() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", b), nil
+}
+
+// hashKey hashes a key so that it can be stored and looked up without storing the key itself.
+// Additional values which the key should be bound to can be provided as parts.
+func hashKey(key string, parts ...string) string {
+	return fmt.Sprintf("%x", auth.HashKey(append([]string{key}, parts...)...))
+}
+
+func errInvalidResetKey() error {
+	return httputil.NewError("Invalid or expired password reset key", http.StatusForbidden)
+}
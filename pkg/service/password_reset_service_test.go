@@ -0,0 +1,202 @@
+package service_test
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/directory/pkg/repository"
+	"github.com/mimir-news/directory/pkg/service"
+	"github.com/mimir-news/pkg/httputil"
+	"github.com/mimir-news/pkg/httputil/auth"
+	"github.com/mimir-news/pkg/id"
+	"github.com/mimir-news/pkg/schema/user"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestPasswordReset(t *testing.T) {
+	assert := assert.New(t)
+
+	storedUser := domain.FullUser{
+		User: user.User{
+			ID:    id.New(),
+			Email: "mail@mail.com",
+		},
+	}
+
+	userRepo := &repository.MockUserRepo{
+		FindByEmailUser: storedUser,
+	}
+	credentialRepo := &repository.MockOneTimeCredentialRepo{}
+	mailer := &service.MockMailer{}
+	resetSvc := service.NewPasswordResetService(nil, mailer, userRepo, nil, credentialRepo, nil)
+
+	err := resetSvc.RequestReset(storedUser.User.Email)
+	assert.NoError(err)
+	assert.Equal(storedUser.User.Email, userRepo.FindByEmailArg)
+	assert.Equal(1, credentialRepo.SaveInvocation)
+	savedCredential := credentialRepo.SaveArg
+	assert.Equal(storedUser.User.ID, savedCredential.UserID)
+	assert.False(savedCredential.HasBeenUsed)
+	assert.True(savedCredential.ValidTo.After(time.Now().UTC()))
+
+	email, ok := mailer.LastSent()
+	assert.True(ok)
+	assert.Equal(storedUser.User.Email, email.To)
+	assert.False(strings.Contains(email.Body, savedCredential.Key))
+	key := extractKey(email.Body)
+	assert.Equal(fmt.Sprintf("%x", auth.HashKey(key)), savedCredential.Key)
+
+	// Failures to send the email should not reveal that the user exists.
+	credentialRepo.UnsetArgs()
+	mailer.SendErr = testError
+	err = resetSvc.RequestReset(storedUser.User.Email)
+	assert.NoError(err)
+	assert.Equal(1, credentialRepo.SaveInvocation)
+	mailer.SendErr = nil
+
+	// Deleted users should not be sent reset keys.
+	credentialRepo.UnsetArgs()
+	mailer.Outbox = nil
+	deletedUser := storedUser
+	deletedUser.DeletedAt = time.Now().UTC()
+	userRepo.FindByEmailUser = deletedUser
+	err = resetSvc.RequestReset(storedUser.User.Email)
+	assert.NoError(err)
+	assert.Equal(0, credentialRepo.SaveInvocation)
+	assert.Equal(0, len(mailer.Outbox))
+	userRepo.FindByEmailUser = storedUser
+
+	// Unknown emails should not reveal that the user does not exist.
+	credentialRepo.UnsetArgs()
+	mailer.Outbox = nil
+	userRepo.FindByEmailErr = repository.ErrNoSuchUser
+	err = resetSvc.RequestReset("unknown@mail.com")
+	assert.NoError(err)
+	assert.Equal(0, credentialRepo.SaveInvocation)
+	assert.Equal(0, len(mailer.Outbox))
+
+	userRepo.FindByEmailErr = testError
+	err = resetSvc.RequestReset(storedUser.User.Email)
+	assert.Equal(testError, err)
+	assert.Equal(0, credentialRepo.SaveInvocation)
+}
+
+func TestResetPassword(t *testing.T) {
+	assert := assert.New(t)
+
+	key := "my-reset-key"
+	userID := id.New()
+	storedUser := domain.FullUser{
+		User: user.User{
+			ID:    userID,
+			Email: "mail@mail.com",
+		},
+		Credentials: domain.StoredCredentials{
+			Email:    "mail@mail.com",
+			Password: "S5UeZOWCDkIfP/5LUDpyhIY0l6+aow+CmkBEVtHqpebhe04vb6kDbPaD/wo05fs6x1lvJfI/6YZ66zbQ8X2lHaEThp4f1Zl0exk7j/wow740KbWZHf9DSA==", // Hashed and encrypted password.
+			Salt:     "3MQEKd3NVnU+WQFQxo8JpYWrTrqXOiwro4MwLwnsckWXinE=",                                                                         // Encrypted salt
+		},
+	}
+	credential := domain.NewOneTimeCredential(domain.PasswordResetCredential, fmt.Sprintf("%x", auth.HashKey(key)), userID, time.Hour)
+
+	userRepo := &repository.MockUserRepo{
+		FindUser: storedUser,
+	}
+	sessionRepo := &repository.MockSessionRepo{}
+	credentialRepo := &repository.MockOneTimeCredentialRepo{
+		FindByKeyCredential: credential,
+	}
+	passwordSvc := service.NewPasswordService(userRepo, "my-pepper", service.NewEncryptionKeyring("my-encryption-key"), service.DefaultHashingConfig, service.DefaultLockoutPolicy)
+	events := &service.MockEventRecorder{}
+	resetSvc := service.NewPasswordResetService(passwordSvc, nil, userRepo, sessionRepo, credentialRepo, events)
+	client := domain.ClientInfo{IP: "10.0.0.1", UserAgent: "test-agent"}
+
+	reset := domain.PasswordReset{
+		New:      "new-password",
+		Repeated: "new-password",
+	}
+
+	err := resetSvc.Reset(key, reset, client)
+	assert.NoError(err)
+	assert.Equal(credential.Key, credentialRepo.FindByKeyArg)
+	assert.Equal(credential.ID, credentialRepo.MarkUsedArg)
+	assert.Equal(userID, userRepo.FindArg)
+	assert.Equal(userID, userRepo.SaveArg.User.ID)
+	assert.NotEqual(storedUser.Credentials.Password, userRepo.SaveArg.Credentials.Password)
+	assert.NotEqual(storedUser.Credentials.Salt, userRepo.SaveArg.Credentials.Salt)
+	assert.Equal(userID, sessionRepo.DeleteByUserIDArg)
+	assert.Equal(userID, userRepo.InvalidateSessionsArgUserID)
+	assert.False(userRepo.InvalidateSessionsArgValidAfter.IsZero())
+	assert.Equal(1, len(events.Events))
+	assert.Equal(domain.PasswordChangeEvent, events.Events[0].Type)
+	assert.Equal(userID, events.Events[0].UserID)
+	assert.Equal(userID, events.Events[0].ActorID)
+	assert.Equal(client, events.Events[0].Client)
+
+	userRepo.FindByEmailUser = userRepo.SaveArg
+	err = passwordSvc.Verify(user.Credentials{Email: storedUser.User.Email, Password: reset.New})
+	assert.NoError(err)
+
+	// Test reset with mismatching passwords.
+	credentialRepo.UnsetArgs()
+	sessionRepo.UnsetArgs()
+	err = resetSvc.Reset(key, domain.PasswordReset{New: "new-password", Repeated: "other-password"}, client)
+	assertHTTPStatus(assert, http.StatusBadRequest, err)
+	assert.Equal(0, credentialRepo.FindByKeyInvocation)
+
+	// Test reset with unknown key.
+	credentialRepo.FindByKeyErr = repository.ErrNoSuchCredential
+	err = resetSvc.Reset("wrong-key", reset, client)
+	assertHTTPStatus(assert, http.StatusForbidden, err)
+	assert.Equal(0, credentialRepo.MarkUsedInvocation)
+	assert.Equal(0, sessionRepo.DeleteByUserIDInvocation)
+
+	// Test reset with used key.
+	credentialRepo.UnsetArgs()
+	credentialRepo.FindByKeyErr = nil
+	usedCredential := credential
+	usedCredential.HasBeenUsed = true
+	credentialRepo.FindByKeyCredential = usedCredential
+	err = resetSvc.Reset(key, reset, client)
+	assertHTTPStatus(assert, http.StatusForbidden, err)
+	assert.Equal(0, credentialRepo.MarkUsedInvocation)
+
+	// Test reset with expired key.
+	credentialRepo.UnsetArgs()
+	expiredCredential := credential
+	expiredCredential.ValidTo = time.Now().UTC().Add(-1 * time.Minute)
+	credentialRepo.FindByKeyCredential = expiredCredential
+	err = resetSvc.Reset(key, reset, client)
+	assertHTTPStatus(assert, http.StatusForbidden, err)
+	assert.Equal(0, credentialRepo.MarkUsedInvocation)
+
+	// Test reset when the key is used concurrently.
+	credentialRepo.UnsetArgs()
+	credentialRepo.FindByKeyCredential = credential
+	credentialRepo.MarkUsedErr = repository.ErrNoSuchCredential
+	err = resetSvc.Reset(key, reset, client)
+	assertHTTPStatus(assert, http.StatusForbidden, err)
+	assert.Equal(1, credentialRepo.MarkUsedInvocation)
+	assert.Equal(0, sessionRepo.DeleteByUserIDInvocation)
+	assert.Equal(1, len(events.Events))
+}
+
+func extractKey(body string) string {
+	prefix := "reset your password: "
+	start := strings.Index(body, prefix) + len(prefix)
+	end := strings.Index(body[start:], "\n")
+	return body[start : start+end]
+}
+
+func assertHTTPStatus(assert *assert.Assertions, expectedStatus int, err error) {
+	assert.Error(err)
+	httpErr, ok := err.(*httputil.Error)
+	assert.True(ok)
+	if ok {
+		assert.Equal(expectedStatus, httpErr.StatusCode)
+	}
+}
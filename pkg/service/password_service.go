@@ -1,9 +1,9 @@
 package service
 
 import (
-	"encoding/base64"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/mimir-news/directory/pkg/domain"
 	"github.com/mimir-news/directory/pkg/repository"
@@ -14,8 +14,40 @@ import (
 // Common errors
 var (
 	ErrInvalidCredentials = errors.New("Invalid credentials")
+	ErrAccountLocked      = errors.New("Account is locked")
 )
 
+// DefaultLockoutPolicy lockout policy used unless configured otherwise.
+var DefaultLockoutPolicy = LockoutPolicy{
+	MaxAttempts: 5,
+	Window:      15 * time.Minute,
+	BaseLockout: 1 * time.Minute,
+	MaxLockout:  1 * time.Hour,
+}
+
+// LockoutPolicy describes when and for how long accounts are locked after failed logins.
+// Accounts are locked once MaxAttempts failed logins have been made within the Window.
+// The lock duration starts at BaseLockout and doubles with every consecutive lockout up to MaxLockout.
+type LockoutPolicy struct {
+	MaxAttempts int
+	Window      time.Duration
+	BaseLockout time.Duration
+	MaxLockout  time.Duration
+}
+
+// lockoutDuration returns the time to lock an account that has been locked out a number of times before.
+func (lp LockoutPolicy) lockoutDuration(previousLockouts int) time.Duration {
+	duration := lp.BaseLockout
+	for i := 0; i < previousLockouts && duration < lp.MaxLockout; i++ {
+		duration *= 2
+	}
+
+	if duration > lp.MaxLockout {
+		return lp.MaxLockout
+	}
+	return duration
+}
+
 var (
 	emptyCredentials = domain.StoredCredentials{}
 )
@@ -24,58 +56,103 @@ var (
 // validating user credentials.
 type PasswordService struct {
 	userRepo  repository.UserRepo
-	hasher    auth.Hasher
+	hasher    hashingScheme
 	encryptor encryptionScheme
+	lockout   LockoutPolicy
 }
 
 // NewPasswordService sets up a password service.
-func NewPasswordService(userRepo repository.UserRepo, pepper, encryptionKey string) *PasswordService {
+func NewPasswordService(
+	userRepo repository.UserRepo, pepper string, keyring EncryptionKeyring,
+	hashing HashingConfig, lockout LockoutPolicy) *PasswordService {
 	return &PasswordService{
-		userRepo: userRepo,
-		hasher:   auth.NewHasher(pepper),
-		encryptor: encryptionScheme{
-			hashedKey: auth.HashKey(encryptionKey),
-			encryptor: auth.NewAESEncryptor(),
-			decryptor: auth.NewAESDecryptor(),
-		},
+		userRepo:  userRepo,
+		hasher:    newHashingScheme(pepper, hashing),
+		encryptor: newEncryptionScheme(keyring),
+		lockout:   lockout,
 	}
 }
 
 // Verify checks that a set of provided credenitals are valid.
+// Failed attempts are counted and the account is temporarily locked if too many are made.
+// Credentials hashed with an outdated algorithm or cost are rehashed on successful verification.
 func (p *PasswordService) Verify(credentials user.Credentials) error {
-	storedCredentials, err := p.getCredentialsByEmail(credentials.Email)
+	storedUser, err := p.userRepo.FindByEmail(credentials.Email)
 	if err != nil {
+		return err
+	}
+
+	return p.VerifyUser(storedUser, credentials.Password)
+}
+
+// VerifyUser checks that a password is valid for a stored user. Failed logins of users with
+// multi factor authentication enabled are not reset until the second factor has been verified.
+func (p *PasswordService) VerifyUser(storedUser domain.FullUser, password string) error {
+	if storedUser.IsLocked(now()) {
+		return ErrAccountLocked
+	}
 
+	hashedPwd, err := p.verifyPassword(password, storedUser.Credentials)
+	if err == ErrInvalidCredentials {
+		lockErr := p.RecordFailedLogin(storedUser.User.ID)
+		if lockErr != nil {
+			return lockErr
+		}
+		return err
+	} else if err != nil {
 		return err
 	}
 
+	if !storedUser.TOTP.Enabled {
+		err = p.ResetLoginFailures(storedUser)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !p.hasher.needsRehash(hashedPwd) {
+		return nil
+	}
+	return p.rehash(storedUser, password)
+}
+
+// verifyPassword checks that a password matches a set of stored credentials
+// and returns the decrypted password hash.
+func (p *PasswordService) verifyPassword(password string, storedCredentials domain.StoredCredentials) (string, error) {
 	hashedPwd, err := p.encryptor.decrypt(storedCredentials.Password)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	salt, err := p.encryptor.decrypt(storedCredentials.Salt)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	saltedPassword := p.saltPassword(credentials.Password, salt)
-	err = p.hasher.Verify(saltedPassword, hashedPwd)
-	if err == auth.ErrHashDoesNotMatch {
-		return ErrInvalidCredentials
+	saltedPassword := p.saltPassword(password, salt)
+	return hashedPwd, p.hasher.verify(saltedPassword, hashedPwd)
+}
+
+// rehash hashes a verified password with the current hashing configuration and saves it.
+func (p *PasswordService) rehash(storedUser domain.FullUser, password string) error {
+	newCredentials, err := p.Create(user.Credentials{Email: storedUser.Credentials.Email, Password: password})
+	if err != nil {
+		return err
 	}
-	return err
+
+	storedUser.Credentials = newCredentials
+	return p.userRepo.Save(storedUser)
 }
 
-// ChangePassword verifies old credentials and updates password and salt if successful.
-func (p *PasswordService) ChangePassword(newPassword string, oldCredentials user.Credentials) (domain.StoredCredentials, error) {
-	err := p.Verify(oldCredentials)
+// ChangePassword verifies the old password of a stored user and creates new credentials if successful.
+func (p *PasswordService) ChangePassword(storedUser domain.FullUser, newPassword, oldPassword string) (domain.StoredCredentials, error) {
+	err := p.VerifyUser(storedUser, oldPassword)
 	if err != nil {
 		return emptyCredentials, err
 	}
 
 	newCredentials := user.Credentials{
-		Email:    oldCredentials.Email,
+		Email:    storedUser.Credentials.Email,
 		Password: newPassword,
 	}
 	return p.Create(newCredentials)
@@ -89,7 +166,7 @@ func (p *PasswordService) Create(credentials user.Credentials) (domain.StoredCre
 	}
 
 	saltedPassword := p.saltPassword(credentials.Password, salt)
-	hashedPassword, err := p.hasher.Hash(saltedPassword)
+	hashedPassword, err := p.hasher.hash(saltedPassword)
 	if err != nil {
 		return emptyCredentials, err
 	}
@@ -112,49 +189,114 @@ func (p *PasswordService) Create(credentials user.Credentials) (domain.StoredCre
 	return encryptedCredentials, nil
 }
 
-// getCredentialsByEmail retrieves a users credentials from their email.
-func (p *PasswordService) getCredentialsByEmail(email string) (domain.StoredCredentials, error) {
-	user, err := p.userRepo.FindByEmail(email)
+// ReencryptCredentials walks through all stored credentials and TOTP secrets in batches and re-encrypts
+// the ones which are not encrypted with the active encryption key. Returns the number of re-encrypted credentials.
+func (p *PasswordService) ReencryptCredentials(batchSize int) (int, error) {
+	reencrypted := 0
+	lastUserID := ""
+	for {
+		batch, err := p.userRepo.ListCredentials(lastUserID, batchSize)
+		if err != nil {
+			return reencrypted, err
+		}
+
+		for _, c := range batch {
+			lastUserID = c.UserID
+			ok, err := p.reencrypt(c)
+			if err != nil {
+				return reencrypted, fmt.Errorf("Failed to re-encrypt credentials of user %s: %s", c.UserID, err)
+			}
+			if ok {
+				reencrypted++
+			}
+		}
+
+		if len(batch) < batchSize {
+			return reencrypted, nil
+		}
+	}
+}
+
+// reencrypt re-encrypts a users credentials and TOTP secret with the active key,
+// credentials that were changed while being re-encrypted are left as is.
+func (p *PasswordService) reencrypt(c domain.UserCredentials) (bool, error) {
+	if p.usesActiveKey(c.Credentials.Password) && p.usesActiveKey(c.Credentials.Salt) && p.usesActiveKey(c.TOTPSecret) {
+		return false, nil
+	}
+
+	password, err := p.reencryptValue(c.Credentials.Password)
 	if err != nil {
-		return emptyCredentials, err
+		return false, err
 	}
 
-	return user.Credentials, nil
-}
+	salt, err := p.reencryptValue(c.Credentials.Salt)
+	if err != nil {
+		return false, err
+	}
 
-// saltPassword concatenates password and salt and returns its checksum.
-func (p *PasswordService) saltPassword(password, salt string) string {
-	return fmt.Sprintf("%x", auth.HashKey(password, salt))
+	totpSecret, err := p.reencryptValue(c.TOTPSecret)
+	if err != nil {
+		return false, err
+	}
+
+	newCredentials := domain.UserCredentials{
+		UserID: c.UserID,
+		Credentials: domain.StoredCredentials{
+			Email:    c.Credentials.Email,
+			Password: password,
+			Salt:     salt,
+		},
+		TOTPSecret: totpSecret,
+	}
+	err = p.userRepo.ReplaceCredentials(c, newCredentials)
+	if err == repository.ErrNoSuchUser {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return true, nil
 }
 
-// encryptionScheme symetric encryption scheme used for encryption and decryption of secrets.
-type encryptionScheme struct {
-	hashedKey []byte
-	encryptor auth.Encryptor
-	decryptor auth.Decryptor
+// usesActiveKey checks if an encrypted value is either missing or encrypted with the active key.
+func (p *PasswordService) usesActiveKey(ciphertext string) bool {
+	return ciphertext == "" || p.encryptor.usesActiveKey(ciphertext)
 }
 
-// encrypt encrypts a string and returns its base64 encoded ciphertext.
-func (e encryptionScheme) encrypt(plaintext string) (string, error) {
-	ciphertext, err := e.encryptor.Encrypt(e.hashedKey, []byte(plaintext))
-	if err != nil {
-		return "", err
+// reencryptValue re-encrypts a value with the active key, missing values are left empty.
+func (p *PasswordService) reencryptValue(ciphertext string) (string, error) {
+	if ciphertext == "" {
+		return "", nil
 	}
 
-	return base64.StdEncoding.EncodeToString(ciphertext), nil
+	return p.encryptor.reencrypt(ciphertext)
 }
 
-// decrypt decodes and decrypts ciphertext and returns the plaintext.
-func (e encryptionScheme) decrypt(ciphertext string) (string, error) {
-	decodedCiphertext, err := base64.StdEncoding.DecodeString(ciphertext)
+// RecordFailedLogin counts a failed login and locks the account if the lockout policy is exceeded.
+func (p *PasswordService) RecordFailedLogin(userID string) error {
+	failures, err := p.userRepo.RecordFailedLogin(userID, now().Add(-1*p.lockout.Window))
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	plaintext, err := e.decryptor.Decrypt(e.hashedKey, decodedCiphertext)
-	if err != nil {
-		return "", err
+	if failures.Attempts < p.lockout.MaxAttempts {
+		return nil
 	}
 
-	return string(plaintext), nil
+	lockedUntil := now().Add(p.lockout.lockoutDuration(failures.Lockouts))
+	return p.userRepo.LockUntil(userID, lockedUntil)
+}
+
+// ResetLoginFailures clears the failed logins of a user if there are any.
+func (p *PasswordService) ResetLoginFailures(storedUser domain.FullUser) error {
+	if storedUser.LoginFailures.Attempts == 0 && storedUser.LoginFailures.Lockouts == 0 {
+		return nil
+	}
+
+	return p.userRepo.ResetLoginFailures(storedUser.User.ID)
+}
+
+// saltPassword concatenates password and salt and returns its checksum.
+func (p *PasswordService) saltPassword(password, salt string) string {
+	return fmt.Sprintf("%x", auth.HashKey(password, salt))
 }
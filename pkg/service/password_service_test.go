@@ -2,11 +2,14 @@ package service_test
 
 import (
 	"errors"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/mimir-news/pkg/id"
 
 	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/directory/pkg/repository"
 	"github.com/mimir-news/directory/pkg/service"
 	"github.com/mimir-news/pkg/schema/user"
 	"github.com/stretchr/testify/assert"
@@ -41,7 +44,7 @@ func TestVerify(t *testing.T) {
 		findByEmailUser: storedUser,
 	}
 
-	passwordSvc := service.NewPasswordService(userRepo, "my-pepper", "my-encryption-key")
+	passwordSvc := service.NewPasswordService(userRepo, "my-pepper", service.NewEncryptionKeyring("my-encryption-key"), service.DefaultHashingConfig, service.DefaultLockoutPolicy)
 
 	creds := user.Credentials{
 		Email:    storedUser.User.Email,
@@ -72,6 +75,209 @@ func TestVerify(t *testing.T) {
 	assert.Equal(creds.Email, userRepo.findByEmailArg)
 }
 
+func TestVerifyRehash(t *testing.T) {
+	assert := assert.New(t)
+
+	legacyUser := domain.FullUser{
+		User: user.User{
+			ID:    id.New(),
+			Email: "mail@mail.com",
+		},
+		Credentials: domain.StoredCredentials{
+			Email:    "mail@mail.com",
+			Password: "S5UeZOWCDkIfP/5LUDpyhIY0l6+aow+CmkBEVtHqpebhe04vb6kDbPaD/wo05fs6x1lvJfI/6YZ66zbQ8X2lHaEThp4f1Zl0exk7j/wow740KbWZHf9DSA==", // Legacy hashed and encrypted password.
+			Salt:     "3MQEKd3NVnU+WQFQxo8JpYWrTrqXOiwro4MwLwnsckWXinE=",                                                                         // Encrypted salt
+		},
+	}
+	creds := user.Credentials{
+		Email:    legacyUser.User.Email,
+		Password: "super-secret-password",
+	}
+
+	userRepo := &mockUserRepo{
+		findByEmailUser: legacyUser,
+	}
+	argon2Svc := service.NewPasswordService(userRepo, "my-pepper", service.NewEncryptionKeyring("my-encryption-key"), service.DefaultHashingConfig, service.DefaultLockoutPolicy)
+
+	// Legacy hashes should be verified and rehashed with the configured algorithm.
+	err := argon2Svc.Verify(creds)
+	assert.NoError(err)
+	rehashedUser := userRepo.saveArg
+	assert.Equal(legacyUser.User.ID, rehashedUser.User.ID)
+	assert.Equal(legacyUser.Credentials.Email, rehashedUser.Credentials.Email)
+	assert.NotEqual(legacyUser.Credentials.Password, rehashedUser.Credentials.Password)
+
+	// Up to date hashes should not be rehashed.
+	userRepo.saveArg = domain.FullUser{}
+	userRepo.findByEmailUser = rehashedUser
+	err = argon2Svc.Verify(creds)
+	assert.NoError(err)
+	assert.Equal("", userRepo.saveArg.User.ID)
+
+	err = argon2Svc.Verify(user.Credentials{Email: creds.Email, Password: "wrong-password"})
+	assert.Equal(service.ErrInvalidCredentials, err)
+	assert.Equal("", userRepo.saveArg.User.ID)
+
+	// Hashes made with another algorithm should be rehashed.
+	bcryptConfig := service.DefaultHashingConfig
+	bcryptConfig.Algorithm = service.BcryptAlgorithm
+	bcryptConfig.BcryptCost = 4
+	bcryptSvc := service.NewPasswordService(userRepo, "my-pepper", service.NewEncryptionKeyring("my-encryption-key"), bcryptConfig, service.DefaultLockoutPolicy)
+	err = bcryptSvc.Verify(creds)
+	assert.NoError(err)
+	assert.Equal(legacyUser.User.ID, userRepo.saveArg.User.ID)
+	bcryptUser := userRepo.saveArg
+
+	userRepo.saveArg = domain.FullUser{}
+	userRepo.findByEmailUser = bcryptUser
+	err = bcryptSvc.Verify(creds)
+	assert.NoError(err)
+	assert.Equal("", userRepo.saveArg.User.ID)
+
+	// Hashes made with an outdated cost should be rehashed.
+	bcryptConfig.BcryptCost = 5
+	costlierBcryptSvc := service.NewPasswordService(userRepo, "my-pepper", service.NewEncryptionKeyring("my-encryption-key"), bcryptConfig, service.DefaultLockoutPolicy)
+	err = costlierBcryptSvc.Verify(creds)
+	assert.NoError(err)
+	assert.Equal(legacyUser.User.ID, userRepo.saveArg.User.ID)
+
+	// Hashes made with a different algorithm should still be verified.
+	userRepo.saveArg = domain.FullUser{}
+	userRepo.findByEmailUser = rehashedUser
+	err = bcryptSvc.Verify(user.Credentials{Email: creds.Email, Password: "wrong-password"})
+	assert.Equal(service.ErrInvalidCredentials, err)
+	assert.Equal("", userRepo.saveArg.User.ID)
+}
+
+func TestHashingConfigValid(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError(service.DefaultHashingConfig.Valid())
+
+	config := service.DefaultHashingConfig
+	config.Algorithm = service.BcryptAlgorithm
+	config.BcryptCost = 14
+	config.Argon2id.Memory = 128 * 1024
+	assert.NoError(config.Valid())
+
+	config = service.DefaultHashingConfig
+	config.Algorithm = "md5"
+	assert.Error(config.Valid())
+
+	config = service.DefaultHashingConfig
+	config.Argon2id.Memory = 1024
+	assert.Error(config.Valid())
+
+	config = service.DefaultHashingConfig
+	config.Argon2id.Iterations = 0
+	assert.Error(config.Valid())
+
+	config = service.DefaultHashingConfig
+	config.Argon2id.Parallelism = 0
+	assert.Error(config.Valid())
+
+	config = service.DefaultHashingConfig
+	config.BcryptCost = 4
+	assert.Error(config.Valid())
+
+	config.BcryptCost = 32
+	assert.Error(config.Valid())
+}
+
+func TestVerifyLockout(t *testing.T) {
+	assert := assert.New(t)
+
+	storedUser := domain.FullUser{
+		User: user.User{
+			ID:    id.New(),
+			Email: "mail@mail.com",
+		},
+		Credentials: domain.StoredCredentials{
+			Email:    "mail@mail.com",
+			Password: "S5UeZOWCDkIfP/5LUDpyhIY0l6+aow+CmkBEVtHqpebhe04vb6kDbPaD/wo05fs6x1lvJfI/6YZ66zbQ8X2lHaEThp4f1Zl0exk7j/wow740KbWZHf9DSA==", // Hashed and encrypted password.
+			Salt:     "3MQEKd3NVnU+WQFQxo8JpYWrTrqXOiwro4MwLwnsckWXinE=",                                                                         // Encrypted salt
+		},
+	}
+	policy := service.LockoutPolicy{
+		MaxAttempts: 3,
+		Window:      10 * time.Minute,
+		BaseLockout: 1 * time.Minute,
+		MaxLockout:  10 * time.Minute,
+	}
+
+	userRepo := &mockUserRepo{
+		findByEmailUser: storedUser,
+		recordFailedLoginRes: domain.LoginFailures{
+			Attempts: 1,
+		},
+	}
+	passwordSvc := service.NewPasswordService(userRepo, "my-pepper", service.NewEncryptionKeyring("my-encryption-key"), service.DefaultHashingConfig, policy)
+
+	creds := user.Credentials{
+		Email:    storedUser.User.Email,
+		Password: "super-secret-password",
+	}
+	wrongCreds := user.Credentials{
+		Email:    storedUser.User.Email,
+		Password: "wrong-password",
+	}
+
+	// Failed attempts below the limit should only be recorded.
+	err := passwordSvc.Verify(wrongCreds)
+	assert.Equal(service.ErrInvalidCredentials, err)
+	assert.Equal(storedUser.User.ID, userRepo.recordFailedLoginArg)
+	assert.True(userRepo.lockUntilArg.IsZero())
+
+	// Reaching the limit should lock the account.
+	userRepo.recordFailedLoginRes = domain.LoginFailures{Attempts: 3}
+	err = passwordSvc.Verify(wrongCreds)
+	assert.Equal(service.ErrInvalidCredentials, err)
+	lockDuration := userRepo.lockUntilArg.Sub(time.Now().UTC())
+	assert.True(lockDuration > 50*time.Second && lockDuration <= time.Minute)
+
+	// Consecutive lockouts should back off exponentially up to the max lockout.
+	userRepo.recordFailedLoginRes = domain.LoginFailures{Attempts: 3, Lockouts: 2}
+	err = passwordSvc.Verify(wrongCreds)
+	assert.Equal(service.ErrInvalidCredentials, err)
+	lockDuration = userRepo.lockUntilArg.Sub(time.Now().UTC())
+	assert.True(lockDuration > 3*time.Minute && lockDuration <= 4*time.Minute)
+
+	userRepo.recordFailedLoginRes = domain.LoginFailures{Attempts: 3, Lockouts: 10}
+	err = passwordSvc.Verify(wrongCreds)
+	assert.Equal(service.ErrInvalidCredentials, err)
+	lockDuration = userRepo.lockUntilArg.Sub(time.Now().UTC())
+	assert.True(lockDuration > 9*time.Minute && lockDuration <= 10*time.Minute)
+
+	// Temporarily locked accounts should be rejected even with valid credentials.
+	userRepo.recordFailedLoginArg = ""
+	lockedUser := storedUser
+	lockedUser.LoginFailures = domain.LoginFailures{
+		Lockouts:    1,
+		LockedUntil: time.Now().UTC().Add(time.Minute),
+	}
+	userRepo.findByEmailUser = lockedUser
+	err = passwordSvc.Verify(creds)
+	assert.Equal(service.ErrAccountLocked, err)
+	err = passwordSvc.Verify(wrongCreds)
+	assert.Equal(service.ErrAccountLocked, err)
+	assert.Equal("", userRepo.recordFailedLoginArg)
+	assert.Equal("", userRepo.resetLoginFailuresArg)
+
+	// Locks should expire after the cooldown and a successful login should reset failures.
+	lockedUser.LoginFailures.LockedUntil = time.Now().UTC().Add(-1 * time.Second)
+	userRepo.findByEmailUser = lockedUser
+	err = passwordSvc.Verify(creds)
+	assert.NoError(err)
+	assert.Equal(storedUser.User.ID, userRepo.resetLoginFailuresArg)
+
+	// Permanently locked accounts should always be rejected.
+	permanentlyLockedUser := storedUser
+	permanentlyLockedUser.Locked = true
+	userRepo.findByEmailUser = permanentlyLockedUser
+	err = passwordSvc.Verify(creds)
+	assert.Equal(service.ErrAccountLocked, err)
+}
+
 func TestChangePassword(t *testing.T) {
 	assert := assert.New(t)
 
@@ -101,10 +307,14 @@ func TestChangePassword(t *testing.T) {
 		Password: "super-new-password",
 	}
 
-	passwordSvc := service.NewPasswordService(userRepo, "my-pepper", "my-encryption-key")
-	res, err := passwordSvc.ChangePassword(newCreds.Password, oldCreds)
+	passwordSvc := service.NewPasswordService(userRepo, "my-pepper", service.NewEncryptionKeyring("my-encryption-key"), service.DefaultHashingConfig, service.DefaultLockoutPolicy)
+	res, err := passwordSvc.ChangePassword(storedUser, newCreds.Password, "wrong-password")
+	assert.Equal(service.ErrInvalidCredentials, err)
+	assert.Equal("", res.Password)
+
+	res, err = passwordSvc.ChangePassword(storedUser, newCreds.Password, oldCreds.Password)
 	assert.Nil(err)
-	assert.Equal(oldCreds.Email, userRepo.findByEmailArg)
+	assert.Equal("", userRepo.findByEmailArg)
 	assert.Equal(newCreds.Email, res.Email)
 	assert.NotEqual(storedUser.Credentials.Password, res.Password)
 	assert.NotEqual(storedUser.Credentials.Salt, res.Salt)
@@ -150,7 +360,7 @@ func TestCreate(t *testing.T) {
 	}
 
 	userRepo := &mockUserRepo{}
-	passwordSvc := service.NewPasswordService(userRepo, "my-pepper", "my-encryption-key")
+	passwordSvc := service.NewPasswordService(userRepo, "my-pepper", service.NewEncryptionKeyring("my-encryption-key"), service.DefaultHashingConfig, service.DefaultLockoutPolicy)
 
 	credentials := user.Credentials{
 		Email:    "mail@mail.com",
@@ -207,6 +417,18 @@ type mockUserRepo struct {
 	findWatchlistsRes []user.Watchlist
 	findWatchlistsErr error
 	findWatchlistsArg string
+
+	recordFailedLoginRes domain.LoginFailures
+	recordFailedLoginErr error
+	recordFailedLoginArg string
+
+	lockUntilErr error
+	lockUntilArg time.Time
+
+	resetLoginFailuresErr error
+	resetLoginFailuresArg string
+
+	invalidateSessionsArg string
 }
 
 func (r *mockUserRepo) Find(id string) (domain.FullUser, error) {
@@ -234,6 +456,70 @@ func (r *mockUserRepo) FindWatchlists(userID string) ([]user.Watchlist, error) {
 	return r.findWatchlistsRes, r.findWatchlistsErr
 }
 
+func (r *mockUserRepo) RecordFailedLogin(userID string, windowStart time.Time) (domain.LoginFailures, error) {
+	r.recordFailedLoginArg = userID
+	return r.recordFailedLoginRes, r.recordFailedLoginErr
+}
+
+func (r *mockUserRepo) LockUntil(userID string, until time.Time) error {
+	r.lockUntilArg = until
+	return r.lockUntilErr
+}
+
+func (r *mockUserRepo) ResetLoginFailures(userID string) error {
+	r.resetLoginFailuresArg = userID
+	return r.resetLoginFailuresErr
+}
+
+func (r *mockUserRepo) ListCredentials(afterUserID string, limit int) ([]domain.UserCredentials, error) {
+	return nil, nil
+}
+
+func (r *mockUserRepo) ReplaceCredentials(old, new domain.UserCredentials) error {
+	return nil
+}
+
+func (r *mockUserRepo) InvalidateSessions(userID string, validAfter time.Time) error {
+	r.invalidateSessionsArg = userID
+	return nil
+}
+
+func (r *mockUserRepo) SaveTOTPSecret(userID, secret string) error {
+	return nil
+}
+
+func (r *mockUserRepo) EnableTOTP(userID string) error {
+	return nil
+}
+
+func (r *mockUserRepo) DisableTOTP(userID string) error {
+	return nil
+}
+
+func (r *mockUserRepo) UseTOTPStep(userID string, step int64) error {
+	return nil
+}
+
+func (r *mockUserRepo) PurgeInactiveGuests(inactiveSince time.Time, limit int) (int, error) {
+	return 0, nil
+}
+
+func (r *mockUserRepo) PurgeDeletedUsers(deletedBefore time.Time, limit int) (int, error) {
+	return 0, nil
+}
+
+func (r *mockUserRepo) Restore(userID string) error {
+	return nil
+}
+
+func (r *mockUserRepo) SearchByEmail(emailPrefix string, limit int) ([]domain.FullUser, error) {
+	return nil, nil
+}
+
+func (r *mockUserRepo) SetLocked(userID string, locked bool) error {
+	return nil
+}
+
 type mockSessionRepo struct {
 	saveErr error
 	saveArg domain.Session
@@ -243,3 +529,137 @@ func (sr *mockSessionRepo) Save(session domain.Session) error {
 	sr.saveArg = session
 	return sr.saveErr
 }
+
+func TestEncryptionKeyRotation(t *testing.T) {
+	assert := assert.New(t)
+
+	legacyCredentials := domain.StoredCredentials{
+		Email:    "mail@mail.com",
+		Password: "S5UeZOWCDkIfP/5LUDpyhIY0l6+aow+CmkBEVtHqpebhe04vb6kDbPaD/wo05fs6x1lvJfI/6YZ66zbQ8X2lHaEThp4f1Zl0exk7j/wow740KbWZHf9DSA==", // Hashed and encrypted password.
+		Salt:     "3MQEKd3NVnU+WQFQxo8JpYWrTrqXOiwro4MwLwnsckWXinE=",                                                                         // Encrypted salt
+	}
+	creds := user.Credentials{
+		Email:    "mail@mail.com",
+		Password: "super-secret-password",
+	}
+
+	keyring := service.EncryptionKeyring{
+		ActiveKeyID: "key-2",
+		Keys: map[string]string{
+			service.LegacyKeyID: "my-encryption-key",
+			"key-2":             "my-new-encryption-key",
+		},
+	}
+	assert.NoError(keyring.Valid())
+
+	userRepo := &repository.MockUserRepo{
+		FindByEmailUser: domain.FullUser{
+			User:        user.User{ID: id.New(), Email: creds.Email},
+			Credentials: legacyCredentials,
+		},
+	}
+	passwordSvc := service.NewPasswordService(userRepo, "my-pepper", keyring, service.DefaultHashingConfig, service.DefaultLockoutPolicy)
+
+	// Credentials encrypted with a decryption only key should still be valid.
+	err := passwordSvc.Verify(creds)
+	assert.NoError(err)
+
+	// New credentials should be encrypted with the active key.
+	newCredentials, err := passwordSvc.Create(creds)
+	assert.NoError(err)
+	assert.True(strings.HasPrefix(newCredentials.Password, "key-2:"))
+	assert.True(strings.HasPrefix(newCredentials.Salt, "key-2:"))
+
+	rotatedSvc := service.NewPasswordService(
+		userRepo, "my-pepper", service.EncryptionKeyring{
+			ActiveKeyID: "key-2",
+			Keys:        map[string]string{"key-2": "my-new-encryption-key"},
+		}, service.DefaultHashingConfig, service.DefaultLockoutPolicy)
+	userRepo.FindByEmailUser.Credentials = newCredentials
+	err = rotatedSvc.Verify(creds)
+	assert.NoError(err)
+
+	userRepo.FindByEmailUser.Credentials = legacyCredentials
+	err = rotatedSvc.Verify(creds)
+	assert.Error(err)
+	assert.NotEqual(service.ErrInvalidCredentials, err)
+
+	invalidKeyring := service.EncryptionKeyring{
+		ActiveKeyID: "key-3",
+		Keys:        map[string]string{"key-2": "my-new-encryption-key"},
+	}
+	assert.Error(invalidKeyring.Valid())
+	invalidKeyring = service.EncryptionKeyring{
+		ActiveKeyID: "key:2",
+		Keys:        map[string]string{"key:2": "my-new-encryption-key"},
+	}
+	assert.Error(invalidKeyring.Valid())
+}
+
+func TestReencryptCredentials(t *testing.T) {
+	assert := assert.New(t)
+
+	legacyCredentials := domain.StoredCredentials{
+		Email:    "mail@mail.com",
+		Password: "S5UeZOWCDkIfP/5LUDpyhIY0l6+aow+CmkBEVtHqpebhe04vb6kDbPaD/wo05fs6x1lvJfI/6YZ66zbQ8X2lHaEThp4f1Zl0exk7j/wow740KbWZHf9DSA==", // Hashed and encrypted password.
+		Salt:     "3MQEKd3NVnU+WQFQxo8JpYWrTrqXOiwro4MwLwnsckWXinE=",                                                                         // Encrypted salt
+	}
+	keyring := service.EncryptionKeyring{
+		ActiveKeyID: "key-2",
+		Keys: map[string]string{
+			service.LegacyKeyID: "my-encryption-key",
+			"key-2":             "my-new-encryption-key",
+		},
+	}
+
+	userRepo := &repository.MockUserRepo{}
+	passwordSvc := service.NewPasswordService(userRepo, "my-pepper", keyring, service.DefaultHashingConfig, service.DefaultLockoutPolicy)
+	rotatedCredentials, err := passwordSvc.Create(user.Credentials{Email: "other@mail.com", Password: "other-password"})
+	assert.NoError(err)
+
+	userRepo.ListCredentialsRes = []domain.UserCredentials{
+		{UserID: "user-1", Credentials: rotatedCredentials},
+		{UserID: "user-2", Credentials: rotatedCredentials},
+		{UserID: "user-3", Credentials: legacyCredentials},
+	}
+
+	count, err := passwordSvc.ReencryptCredentials(2)
+	assert.NoError(err)
+	assert.Equal(1, count)
+	assert.Equal(2, userRepo.ListCredentialsArgLimit)
+	assert.Equal("user-2", userRepo.ListCredentialsArgAfterID)
+	assert.Equal(2, userRepo.ListCredentialsInvocation)
+	assert.Equal(1, userRepo.ReplaceCredentialsInvocation)
+	assert.Equal("user-3", userRepo.ReplaceCredentialsArgNew.UserID)
+	assert.Equal(userRepo.ListCredentialsRes[2], userRepo.ReplaceCredentialsArgOld)
+	assert.True(strings.HasPrefix(userRepo.ReplaceCredentialsArgNew.Credentials.Password, "key-2:"))
+	assert.True(strings.HasPrefix(userRepo.ReplaceCredentialsArgNew.Credentials.Salt, "key-2:"))
+	assert.Equal("", userRepo.ReplaceCredentialsArgNew.TOTPSecret)
+
+	// The re-encrypted credentials should be valid with the new key only.
+	rotatedSvc := service.NewPasswordService(
+		userRepo, "my-pepper", service.EncryptionKeyring{
+			ActiveKeyID: "key-2",
+			Keys:        map[string]string{"key-2": "my-new-encryption-key"},
+		}, service.DefaultHashingConfig, service.DefaultLockoutPolicy)
+	userRepo.FindByEmailUser = domain.FullUser{
+		User:        user.User{ID: "user-3", Email: legacyCredentials.Email},
+		Credentials: userRepo.ReplaceCredentialsArgNew.Credentials,
+	}
+	err = rotatedSvc.Verify(user.Credentials{Email: legacyCredentials.Email, Password: "super-secret-password"})
+	assert.NoError(err)
+
+	// Credentials changed during re-encryption should be skipped.
+	userRepo.ReplaceCredentialsErr = repository.ErrNoSuchUser
+	count, err = passwordSvc.ReencryptCredentials(10)
+	assert.NoError(err)
+	assert.Equal(0, count)
+
+	userRepo.ReplaceCredentialsErr = testError
+	_, err = passwordSvc.ReencryptCredentials(10)
+	assert.Error(err)
+
+	userRepo.ListCredentialsErr = testError
+	_, err = passwordSvc.ReencryptCredentials(10)
+	assert.Equal(testError, err)
+}
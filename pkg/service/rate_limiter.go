@@ -0,0 +1,100 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/directory/pkg/repository"
+	"github.com/mimir-news/pkg/httputil"
+)
+
+// Rate limited actions.
+const (
+	LoginAction              = "login"
+	RegistrationAction       = "registration"
+	AnonymousTokenAction     = "anonymous-token"
+	EmailLoginAction         = "email-login"
+	EmailCodeAction          = "email-code"
+	MFALoginAction           = "mfa-login"
+	PasswordResetAction      = "password-reset"
+	VerificationResendAction = "verification-resend"
+	GuestAction              = "guest"
+)
+
+// DefaultRateLimitPolicy rate limit policy used unless configured otherwise.
+var DefaultRateLimitPolicy = RateLimitPolicy{
+	PerIP:    domain.RateLimit{Requests: 30, Period: time.Minute},
+	PerEmail: domain.RateLimit{Requests: 10, Period: time.Minute},
+}
+
+// RateLimitPolicy describes how often an action may be taken from the same client ip
+// and against the same email address. Every action is limited separately.
+type RateLimitPolicy struct {
+	PerIP    domain.RateLimit
+	PerEmail domain.RateLimit
+}
+
+// RateLimiter service responsible for limiting how often unsecured actions can be taken.
+type RateLimiter interface {
+	Allow(action, clientIP, email string) (time.Duration, error)
+	PurgeFull() (int, error)
+}
+
+// NewRateLimiter creates a new RateLimiter using the default implementation.
+func NewRateLimiter(policy RateLimitPolicy, rateLimitRepo repository.RateLimitRepo) RateLimiter {
+	return &rateLimiter{
+		policy:        policy,
+		rateLimitRepo: rateLimitRepo,
+	}
+}
+
+type rateLimiter struct {
+	policy        RateLimitPolicy
+	rateLimitRepo repository.RateLimitRepo
+}
+
+// Allow takes a token for the action from the buckets of the client ip and the email address, if one is given.
+// If either bucket is empty a 429 error is returned along with the time until the action may be retried.
+func (rl *rateLimiter) Allow(action, clientIP, email string) (time.Duration, error) {
+	at := now()
+	retryAfter, err := rl.take(rateLimitKey(action, "ip", clientIP), rl.policy.PerIP, at)
+	if err != nil {
+		return retryAfter, err
+	}
+
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return 0, nil
+	}
+
+	return rl.take(rateLimitKey(action, "email", email), rl.policy.PerEmail, at)
+}
+
+func (rl *rateLimiter) take(key string, limit domain.RateLimit, at time.Time) (time.Duration, error) {
+	retryAfter, err := rl.rateLimitRepo.Take(key, limit, at)
+	if err != nil {
+		return 0, err
+	}
+
+	if retryAfter > 0 {
+		return retryAfter, errTooManyRequests()
+	}
+
+	return 0, nil
+}
+
+// PurgeFull removes buckets which have been refilled and returns the number removed.
+func (rl *rateLimiter) PurgeFull() (int, error) {
+	return rl.rateLimitRepo.DeleteFull(now())
+}
+
+func rateLimitKey(action, keyType, value string) string {
+	return fmt.Sprintf("%s:%s:%s", action, keyType, value)
+}
+
+func errTooManyRequests() error {
+	return httputil.NewError("Too many requests", http.StatusTooManyRequests)
+}
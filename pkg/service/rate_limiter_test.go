@@ -0,0 +1,78 @@
+package service_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/directory/pkg/repository"
+	"github.com/mimir-news/directory/pkg/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	assert := assert.New(t)
+
+	policy := service.RateLimitPolicy{
+		PerIP:    domain.RateLimit{Requests: 3, Period: time.Minute},
+		PerEmail: domain.RateLimit{Requests: 2, Period: time.Minute},
+	}
+	rateLimiter := service.NewRateLimiter(policy, repository.NewMemoryRateLimitRepo())
+
+	for i := 0; i < 2; i++ {
+		retryAfter, err := rateLimiter.Allow(service.LoginAction, "10.0.0.1", "mail@mail.com")
+		assert.NoError(err)
+		assert.Equal(time.Duration(0), retryAfter)
+	}
+
+	// Emails are limited regardless of case and surrounding whitespace.
+	retryAfter, err := rateLimiter.Allow(service.LoginAction, "10.0.0.2", " Mail@Mail.com ")
+	assertHTTPStatus(assert, http.StatusTooManyRequests, err)
+	assert.True(retryAfter > 0)
+	assert.True(retryAfter <= 30*time.Second)
+
+	// The ip still has a token left for other emails.
+	_, err = rateLimiter.Allow(service.LoginAction, "10.0.0.1", "other@mail.com")
+	assert.NoError(err)
+
+	retryAfter, err = rateLimiter.Allow(service.LoginAction, "10.0.0.1", "")
+	assertHTTPStatus(assert, http.StatusTooManyRequests, err)
+	assert.True(retryAfter > 0)
+	assert.True(retryAfter <= 20*time.Second)
+
+	// Actions are limited separately.
+	_, err = rateLimiter.Allow(service.RegistrationAction, "10.0.0.1", "mail@mail.com")
+	assert.NoError(err)
+
+	count, err := rateLimiter.PurgeFull()
+	assert.NoError(err)
+	assert.Equal(0, count)
+}
+
+func TestTokenBucket(t *testing.T) {
+	assert := assert.New(t)
+
+	limit := domain.RateLimit{Requests: 2, Period: time.Minute}
+	start := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	bucket := domain.NewTokenBucket("key", limit, start)
+
+	bucket, retryAfter := bucket.Take(limit, start)
+	assert.Equal(time.Duration(0), retryAfter)
+	bucket, retryAfter = bucket.Take(limit, start)
+	assert.Equal(time.Duration(0), retryAfter)
+	bucket, retryAfter = bucket.Take(limit, start.Add(10*time.Second))
+	assert.Equal(20*time.Second, retryAfter)
+	assert.Equal(start.Add(time.Minute), bucket.FullAt(limit))
+
+	bucket, retryAfter = bucket.Take(limit, start.Add(30*time.Second))
+	assert.Equal(time.Duration(0), retryAfter)
+	assert.Equal(float64(0), bucket.Tokens)
+
+	bucket, retryAfter = bucket.Take(limit, start.Add(time.Hour))
+	assert.Equal(time.Duration(0), retryAfter)
+	assert.Equal(float64(1), bucket.Tokens)
+
+	assert.Error(domain.RateLimit{Requests: 0, Period: time.Minute}.Valid())
+	assert.NoError(limit.Valid())
+}
@@ -0,0 +1,42 @@
+package service
+
+import (
+	"crypto/hmac"
+	"encoding/hex"
+
+	"github.com/mimir-news/pkg/httputil/auth"
+	"golang.org/x/crypto/sha3"
+)
+
+// RefreshTokenHasher creates keyed hashes of refresh tokens so that the tokens themselves never need to be stored.
+type RefreshTokenHasher struct {
+	key []byte
+}
+
+// NewRefreshTokenHasher creates a RefreshTokenHasher using the provided secret key.
+func NewRefreshTokenHasher(key string) RefreshTokenHasher {
+	return RefreshTokenHasher{
+		key: auth.HashKey(key),
+	}
+}
+
+// Hash returns the hex encoded HMAC-SHA3-256 of a refresh token.
+func (h RefreshTokenHasher) Hash(refreshToken string) string {
+	return hex.EncodeToString(h.sum(refreshToken))
+}
+
+// Matches checks in constant time if a refresh token matches a stored hash.
+func (h RefreshTokenHasher) Matches(refreshToken, hash string) bool {
+	decodedHash, err := hex.DecodeString(hash)
+	if err != nil || len(decodedHash) == 0 {
+		return false
+	}
+
+	return hmac.Equal(h.sum(refreshToken), decodedHash)
+}
+
+func (h RefreshTokenHasher) sum(refreshToken string) []byte {
+	mac := hmac.New(sha3.New256, h.key)
+	mac.Write([]byte(refreshToken))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"time"
+
+	"github.com/mimir-news/directory/pkg/repository"
+)
+
+const retentionBatchSize = 100
+
+// RetentionService service responsible for purging data that is no longer retained.
+type RetentionService interface {
+	PurgeInactiveGuests() (int, error)
+	PurgeDeletedUsers() (int, error)
+}
+
+// NewRetentionService creates a new RetentionService using the default implementation.
+// Guests are purged once they have been inactive for longer than the guestRetention period,
+// and deleted users once they were deleted longer than the deletionGracePeriod ago.
+func NewRetentionService(userRepo repository.UserRepo, guestRetention, deletionGracePeriod time.Duration) RetentionService {
+	return &retentionSvc{
+		userRepo:            userRepo,
+		guestRetention:      guestRetention,
+		deletionGracePeriod: deletionGracePeriod,
+	}
+}
+
+type retentionSvc struct {
+	userRepo            repository.UserRepo
+	guestRetention      time.Duration
+	deletionGracePeriod time.Duration
+}
+
+// PurgeInactiveGuests purges guests in batches until no inactive guests remain and returns the number purged.
+func (rs *retentionSvc) PurgeInactiveGuests() (int, error) {
+	inactiveSince := now().Add(-rs.guestRetention)
+	return purgeInBatches(func() (int, error) {
+		return rs.userRepo.PurgeInactiveGuests(inactiveSince, retentionBatchSize)
+	})
+}
+
+// PurgeDeletedUsers purges users whose deletion grace period has passed in batches
+// until no such users remain and returns the number purged.
+func (rs *retentionSvc) PurgeDeletedUsers() (int, error) {
+	deletedBefore := now().Add(-rs.deletionGracePeriod)
+	return purgeInBatches(func() (int, error) {
+		return rs.userRepo.PurgeDeletedUsers(deletedBefore, retentionBatchSize)
+	})
+}
+
+// purgeInBatches runs a batch purge until a batch is not full and returns the total number purged.
+func purgeInBatches(purgeBatch func() (int, error)) (int, error) {
+	total := 0
+	for {
+		purged, err := purgeBatch()
+		total += purged
+		if err != nil {
+			return total, err
+		}
+
+		if purged < retentionBatchSize {
+			return total, nil
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package service_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mimir-news/directory/pkg/repository"
+	"github.com/mimir-news/directory/pkg/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPurgeInactiveGuests(t *testing.T) {
+	assert := assert.New(t)
+
+	retention := 30 * 24 * time.Hour
+	userRepo := &repository.MockUserRepo{
+		PurgeInactiveGuestsRes: 42,
+	}
+	retentionSvc := service.NewRetentionService(userRepo, retention, time.Hour)
+
+	count, err := retentionSvc.PurgeInactiveGuests()
+	assert.NoError(err)
+	assert.Equal(42, count)
+	assert.Equal(1, userRepo.PurgeInactiveGuestsInvocation)
+	assert.True(userRepo.PurgeInactiveGuestsArgLimit > 0)
+	inactiveSince := time.Now().UTC().Add(-retention)
+	assert.True(userRepo.PurgeInactiveGuestsArgSince.Before(inactiveSince.Add(time.Second)))
+	assert.True(userRepo.PurgeInactiveGuestsArgSince.After(inactiveSince.Add(-time.Minute)))
+
+	userRepo.PurgeInactiveGuestsInvocation = 0
+	userRepo.PurgeInactiveGuestsRes = 0
+	userRepo.PurgeInactiveGuestsErr = testError
+	_, err = retentionSvc.PurgeInactiveGuests()
+	assert.Error(err)
+	assert.Equal(1, userRepo.PurgeInactiveGuestsInvocation)
+}
+
+func TestPurgeDeletedUsers(t *testing.T) {
+	assert := assert.New(t)
+
+	gracePeriod := 30 * 24 * time.Hour
+	userRepo := &repository.MockUserRepo{
+		PurgeDeletedUsersRes: 7,
+	}
+	retentionSvc := service.NewRetentionService(userRepo, time.Hour, gracePeriod)
+
+	count, err := retentionSvc.PurgeDeletedUsers()
+	assert.NoError(err)
+	assert.Equal(7, count)
+	assert.Equal(1, userRepo.PurgeDeletedUsersInvocation)
+	assert.True(userRepo.PurgeDeletedUsersArgLimit > 0)
+	deletedBefore := time.Now().UTC().Add(-gracePeriod)
+	assert.True(userRepo.PurgeDeletedUsersArgBefore.Before(deletedBefore.Add(time.Second)))
+	assert.True(userRepo.PurgeDeletedUsersArgBefore.After(deletedBefore.Add(-time.Minute)))
+	assert.Equal(0, userRepo.PurgeInactiveGuestsInvocation)
+
+	userRepo.PurgeDeletedUsersInvocation = 0
+	userRepo.PurgeDeletedUsersRes = 0
+	userRepo.PurgeDeletedUsersErr = testError
+	_, err = retentionSvc.PurgeDeletedUsers()
+	assert.Error(err)
+	assert.Equal(1, userRepo.PurgeDeletedUsersInvocation)
+}
@@ -0,0 +1,39 @@
+package service
+
+import (
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/directory/pkg/repository"
+)
+
+// SecurityEventRecorder records security relevant events.
+type SecurityEventRecorder interface {
+	Record(event domain.SecurityEvent) error
+}
+
+// NewAuditEventRecorder creates a SecurityEventRecorder that appends events to the audit log.
+func NewAuditEventRecorder(eventRepo repository.AuditEventRepo) SecurityEventRecorder {
+	return &auditEventRecorder{
+		eventRepo: eventRepo,
+	}
+}
+
+type auditEventRecorder struct {
+	eventRepo repository.AuditEventRepo
+}
+
+// Record stores a security event in the audit log.
+func (r *auditEventRecorder) Record(e domain.SecurityEvent) error {
+	return r.eventRepo.Save(e)
+}
+
+// MockEventRecorder mock implementation of SecurityEventRecorder.
+type MockEventRecorder struct {
+	Events    []domain.SecurityEvent
+	RecordErr error
+}
+
+// Record mock implementation of recording a security event.
+func (r *MockEventRecorder) Record(e domain.SecurityEvent) error {
+	r.Events = append(r.Events, e)
+	return r.RecordErr
+}
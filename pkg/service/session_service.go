@@ -0,0 +1,102 @@
+package service
+
+import (
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/directory/pkg/repository"
+	"github.com/mimir-news/pkg/httputil"
+	"github.com/mimir-news/pkg/httputil/auth"
+)
+
+// SessionService service responsible for handling user sessions.
+type SessionService interface {
+	Logout(accessToken string, client domain.ClientInfo) error
+	LogoutAll(userID string, client domain.ClientInfo) error
+	List(userID string) ([]domain.SessionInfo, error)
+	Delete(userID, sessionID string, client domain.ClientInfo) error
+}
+
+// NewSessionService creates a new SessionService using the default implementation.
+func NewSessionService(
+	verifier auth.Verifier, sessionRepo repository.SessionRepo, events SecurityEventRecorder) SessionService {
+	return &sessionSvc{
+		verifier:    verifier,
+		sessionRepo: sessionRepo,
+		events:      events,
+	}
+}
+
+type sessionSvc struct {
+	verifier    auth.Verifier
+	sessionRepo repository.SessionRepo
+	events      SecurityEventRecorder
+}
+
+// Logout deactivates the session that an access token was issued for.
+func (ss *sessionSvc) Logout(accessToken string, client domain.ClientInfo) error {
+	token, err := ss.verifier.Verify(accessToken)
+	if err != nil {
+		return httputil.ErrUnauthorized()
+	}
+
+	err = ss.sessionRepo.DeleteUserSession(token.User.ID, token.ID)
+	if err == repository.ErrNoSuchSession {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return ss.recordRevocation(token.User.ID, token.ID, client)
+}
+
+// LogoutAll deactivates all sessions of a user.
+func (ss *sessionSvc) LogoutAll(userID string, client domain.ClientInfo) error {
+	err := ss.sessionRepo.DeleteByUserID(userID)
+	if err != nil {
+		return err
+	}
+
+	return ss.recordRevocation(userID, "", client)
+}
+
+// List lists the active sessions of a user.
+func (ss *sessionSvc) List(userID string) ([]domain.SessionInfo, error) {
+	sessions, err := ss.sessionRepo.FindByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]domain.SessionInfo, 0, len(sessions))
+	for _, s := range sessions {
+		infos = append(infos, s.Info())
+	}
+
+	return infos, nil
+}
+
+// Delete deactivates a session belonging to a user.
+func (ss *sessionSvc) Delete(userID, sessionID string, client domain.ClientInfo) error {
+	err := ss.sessionRepo.DeleteUserSession(userID, sessionID)
+	if err == repository.ErrNoSuchSession {
+		return httputil.ErrNotFound()
+	} else if err != nil {
+		return err
+	}
+
+	return ss.recordRevocation(userID, sessionID, client)
+}
+
+// recordRevocation records that a user ended one of its sessions, or all of them if the session id is empty.
+func (ss *sessionSvc) recordRevocation(userID, sessionID string, client domain.ClientInfo) error {
+	return ss.events.Record(domain.NewAccountEvent(domain.SessionRevocationEvent, userID, sessionID, client))
+}
+
+// invalidateSessions deactivates all sessions of a user and marks sessions started
+// before now as invalid, so that they cannot be refreshed even if they are still stored as active.
+func invalidateSessions(userRepo repository.UserRepo, sessionRepo repository.SessionRepo, userID string) error {
+	err := userRepo.InvalidateSessions(userID, now())
+	if err != nil {
+		return err
+	}
+
+	return sessionRepo.DeleteByUserID(userID)
+}
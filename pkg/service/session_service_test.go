@@ -0,0 +1,126 @@
+package service_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mimir-news/directory/pkg/domain"
+	"github.com/mimir-news/directory/pkg/repository"
+	"github.com/mimir-news/directory/pkg/service"
+	"github.com/mimir-news/pkg/httputil/auth"
+	"github.com/mimir-news/pkg/id"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogout(t *testing.T) {
+	assert := assert.New(t)
+
+	userID := id.New()
+	sessionID := id.New()
+
+	jwtCreds := auth.JWTCredentials{Issuer: "session_service_test", Secret: id.New()}
+	signer := auth.NewSigner(jwtCreds, 24*time.Hour)
+	verifier := auth.NewVerifier(jwtCreds, 365*24*time.Hour)
+	sessionRepo := &repository.MockSessionRepo{}
+	sessionSvc := service.NewSessionService(verifier, sessionRepo, &service.MockEventRecorder{})
+
+	accessToken, err := signer.Sign(sessionID, auth.User{ID: userID, Role: auth.UserRole})
+	assert.NoError(err)
+
+	err = sessionSvc.Logout(accessToken, domain.ClientInfo{})
+	assert.NoError(err)
+	assert.Equal(userID, sessionRepo.DeleteUserSessionArgUserID)
+	assert.Equal(sessionID, sessionRepo.DeleteUserSessionArgSessionID)
+
+	sessionRepo.UnsetArgs()
+	sessionRepo.DeleteUserSessionErr = repository.ErrNoSuchSession
+	err = sessionSvc.Logout(accessToken, domain.ClientInfo{})
+	assert.NoError(err)
+
+	sessionRepo.DeleteUserSessionErr = testError
+	err = sessionSvc.Logout(accessToken, domain.ClientInfo{})
+	assert.Equal(testError, err)
+
+	sessionRepo.UnsetArgs()
+	otherSigner := auth.NewSigner(auth.JWTCredentials{Issuer: "other", Secret: id.New()}, time.Hour)
+	otherToken, err := otherSigner.Sign(sessionID, auth.User{ID: userID, Role: auth.UserRole})
+	assert.NoError(err)
+	err = sessionSvc.Logout(otherToken, domain.ClientInfo{})
+	assertHTTPStatus(assert, http.StatusUnauthorized, err)
+	assert.Equal(0, sessionRepo.DeleteUserSessionInvocation)
+}
+
+func TestLogoutAll(t *testing.T) {
+	assert := assert.New(t)
+
+	userID := id.New()
+	sessionRepo := &repository.MockSessionRepo{}
+	events := &service.MockEventRecorder{}
+	sessionSvc := service.NewSessionService(nil, sessionRepo, events)
+
+	err := sessionSvc.LogoutAll(userID, domain.ClientInfo{IP: "10.0.0.1"})
+	assert.NoError(err)
+	assert.Equal(userID, sessionRepo.DeleteByUserIDArg)
+	assert.Len(events.Events, 1)
+	assert.Equal(domain.SessionRevocationEvent, events.Events[0].Type)
+	assert.Equal(userID, events.Events[0].UserID)
+	assert.Equal("", events.Events[0].SessionID)
+	assert.Equal("10.0.0.1", events.Events[0].Client.IP)
+
+	sessionRepo.DeleteByUserIDErr = testError
+	err = sessionSvc.LogoutAll(userID, domain.ClientInfo{})
+	assert.Equal(testError, err)
+	assert.Len(events.Events, 1)
+}
+
+func TestListSessions(t *testing.T) {
+	assert := assert.New(t)
+
+	userID := id.New()
+	session := domain.NewSession(userID, domain.ClientInfo{
+		UserAgent:  "test-agent/1.0",
+		IP:         "10.0.0.1",
+		DeviceName: "My phone",
+	})
+	refreshedSession := domain.NewSession(userID, domain.ClientInfo{})
+	refreshedSession.LastRefreshedAt = time.Now().UTC()
+
+	sessionRepo := &repository.MockSessionRepo{
+		FindByUserIDSessions: []domain.Session{session, refreshedSession},
+	}
+	sessionSvc := service.NewSessionService(nil, sessionRepo, &service.MockEventRecorder{})
+
+	sessions, err := sessionSvc.List(userID)
+	assert.NoError(err)
+	assert.Equal(userID, sessionRepo.FindByUserIDArg)
+	assert.Len(sessions, 2)
+	assert.Equal(session.ID, sessions[0].ID)
+	assert.Equal(session.UserAgent, sessions[0].UserAgent)
+	assert.Equal(session.ClientIP, sessions[0].ClientIP)
+	assert.Equal(session.DeviceName, sessions[0].DeviceName)
+	assert.Nil(sessions[0].LastRefreshedAt)
+	assert.NotNil(sessions[1].LastRefreshedAt)
+
+	sessionRepo.FindByUserIDErr = testError
+	_, err = sessionSvc.List(userID)
+	assert.Equal(testError, err)
+}
+
+func TestDeleteSession(t *testing.T) {
+	assert := assert.New(t)
+
+	userID := id.New()
+	sessionID := id.New()
+	sessionRepo := &repository.MockSessionRepo{}
+	sessionSvc := service.NewSessionService(nil, sessionRepo, &service.MockEventRecorder{})
+
+	err := sessionSvc.Delete(userID, sessionID, domain.ClientInfo{})
+	assert.NoError(err)
+	assert.Equal(userID, sessionRepo.DeleteUserSessionArgUserID)
+	assert.Equal(sessionID, sessionRepo.DeleteUserSessionArgSessionID)
+
+	sessionRepo.DeleteUserSessionErr = repository.ErrNoSuchSession
+	err = sessionSvc.Delete(userID, sessionID, domain.ClientInfo{})
+	assertHTTPStatus(assert, http.StatusNotFound, err)
+}
@@ -0,0 +1,323 @@
+package service
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/mimir-news/pkg/httputil"
+	"github.com/mimir-news/pkg/httputil/auth"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// Supported token signing algorithms.
+const (
+	RS256Algorithm = string(jose.RS256)
+	ES256Algorithm = string(jose.ES256)
+)
+
+const (
+	tokenKeyUse     = "sig"
+	minRSAKeyLength = 2048
+)
+
+// TokenKeyring set of asymmetric keys used to sign access tokens, identified by key ids. Tokens are signed
+// with the active key while every key in the keyring can be used for verification and is published as a JWK.
+// Keys which are being rotated in or out of use only need a public key.
+type TokenKeyring struct {
+	ActiveKeyID string
+	Keys        map[string]jose.JSONWebKey
+}
+
+// NewTokenKeyring creates a keyring with a single active signing key.
+func NewTokenKeyring(key jose.JSONWebKey) TokenKeyring {
+	return TokenKeyring{
+		ActiveKeyID: key.KeyID,
+		Keys: map[string]jose.JSONWebKey{
+			key.KeyID: key,
+		},
+	}
+}
+
+// NewTokenKey creates a token key with an id from a private or public RSA or ECDSA key.
+func NewTokenKey(keyID, algorithm string, key interface{}) (jose.JSONWebKey, error) {
+	jwk := jose.JSONWebKey{
+		Key:       key,
+		KeyID:     keyID,
+		Algorithm: algorithm,
+		Use:       tokenKeyUse,
+	}
+
+	return jwk, validateTokenKey(jwk)
+}
+
+// ParseTokenKey parses a PEM encoded private or public key into a token key.
+func ParseTokenKey(keyID, algorithm, pemKey string) (jose.JSONWebKey, error) {
+	block, _ := pem.Decode([]byte(pemKey))
+	if block == nil {
+		return jose.JSONWebKey{}, fmt.Errorf("Token key %s is not PEM encoded", keyID)
+	}
+
+	key, err := parsePEMBlock(block)
+	if err != nil {
+		return jose.JSONWebKey{}, fmt.Errorf("Failed to parse token key %s: %s", keyID, err)
+	}
+
+	return NewTokenKey(keyID, algorithm, key)
+}
+
+// Valid checks that the active key is present and can sign, and that all keys are supported.
+func (k TokenKeyring) Valid() error {
+	activeKey, ok := k.Keys[k.ActiveKeyID]
+	if !ok {
+		return fmt.Errorf("No token key with id: %s", k.ActiveKeyID)
+	}
+
+	if activeKey.IsPublic() {
+		return fmt.Errorf("Active token key %s has no private key", k.ActiveKeyID)
+	}
+
+	for keyID, key := range k.Keys {
+		if keyID == "" || keyID != key.KeyID {
+			return fmt.Errorf("Token key id must be set and match the keyring: %s", keyID)
+		}
+
+		err := validateTokenKey(key)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PublicKeys returns the public keys of the keyring ordered by key id.
+func (k TokenKeyring) PublicKeys() jose.JSONWebKeySet {
+	keyIDs := make([]string, 0, len(k.Keys))
+	for keyID := range k.Keys {
+		keyIDs = append(keyIDs, keyID)
+	}
+	sort.Strings(keyIDs)
+
+	keys := make([]jose.JSONWebKey, 0, len(keyIDs))
+	for _, keyID := range keyIDs {
+		key := k.Keys[keyID]
+		keys = append(keys, key.Public())
+	}
+
+	return jose.JSONWebKeySet{Keys: keys}
+}
+
+// Algorithms returns the signing algorithms used by keys in the keyring.
+func (k TokenKeyring) Algorithms() []string {
+	seen := make(map[string]bool)
+	algorithms := make([]string, 0)
+	for _, key := range k.Keys {
+		if !seen[key.Algorithm] {
+			seen[key.Algorithm] = true
+			algorithms = append(algorithms, key.Algorithm)
+		}
+	}
+	sort.Strings(algorithms)
+
+	return algorithms
+}
+
+// tokenClaims claims of an access token. The user is included in the same form as
+// in tokens issued with a shared secret so that consumers can read both kinds of tokens.
+type tokenClaims struct {
+	jwt.Claims
+	User auth.User `json:"user"`
+}
+
+// NewTokenSigner creates an auth.Signer which signs tokens with the active key of the keyring.
+// The key id is included in the token header so that verifiers can pick the right key.
+func NewTokenSigner(keyring TokenKeyring, issuer string, ttl time.Duration) auth.Signer {
+	return &tokenSigner{
+		keyring: keyring,
+		issuer:  issuer,
+		ttl:     ttl,
+	}
+}
+
+type tokenSigner struct {
+	keyring TokenKeyring
+	issuer  string
+	ttl     time.Duration
+}
+
+// Sign creates a signed token for a user with the given token id.
+func (s *tokenSigner) Sign(tokenID string, user auth.User) (string, error) {
+	key, ok := s.keyring.Keys[s.keyring.ActiveKeyID]
+	if !ok {
+		return "", fmt.Errorf("No token key with id: %s", s.keyring.ActiveKeyID)
+	}
+
+	signingKey := jose.SigningKey{Algorithm: jose.SignatureAlgorithm(key.Algorithm), Key: key}
+	signer, err := jose.NewSigner(signingKey, (&jose.SignerOptions{}).WithType("JWT"))
+	if err != nil {
+		return "", err
+	}
+
+	issuedAt := now()
+	claims := tokenClaims{
+		Claims: jwt.Claims{
+			ID:       tokenID,
+			Issuer:   s.issuer,
+			Subject:  user.ID,
+			IssuedAt: jwt.NewNumericDate(issuedAt),
+			Expiry:   jwt.NewNumericDate(issuedAt.Add(s.ttl)),
+		},
+		User: user,
+	}
+
+	return jwt.Signed(signer).Claims(claims).CompactSerialize()
+}
+
+// NewTokenVerifier creates an auth.Verifier which verifies tokens signed with any key in the keyring.
+// Tokens which are not signed with a key in the keyring are passed to the legacy verifier if one is provided,
+// which allows tokens signed with a shared secret to be used until they expire.
+func NewTokenVerifier(keyring TokenKeyring, issuer string, leeway time.Duration, legacy auth.Verifier) auth.Verifier {
+	return &tokenVerifier{
+		keyring: keyring,
+		issuer:  issuer,
+		leeway:  leeway,
+		legacy:  legacy,
+	}
+}
+
+type tokenVerifier struct {
+	keyring TokenKeyring
+	issuer  string
+	leeway  time.Duration
+	legacy  auth.Verifier
+}
+
+// Verify verifies the signature, issuer and expiry of a token.
+func (v *tokenVerifier) Verify(rawToken string) (auth.Token, error) {
+	token, err := jwt.ParseSigned(rawToken)
+	if err != nil || len(token.Headers) != 1 {
+		return v.verifyLegacy(rawToken)
+	}
+
+	header := token.Headers[0]
+	key, ok := v.keyring.Keys[header.KeyID]
+	if !ok {
+		return v.verifyLegacy(rawToken)
+	}
+
+	if header.Algorithm != key.Algorithm {
+		return auth.Token{}, httputil.ErrUnauthorized()
+	}
+
+	var claims tokenClaims
+	err = token.Claims(key.Public(), &claims)
+	if err != nil {
+		return auth.Token{}, httputil.ErrUnauthorized()
+	}
+
+	err = claims.ValidateWithLeeway(jwt.Expected{Issuer: v.issuer, Time: now()}, v.leeway)
+	if err != nil || claims.Expiry == nil || claims.Subject != claims.User.ID {
+		return auth.Token{}, httputil.ErrUnauthorized()
+	}
+
+	return auth.Token{
+		ID:   claims.ID,
+		User: claims.User,
+	}, nil
+}
+
+func (v *tokenVerifier) verifyLegacy(rawToken string) (auth.Token, error) {
+	if v.legacy == nil {
+		return auth.Token{}, httputil.ErrUnauthorized()
+	}
+
+	return v.legacy.Verify(rawToken)
+}
+
+// NewLegacyTokenVerifier creates an auth.Verifier for tokens signed with the shared secret which stops accepting
+// tokens once the cut-off time has passed, so that the shared secret can be retired.
+func NewLegacyTokenVerifier(credentials auth.JWTCredentials, leeway time.Duration, acceptUntil time.Time) auth.Verifier {
+	return &legacyTokenVerifier{
+		verifier:    auth.NewVerifier(credentials, leeway),
+		acceptUntil: acceptUntil,
+	}
+}
+
+type legacyTokenVerifier struct {
+	verifier    auth.Verifier
+	acceptUntil time.Time
+}
+
+// Verify verifies a token signed with the shared secret if the cut-off time has not passed.
+func (v *legacyTokenVerifier) Verify(rawToken string) (auth.Token, error) {
+	if !now().Before(v.acceptUntil) {
+		return auth.Token{}, httputil.ErrUnauthorized()
+	}
+
+	return v.verifier.Verify(rawToken)
+}
+
+func validateTokenKey(key jose.JSONWebKey) error {
+	if !key.Valid() {
+		return fmt.Errorf("Invalid token key: %s", key.KeyID)
+	}
+
+	switch key.Algorithm {
+	case RS256Algorithm:
+		if !isRSAKey(key.Key) {
+			return fmt.Errorf("Token key %s must be an RSA key of at least %d bits", key.KeyID, minRSAKeyLength)
+		}
+	case ES256Algorithm:
+		if !isP256Key(key.Key) {
+			return fmt.Errorf("Token key %s must be a P-256 ECDSA key", key.KeyID)
+		}
+	default:
+		return fmt.Errorf("Unsupported algorithm %s for token key %s", key.Algorithm, key.KeyID)
+	}
+
+	return nil
+}
+
+func isRSAKey(key interface{}) bool {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return k.N.BitLen() >= minRSAKeyLength
+	case *rsa.PublicKey:
+		return k.N.BitLen() >= minRSAKeyLength
+	default:
+		return false
+	}
+}
+
+func isP256Key(key interface{}) bool {
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		return k.Curve == elliptic.P256()
+	case *ecdsa.PublicKey:
+		return k.Curve == elliptic.P256()
+	default:
+		return false
+	}
+}
+
+func parsePEMBlock(block *pem.Block) (interface{}, error) {
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("Unsupported PEM block type: %s", block.Type)
+	}
+}
@@ -0,0 +1,175 @@
+package service_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/mimir-news/directory/pkg/service"
+	"github.com/mimir-news/pkg/httputil/auth"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestTokenSignerAndVerifier(t *testing.T) {
+	assert := assert.New(t)
+
+	issuer := "http://directory:8080"
+	oldKey := newTestTokenKey(t, "key-1", service.RS256Algorithm)
+	newKey := newTestTokenKey(t, "key-2", service.ES256Algorithm)
+	user := auth.User{ID: "user-0", Role: auth.UserRole}
+
+	oldKeyring := service.NewTokenKeyring(oldKey)
+	assert.NoError(oldKeyring.Valid())
+	oldSigner := service.NewTokenSigner(oldKeyring, issuer, time.Hour)
+	oldToken, err := oldSigner.Sign("token-0", user)
+	assert.NoError(err)
+
+	// Rotate in a new active key, the old key is kept for verification only.
+	keyring := service.TokenKeyring{
+		ActiveKeyID: newKey.KeyID,
+		Keys: map[string]jose.JSONWebKey{
+			oldKey.KeyID: oldKey.Public(),
+			newKey.KeyID: newKey,
+		},
+	}
+	assert.NoError(keyring.Valid())
+	signer := service.NewTokenSigner(keyring, issuer, time.Hour)
+	verifier := service.NewTokenVerifier(keyring, issuer, 0, nil)
+
+	token, err := signer.Sign("token-1", user)
+	assert.NoError(err)
+	parsed, err := jwt.ParseSigned(token)
+	assert.NoError(err)
+	assert.Equal("key-2", parsed.Headers[0].KeyID)
+	assert.Equal(service.ES256Algorithm, parsed.Headers[0].Algorithm)
+
+	verified, err := verifier.Verify(token)
+	assert.NoError(err)
+	assert.Equal("token-1", verified.ID)
+	assert.Equal(user, verified.User)
+
+	verified, err = verifier.Verify(oldToken)
+	assert.NoError(err)
+	assert.Equal("token-0", verified.ID)
+	assert.Equal(user, verified.User)
+
+	// Keys that are rotated out can no longer be used for verification.
+	newKeyring := service.NewTokenKeyring(newKey)
+	_, err = service.NewTokenVerifier(newKeyring, issuer, 0, nil).Verify(oldToken)
+	assert.Error(err)
+
+	_, err = service.NewTokenVerifier(keyring, "other-issuer", 0, nil).Verify(token)
+	assert.Error(err)
+
+	expiredToken, err := service.NewTokenSigner(keyring, issuer, -time.Hour).Sign("token-2", user)
+	assert.NoError(err)
+	_, err = verifier.Verify(expiredToken)
+	assert.Error(err)
+	_, err = service.NewTokenVerifier(keyring, issuer, 2*time.Hour, nil).Verify(expiredToken)
+	assert.NoError(err)
+
+	// Tokens from the legacy signer are only accepted if a legacy verifier is provided.
+	creds := auth.JWTCredentials{Issuer: "directory", Secret: "my-secret"}
+	legacyToken, err := auth.NewSigner(creds, time.Hour).Sign("token-3", user)
+	assert.NoError(err)
+	_, err = verifier.Verify(legacyToken)
+	assert.Error(err)
+	verified, err = service.NewTokenVerifier(keyring, issuer, 0, auth.NewVerifier(creds, 0)).Verify(legacyToken)
+	assert.NoError(err)
+	assert.Equal("token-3", verified.ID)
+
+	// Legacy tokens are rejected once the cut-off time has passed.
+	legacyVerifier := service.NewLegacyTokenVerifier(creds, 0, time.Now().Add(time.Hour))
+	verified, err = service.NewTokenVerifier(keyring, issuer, 0, legacyVerifier).Verify(legacyToken)
+	assert.NoError(err)
+	assert.Equal("token-3", verified.ID)
+	legacyVerifier = service.NewLegacyTokenVerifier(creds, 0, time.Now().Add(-time.Hour))
+	_, err = service.NewTokenVerifier(keyring, issuer, 0, legacyVerifier).Verify(legacyToken)
+	assert.Error(err)
+	_, err = service.NewTokenVerifier(keyring, issuer, 0, legacyVerifier).Verify(token)
+	assert.NoError(err)
+
+	jwks := keyring.PublicKeys()
+	assert.Len(jwks.Keys, 2)
+	for _, key := range jwks.Keys {
+		assert.True(key.IsPublic())
+	}
+	assert.Equal([]string{service.ES256Algorithm, service.RS256Algorithm}, keyring.Algorithms())
+}
+
+func TestTokenKeyringValid(t *testing.T) {
+	assert := assert.New(t)
+
+	key := newTestTokenKey(t, "key-1", service.ES256Algorithm)
+	assert.NoError(service.NewTokenKeyring(key).Valid())
+
+	publicOnly := service.NewTokenKeyring(key.Public())
+	assert.Error(publicOnly.Valid())
+
+	missingActive := service.NewTokenKeyring(key)
+	missingActive.ActiveKeyID = "key-2"
+	assert.Error(missingActive.Valid())
+
+	_, err := service.NewTokenKey("key-2", service.RS256Algorithm, key.Key)
+	assert.Error(err)
+
+	_, err = service.NewTokenKey("key-2", "HS256", key.Key)
+	assert.Error(err)
+
+	smallKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	assert.NoError(err)
+	_, err = service.NewTokenKey("key-2", service.RS256Algorithm, smallKey)
+	assert.Error(err)
+}
+
+func TestParseTokenKey(t *testing.T) {
+	assert := assert.New(t)
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(err)
+
+	privateDER, err := x509.MarshalECPrivateKey(privateKey)
+	assert.NoError(err)
+	privatePEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: privateDER})
+	key, err := service.ParseTokenKey("key-1", service.ES256Algorithm, string(privatePEM))
+	assert.NoError(err)
+	assert.Equal("key-1", key.KeyID)
+	assert.False(key.IsPublic())
+
+	publicDER, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	assert.NoError(err)
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+	key, err = service.ParseTokenKey("key-1", service.ES256Algorithm, string(publicPEM))
+	assert.NoError(err)
+	assert.True(key.IsPublic())
+
+	_, err = service.ParseTokenKey("key-1", service.ES256Algorithm, "not-a-pem-key")
+	assert.Error(err)
+}
+
+func newTestTokenKey(t *testing.T, keyID, algorithm string) jose.JSONWebKey {
+	var privateKey interface{}
+	var err error
+	if algorithm == service.RS256Algorithm {
+		privateKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	} else {
+		privateKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := service.NewTokenKey(keyID, algorithm, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP parameters as described in RFC 6238, using the defaults supported by most authenticator apps.
+const (
+	totpPeriod       = 30
+	totpDigits       = 6
+	totpModulus      = 1000000
+	totpSecretLength = 20
+	totpSkew         = 1
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TOTPCode computes the TOTP code of a base32 encoded secret at a given time.
+func TOTPCode(secret string, at time.Time) (string, error) {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return "", err
+	}
+
+	return totpCode(key, totpStep(at)), nil
+}
+
+// generateTOTPSecret generates a random base32 encoded TOTP secret.
+func generateTOTPSecret() (string, error) {
+	key := make([]byte, totpSecretLength)
+	_, err := rand.Read(key)
+	if err != nil {
+		return "", err
+	}
+
+	return totpEncoding.EncodeToString(key), nil
+}
+
+func decodeTOTPSecret(secret string) ([]byte, error) {
+	normalized := strings.TrimRight(strings.ToUpper(secret), "=")
+	return totpEncoding.DecodeString(normalized)
+}
+
+// totpStep returns the time step that a given time belongs to.
+func totpStep(at time.Time) int64 {
+	return at.Unix() / totpPeriod
+}
+
+// totpCode computes the code for a time step using HMAC-SHA1 and dynamic truncation.
+func totpCode(key []byte, step int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%totpModulus)
+}
+
+// totpURI creates an otpauth uri which authenticator apps can use to add a TOTP generator.
+func totpURI(issuer, account, secret string) string {
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprintf("%d", totpDigits))
+	params.Set("period", fmt.Sprintf("%d", totpPeriod))
+
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
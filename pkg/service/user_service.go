@@ -1,6 +1,7 @@
 package service
 
 import (
+	"log"
 	"net/http"
 	"time"
 
@@ -24,33 +25,53 @@ var (
 type UserService interface {
 	Get(userID string) (user.User, error)
 	Create(credentials user.Credentials) (user.User, error)
-	Delete(userID string) error
-	Authenticate(credentials user.Credentials) (user.Token, error)
-	RefreshToken(old user.Token) (user.Token, error)
-	ChangePassword(change user.PasswordChange) error
-	ChangeEmail(userID, newEmail string) error
-	GetAnonymousToken() (user.Token, error)
+	Upgrade(anonymousToken string, credentials user.Credentials) (user.User, error)
+	Delete(userID string, client domain.ClientInfo) error
+	Authenticate(credentials user.Credentials, client domain.ClientInfo) (domain.LoginResult, error)
+	AuthenticateEmail(login domain.EmailLogin, client domain.ClientInfo) (domain.LoginResult, error)
+	AuthenticateExternal(provider string, callback domain.ExternalLoginCallback, client domain.ClientInfo) (domain.LoginResult, error)
+	CompleteMFALogin(verification domain.MFAVerification, client domain.ClientInfo) (user.Token, error)
+	RefreshToken(old user.Token, client domain.ClientInfo) (user.Token, error)
+	ChangePassword(userID string, change user.PasswordChange, client domain.ClientInfo) error
+	GetAnonymousToken(client domain.ClientInfo) (user.Token, error)
+	CreateGuest(client domain.ClientInfo) (user.Token, error)
 }
 
 // NewUserService creates a new UserService using the default implementation.
 func NewUserService(
-	pwdSvc *PasswordService, signer auth.Signer, verifier auth.Verifier,
-	userRepo repository.UserRepo, sessionRepo repository.SessionRepo) UserService {
+	pwdSvc *PasswordService, verificationSvc EmailVerificationService, mfaSvc MFAService,
+	emailLoginSvc EmailLoginService, externalLoginSvc ExternalLoginService, signer auth.Signer, verifier auth.Verifier, tokenHasher RefreshTokenHasher,
+	userRepo repository.UserRepo, sessionRepo repository.SessionRepo, listRepo repository.WatchlistRepo,
+	events SecurityEventRecorder) UserService {
 	return &userSvc{
-		passwordSvc: pwdSvc,
-		tokenSigner: signer,
-		verifier:    verifier,
-		userRepo:    userRepo,
-		sessionRepo: sessionRepo,
+		passwordSvc:      pwdSvc,
+		verificationSvc:  verificationSvc,
+		mfaSvc:           mfaSvc,
+		emailLoginSvc:    emailLoginSvc,
+		externalLoginSvc: externalLoginSvc,
+		tokenSigner:      signer,
+		verifier:         verifier,
+		tokenHasher:      tokenHasher,
+		userRepo:         userRepo,
+		sessionRepo:      sessionRepo,
+		listRepo:         listRepo,
+		events:           events,
 	}
 }
 
 type userSvc struct {
-	passwordSvc *PasswordService
-	tokenSigner auth.Signer
-	verifier    auth.Verifier
-	userRepo    repository.UserRepo
-	sessionRepo repository.SessionRepo
+	passwordSvc      *PasswordService
+	verificationSvc  EmailVerificationService
+	mfaSvc           MFAService
+	emailLoginSvc    EmailLoginService
+	externalLoginSvc ExternalLoginService
+	tokenSigner      auth.Signer
+	verifier         auth.Verifier
+	tokenHasher      RefreshTokenHasher
+	userRepo         repository.UserRepo
+	sessionRepo      repository.SessionRepo
+	listRepo         repository.WatchlistRepo
+	events           SecurityEventRecorder
 }
 
 // Get gets the user with the provided id.
@@ -79,46 +100,128 @@ func (us *userSvc) Create(credentials user.Credentials) (user.User, error) {
 		return emptyUser, err
 	}
 
-	newUser, err := us.createNewUser(credentials)
+	newUser, err := us.createNewUser(credentials, "", nil)
 	if err != nil {
 		return emptyUser, err
 	}
 
-	return newUser, nil
+	us.sendVerification(newUser)
+	return newUser.User, nil
+}
+
+// Upgrade turns an anonymous user or a guest into a user with the given credentials.
+// An anonymous user keeps the user id of its token and gets the default watchlist saved,
+// while a guest keeps its user id and the watchlists it has already edited.
+func (us *userSvc) Upgrade(anonymousToken string, credentials user.Credentials) (user.User, error) {
+	tokenBody, err := us.verifier.Verify(anonymousToken)
+	if err != nil {
+		return emptyUser, httputil.ErrUnauthorized()
+	}
+
+	var newUser domain.FullUser
+	switch tokenBody.User.Role {
+	case auth.AnonymousRole:
+		newUser, err = us.upgradeAnonymous(tokenBody.User.ID, credentials)
+	case domain.GuestRole:
+		newUser, err = us.upgradeGuest(tokenBody.User.ID, credentials)
+	default:
+		return emptyUser, httputil.ErrForbidden()
+	}
+	if err != nil {
+		return emptyUser, err
+	}
+
+	us.sendVerification(newUser)
+	return newUser.User, nil
 }
 
-// Delete deletes the user with the given id.
-func (us *userSvc) Delete(userID string) error {
+// sendVerification mails a verification key to a newly registered user. Since the user has already
+// been stored failures are only logged, the email can still be verified by logging in with an email link.
+func (us *userSvc) sendVerification(u domain.FullUser) {
+	err := us.verificationSvc.SendVerification(u)
+	if err != nil {
+		log.Printf("Failed to send email verification to user %s: %s\n", u.User.ID, err)
+	}
+}
+
+// Delete deletes the user with the given id and invalidates all of the users sessions.
+// The user is purged once the deletion grace period has passed, unless the user logs in before then.
+func (us *userSvc) Delete(userID string, client domain.ClientInfo) error {
 	err := us.userRepo.Delete(userID)
 	if err == repository.ErrNoSuchUser {
 		return httputil.ErrNotFound()
+	} else if err != nil {
+		return err
 	}
 
-	return err
+	err = invalidateSessions(us.userRepo, us.sessionRepo, userID)
+	if err != nil {
+		return err
+	}
+
+	return us.events.Record(domain.NewAccountEvent(domain.UserDeletionEvent, userID, "", client))
 }
 
-// Authenticate validates the credentials provided.
-func (us *userSvc) Authenticate(credentials user.Credentials) (user.Token, error) {
+// Authenticate validates the credentials provided and starts a session for the client.
+// If the user has multi factor authentication enabled a challenge is returned instead of a token.
+func (us *userSvc) Authenticate(credentials user.Credentials, client domain.ClientInfo) (domain.LoginResult, error) {
 	err := us.passwordSvc.Verify(credentials)
-	if err != nil {
-		return emptyToken, httputil.ErrUnauthorized()
+	if err == ErrAccountLocked {
+		return domain.LoginResult{}, us.recordLoginFailure(credentials.Email, client, errAccountLocked())
+	} else if err != nil {
+		return domain.LoginResult{}, us.recordLoginFailure(credentials.Email, client, httputil.ErrUnauthorized())
 	}
 
 	u, err := us.userRepo.FindByEmail(credentials.Email)
+	if err != nil {
+		return domain.LoginResult{}, err
+	}
+
+	return us.startLogin(u, client)
+}
+
+// AuthenticateEmail redeems a passwordless login link or code and starts a session for the client.
+// If the user has multi factor authentication enabled a challenge is returned instead of a token.
+func (us *userSvc) AuthenticateEmail(login domain.EmailLogin, client domain.ClientInfo) (domain.LoginResult, error) {
+	u, err := us.emailLoginSvc.Redeem(login, client)
+	if err != nil {
+		return domain.LoginResult{}, err
+	}
+
+	return us.startLogin(u, client)
+}
+
+// AuthenticateExternal completes a login with an external OpenID Connect provider and starts a session for the client.
+// If the user has multi factor authentication enabled a challenge is returned instead of a token.
+func (us *userSvc) AuthenticateExternal(
+	provider string, callback domain.ExternalLoginCallback, client domain.ClientInfo) (domain.LoginResult, error) {
+	u, err := us.externalLoginSvc.Redeem(provider, callback)
+	if err != nil {
+		return domain.LoginResult{}, err
+	}
+
+	return us.startLogin(u, client)
+}
+
+// CompleteMFALogin verifies the second factor of an MFA challenge and starts a session for the client.
+func (us *userSvc) CompleteMFALogin(verification domain.MFAVerification, client domain.ClientInfo) (user.Token, error) {
+	u, err := us.mfaSvc.VerifyChallenge(verification, client)
 	if err != nil {
 		return emptyToken, err
 	}
 
-	token, err := us.createSessionToken(u.User)
+	u.User.Role, err = us.verificationSvc.AuthorizedRole(u)
 	if err != nil {
 		return emptyToken, err
 	}
 
-	return token, nil
+	return us.createLoginToken(u, client)
 }
 
 // RefreshToken refreshes an old token if old is valid.
-func (us *userSvc) RefreshToken(old user.Token) (user.Token, error) {
+// The session is rotated into a new session in the same session family. If a refresh token
+// which has already been rotated is presented again, the whole session family is revoked.
+func (us *userSvc) RefreshToken(old user.Token, client domain.ClientInfo) (user.Token, error) {
 	tokenBody, err := us.verifier.Verify(old.Token)
 	if err != nil {
 		return emptyToken, httputil.ErrUnauthorized()
@@ -129,78 +232,219 @@ func (us *userSvc) RefreshToken(old user.Token) (user.Token, error) {
 		return emptyToken, httputil.ErrForbidden()
 	}
 
+	if us.isRefreshTokenReuse(old.RefreshToken, tokenBody, oldSession) {
+		return emptyToken, us.revokeSessionFamily(oldSession, client)
+	}
+
 	storedUser, err := us.getRefreshUser(tokenBody.User.ID)
 	if err != nil {
 		return emptyToken, err
 	}
 
-	err = verifyRefreshToken(old.RefreshToken, tokenBody, oldSession)
+	err = us.verifyRefreshToken(old.RefreshToken, tokenBody, oldSession)
 	if err != nil {
 		return emptyToken, httputil.ErrForbidden()
 	}
 
-	err = us.sessionRepo.Delete(tokenBody.ID)
+	if storedUser.SessionRevoked(oldSession) {
+		return emptyToken, httputil.ErrForbidden()
+	}
+
+	storedUser.User.Role, err = us.verificationSvc.AuthorizedRole(storedUser)
 	if err != nil {
 		return emptyToken, err
 	}
 
-	return us.createSessionToken(storedUser.User)
+	err = us.sessionRepo.Rotate(tokenBody.ID)
+	if err == repository.ErrNoSuchSession {
+		// The session was rotated concurrently, which means that the refresh token was reused.
+		return emptyToken, us.revokeSessionFamily(oldSession, client)
+	} else if err != nil {
+		return emptyToken, err
+	}
+
+	session := oldSession.Rotate(client)
+	token, err := us.createSessionToken(storedUser.User, session)
+	if err != nil {
+		return emptyToken, err
+	}
+
+	event := domain.NewAccountEvent(domain.TokenRefreshEvent, storedUser.User.ID, session.ID, client)
+	return token, us.events.Record(event)
 }
 
-// ChangePassword changes a users password if valid credentials are provided.
-func (us *userSvc) ChangePassword(change user.PasswordChange) error {
+// ChangePassword changes the password of a user if the users current password is provided
+// and invalidates all of the users sessions. If an email is provided along with the
+// current password it must belong to the user.
+func (us *userSvc) ChangePassword(userID string, change user.PasswordChange, client domain.ClientInfo) error {
 	if change.New != change.Repeated {
 		return errPasswordMissmatch()
 	}
 
-	newCreds, err := us.passwordSvc.ChangePassword(change.New, change.Old)
-	if err != nil {
+	storedUser, err := us.userRepo.Find(userID)
+	if err == repository.ErrNoSuchUser {
+		return httputil.ErrNotFound()
+	} else if err != nil {
 		return err
 	}
 
-	return us.updateUserCredentials(newCreds)
-}
-
-// ChangeEmail changes the email of a given user.
-func (us *userSvc) ChangeEmail(userID, newEmail string) error {
-	savedUser, err := us.userRepo.Find(userID)
-	if err == repository.ErrNoSuchUser {
+	if storedUser.User.Email == "" {
 		return httputil.ErrNotFound()
+	}
+
+	if change.Old.Email != "" && change.Old.Email != storedUser.Credentials.Email {
+		return httputil.ErrForbidden()
+	}
+
+	newCreds, err := us.passwordSvc.ChangePassword(storedUser, change.New, change.Old.Password)
+	if err == ErrAccountLocked {
+		return errAccountLocked()
+	} else if err == ErrInvalidCredentials {
+		return httputil.ErrForbidden()
 	} else if err != nil {
 		return err
 	}
 
-	savedUser.User.Email = newEmail
-	savedUser.Credentials.Email = newEmail
-	return us.userRepo.Save(savedUser)
+	storedUser.Credentials = newCreds
+	err = us.userRepo.Save(storedUser)
+	if err != nil {
+		return err
+	}
+
+	err = invalidateSessions(us.userRepo, us.sessionRepo, userID)
+	if err != nil {
+		return err
+	}
+
+	return us.events.Record(domain.NewAccountEvent(domain.PasswordChangeEvent, userID, "", client))
 }
 
 // GetAnonymousToken creates a new anonymous token.
-func (us *userSvc) GetAnonymousToken() (user.Token, error) {
+func (us *userSvc) GetAnonymousToken(client domain.ClientInfo) (user.Token, error) {
 	watchlists := []user.Watchlist{getDefaultWatchlist()}
 	u := user.New("", auth.AnonymousRole, watchlists)
-	accessToken, err := us.tokenSigner.Sign(id.New(), auth.User{ID: u.ID, Role: u.Role})
+	tokenID := id.New()
+	accessToken, err := us.tokenSigner.Sign(tokenID, auth.User{ID: u.ID, Role: u.Role})
+	if err != nil {
+		return emptyToken, err
+	}
+
+	event := domain.NewAccountEvent(domain.AnonymousTokenEvent, u.ID, tokenID, client)
+	return user.NewToken(accessToken, "", u), us.events.Record(event)
+}
+
+// CreateGuest creates a persisted guest without email or credentials and starts a session for the client.
+// Guests can edit their own watchlists and are purged once they have been inactive for the retention period.
+func (us *userSvc) CreateGuest(client domain.ClientInfo) (user.Token, error) {
+	guest := domain.NewGuest([]user.Watchlist{getDefaultWatchlist()})
+	err := us.saveNewUser(guest)
 	if err != nil {
 		return emptyToken, err
 	}
 
-	return user.NewToken(accessToken, "", u), nil
+	return us.createSessionToken(guest.User, domain.NewSession(guest.User.ID, client))
+}
+
+func (us *userSvc) upgradeAnonymous(userID string, credentials user.Credentials) (domain.FullUser, error) {
+	err := us.ensureUserDoesNotExist(credentials.Email)
+	if err != nil {
+		return domain.FullUser{}, err
+	}
+
+	_, err = us.userRepo.Find(userID)
+	if err == nil {
+		return domain.FullUser{}, errUserAlreadyExists()
+	} else if err != repository.ErrNoSuchUser {
+		return domain.FullUser{}, err
+	}
+
+	watchlists := []user.Watchlist{getDefaultWatchlist()}
+	return us.createNewUser(credentials, userID, watchlists)
+}
+
+// upgradeGuest adds credentials to a stored guest. The watchlists of the guest are already stored.
+func (us *userSvc) upgradeGuest(userID string, credentials user.Credentials) (domain.FullUser, error) {
+	err := us.ensureUserDoesNotExist(credentials.Email)
+	if err != nil {
+		return domain.FullUser{}, err
+	}
+
+	guest, err := us.userRepo.Find(userID)
+	if err == repository.ErrNoSuchUser {
+		return domain.FullUser{}, httputil.ErrForbidden()
+	} else if err != nil {
+		return domain.FullUser{}, err
+	}
+
+	if !guest.IsGuest() {
+		return domain.FullUser{}, errUserAlreadyExists()
+	}
+
+	if guest.IsLocked(now()) {
+		return domain.FullUser{}, errAccountLocked()
+	}
+
+	secureCreds, err := us.passwordSvc.Create(credentials)
+	if err != nil {
+		return domain.FullUser{}, err
+	}
+
+	guest.User.Email = secureCreds.Email
+	guest.User.Role = auth.UserRole
+	guest.Credentials = secureCreds
+	err = us.userRepo.Save(guest)
+	if err == repository.ErrEmailTaken {
+		return domain.FullUser{}, errUserAlreadyExists()
+	} else if err != nil {
+		return domain.FullUser{}, err
+	}
+
+	guest.User.Watchlists, err = us.userRepo.FindWatchlists(userID)
+	if err != nil {
+		return domain.FullUser{}, err
+	}
+
+	return guest, nil
 }
 
-func (us *userSvc) createNewUser(credentials user.Credentials) (user.User, error) {
+// createNewUser creates and stores a new user along with its watchlists.
+// If no user id is provided a new id is generated.
+func (us *userSvc) createNewUser(credentials user.Credentials, userID string, watchlists []user.Watchlist) (domain.FullUser, error) {
 	secureCreds, err := us.passwordSvc.Create(credentials)
 	if err != nil {
-		return user.User{}, err
+		return domain.FullUser{}, err
 	}
 
-	newUser := domain.NewUser(secureCreds, nil)
+	newUser := domain.NewUser(secureCreds, watchlists)
+	if userID != "" {
+		newUser.User.ID = userID
+	}
 
-	err = us.userRepo.Save(newUser)
+	err = us.saveNewUser(newUser)
 	if err != nil {
-		return user.User{}, err
+		return domain.FullUser{}, err
 	}
 
-	return newUser.User, nil
+	return newUser, nil
+}
+
+// saveNewUser stores a new user along with its watchlists.
+func (us *userSvc) saveNewUser(newUser domain.FullUser) error {
+	err := us.userRepo.Save(newUser)
+	if err == repository.ErrEmailTaken {
+		return errUserAlreadyExists()
+	} else if err != nil {
+		return err
+	}
+
+	for _, watchlist := range newUser.User.Watchlists {
+		err = us.listRepo.Save(newUser.User.ID, watchlist)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 func (us *userSvc) ensureUserDoesNotExist(email string) error {
@@ -214,33 +458,90 @@ func (us *userSvc) ensureUserDoesNotExist(email string) error {
 	return err
 }
 
-func (us *userSvc) createSessionToken(u user.User) (user.Token, error) {
-	session := domain.NewSession(u.ID)
-	accessToken, err := us.tokenSigner.Sign(session.ID, auth.User{ID: u.ID, Role: u.Role})
+// startLogin starts a session for an authenticated user, or issues an MFA challenge if the user has it enabled.
+func (us *userSvc) startLogin(u domain.FullUser, client domain.ClientInfo) (domain.LoginResult, error) {
+	var err error
+	u.User.Role, err = us.verificationSvc.AuthorizedRole(u)
 	if err != nil {
-		return emptyToken, err
+		return domain.LoginResult{}, err
 	}
 
-	err = us.sessionRepo.Save(session)
+	if u.TOTP.Enabled {
+		challenge, err := us.mfaSvc.Challenge(u.User.ID)
+		if err != nil {
+			return domain.LoginResult{}, err
+		}
+
+		return domain.LoginResult{Challenge: &challenge}, nil
+	}
+
+	token, err := us.createLoginToken(u, client)
+	if err != nil {
+		return domain.LoginResult{}, err
+	}
+
+	return domain.LoginResult{Token: token}, nil
+}
+
+// createLoginToken starts a new session for a user that has logged in and records the login.
+// Users that log in after deleting their account, but before it has been purged, have their account restored.
+func (us *userSvc) createLoginToken(u domain.FullUser, client domain.ClientInfo) (user.Token, error) {
+	if u.IsDeleted() {
+		err := us.restore(u.User.ID, client)
+		if err != nil {
+			return emptyToken, err
+		}
+	}
+
+	session := domain.NewSession(u.User.ID, client)
+	token, err := us.createSessionToken(u.User, session)
 	if err != nil {
 		return emptyToken, err
 	}
 
-	return user.NewToken(accessToken, session.RefreshToken, u), nil
+	return token, us.events.Record(domain.NewAccountEvent(domain.LoginSuccessEvent, u.User.ID, session.ID, client))
+}
+
+func (us *userSvc) restore(userID string, client domain.ClientInfo) error {
+	err := us.userRepo.Restore(userID)
+	if err != nil {
+		return err
+	}
+
+	return us.events.Record(domain.NewAccountEvent(domain.UserRestoreEvent, userID, "", client))
 }
 
-func (us *userSvc) updateUserCredentials(newCreds domain.StoredCredentials) error {
-	user, err := us.userRepo.FindByEmail(newCreds.Email)
+// recordLoginFailure records a failed login against the account that the email belongs to, if there is one.
+// The login error is returned unless the failure could not be recorded.
+func (us *userSvc) recordLoginFailure(email string, client domain.ClientInfo, loginErr error) error {
+	u, err := us.userRepo.FindByEmail(email)
+	if err == repository.ErrNoSuchUser {
+		return loginErr
+	} else if err != nil {
+		return err
+	}
+
+	err = us.events.Record(domain.NewSecurityEvent(domain.LoginFailureEvent, u.User.ID, "", client))
 	if err != nil {
 		return err
 	}
 
-	newUser := domain.FullUser{
-		User:        user.User,
-		Credentials: newCreds,
+	return loginErr
+}
+
+func (us *userSvc) createSessionToken(u user.User, session domain.Session) (user.Token, error) {
+	accessToken, err := us.tokenSigner.Sign(session.ID, auth.User{ID: u.ID, Role: u.Role})
+	if err != nil {
+		return emptyToken, err
+	}
+
+	session.RefreshTokenHash = us.tokenHasher.Hash(session.RefreshToken)
+	err = us.sessionRepo.Save(session)
+	if err != nil {
+		return emptyToken, err
 	}
 
-	return us.userRepo.Save(newUser)
+	return user.NewToken(accessToken, session.RefreshToken, u), nil
 }
 
 func (us *userSvc) getRefreshUser(userID string) (domain.FullUser, error) {
@@ -249,15 +550,44 @@ func (us *userSvc) getRefreshUser(userID string) (domain.FullUser, error) {
 		return domain.FullUser{}, err
 	}
 
-	if storedUser.User.Email == "" {
+	if (storedUser.User.Email == "" && !storedUser.IsGuest()) || storedUser.IsDeleted() {
 		return domain.FullUser{}, httputil.ErrForbidden()
 	}
 
+	if storedUser.IsLocked(now()) {
+		return domain.FullUser{}, errAccountLocked()
+	}
+
 	return storedUser, nil
 }
 
-func verifyRefreshToken(refreshToken string, token auth.Token, session domain.Session) error {
-	if token.User.Role != auth.UserRole {
+// revokeSessionFamily revokes all sessions in the family of a session whose refresh token was reused.
+func (us *userSvc) revokeSessionFamily(session domain.Session, client domain.ClientInfo) error {
+	err := us.sessionRepo.DeleteFamily(session.FamilyID)
+	if err != nil {
+		return err
+	}
+
+	event := domain.NewSecurityEvent(domain.RefreshTokenReuseEvent, session.UserID, session.ID, client)
+	err = us.events.Record(event)
+	if err != nil {
+		return err
+	}
+
+	return httputil.ErrForbidden()
+}
+
+// isRefreshTokenReuse checks if a valid refresh token is presented for a session that has already been rotated.
+func (us *userSvc) isRefreshTokenReuse(refreshToken string, token auth.Token, session domain.Session) bool {
+	return session.Rotated() &&
+		token.User.ID == session.UserID &&
+		us.tokenHasher.Matches(refreshToken, session.RefreshTokenHash)
+}
+
+func (us *userSvc) verifyRefreshToken(refreshToken string, token auth.Token, session domain.Session) error {
+	switch token.User.Role {
+	case auth.UserRole, domain.UnverifiedRole, domain.GuestRole, domain.AdminRole:
+	default:
 		return httputil.ErrForbidden()
 	}
 
@@ -273,7 +603,7 @@ func verifyRefreshToken(refreshToken string, token auth.Token, session domain.Se
 		return httputil.ErrForbidden()
 	}
 
-	if session.RefreshToken != refreshToken {
+	if !us.tokenHasher.Matches(refreshToken, session.RefreshTokenHash) {
 		return httputil.ErrForbidden()
 	}
 
@@ -284,6 +614,10 @@ func errUserAlreadyExists() error {
 	return httputil.NewError("User already exists", http.StatusConflict)
 }
 
+func errAccountLocked() error {
+	return httputil.NewError("Account is locked", http.StatusLocked)
+}
+
 func errPasswordMissmatch() error {
 	return httputil.NewError("Passwords do not match", http.StatusBadRequest)
 }
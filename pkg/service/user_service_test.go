@@ -30,7 +30,7 @@ func TestGetUser(t *testing.T) {
 	userRepo := &mockUserRepo{
 		findUser: expectedUser,
 	}
-	userSvc := service.NewUserService(nil, nil, nil, userRepo, nil)
+	userSvc := service.NewUserService(nil, nil, nil, nil, nil, nil, nil, service.RefreshTokenHasher{}, userRepo, nil, nil, &service.MockEventRecorder{})
 
 	u, err := userSvc.Get(userID)
 	assert.NoError(err)
@@ -41,7 +41,7 @@ func TestGetUser(t *testing.T) {
 	userRepo = &mockUserRepo{
 		findErr: repository.ErrNoSuchUser,
 	}
-	userSvc = service.NewUserService(nil, nil, nil, userRepo, nil)
+	userSvc = service.NewUserService(nil, nil, nil, nil, nil, nil, nil, service.RefreshTokenHasher{}, userRepo, nil, nil, &service.MockEventRecorder{})
 
 	u, err = userSvc.Get(userID)
 	assert.Error(err)
@@ -54,7 +54,7 @@ func TestGetUser(t *testing.T) {
 	userRepo = &mockUserRepo{
 		findErr: testError,
 	}
-	userSvc = service.NewUserService(nil, nil, nil, userRepo, nil)
+	userSvc = service.NewUserService(nil, nil, nil, nil, nil, nil, nil, service.RefreshTokenHasher{}, userRepo, nil, nil, &service.MockEventRecorder{})
 
 	u, err = userSvc.Get(userID)
 	assert.Equal(testError, err)
@@ -69,9 +69,9 @@ func TestDeleteUser(t *testing.T) {
 	userRepo := &mockUserRepo{
 		deleteErr: repository.ErrNoSuchUser,
 	}
-	userSvc := service.NewUserService(nil, nil, nil, userRepo, nil)
+	userSvc := service.NewUserService(nil, nil, nil, nil, nil, nil, nil, service.RefreshTokenHasher{}, userRepo, nil, nil, &service.MockEventRecorder{})
 
-	err := userSvc.Delete(userID)
+	err := userSvc.Delete(userID, domain.ClientInfo{})
 	assert.Error(err)
 	httpErr, ok := err.(*httputil.Error)
 	assert.True(ok)
@@ -80,11 +80,29 @@ func TestDeleteUser(t *testing.T) {
 	userRepo = &mockUserRepo{
 		deleteErr: testError,
 	}
-	userSvc = service.NewUserService(nil, nil, nil, userRepo, nil)
+	userSvc = service.NewUserService(nil, nil, nil, nil, nil, nil, nil, service.RefreshTokenHasher{}, userRepo, nil, nil, &service.MockEventRecorder{})
 
-	err = userSvc.Delete(userID)
+	err = userSvc.Delete(userID, domain.ClientInfo{})
 	assert.Equal(testError, err)
 	assert.Equal(userID, userRepo.deleteArg)
+	assert.Equal("", userRepo.invalidateSessionsArg)
+
+	userRepo = &mockUserRepo{}
+	sessionRepo := &repository.MockSessionRepo{}
+	events := &service.MockEventRecorder{}
+	userSvc = service.NewUserService(nil, nil, nil, nil, nil, nil, nil, service.RefreshTokenHasher{}, userRepo, sessionRepo, nil, events)
+
+	client := domain.ClientInfo{UserAgent: "test-agent/1.0", IP: "10.0.0.1"}
+	err = userSvc.Delete(userID, client)
+	assert.NoError(err)
+	assert.Equal(userID, userRepo.deleteArg)
+	assert.Equal(userID, userRepo.invalidateSessionsArg)
+	assert.Equal(userID, sessionRepo.DeleteByUserIDArg)
+	assert.Len(events.Events, 1)
+	assert.Equal(domain.UserDeletionEvent, events.Events[0].Type)
+	assert.Equal(userID, events.Events[0].UserID)
+	assert.Equal(userID, events.Events[0].ActorID)
+	assert.Equal(client, events.Events[0].Client)
 }
 
 func TestUserSvcChangePassword(t *testing.T) {
@@ -103,30 +121,35 @@ func TestUserSvcChangePassword(t *testing.T) {
 	}
 
 	userRepo := &mockUserRepo{
-		findByEmailUser: storedUser,
+		findUser: storedUser,
 	}
 
-	passwordSvc := service.NewPasswordService(userRepo, "my-pepper", "my-encryption-key")
-	userSvc := service.NewUserService(passwordSvc, nil, nil, userRepo, nil)
+	sessionRepo := &repository.MockSessionRepo{}
+	passwordSvc := service.NewPasswordService(userRepo, "my-pepper", service.NewEncryptionKeyring("my-encryption-key"), service.DefaultHashingConfig, service.DefaultLockoutPolicy)
+	userSvc := service.NewUserService(passwordSvc, nil, nil, nil, nil, nil, nil, service.RefreshTokenHasher{}, userRepo, sessionRepo, nil, &service.MockEventRecorder{})
 
 	pwdChange := user.PasswordChange{
 		New:      "new-password",
 		Repeated: "new-password",
 		Old: user.Credentials{
-			Email:    storedUser.User.Email,
 			Password: "super-secret-password",
 		},
 	}
 
-	err := userSvc.ChangePassword(pwdChange)
+	userID := storedUser.User.ID
+	err := userSvc.ChangePassword(userID, pwdChange, domain.ClientInfo{})
 	assert.NoError(err)
-	assert.Equal(pwdChange.Old.Email, userRepo.findByEmailArg)
+	assert.Equal(userID, userRepo.findArg)
+	assert.Equal("", userRepo.findByEmailArg)
+	assert.Equal(userID, userRepo.saveArg.User.ID)
 	savedCreds := userRepo.saveArg.Credentials
 	assert.NotEqual(storedUser.Credentials.Salt, savedCreds.Salt)
 	assert.NotEqual("", savedCreds.Salt)
 	assert.NotEqual(storedUser.Credentials.Password, savedCreds.Password)
 	assert.NotEqual("", savedCreds.Password)
 	assert.Equal(storedUser.Credentials.Email, savedCreds.Email)
+	assert.Equal(storedUser.User.ID, userRepo.invalidateSessionsArg)
+	assert.Equal(storedUser.User.ID, sessionRepo.DeleteByUserIDArg)
 
 	inconsistentPwdChange := user.PasswordChange{
 		New:      "new-password",
@@ -137,14 +160,14 @@ func TestUserSvcChangePassword(t *testing.T) {
 		},
 	}
 
-	userRepo.findByEmailArg = ""
+	userRepo.findArg = ""
 	userRepo.saveArg = domain.FullUser{}
-	err = userSvc.ChangePassword(inconsistentPwdChange)
+	err = userSvc.ChangePassword(userID, inconsistentPwdChange, domain.ClientInfo{})
 	assert.Error(err)
 	httpError, ok := err.(*httputil.Error)
 	assert.True(ok)
 	assert.Equal(http.StatusBadRequest, httpError.StatusCode)
-	assert.Equal("", userRepo.findByEmailArg)
+	assert.Equal("", userRepo.findArg)
 	savedCreds = userRepo.saveArg.Credentials
 	assert.Equal("", savedCreds.Salt)
 	assert.Equal("", savedCreds.Password)
@@ -159,63 +182,37 @@ func TestUserSvcChangePassword(t *testing.T) {
 		},
 	}
 
-	userRepo.findByEmailArg = ""
+	userRepo.findArg = ""
 	userRepo.saveArg = domain.FullUser{}
-	err = userSvc.ChangePassword(wrongPwdChange)
-	assert.Error(err)
-	assert.Equal(wrongPwdChange.Old.Email, userRepo.findByEmailArg)
+	err = userSvc.ChangePassword(userID, wrongPwdChange, domain.ClientInfo{})
+	assertHTTPStatus(assert, http.StatusForbidden, err)
+	assert.Equal(userID, userRepo.findArg)
 	savedCreds = userRepo.saveArg.Credentials
 	assert.Equal("", savedCreds.Salt)
 	assert.Equal("", savedCreds.Password)
 	assert.Equal("", savedCreds.Email)
 
-}
-
-func TestChangeEmail(t *testing.T) {
-	assert := assert.New(t)
-
-	userID := id.New()
-	oldEmail := "mail@mail.com"
-	storedUser := domain.FullUser{
-		User: user.User{
-			ID:    userID,
-			Email: oldEmail,
-		},
-		Credentials: domain.StoredCredentials{
-			Email:    oldEmail,
-			Password: "old-hashed-and-encrypted-password",
-			Salt:     "3old-hashed-and-encrypted-salt",
+	// Credentials of another account should be rejected.
+	otherAccountPwdChange := user.PasswordChange{
+		New:      "new-password",
+		Repeated: "new-password",
+		Old: user.Credentials{
+			Email:    "other@mail.com",
+			Password: "super-secret-password",
 		},
 	}
 
-	userRepo := &mockUserRepo{
-		findUser: storedUser,
-	}
-
-	userSvc := service.NewUserService(nil, nil, nil, userRepo, nil)
-
-	newEmail := "new.email@mail.com"
-	err := userSvc.ChangeEmail(userID, newEmail)
-	assert.NoError(err)
-	savedUser := userRepo.saveArg
-	assert.Equal(userID, savedUser.User.ID)
-	assert.Equal(newEmail, savedUser.User.Email)
-	assert.Equal(newEmail, savedUser.Credentials.Email)
-	assert.Equal(storedUser.Credentials.Password, savedUser.Credentials.Password)
-	assert.Equal(storedUser.Credentials.Salt, savedUser.Credentials.Salt)
-
-	userRepo.findErr = repository.ErrNoSuchUser
-	userRepo.findUser = domain.FullUser{}
+	userRepo.findArg = ""
 	userRepo.saveArg = domain.FullUser{}
+	err = userSvc.ChangePassword(userID, otherAccountPwdChange, domain.ClientInfo{})
+	assertHTTPStatus(assert, http.StatusForbidden, err)
+	assert.Equal(userID, userRepo.findArg)
+	assert.Equal("", userRepo.saveArg.User.ID)
 
-	err = userSvc.ChangeEmail(userID, newEmail)
-	assert.Error(err)
-	httpError, ok := err.(*httputil.Error)
-	assert.True(ok)
-	assert.Equal(http.StatusNotFound, httpError.StatusCode)
-	savedUser = userRepo.saveArg
-	assert.Equal("", savedUser.User.ID)
-
+	userRepo.findErr = repository.ErrNoSuchUser
+	err = userSvc.ChangePassword(userID, pwdChange, domain.ClientInfo{})
+	assertHTTPStatus(assert, http.StatusNotFound, err)
+	assert.Equal("", userRepo.saveArg.User.ID)
 }
 
 func TestCreateAnonymousUser(t *testing.T) {
@@ -224,11 +221,16 @@ func TestCreateAnonymousUser(t *testing.T) {
 	jwtCreds := auth.JWTCredentials{Issuer: "user_service_test", Secret: id.New()}
 	signer := auth.NewSigner(jwtCreds, 24*time.Hour)
 	verifier := auth.NewVerifier(jwtCreds, 0)
-	userSvc := service.NewUserService(nil, signer, nil, nil, nil)
+	events := &service.MockEventRecorder{}
+	userSvc := service.NewUserService(nil, nil, nil, nil, nil, signer, nil, service.RefreshTokenHasher{}, nil, nil, nil, events)
 
-	token, err := userSvc.GetAnonymousToken()
+	token, err := userSvc.GetAnonymousToken(domain.ClientInfo{IP: "10.0.0.1"})
 	assert.NoError(err)
 	assert.Equal(auth.AnonymousRole, token.User.Role)
+	assert.Len(events.Events, 1)
+	assert.Equal(domain.AnonymousTokenEvent, events.Events[0].Type)
+	assert.Equal(token.User.ID, events.Events[0].UserID)
+	assert.Equal("10.0.0.1", events.Events[0].Client.IP)
 	assert.Equal("", token.RefreshToken)
 	assert.Equal(1, len(token.User.Watchlists))
 	assert.Equal(5, len(token.User.Watchlists[0].Stocks))
@@ -243,18 +245,167 @@ func TestCreateAnonymousUser(t *testing.T) {
 	assert.Equal(token.User.ID, content.User.ID)
 }
 
+func TestUpgradeAnonymousUser(t *testing.T) {
+	assert := assert.New(t)
+
+	jwtCreds := auth.JWTCredentials{Issuer: "user_service_test", Secret: id.New()}
+	signer := auth.NewSigner(jwtCreds, 24*time.Hour)
+	verifier := auth.NewVerifier(jwtCreds, 0)
+	userRepo := &repository.MockUserRepo{
+		FindErr:        repository.ErrNoSuchUser,
+		FindByEmailErr: repository.ErrNoSuchUser,
+	}
+	listRepo := &repository.MockWatchlistRepo{}
+	mailer := &service.MockMailer{}
+	passwordSvc := service.NewPasswordService(userRepo, "my-pepper", service.NewEncryptionKeyring("my-encryption-key"), service.DefaultHashingConfig, service.DefaultLockoutPolicy)
+	verificationSvc := service.NewEmailVerificationService(service.AllowUnverified, mailer, userRepo, &repository.MockOneTimeCredentialRepo{})
+	userSvc := service.NewUserService(passwordSvc, verificationSvc, nil, nil, nil, signer, verifier, service.RefreshTokenHasher{}, userRepo, nil, listRepo, &service.MockEventRecorder{})
+
+	anonymousToken, err := userSvc.GetAnonymousToken(domain.ClientInfo{})
+	assert.NoError(err)
+	credentials := user.Credentials{
+		Email:    "mail@mail.com",
+		Password: "super-secret-password",
+	}
+
+	u, err := userSvc.Upgrade(anonymousToken.Token, credentials)
+	assert.NoError(err)
+	assert.Equal(anonymousToken.User.ID, u.ID)
+	assert.Equal(credentials.Email, u.Email)
+	assert.Equal(auth.UserRole, u.Role)
+	assert.Equal(anonymousToken.User.ID, userRepo.FindArg)
+	assert.Equal(anonymousToken.User.ID, userRepo.SaveArg.User.ID)
+	assert.Equal(anonymousToken.User.ID, listRepo.SaveArgUserID)
+	assert.Equal(anonymousToken.User.Watchlists[0].Name, listRepo.SaveArgWatchlist.Name)
+	assert.Equal(anonymousToken.User.Watchlists[0].Stocks, listRepo.SaveArgWatchlist.Stocks)
+	assert.Equal(1, len(u.Watchlists))
+	assert.Equal(1, len(mailer.Outbox))
+
+	// Users should be upgraded even if the verification email cannot be sent, since they have already been stored.
+	otherAnonymousToken, err := userSvc.GetAnonymousToken(domain.ClientInfo{})
+	assert.NoError(err)
+	mailer.SendErr = testError
+	u, err = userSvc.Upgrade(otherAnonymousToken.Token, credentials)
+	assert.NoError(err)
+	assert.Equal(otherAnonymousToken.User.ID, u.ID)
+	assert.Equal(otherAnonymousToken.User.ID, userRepo.SaveArg.User.ID)
+	assert.Equal(1, len(mailer.Outbox))
+	mailer.SendErr = nil
+
+	// Anonymous tokens which have already been upgraded should be rejected.
+	userRepo.SaveArg = domain.FullUser{}
+	userRepo.FindErr = nil
+	userRepo.FindUser = domain.FullUser{User: user.User{ID: anonymousToken.User.ID, Email: "other@mail.com"}}
+	_, err = userSvc.Upgrade(anonymousToken.Token, credentials)
+	assertHTTPStatus(assert, http.StatusConflict, err)
+	assert.Equal("", userRepo.SaveArg.User.ID)
+	userRepo.FindErr = repository.ErrNoSuchUser
+
+	// Tokens of registered users should be rejected.
+	userToken, err := signer.Sign(id.New(), auth.User{ID: id.New(), Role: auth.UserRole})
+	assert.NoError(err)
+	_, err = userSvc.Upgrade(userToken, credentials)
+	assertHTTPStatus(assert, http.StatusForbidden, err)
+	assert.Equal("", userRepo.SaveArg.User.ID)
+
+	// Invalid tokens should be rejected.
+	_, err = userSvc.Upgrade("invalid-token", credentials)
+	assertHTTPStatus(assert, http.StatusUnauthorized, err)
+	assert.Equal("", userRepo.SaveArg.User.ID)
+}
+
+func TestGuestUser(t *testing.T) {
+	assert := assert.New(t)
+
+	jwtCreds := auth.JWTCredentials{Issuer: "user_service_test", Secret: id.New()}
+	signer := auth.NewSigner(jwtCreds, 24*time.Hour)
+	verifier := auth.NewVerifier(jwtCreds, 365*24*time.Hour)
+	tokenHasher := service.NewRefreshTokenHasher("my-refresh-token-key")
+	userRepo := &repository.MockUserRepo{
+		FindByEmailErr: repository.ErrNoSuchUser,
+	}
+	sessionRepo := &repository.MockSessionRepo{}
+	listRepo := &repository.MockWatchlistRepo{}
+	mailer := &service.MockMailer{}
+	passwordSvc := service.NewPasswordService(userRepo, "my-pepper", service.NewEncryptionKeyring("my-encryption-key"), service.DefaultHashingConfig, service.DefaultLockoutPolicy)
+	verificationSvc := service.NewEmailVerificationService(service.BlockUnverified, mailer, userRepo, &repository.MockOneTimeCredentialRepo{})
+	userSvc := service.NewUserService(passwordSvc, verificationSvc, nil, nil, nil, signer, verifier, tokenHasher, userRepo, sessionRepo, listRepo, &service.MockEventRecorder{})
+
+	client := domain.ClientInfo{UserAgent: "test-agent", IP: "127.0.0.1"}
+	guestToken, err := userSvc.CreateGuest(client)
+	assert.NoError(err)
+	assert.Equal(domain.GuestRole, guestToken.User.Role)
+	assert.Equal("", guestToken.User.Email)
+	assert.NotEqual("", guestToken.RefreshToken)
+	guest := userRepo.SaveArg
+	assert.Equal(guestToken.User.ID, guest.User.ID)
+	assert.Equal(domain.GuestRole, guest.User.Role)
+	assert.Equal("", guest.Credentials.Password)
+	assert.Equal(guestToken.User.ID, listRepo.SaveArgUserID)
+	assert.Equal(guestToken.User.Watchlists[0].Name, listRepo.SaveArgWatchlist.Name)
+	assert.Equal(guestToken.User.ID, sessionRepo.SaveArg.UserID)
+	tokenBody, err := verifier.Verify(guestToken.Token)
+	assert.NoError(err)
+	assert.Equal(domain.GuestRole, tokenBody.User.Role)
+
+	// Guests should be able to refresh their sessions even though they have no email.
+	userRepo.FindUser = guest
+	sessionRepo.FindSession = sessionRepo.SaveArg
+	refreshedToken, err := userSvc.RefreshToken(guestToken, client)
+	assert.NoError(err)
+	assert.Equal(guestToken.User.ID, refreshedToken.User.ID)
+	assert.Equal(domain.GuestRole, refreshedToken.User.Role)
+	assert.Equal(sessionRepo.FindSession.ID, sessionRepo.RotateArg)
+
+	// Upgrading a guest should keep its id and stored watchlists.
+	userRepo.FindWatchlistsRes = []user.Watchlist{guestToken.User.Watchlists[0], {ID: id.New(), Name: "My list"}}
+	listRepo.SaveArgUserID = ""
+	credentials := user.Credentials{
+		Email:    "mail@mail.com",
+		Password: "super-secret-password",
+	}
+	u, err := userSvc.Upgrade(refreshedToken.Token, credentials)
+	assert.NoError(err)
+	assert.Equal(guestToken.User.ID, u.ID)
+	assert.Equal(credentials.Email, u.Email)
+	assert.Equal(auth.UserRole, u.Role)
+	assert.Equal(2, len(u.Watchlists))
+	assert.Equal(guestToken.User.ID, userRepo.SaveArg.User.ID)
+	assert.Equal(auth.UserRole, userRepo.SaveArg.User.Role)
+	assert.Equal(credentials.Email, userRepo.SaveArg.Credentials.Email)
+	assert.NotEqual("", userRepo.SaveArg.Credentials.Password)
+	assert.Equal("", listRepo.SaveArgUserID)
+	assert.Equal(1, len(mailer.Outbox))
+
+	// Guests which have already been upgraded should be rejected.
+	userRepo.SaveArg = domain.FullUser{}
+	userRepo.FindUser = domain.FullUser{User: user.User{ID: guestToken.User.ID, Email: "other@mail.com", Role: auth.UserRole}}
+	_, err = userSvc.Upgrade(refreshedToken.Token, credentials)
+	assertHTTPStatus(assert, http.StatusConflict, err)
+	assert.Equal("", userRepo.SaveArg.User.ID)
+
+	// Guests which have been purged should be rejected.
+	userRepo.FindErr = repository.ErrNoSuchUser
+	_, err = userSvc.Upgrade(refreshedToken.Token, credentials)
+	assertHTTPStatus(assert, http.StatusForbidden, err)
+	assert.Equal("", userRepo.SaveArg.User.ID)
+}
+
 func TestRefreshToken(t *testing.T) {
 	assert := assert.New(t)
 
 	userID := id.New()
 	tokenID := id.New()
+	refreshToken := id.New()
+	tokenHasher := service.NewRefreshTokenHasher("my-refresh-token-key")
 
 	oldSession := domain.Session{
-		ID:           tokenID,
-		UserID:       userID,
-		Active:       true,
-		RefreshToken: id.New(),
-		CreatedAt:    time.Now().UTC().Add(-48 * time.Hour),
+		ID:               tokenID,
+		UserID:           userID,
+		Active:           true,
+		RefreshTokenHash: tokenHasher.Hash(refreshToken),
+		CreatedAt:        time.Now().UTC().Add(-48 * time.Hour),
+		DeviceName:       "My phone",
 	}
 
 	authUser := auth.User{
@@ -279,27 +430,38 @@ func TestRefreshToken(t *testing.T) {
 	sessionRepo := &repository.MockSessionRepo{
 		FindSession: oldSession,
 	}
-	userSvc := service.NewUserService(nil, signer, verifier, userRepo, sessionRepo)
+	verificationSvc := service.NewEmailVerificationService(service.AllowUnverified, nil, userRepo, nil)
+	events := &service.MockEventRecorder{}
+	userSvc := service.NewUserService(nil, verificationSvc, nil, nil, nil, signer, verifier, tokenHasher, userRepo, sessionRepo, nil, events)
 
 	oldJwt, err := signer.Sign(tokenID, authUser)
 	assert.NoError(err)
 
 	oldToken := user.Token{
 		Token:        oldJwt,
-		RefreshToken: oldSession.RefreshToken,
+		RefreshToken: refreshToken,
 	}
 
-	newToken, err := userSvc.RefreshToken(oldToken)
+	client := domain.ClientInfo{
+		UserAgent: "test-agent/1.0",
+		IP:        "10.0.0.1",
+	}
+	newToken, err := userSvc.RefreshToken(oldToken, client)
 	assert.NoError(err)
 	assert.Equal(userID, userRepo.FindArg)
 	assert.Equal(1, sessionRepo.FindInvocation)
 	assert.Equal(1, sessionRepo.SaveInvocation)
+	assert.Equal(client.UserAgent, sessionRepo.SaveArg.UserAgent)
+	assert.Equal(client.IP, sessionRepo.SaveArg.ClientIP)
+	assert.Equal(oldSession.DeviceName, sessionRepo.SaveArg.DeviceName)
+	assert.False(sessionRepo.SaveArg.LastRefreshedAt.IsZero())
 
 	newTokenBody, err := verifier.Verify(newToken.Token)
 	assert.NoError(err)
 	assert.Equal(userID, newToken.User.ID)
-	assert.Equal(sessionRepo.SaveArg.RefreshToken, newToken.RefreshToken)
-	assert.NotEqual(oldToken.RefreshToken, sessionRepo.SaveArg.RefreshToken)
+	assert.Equal(tokenHasher.Hash(newToken.RefreshToken), sessionRepo.SaveArg.RefreshTokenHash)
+	assert.NotEqual(newToken.RefreshToken, sessionRepo.SaveArg.RefreshTokenHash)
+	assert.NotEqual(oldToken.RefreshToken, newToken.RefreshToken)
 	assert.Equal(sessionRepo.SaveArg.ID, newTokenBody.ID)
 	assert.NotEqual(tokenID, sessionRepo.SaveArg.ID)
 
@@ -309,15 +471,15 @@ func TestRefreshToken(t *testing.T) {
 	sessionRepo.UnsetArgs()
 	userRepo.FindArg = ""
 	otherSession := domain.Session{
-		ID:           tokenID,
-		UserID:       userID,
-		Active:       true,
-		RefreshToken: "well-this-is-clearly-the-wrong-token",
-		CreatedAt:    time.Now().UTC().Add(-48 * time.Hour),
+		ID:               tokenID,
+		UserID:           userID,
+		Active:           true,
+		RefreshTokenHash: tokenHasher.Hash("well-this-is-clearly-the-wrong-token"),
+		CreatedAt:        time.Now().UTC().Add(-48 * time.Hour),
 	}
 
 	sessionRepo.FindSession = otherSession
-	_, err = userSvc.RefreshToken(oldToken)
+	_, err = userSvc.RefreshToken(oldToken, domain.ClientInfo{})
 	assert.Error(err)
 	httpErr, ok := err.(*httputil.Error)
 	assert.True(ok)
@@ -330,15 +492,15 @@ func TestRefreshToken(t *testing.T) {
 	sessionRepo.UnsetArgs()
 	userRepo.FindArg = ""
 	inactiveSession := domain.Session{
-		ID:           tokenID,
-		UserID:       userID,
-		Active:       false,
-		RefreshToken: oldSession.RefreshToken,
-		CreatedAt:    time.Now().UTC().Add(-48 * time.Hour),
+		ID:               tokenID,
+		UserID:           userID,
+		Active:           false,
+		RefreshTokenHash: oldSession.RefreshTokenHash,
+		CreatedAt:        time.Now().UTC().Add(-48 * time.Hour),
 	}
 
 	sessionRepo.FindSession = inactiveSession
-	_, err = userSvc.RefreshToken(oldToken)
+	_, err = userSvc.RefreshToken(oldToken, domain.ClientInfo{})
 	assert.Error(err)
 	httpErr, ok = err.(*httputil.Error)
 	assert.True(ok)
@@ -351,15 +513,15 @@ func TestRefreshToken(t *testing.T) {
 	sessionRepo.UnsetArgs()
 	userRepo.FindArg = ""
 	veryOldSession := domain.Session{
-		ID:           tokenID,
-		UserID:       userID,
-		Active:       true,
-		RefreshToken: oldSession.RefreshToken,
-		CreatedAt:    time.Now().UTC().Add(-366 * 24 * time.Hour),
+		ID:               tokenID,
+		UserID:           userID,
+		Active:           true,
+		RefreshTokenHash: oldSession.RefreshTokenHash,
+		CreatedAt:        time.Now().UTC().Add(-366 * 24 * time.Hour),
 	}
 
 	sessionRepo.FindSession = veryOldSession
-	_, err = userSvc.RefreshToken(oldToken)
+	_, err = userSvc.RefreshToken(oldToken, domain.ClientInfo{})
 	assert.Error(err)
 	httpErr, ok = err.(*httputil.Error)
 	assert.True(ok)
@@ -379,7 +541,7 @@ func TestRefreshToken(t *testing.T) {
 	}
 
 	sessionRepo.FindSession = wrongUserSession
-	_, err = userSvc.RefreshToken(oldToken)
+	_, err = userSvc.RefreshToken(oldToken, domain.ClientInfo{})
 	assert.Error(err)
 	httpErr, ok = err.(*httputil.Error)
 	assert.True(ok)
@@ -387,4 +549,132 @@ func TestRefreshToken(t *testing.T) {
 	assert.Equal(1, sessionRepo.FindInvocation)
 	assert.Equal(0, sessionRepo.SaveInvocation)
 	assert.Equal(userID, userRepo.FindArg)
+
+	// Test renewing token for locked user.
+	sessionRepo.UnsetArgs()
+	sessionRepo.FindSession = oldSession
+	lockedUser := expectedUser
+	lockedUser.Locked = true
+	userRepo.FindUser = lockedUser
+	_, err = userSvc.RefreshToken(oldToken, domain.ClientInfo{})
+	assertHTTPStatus(assert, http.StatusLocked, err)
+	assert.Equal(0, sessionRepo.SaveInvocation)
+	assert.Equal(0, sessionRepo.RotateInvocation)
+
+	// Test renewing token for session started before the users sessions were invalidated.
+	sessionRepo.UnsetArgs()
+	invalidatedUser := expectedUser
+	invalidatedUser.SessionsValidAfter = time.Now().UTC().Add(-24 * time.Hour)
+	userRepo.FindUser = invalidatedUser
+	_, err = userSvc.RefreshToken(oldToken, domain.ClientInfo{})
+	assertHTTPStatus(assert, http.StatusForbidden, err)
+	assert.Equal(0, sessionRepo.SaveInvocation)
+	assert.Equal(0, sessionRepo.RotateInvocation)
+
+	// Sessions started after the invalidation should be refreshable.
+	sessionRepo.UnsetArgs()
+	invalidatedUser.SessionsValidAfter = oldSession.CreatedAt.Add(-1 * time.Minute)
+	userRepo.FindUser = invalidatedUser
+	_, err = userSvc.RefreshToken(oldToken, domain.ClientInfo{})
+	assert.NoError(err)
+	assert.Equal(1, sessionRepo.RotateInvocation)
+}
+
+func TestRefreshTokenReuse(t *testing.T) {
+	assert := assert.New(t)
+
+	userID := id.New()
+	tokenID := id.New()
+	refreshToken := id.New()
+	tokenHasher := service.NewRefreshTokenHasher("my-refresh-token-key")
+	familyID := id.New()
+
+	oldSession := domain.Session{
+		ID:               tokenID,
+		FamilyID:         familyID,
+		UserID:           userID,
+		Active:           true,
+		RefreshTokenHash: tokenHasher.Hash(refreshToken),
+		CreatedAt:        time.Now().UTC().Add(-48 * time.Hour),
+	}
+	authUser := auth.User{
+		ID:   userID,
+		Role: auth.UserRole,
+	}
+	storedUser := domain.FullUser{
+		User: user.User{
+			ID:    userID,
+			Email: "some@email.com",
+			Role:  authUser.Role,
+		},
+	}
+
+	jwtCreds := auth.JWTCredentials{Issuer: "user_service_test", Secret: id.New()}
+	signer := auth.NewSigner(jwtCreds, 24*time.Hour)
+	verifier := auth.NewVerifier(jwtCreds, 365*24*time.Hour)
+	userRepo := &repository.MockUserRepo{
+		FindUser: storedUser,
+	}
+	sessionRepo := &repository.MockSessionRepo{
+		FindSession: oldSession,
+	}
+	events := &service.MockEventRecorder{}
+	verificationSvc := service.NewEmailVerificationService(service.AllowUnverified, nil, userRepo, nil)
+	userSvc := service.NewUserService(nil, verificationSvc, nil, nil, nil, signer, verifier, tokenHasher, userRepo, sessionRepo, nil, events)
+
+	oldJwt, err := signer.Sign(tokenID, authUser)
+	assert.NoError(err)
+	oldToken := user.Token{
+		Token:        oldJwt,
+		RefreshToken: refreshToken,
+	}
+
+	// Refreshing should rotate the session within its family.
+	_, err = userSvc.RefreshToken(oldToken, domain.ClientInfo{})
+	assert.NoError(err)
+	assert.Equal(tokenID, sessionRepo.RotateArg)
+	assert.Equal(familyID, sessionRepo.SaveArg.FamilyID)
+	assert.NotEqual(tokenID, sessionRepo.SaveArg.ID)
+	assert.Equal(0, sessionRepo.DeleteFamilyInvocation)
+	assert.Len(events.Events, 1)
+	assert.Equal(domain.TokenRefreshEvent, events.Events[0].Type)
+	assert.Equal(sessionRepo.SaveArg.ID, events.Events[0].SessionID)
+
+	// Reusing a rotated refresh token should revoke the session family.
+	sessionRepo.UnsetArgs()
+	events.Events = nil
+	rotatedSession := oldSession
+	rotatedSession.Active = false
+	rotatedSession.RotatedAt = time.Now().UTC()
+	sessionRepo.FindSession = rotatedSession
+	client := domain.ClientInfo{UserAgent: "evil-agent/1.0", IP: "10.0.0.66"}
+	_, err = userSvc.RefreshToken(oldToken, client)
+	assertHTTPStatus(assert, http.StatusForbidden, err)
+	assert.Equal(familyID, sessionRepo.DeleteFamilyArg)
+	assert.Equal(0, sessionRepo.RotateInvocation)
+	assert.Equal(0, sessionRepo.SaveInvocation)
+	assert.Len(events.Events, 1)
+	event := events.Events[0]
+	assert.Equal(domain.RefreshTokenReuseEvent, event.Type)
+	assert.Equal(userID, event.UserID)
+	assert.Equal(tokenID, event.SessionID)
+	assert.Equal(client, event.Client)
+
+	// Presenting a wrong refresh token for a rotated session should not revoke the family.
+	sessionRepo.UnsetArgs()
+	events.Events = nil
+	_, err = userSvc.RefreshToken(user.Token{Token: oldJwt, RefreshToken: "wrong-refresh-token"}, client)
+	assertHTTPStatus(assert, http.StatusForbidden, err)
+	assert.Equal(0, sessionRepo.DeleteFamilyInvocation)
+	assert.Len(events.Events, 0)
+
+	// Concurrent rotation of the same session should revoke the family.
+	sessionRepo.UnsetArgs()
+	sessionRepo.FindSession = oldSession
+	sessionRepo.RotateErr = repository.ErrNoSuchSession
+	_, err = userSvc.RefreshToken(oldToken, client)
+	assertHTTPStatus(assert, http.StatusForbidden, err)
+	assert.Equal(familyID, sessionRepo.DeleteFamilyArg)
+	assert.Equal(0, sessionRepo.SaveInvocation)
+	assert.Len(events.Events, 1)
 }